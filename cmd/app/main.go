@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/tsatke/verbose-broccoli/internal/app"
@@ -37,15 +41,170 @@ func main() {
 		fatal(err)
 	}
 
-	a := app.New(lis,
+	auth, err := newAuthService(log, c, p)
+	if err != nil {
+		fatal(err)
+	}
+
+	sessionOpts, err := app.NewSessionOptions(c)
+	if err != nil {
+		fatal(err)
+	}
+	sessionStore, err := newSessionStore(c, p)
+	if err != nil {
+		fatal(err)
+	}
+	if len(c.GetStringSlice(appcfg.SessionKeys)) == 0 {
+		log.Warn().Msg("no session keys configured, sessions end with a restart")
+	}
+	transferOpt, err := app.NewTransferURLOption(c)
+	if err != nil {
+		fatal(err)
+	}
+	objects, storageOpts, err := newObjectStorage(log, c, p)
+	if err != nil {
+		fatal(err)
+	}
+
+	opts := []app.Option{
 		app.WithLogger(log),
-		app.WithObjectStorage(app.NewS3Storage(c)),
+		app.WithObjectStorage(objects),
 		app.WithDocumentRepo(app.NewPostgresDocumentRepo(p)),
-		app.WithAuthService(app.NewCognitoService(c)),
-	)
-	if err := a.Run(); err != nil {
+		app.WithAuthService(auth),
+		app.WithAdminGroup(c.GetString(appcfg.AdminGroup)),
+		app.WithAPITokenRepo(app.NewPostgresAPITokenRepo(p)),
+		app.WithShareLinkRepo(app.NewPostgresShareLinkRepo(p)),
+		app.WithQuotaRepo(app.NewPostgresQuotaRepo(p)),
+		app.WithTransferTokenRepo(app.NewPostgresTransferTokenRepo(p)),
+		app.WithDefaultQuota(c.GetInt64(appcfg.QuotaBytes), c.GetInt64(appcfg.QuotaDocuments)),
+		app.WithSessionStore(sessionStore),
+		app.WithSessionOptions(sessionOpts),
+		app.WithJobQueue(app.NewPostgresJobQueue(p)),
+		app.WithJobWorkers(c.GetInt(appcfg.JobWorkers)),
+		app.WithDrainDelay(c.GetDuration(appcfg.DrainDelay)),
+		transferOpt,
+	}
+	a := app.New(lis, append(opts, storageOpts...)...)
+	if _, ok := objects.(*app.EncryptedObjectStorage); ok {
+		if _, err := a.EnqueueJob(jobRotateKeys, "", nil); err != nil {
+			fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Run()
+	}()
+	select {
+	case err := <-errs:
 		fatal(err)
+	case <-ctx.Done():
+	}
+	// a second signal kills the app right away
+	stop()
+
+	log.Info().Msg("shut down")
+	ctx, cancel := context.WithTimeout(context.Background(), c.GetDuration(appcfg.DrainDelay)+c.GetDuration(appcfg.DrainTimeout))
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("drain server")
+	}
+	if err := p.Close(); err != nil {
+		log.Error().Err(err).Msg("close database")
+	}
+}
+
+func newAuthService(log zerolog.Logger, c appcfg.Config, p *app.PostgresDatabaseProvider) (app.AuthService, error) {
+	switch c.GetString(appcfg.AuthBackend) {
+	case appcfg.AuthOIDC:
+		return app.NewOIDCService(c), nil
+	case appcfg.AuthLDAP:
+		return app.NewLDAPService(c)
+	case appcfg.AuthLocal:
+		s, err := app.NewLocalAuthService(c, app.NewPostgresLocalUserRepo(p))
+		if err != nil {
+			return nil, err
+		}
+		if pass := c.GetString(appcfg.LocalAdminPassword); pass != "" {
+			user := c.GetString(appcfg.LocalAdminUsername)
+			created, err := s.Bootstrap(user, pass, c.GetString(appcfg.AdminGroup))
+			if err != nil {
+				return nil, fmt.Errorf("bootstrap admin: %w", err)
+			}
+			if created {
+				log.Info().Str("user", user).Msg("created first admin")
+			}
+		}
+		return s, nil
+	default:
+		return app.NewCognitoService(c), nil
+	}
+}
+
+// Types of the jobs of the app.
+const (
+	jobRotateKeys     = "rotate-keys"
+	jobCollectGarbage = "collect-garbage"
+)
+
+// newObjectStorage returns the object storage and the options that register
+// the jobs that maintain it.
+func newObjectStorage(log zerolog.Logger, c appcfg.Config, p *app.PostgresDatabaseProvider) (app.ObjectStorage, []app.Option, error) {
+	var opts []app.Option
+	objects, err := app.NewEncryptedObjectStorageFromConfig(c, app.NewS3Storage(c), app.NewPostgresContentKeyRepo(p))
+	if err != nil {
+		return nil, nil, err
+	}
+	if enc, ok := objects.(*app.EncryptedObjectStorage); ok {
+		opts = append(opts, app.WithJob(jobRotateKeys, 3, func(ctx context.Context, run *app.JobRun) error {
+			n, err := enc.RotateKeys()
+			run.SetResult(map[string]int{"rotated": n})
+			return err
+		}))
+	}
+
+	// content is compressed before it's encrypted, since encrypted content
+	// can't be compressed
+	compress := func(objects app.ObjectStorage) app.ObjectStorage {
+		if c.GetBool(appcfg.Compression) {
+			return app.NewCompressedObjectStorage(objects)
+		}
+		return objects
+	}
+
+	if !c.GetBool(appcfg.Dedup) {
+		return compress(objects), opts, nil
+	}
+	interval := c.GetDuration(appcfg.DedupGCInterval)
+	if interval <= 0 {
+		return nil, nil, fmt.Errorf("%v must be positive", appcfg.DedupGCInterval)
+	}
+	// the blobs are encrypted rather than the documents, since the same
+	// content would be encrypted differently for every document
+	dedup := app.NewDedupObjectStorage(objects, app.NewPostgresBlobRepo(p))
+	opts = append(opts,
+		app.WithJob(jobCollectGarbage, 1, func(ctx context.Context, run *app.JobRun) error {
+			n, err := dedup.CollectGarbage()
+			if err != nil {
+				// the blobs that can't be deleted are tried again in the
+				// next run
+				log.Error().Err(err).Int("deleted", n).Msg("collect garbage")
+			}
+			run.SetResult(map[string]int{"deleted": n})
+			return err
+		}),
+		app.WithJobSchedule(jobCollectGarbage, interval),
+	)
+	return compress(dedup), opts, nil
+}
+
+func newSessionStore(c appcfg.Config, p *app.PostgresDatabaseProvider) (sessions.Store, error) {
+	if c.GetString(appcfg.SessionStore) == appcfg.SessionStorePostgres {
+		return app.NewPostgresSessionStore(p)
 	}
+	return app.NewCookieSessionStore(c)
 }
 
 func fatal(err error) {
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tsatke/verbose-broccoli/pkg/client"
+	"golang.org/x/term"
+)
+
+const passwordEnv = "BROCCOLI_PASSWORD"
+
+func cmdLogin(ctx context.Context, args []string) error {
+	fs := newFlagSet("login")
+	server := fs.String("server", "", "base URL of the server, e.g. https://docs.example.com")
+	user := fs.String("user", "", "username, prompted for if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *server == "" {
+		if cfg, err := loadConfig(); err == nil {
+			*server = cfg.Server
+		}
+	}
+	if *server == "" {
+		return fmt.Errorf("-server is required")
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *user == "" {
+		*user = prompt(stdin, "username: ")
+	}
+	pass := os.Getenv(passwordEnv)
+	if pass == "" {
+		pass = promptPassword(stdin, "password: ")
+	}
+
+	c, err := client.New(*server)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.Login(ctx, *user, pass)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	for res.Challenge != "" {
+		answer := prompt(stdin, res.Challenge+": ")
+		res, err = c.AnswerChallenge(ctx, *user, res.Challenge, answer)
+		if err != nil {
+			return fmt.Errorf("answer challenge: %w", err)
+		}
+	}
+
+	return saveSession(c)
+}
+
+func cmdList(ctx context.Context, args []string) error {
+	fs := newFlagSet("ls")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	docs, err := c.Documents(ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(docs)
+	}
+	return printDocuments(docs)
+}
+
+func cmdPut(ctx context.Context, args []string) error {
+	fs := newFlagSet("put")
+	recursive := fs.Bool("r", false, "upload directories recursively")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	progress := fs.Bool("progress", false, "print progress to stderr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no files given")
+	}
+
+	type upload struct {
+		path string
+		name string
+	}
+	var uploads []upload
+	for _, pattern := range fs.Args() {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: no such file", pattern)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				uploads = append(uploads, upload{match, filepath.Base(match)})
+				continue
+			}
+			if !*recursive {
+				return fmt.Errorf("%s is a directory (use -r)", match)
+			}
+
+			// documents keep the directory structure in their slash separated name
+			base := filepath.Dir(filepath.Clean(match))
+			if err := filepath.Walk(match, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(base, p)
+				if err != nil {
+					return err
+				}
+				uploads = append(uploads, upload{p, filepath.ToSlash(rel)})
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var docs []client.Document
+	for _, u := range uploads {
+		doc, err := putFile(ctx, c, u.path, u.name, *progress)
+		if err != nil {
+			return fmt.Errorf("%s: %w", u.path, err)
+		}
+		docs = append(docs, doc)
+	}
+
+	if *asJSON {
+		return printJSON(docs)
+	}
+	return printDocuments(docs)
+}
+
+func putFile(ctx context.Context, c *client.Client, p, name string, progress bool) (client.Document, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return client.Document{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return client.Document{}, err
+	}
+
+	id, err := c.CreateDocument(ctx, name)
+	if err != nil {
+		return client.Document{}, fmt.Errorf("create document: %w", err)
+	}
+	if err := c.Upload(ctx, id, name, f, info.Size(), progressFunc(progress, name)); err != nil {
+		// don't leave an empty document behind
+		if delErr := c.DeleteDocument(ctx, id); delErr != nil {
+			return client.Document{}, fmt.Errorf("upload: %w (delete document %s: %v)", err, id, delErr)
+		}
+		return client.Document{}, fmt.Errorf("upload: %w", err)
+	}
+	return c.Document(ctx, id)
+}
+
+func cmdImport(ctx context.Context, args []string) error {
+	fs := newFlagSet("import")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	progress := fs.Bool("progress", false, "print progress to stderr")
+	noWait := fs.Bool("nowait", false, "only print the ID of the import instead of waiting for it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one archive is required")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(fs.Arg(0))
+	id, err := c.StartImport(ctx, name, f, info.Size(), progressFunc(*progress, name))
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	if *noWait {
+		fmt.Println(id)
+		return nil
+	}
+
+	imp, err := c.WaitImport(ctx, id, time.Second, progressFunc(*progress, "import"))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if err := printJSON(imp); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tNAME\tSIZE\tERROR")
+		for _, res := range imp.Results {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", orDash(res.ID), res.Name, res.Size, orDash(res.Error))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	switch imp.State {
+	case "failed":
+		return fmt.Errorf("import failed: %s", imp.Error)
+	case "canceled":
+		return fmt.Errorf("import canceled")
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func cmdGet(ctx context.Context, args []string) error {
+	fs := newFlagSet("get")
+	out := fs.String("o", ".", "output directory, or - for stdout")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	progress := fs.Bool("progress", false, "print progress to stderr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	docs, err := resolve(ctx, c, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		for _, doc := range docs {
+			if _, err := c.Download(ctx, doc.ID, os.Stdout, progressFunc(*progress, doc.Name)); err != nil {
+				return fmt.Errorf("%s: %w", doc.Name, err)
+			}
+		}
+		return nil
+	}
+
+	for _, doc := range docs {
+		if err := getFile(ctx, c, doc, *out, *progress); err != nil {
+			return fmt.Errorf("%s: %w", doc.Name, err)
+		}
+	}
+
+	if *asJSON {
+		return printJSON(docs)
+	}
+	return printDocuments(docs)
+}
+
+func getFile(ctx context.Context, c *client.Client, doc client.Document, dir string, progress bool) error {
+	// never write outside of the output directory, no matter what the
+	// document is called
+	name := path.Clean("/" + doc.Name)
+	if name == "/" {
+		return fmt.Errorf("invalid document name")
+	}
+	p := filepath.Join(dir, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Download(ctx, doc.ID, f, progressFunc(progress, doc.Name))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func cmdRemove(ctx context.Context, args []string) error {
+	fs := newFlagSet("rm")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	docs, err := resolve(ctx, c, fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := c.DeleteDocument(ctx, doc.ID); err != nil {
+			return fmt.Errorf("%s: %w", doc.Name, err)
+		}
+	}
+	return nil
+}
+
+func cmdShare(ctx context.Context, args []string) error {
+	fs := newFlagSet("share")
+	user := fs.String("user", "", "user to share the documents with")
+	group := fs.String("group", "", "group to share the documents with")
+	read := fs.Bool("read", false, "allow reading")
+	write := fs.Bool("write", false, "allow writing")
+	del := fs.Bool("delete", false, "allow deleting")
+	share := fs.Bool("share", false, "allow sharing")
+	revoke := fs.Bool("revoke", false, "revoke all permissions of the user or group")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*user == "") == (*group == "") {
+		return fmt.Errorf("exactly one of -user and -group is required")
+	}
+	if !*read && !*write && !*del && !*share && !*revoke {
+		return fmt.Errorf("no permissions given, use -revoke to revoke access")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	docs, err := resolve(ctx, c, fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := c.Share(ctx, doc.ID, client.Permission{
+			Username: *user,
+			Group:    *group,
+			Read:     *read && !*revoke,
+			Write:    *write && !*revoke,
+			Delete:   *del && !*revoke,
+			Share:    *share && !*revoke,
+		}); err != nil {
+			return fmt.Errorf("%s: %w", doc.Name, err)
+		}
+	}
+	return nil
+}
+
+func cmdInfo(ctx context.Context, args []string) error {
+	fs := newFlagSet("info")
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	docs, err := resolve(ctx, c, fs.Args())
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(docs)
+	}
+
+	for i, doc := range docs {
+		if i > 0 {
+			fmt.Println()
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "ID:\t%s\n", doc.ID)
+		_, _ = fmt.Fprintf(w, "Name:\t%s\n", doc.Name)
+		_, _ = fmt.Fprintf(w, "Owner:\t%s\n", doc.Owner)
+		_, _ = fmt.Fprintf(w, "Size:\t%d\n", doc.Size)
+		_, _ = fmt.Fprintf(w, "Created:\t%s\n", doc.Created.Format(time.RFC3339))
+		_, _ = fmt.Fprintf(w, "Updated:\t%s\n", formatUpdated(doc))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdQuota(ctx context.Context, args []string) error {
+	fs := newFlagSet("quota")
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	usage, err := c.Usage(ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(usage)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Bytes:\t%s\n", formatQuota(usage.Bytes))
+	_, _ = fmt.Fprintf(w, "Documents:\t%s\n", formatQuota(usage.Documents))
+	return w.Flush()
+}
+
+func formatQuota(q client.QuotaUsage) string {
+	if q.Limit == 0 {
+		return fmt.Sprintf("%d (unlimited)", q.Used)
+	}
+	return fmt.Sprintf("%d of %d", q.Used, q.Limit)
+}
+
+func cmdJobs(ctx context.Context, args []string) error {
+	fs := newFlagSet("jobs")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	cancel := fs.Bool("cancel", false, "cancel the jobs with the given IDs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *cancel && fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no jobs given")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	if *cancel {
+		for _, id := range fs.Args() {
+			if err := c.CancelJob(ctx, id); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		return nil
+	}
+
+	var jobs []client.Job
+	if fs.NArg() == 0 {
+		jobs, err = c.Jobs(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range fs.Args() {
+		job, err := c.Job(ctx, id)
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		jobs = append(jobs, job)
+	}
+	if *asJSON {
+		return printJSON(jobs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tTYPE\tSTATE\tPROGRESS\tCREATED\tERROR")
+	for _, job := range jobs {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", job.ID, job.Type, job.State, formatProgress(job.Done, job.Total), job.Created.Format(time.RFC3339), orDash(job.Error))
+	}
+	return w.Flush()
+}
+
+func formatProgress(done, total int64) string {
+	if total == 0 {
+		return fmt.Sprintf("%d", done)
+	}
+	return fmt.Sprintf("%d/%d", done, total)
+}
+
+// resolve finds the documents for the given arguments, which are either
+// document IDs or glob patterns that are matched against the document names.
+func resolve(ctx context.Context, c *client.Client, args []string) ([]client.Document, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no documents given")
+	}
+
+	all, err := c.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []client.Document
+	seen := map[string]bool{}
+	for _, arg := range args {
+		found := false
+		for _, doc := range all {
+			match := doc.ID == arg
+			if !match {
+				if match, err = path.Match(arg, doc.Name); err != nil {
+					return nil, fmt.Errorf("%s: %w", arg, err)
+				}
+			}
+			if !match {
+				continue
+			}
+			found = true
+			if !seen[doc.ID] {
+				seen[doc.ID] = true
+				docs = append(docs, doc)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: no such document", arg)
+		}
+	}
+	return docs, nil
+}
+
+func printDocuments(docs []client.Document) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tNAME\tOWNER\tSIZE\tUPDATED")
+	for _, doc := range docs {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", doc.ID, doc.Name, doc.Owner, doc.Size, formatUpdated(doc))
+	}
+	return w.Flush()
+}
+
+func formatUpdated(doc client.Document) string {
+	if doc.Updated == nil {
+		return "-"
+	}
+	return doc.Updated.Format(time.RFC3339)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func progressFunc(enabled bool, name string) client.ProgressFunc {
+	if !enabled {
+		return nil
+	}
+	return func(transferred, total int64) {
+		if total > 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "\r%s: %d/%d bytes (%d%%)", name, transferred, total, transferred*100/total)
+			if transferred == total {
+				_, _ = fmt.Fprintln(os.Stderr)
+			}
+			return
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "\r%s: %d bytes", name, transferred)
+	}
+}
+
+func prompt(rd *bufio.Reader, label string) string {
+	_, _ = fmt.Fprint(os.Stderr, label)
+	line, err := rd.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return ""
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// promptPassword is like prompt, but doesn't echo the input if stdin is a
+// terminal.
+func promptPassword(rd *bufio.Reader, label string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return prompt(rd, label)
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, label)
+	pass, err := term.ReadPassword(fd)
+	// the newline of the input isn't echoed either
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return ""
+	}
+	return string(pass)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/tsatke/verbose-broccoli/pkg/client"
+)
+
+func TestCommandsSuite(t *testing.T) {
+	suite.Run(t, new(CommandsSuite))
+}
+
+type CommandsSuite struct {
+	suite.Suite
+
+	ctx    context.Context
+	server *httptest.Server
+	client *client.Client
+
+	// docs are listed by the server, content is served for their IDs
+	docs    []client.Document
+	content map[string]string
+	// failUpload makes the server reject uploads
+	failUpload bool
+
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (suite *CommandsSuite) SetupTest() {
+	suite.ctx = context.Background()
+	suite.docs = nil
+	suite.content = map[string]string{}
+	suite.failUpload = false
+	suite.deleted = nil
+
+	suite.server = httptest.NewServer(http.HandlerFunc(suite.serveHTTP))
+
+	var err error
+	suite.client, err = client.New(suite.server.URL, client.WithAPIToken("token"))
+	suite.Require().NoError(err)
+}
+
+func (suite *CommandsSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+// serveHTTP is a minimal fake of the REST API of the server.
+func (suite *CommandsSuite) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/rest")
+	switch {
+	case r.Method == http.MethodGet && p == "/doc":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"documents": suite.docs,
+		})
+	case r.Method == http.MethodPost && p == "/doc":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"id":      "new",
+		})
+	case r.Method == http.MethodGet && strings.HasSuffix(p, "/content"):
+		content, ok := suite.content[strings.TrimSuffix(strings.TrimPrefix(p, "/doc/"), "/content")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{
+				"success": false,
+				"code":    "not_found",
+			})
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	case r.Method == http.MethodPost && strings.HasSuffix(p, "/content"):
+		if suite.failUpload {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"success": false,
+				"code":    "internal",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+		})
+	case r.Method == http.MethodDelete && strings.HasPrefix(p, "/doc/"):
+		suite.mu.Lock()
+		suite.deleted = append(suite.deleted, strings.TrimPrefix(p, "/doc/"))
+		suite.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// setenv sets the environment variable for the current test.
+func (suite *CommandsSuite) setenv(key, value string) {
+	old, found := os.LookupEnv(key)
+	suite.Require().NoError(os.Setenv(key, value))
+	suite.T().Cleanup(func() {
+		if found {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func (suite *CommandsSuite) TestResolve() {
+	suite.docs = []client.Document{
+		{ID: "a", Name: "reports/q1.txt"},
+		{ID: "b", Name: "reports/q2.txt"},
+		{ID: "c", Name: "notes.txt"},
+	}
+
+	docs, err := resolve(suite.ctx, suite.client, []string{"reports/*"})
+	suite.NoError(err)
+	suite.Equal(suite.docs[:2], docs)
+
+	// IDs and patterns can be mixed, but every document is only returned once
+	docs, err = resolve(suite.ctx, suite.client, []string{"c", "b", "*.txt"})
+	suite.NoError(err)
+	suite.Equal([]client.Document{suite.docs[2], suite.docs[1]}, docs)
+
+	// the pattern doesn't match across slashes
+	_, err = resolve(suite.ctx, suite.client, []string{"*q1.txt"})
+	suite.EqualError(err, "*q1.txt: no such document")
+
+	_, err = resolve(suite.ctx, suite.client, []string{"["})
+	suite.Error(err)
+
+	_, err = resolve(suite.ctx, suite.client, nil)
+	suite.EqualError(err, "no documents given")
+}
+
+func (suite *CommandsSuite) TestGetFile() {
+	dir := filepath.Join(suite.T().TempDir(), "out")
+	suite.content["a"] = "hello"
+
+	for name, want := range map[string]string{
+		"reports/q1.txt":       "reports/q1.txt",
+		"../../outside.txt":    "outside.txt",
+		"/etc/passwd":          "etc/passwd",
+		"a/../../../other.txt": "other.txt",
+	} {
+		suite.NoError(getFile(suite.ctx, suite.client, client.Document{ID: "a", Name: name}, dir, false), name)
+
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(want)))
+		suite.NoError(err, name)
+		suite.Equal("hello", string(data), name)
+	}
+
+	// nothing was written next to the output directory
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	suite.NoError(err)
+	suite.Len(entries, 1)
+
+	suite.EqualError(getFile(suite.ctx, suite.client, client.Document{ID: "a", Name: ".."}, dir, false), "invalid document name")
+}
+
+func (suite *CommandsSuite) TestPutFileDeletesDocument() {
+	p := filepath.Join(suite.T().TempDir(), "hello.txt")
+	suite.Require().NoError(os.WriteFile(p, []byte("hello"), 0600))
+	suite.failUpload = true
+
+	_, err := putFile(suite.ctx, suite.client, p, "hello.txt", false)
+	suite.Error(err)
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	suite.Equal([]string{"new"}, suite.deleted)
+}
+
+func (suite *CommandsSuite) TestSaveConfig() {
+	p := filepath.Join(suite.T().TempDir(), "broccoli", "config.json")
+	suite.setenv(configEnv, p)
+	suite.setenv(tokenEnv, "")
+
+	_, err := loadConfig()
+	suite.EqualError(err, "not logged in, run 'broccoli login' first")
+
+	c, err := client.New(suite.server.URL)
+	suite.Require().NoError(err)
+	c.HTTPClient().Jar.SetCookies(c.BaseURL(), []*http.Cookie{
+		{Name: "session", Value: "secret"},
+	})
+	suite.Require().NoError(saveSession(c))
+
+	// the file contains the session, so only the user may read it
+	info, err := os.Stat(p)
+	suite.Require().NoError(err)
+	suite.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	cfg, err := loadConfig()
+	suite.NoError(err)
+	suite.Equal(config{
+		Server:  suite.server.URL,
+		Cookies: []storedCookie{{Name: "session", Value: "secret"}},
+	}, cfg)
+
+	c, err = newClient()
+	suite.Require().NoError(err)
+	suite.Equal(suite.server.URL, c.BaseURL().String())
+	cookies := c.HTTPClient().Jar.Cookies(c.BaseURL())
+	suite.Require().Len(cookies, 1)
+	suite.Equal("secret", cookies[0].Value)
+}
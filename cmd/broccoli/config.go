@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/tsatke/verbose-broccoli/pkg/client"
+)
+
+const (
+	configEnv = "BROCCOLI_CONFIG"
+	// tokenEnv and serverEnv allow to use an API token instead of the
+	// session, e.g. in CI jobs
+	tokenEnv  = "BROCCOLI_TOKEN"
+	serverEnv = "BROCCOLI_SERVER"
+)
+
+// config is persisted between invocations, so that only the login command
+// needs credentials.
+type config struct {
+	Server  string         `json:"server"`
+	Cookies []storedCookie `json:"cookies,omitempty"`
+}
+
+type storedCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func configPath() string {
+	if p := os.Getenv(configEnv); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "broccoli", "config.json")
+}
+
+func loadConfig() (config, error) {
+	data, err := os.ReadFile(configPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return config{}, fmt.Errorf("not logged in, run 'broccoli login' first")
+	} else if err != nil {
+		return config{}, fmt.Errorf("read config: %w", err)
+	}
+
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return config{}, fmt.Errorf("parse config %s: %w", configPath(), err)
+	}
+	return cfg, nil
+}
+
+func saveConfig(cfg config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+
+	p := configPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+	// the file contains the session cookie, so only the user may read it
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// newClient creates a client for the configured server that re-uses the
+// session of the last login, or uses the API token from $BROCCOLI_TOKEN.
+func newClient() (*client.Client, error) {
+	if token := os.Getenv(tokenEnv); token != "" {
+		server := os.Getenv(serverEnv)
+		if server == "" {
+			cfg, err := loadConfig()
+			if err != nil {
+				return nil, fmt.Errorf("$%s is set, but $%s isn't: %w", tokenEnv, serverEnv, err)
+			}
+			server = cfg.Server
+		}
+		return client.New(server, client.WithAPIToken(token))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.New(cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := make([]*http.Cookie, len(cfg.Cookies))
+	for i, sc := range cfg.Cookies {
+		cookies[i] = &http.Cookie{
+			Name:  sc.Name,
+			Value: sc.Value,
+		}
+	}
+	c.HTTPClient().Jar.SetCookies(c.BaseURL(), cookies)
+
+	return c, nil
+}
+
+func saveSession(c *client.Client) error {
+	cfg := config{
+		Server: c.BaseURL().String(),
+	}
+	for _, cookie := range c.HTTPClient().Jar.Cookies(c.BaseURL()) {
+		cfg.Cookies = append(cfg.Cookies, storedCookie{
+			Name:  cookie.Name,
+			Value: cookie.Value,
+		})
+	}
+	return saveConfig(cfg)
+}
@@ -0,0 +1,91 @@
+// Command broccoli is a command line client for the document management
+// system, intended for scripting document operations.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	// initialized here, because the commands refer to the usage in this map
+	commands = map[string]command{
+		"login":  {"login -server URL [-user NAME]", cmdLogin},
+		"ls":     {"ls [-json]", cmdList},
+		"put":    {"put [-r] [-json] [-progress] PATH|GLOB...", cmdPut},
+		"get":    {"get [-o DIR|-] [-json] [-progress] ID|GLOB...", cmdGet},
+		"rm":     {"rm ID|GLOB...", cmdRemove},
+		"share":  {"share -user NAME|-group NAME [-read] [-write] [-delete] [-share] [-revoke] ID|GLOB...", cmdShare},
+		"info":   {"info [-json] ID|GLOB...", cmdInfo},
+		"quota":  {"quota [-json]", cmdQuota},
+		"import": {"import [-json] [-progress] [-nowait] ZIP|TAR|TGZ", cmdImport},
+		"jobs":   {"jobs [-json] [-cancel] [ID...]", cmdJobs},
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(context.Background(), flag.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fatal(err)
+	}
+}
+
+func usage() {
+	_, _ = fmt.Fprintln(os.Stderr, "usage: broccoli <command> [flags] [args]")
+	_, _ = fmt.Fprintln(os.Stderr)
+	_, _ = fmt.Fprintln(os.Stderr, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	_, _ = fmt.Fprintln(os.Stderr)
+	_, _ = fmt.Fprintf(os.Stderr, "The session is stored in %s, which can be changed with $%s.\n", configPath(), configEnv)
+	_, _ = fmt.Fprintf(os.Stderr, "To use an API token instead, set $%s and $%s.\n", tokenEnv, serverEnv)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: broccoli %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func fatal(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Scopes of API tokens. Each scope allows the routes and document
+// permissions of the same name.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+	ScopeShare  = "share"
+)
+
+// apiTokenPrefix is prepended to the secret of every API token, so that
+// leaked tokens are easy to recognize.
+const apiTokenPrefix = "vb_"
+
+type APIToken struct {
+	ID    string
+	Name  string
+	Owner string
+	// Hash is the hex encoded SHA-256 hash of the secret. The secret
+	// itself is only shown once when the token is created.
+	Hash    string
+	Scopes  []string
+	Created time.Time
+	Expires time.Time
+}
+
+type APITokenRepo interface {
+	Create(APIToken) error
+	// Get returns the token with the given hash.
+	Get(hash string) (APIToken, error)
+	// List returns the tokens of the given user, ordered by creation.
+	List(owner string) ([]APIToken, error)
+	// Delete deletes the token with the given ID, if it belongs to the
+	// given user.
+	Delete(owner, id string) error
+	// DeleteAll deletes all tokens of the given user.
+	DeleteAll(owner string) error
+}
+
+// hashAPIToken returns the hash of a token secret that is stored in the
+// APITokenRepo or the ShareLinkRepo. The secrets are random, so a plain hash
+// is sufficient.
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t APIToken) hasScope(scope string) bool {
+	return containsString(t.Scopes, scope)
+}
+
+// apiTokenRouteScopes are the routes that can be used with an API token and
+// the scope that they require. All other routes, most notably the
+// management of the tokens themselves, require a session.
+var apiTokenRouteScopes = map[string]string{
+	"GET /rest/user":                      ScopeRead,
+	"GET /rest/doc":                       ScopeRead,
+	"GET /rest/doc/:id":                   ScopeRead,
+	"GET /rest/doc/:id/content":           ScopeRead,
+	"POST /rest/doc":                      ScopeWrite,
+	"POST /rest/doc/archive":              ScopeRead,
+	"POST /rest/doc/import":               ScopeWrite,
+	"GET /rest/doc/import/:id":            ScopeWrite,
+	"POST /rest/doc/:id/content":          ScopeWrite,
+	"GET /rest/doc/:id/content/url":       ScopeRead,
+	"POST /rest/doc/:id/content/url":      ScopeWrite,
+	"POST /rest/doc/:id/content/complete": ScopeWrite,
+	"DELETE /rest/doc/:id":                ScopeDelete,
+	"POST /rest/doc/:id/share":            ScopeShare,
+	"GET /rest/doc/:id/links":             ScopeShare,
+	"POST /rest/doc/:id/links":            ScopeShare,
+	"DELETE /rest/doc/:id/links/:link":    ScopeShare,
+	"GET /rest/jobs":                      ScopeRead,
+	"GET /rest/jobs/:id":                  ScopeRead,
+	"POST /rest/jobs/:id/cancel":          ScopeWrite,
+}
+
+// restrictPermission removes the rights from a permission that the scopes
+// of the token don't allow.
+func (t APIToken) restrictPermission(p Permission) Permission {
+	p.Read = p.Read && t.hasScope(ScopeRead)
+	p.Write = p.Write && t.hasScope(ScopeWrite)
+	p.Delete = p.Delete && t.hasScope(ScopeDelete)
+	p.Share = p.Share && t.hasScope(ScopeShare)
+	return p
+}
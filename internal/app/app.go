@@ -1,15 +1,24 @@
 package app
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
 
+// DefaultAdminGroup is the group of administrators, unless another one is
+// configured with WithAdminGroup.
+const DefaultAdminGroup = "admin"
+
 type App struct {
 	log         zerolog.Logger
 	srv         *http.Server
@@ -21,6 +30,43 @@ type App struct {
 	objects     ObjectStorage
 	documents   DocumentRepo
 	auth        AuthService
+	apiTokens   APITokenRepo
+	shareLinks  ShareLinkRepo
+	quotas      QuotaRepo
+	jobs        JobQueue
+	// defaultQuota applies to users without a quota of their own or of
+	// one of their groups.
+	defaultQuota Quota
+	// adminGroup is the group whose members may use the admin API.
+	adminGroup string
+	// transferTTL is how long transfer URLs are valid, 0 if they are
+	// disabled. transferSecret signs the URLs that the app serves itself,
+	// transferTokens records which of them were used for uploads.
+	transferTTL    time.Duration
+	transferSecret []byte
+	transferTokens TransferTokenRepo
+
+	sessionStore   sessions.Store
+	sessionOptions sessions.Options
+
+	// draining is set once the app shuts down, see Shutdown.
+	draining   int32
+	drainDelay time.Duration
+
+	jobTypes     map[string]jobType
+	jobSchedules []jobSchedule
+	jobWorkers   int
+	jobHeartbeat time.Duration
+	// jobWake wakes up an idle worker when a job is enqueued.
+	jobWake chan struct{}
+
+	jobsMu     sync.Mutex
+	jobsClosed bool
+	// drainJobs stops the workers from claiming jobs, stopJobs interrupts
+	// the running ones.
+	drainJobs   context.CancelFunc
+	stopJobs    context.CancelFunc
+	jobsRunning sync.WaitGroup
 }
 
 func New(lis net.Listener, opts ...Option) *App {
@@ -29,6 +75,14 @@ func New(lis net.Listener, opts ...Option) *App {
 		log:      zerolog.Nop(),
 		genUUID:  uuid.New,
 		clock:    TimeClock{},
+
+		adminGroup:     DefaultAdminGroup,
+		sessionOptions: DefaultSessionOptions,
+
+		jobTypes:     map[string]jobType{},
+		jobWorkers:   defaultJobWorkers,
+		jobHeartbeat: defaultJobHeartbeat,
+		jobWake:      make(chan struct{}, 1),
 	}
 
 	for _, opt := range opts {
@@ -49,21 +103,64 @@ func New(lis net.Listener, opts ...Option) *App {
 	if a.auth == nil {
 		a.auth = NewMemAuthService()
 	}
+	if a.apiTokens == nil {
+		a.apiTokens = NewMemAPITokenRepo()
+	}
+	if a.shareLinks == nil {
+		a.shareLinks = NewMemShareLinkRepo()
+	}
+	if a.quotas == nil {
+		a.quotas = NewMemQuotaRepo()
+	}
+	if a.transferTokens == nil {
+		a.transferTokens = NewMemTransferTokenRepo()
+	}
+	if a.jobs == nil {
+		a.jobs = NewMemJobQueue()
+	}
+	a.jobTypes[JobTypeImport] = jobType{fn: a.runImportJob, maxAttempts: 1}
+	if a.sessionStore == nil {
+		// no keys, so sessions don't survive a restart
+		pairs, _ := sessionKeyPairs(nil)
+		a.sessionStore = cookie.NewStore(pairs...)
+	}
+	a.sessionStore.Options(a.sessionOptions)
 	if a.srv == nil {
+		// there are no read and write timeouts, since uploads, downloads
+		// and archives take as long as their content needs
 		a.srv = &http.Server{
 			Handler:           a.router,
-			ReadTimeout:       30 * time.Second,
 			ReadHeaderTimeout: 30 * time.Second,
-			WriteTimeout:      30 * time.Second,
 			IdleTimeout:       30 * time.Second,
 		}
 	}
+	connContext := a.srv.ConnContext
+	a.srv.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		return context.WithValue(ctx, connKey{}, conn)
+	}
 
 	a.setupCORS()
 	a.setupRoutes()
 	return a
 }
 
+// connKey is the context key of the connection of a request.
+type connKey struct{}
+
+// clearWriteDeadline lets the response of a request take longer than the
+// WriteTimeout of a server given with WithHTTPServer, for responses that are
+// as large as the documents that they contain.
+func clearWriteDeadline(c *gin.Context) error {
+	conn, ok := c.Request.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return nil
+	}
+	return conn.SetWriteDeadline(time.Time{})
+}
+
 func middlewareLogger(a *App) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		path := c.Request.URL.Path
@@ -103,7 +200,10 @@ func middlewareLogger(a *App) func(c *gin.Context) {
 	}
 }
 
+// Run runs the job workers and serves requests until the app is closed.
 func (a *App) Run() error {
+	a.startJobs()
+
 	a.log.
 		Info().
 		IPAddr("host", a.listener.Addr().(*net.TCPAddr).IP).
@@ -115,6 +215,39 @@ func (a *App) Run() error {
 	return nil
 }
 
+// Close closes the server and waits until the job workers stopped. Running
+// jobs are interrupted and retried by another instance, if they may be.
 func (a *App) Close() error {
-	return a.srv.Close()
+	err := a.srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.closeJobs(ctx)
+	return err
+}
+
+// Shutdown reports the app as unhealthy and waits for the drain delay, so
+// that load balancers stop sending requests to it. It then stops accepting
+// requests and waits until the requests in flight and the running jobs are
+// finished or the context is done. Jobs that still run then are interrupted
+// like with Close.
+func (a *App) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&a.draining, 1)
+	a.log.
+		Info().
+		Stringer("delay", a.drainDelay).
+		Msg("drain server")
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(a.drainDelay):
+	}
+
+	jobsClosed := make(chan struct{})
+	go func() {
+		defer close(jobsClosed)
+		a.closeJobs(ctx)
+	}()
+	err := a.srv.Shutdown(ctx)
+	<-jobsClosed
+	return err
 }
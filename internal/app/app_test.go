@@ -2,10 +2,17 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,12 +33,19 @@ func TestAppSuite(t *testing.T) {
 type AppSuite struct {
 	suite.Suite
 
-	app     *App
+	app *App
+	// clock is the clock of the app, which tests set with clock.set, since
+	// the job workers read it concurrently
+	clock   *testClock
 	cookies *cookiejar.Jar
+	// csrfToken is sent with all requests that change something, see
+	// TestRequest.ExpectCustom
+	csrfToken string
 }
 
 func (suite *AppSuite) SetupTest() {
 	suite.cookies, _ = cookiejar.New(nil)
+	suite.csrfToken = ""
 
 	lis, err := nettest.NewLocalListener("tcp")
 	suite.NoError(err)
@@ -41,8 +55,9 @@ func (suite *AppSuite) SetupTest() {
 		Timestamp().
 		Logger()
 
+	suite.clock = &testClock{}
 	var opts []Option
-	opts = append(opts, WithLogger(log))
+	opts = append(opts, WithLogger(log), WithClock(suite.clock))
 
 	pgHost := os.Getenv("PG_HOST")
 	if pgHost != "" {
@@ -59,21 +74,35 @@ func (suite *AppSuite) SetupTest() {
 		suite.NoError(err)
 		suite.Require().NoError(dbProvider.tx(func(tx *sql.Tx) error {
 			_, err := tx.Exec(`
+DELETE FROM au_api_tokens;
+DELETE FROM au_transfer_tokens;
+DELETE FROM au_quotas;
+DELETE FROM au_usage;
+DELETE FROM au_share_links;
 DELETE FROM au_document_acls;
 DELETE FROM au_document_headers;
 `)
 			return err
 		}))
 
-		opts = append(opts, WithDocumentRepo(NewPostgresDocumentRepo(dbProvider)))
+		opts = append(opts,
+			WithDocumentRepo(NewPostgresDocumentRepo(dbProvider)),
+			WithAPITokenRepo(NewPostgresAPITokenRepo(dbProvider)),
+			WithShareLinkRepo(NewPostgresShareLinkRepo(dbProvider)),
+			WithQuotaRepo(NewPostgresQuotaRepo(dbProvider)),
+			WithTransferTokenRepo(NewPostgresTransferTokenRepo(dbProvider)),
+		)
 	}
 
 	suite.app = New(lis, opts...)
 	suite.IsType(&MemObjectStorage{}, suite.app.objects)
 	suite.IsType(&MemAuthService{}, suite.app.auth)
 
+	// don't refer to suite.app in the goroutine, since it might be replaced
+	// by the next test before the goroutine even starts
+	app := suite.app
 	go func() {
-		if err := suite.app.Run(); err != nil {
+		if err := app.Run(); err != nil {
 			panic(err)
 		}
 	}()
@@ -106,16 +135,33 @@ func (suite *AppSuite) login() string {
 		ExpectJSON(http.StatusOK, M{
 			"success": true,
 		})
+	suite.fetchCSRFToken()
 
 	return user
 }
 
+func (suite *AppSuite) fetchCSRFToken() {
+	var res struct {
+		Token string `json:"token"`
+	}
+	suite.
+		Get("/auth/csrf").
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	suite.Require().NotEmpty(res.Token)
+	suite.csrfToken = res.Token
+}
+
 func (suite *AppSuite) logout() {
 	suite.
 		Request("GET", "/auth/logout").
 		ExpectJSON(http.StatusOK, M{
 			"success": true,
 		})
+	suite.csrfToken = ""
 }
 
 func (suite *AppSuite) createUser(user, pass string) {
@@ -125,3 +171,92 @@ func (suite *AppSuite) createUser(user, pass string) {
 func (suite *AppSuite) createContent(id string, content []byte) {
 	suite.NoError(suite.app.objects.(*MemObjectStorage).Create(DocID(id), bytes.NewReader(content)))
 }
+
+func (suite *AppSuite) TestShutdown() {
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "hello.txt",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+	suite.app.drainDelay = 50 * time.Millisecond
+
+	// the upload is still in flight when the app shuts down
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	uploaded := make(chan struct{})
+	go func() {
+		defer close(uploaded)
+		suite.
+			Post("/doc/doc/content").
+			Header("Content-Type", mw.FormDataContentType()).
+			BodyReader(pr).
+			ExpectJSON(http.StatusOK, M{
+				"success": true,
+			})
+	}()
+	w, err := mw.CreateFormFile("file", "hello.txt")
+	suite.Require().NoError(err)
+	_, err = w.Write([]byte("hello"))
+	suite.Require().NoError(err)
+
+	app := suite.app
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- app.Shutdown(context.Background())
+	}()
+	for atomic.LoadInt32(&app.draining) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	suite.
+		Get("/healthcheck").
+		ExpectJSON(http.StatusServiceUnavailable, M{
+			"success": false,
+			"code":    CodeUnavailable,
+			"message": "shutting down",
+		})
+
+	// the upload finishes after the server stopped accepting connections
+	for {
+		conn, err := net.Dial("tcp", app.listener.Addr().String())
+		if err != nil {
+			break
+		}
+		suite.NoError(conn.Close())
+		time.Sleep(time.Millisecond)
+	}
+	suite.NoError(mw.Close())
+	suite.NoError(pw.Close())
+	<-uploaded
+	suite.NoError(<-shutdown)
+
+	rdc, err := app.objects.Read("doc")
+	suite.Require().NoError(err)
+	data, err := io.ReadAll(rdc)
+	suite.NoError(err)
+	suite.NoError(rdc.Close())
+	suite.Equal("hello", string(data))
+}
+
+// testClock is a Clock that can be replaced while it's used. It shows the
+// current time until it's set.
+type testClock struct {
+	mu    sync.Mutex
+	clock Clock
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+func (c *testClock) set(clock Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clock
+}
@@ -1,15 +1,73 @@
 package app
 
+import "time"
+
 //go:generate mockery --inpackage --testonly --case snake --name AuthService --filename auth_service_mock_test.go
 
 type LoginResult struct {
 	Success   bool
 	Challenge string
 	Token     string
+	// RefreshToken can be used to obtain a new token once Token expires.
+	// It is empty if the backend doesn't support refreshing.
+	RefreshToken string
+	// ExpiresAt is when Token expires, zero if unknown.
+	ExpiresAt time.Time
+	// Groups are the groups that the user is a member of, if the backend
+	// knows about groups.
+	Groups []string
+	// Username is the name of the user as the backend knows it, if it may
+	// differ from the name that the user logged in with, e.g. in case.
+	Username string
+}
+
+// user returns the name of the user of a login with the given name.
+func (r LoginResult) user(name string) string {
+	if r.Username != "" {
+		return r.Username
+	}
+	return name
 }
 
 type AuthService interface {
 	Login(user, pass string) (LoginResult, error)
 	AnswerChallenge(user, challenge, payload string) (LoginResult, error)
+	// Refresh obtains a new token for the user with a refresh token of an
+	// earlier login. The result contains the refresh token that has to be
+	// used for the next refresh, which may be the same one.
+	Refresh(user, refreshToken string) (LoginResult, error)
 	TokenValid(string) bool
 }
+
+// GroupResolver is implemented by auth services that can look up the groups
+// of a user without a login. Requests with an API token need it to be
+// authorized by the group entries of ACLs.
+type GroupResolver interface {
+	UserGroups(user string) ([]string, error)
+}
+
+// UserInfo is a user as shown to administrators.
+type UserInfo struct {
+	Username string
+	Enabled  bool
+	Groups   []string
+}
+
+// UserAdmin is implemented by auth services whose users and groups can be
+// managed with the admin API, see HandlerGetUsers.
+type UserAdmin interface {
+	Users() ([]UserInfo, error)
+	CreateUser(user, pass string) error
+	DeleteUser(user string) error
+	// SetUserEnabled disables or enables a user. Disabled users can't log
+	// in, and their sessions end with the next refresh.
+	SetUserEnabled(user string, enabled bool) error
+	SetPassword(user, pass string) error
+
+	Groups() ([]string, error)
+	CreateGroup(group string) error
+	// DeleteGroup deletes a group. Its members stay, but lose the group.
+	DeleteGroup(group string) error
+	AddUserToGroup(user, group string) error
+	RemoveUserFromGroup(user, group string) error
+}
@@ -51,6 +51,27 @@ func (_m *MockAuthService) Login(user string, pass string) (LoginResult, error)
 	return r0, r1
 }
 
+// Refresh provides a mock function with given fields: user, refreshToken
+func (_m *MockAuthService) Refresh(user string, refreshToken string) (LoginResult, error) {
+	ret := _m.Called(user, refreshToken)
+
+	var r0 LoginResult
+	if rf, ok := ret.Get(0).(func(string, string) LoginResult); ok {
+		r0 = rf(user, refreshToken)
+	} else {
+		r0 = ret.Get(0).(LoginResult)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(user, refreshToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // TokenValid provides a mock function with given fields: _a0
 func (_m *MockAuthService) TokenValid(_a0 string) bool {
 	ret := _m.Called(_a0)
@@ -0,0 +1,38 @@
+package app
+
+import "time"
+
+// BlobRepo counts the references to the blobs of DedupObjectStorage, which
+// are identified by the hex encoded SHA-256 hash of their content. Every
+// document with content holds one reference, and so does every upload that
+// is in progress.
+type BlobRepo interface {
+	// Acquire adds a reference to the blob, and reports whether its
+	// content is stored already.
+	Acquire(hash string) (stored bool, err error)
+	// Claim claims storing the content of a blob until the given time,
+	// unless it's stored already or claimed by another upload that didn't
+	// run out of time, so that only one upload stores the content.
+	Claim(hash string, now, until time.Time) (claimed, stored bool, err error)
+	// Unclaim gives up the claim of a blob whose content couldn't be
+	// stored.
+	Unclaim(hash string) error
+	// Stored records that the content of the blob is stored.
+	Stored(hash string) error
+	// Release removes a reference to the blob.
+	Release(hash string) error
+	// Link makes the document refer to the blob, taking over a reference
+	// that was acquired before. The reference to the blob that the
+	// document referred to before, if any, is released.
+	Link(id DocID, hash string) error
+	// Unlink removes the reference of the document.
+	Unlink(DocID) error
+	// Blob returns the hash of the blob that the document refers to.
+	Blob(DocID) (string, error)
+	// Collect calls del for every blob without references and forgets
+	// the ones that del succeeded for. References to a blob can't be
+	// acquired while del runs for it, so that a blob is never deleted
+	// after it was acquired. Blobs that del fails for are skipped, and the
+	// first of their errors is returned once all blobs were tried.
+	Collect(del func(hash string) error) (int, error)
+}
@@ -3,6 +3,9 @@ package app
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	cip "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
@@ -15,6 +18,20 @@ import (
 type cognitoIdentityProviderAPI interface {
 	InitiateAuth(context.Context, *cip.InitiateAuthInput, ...func(*cip.Options)) (*cip.InitiateAuthOutput, error)
 	RespondToAuthChallenge(context.Context, *cip.RespondToAuthChallengeInput, ...func(*cip.Options)) (*cip.RespondToAuthChallengeOutput, error)
+
+	AdminCreateUser(context.Context, *cip.AdminCreateUserInput, ...func(*cip.Options)) (*cip.AdminCreateUserOutput, error)
+	AdminDeleteUser(context.Context, *cip.AdminDeleteUserInput, ...func(*cip.Options)) (*cip.AdminDeleteUserOutput, error)
+	AdminDisableUser(context.Context, *cip.AdminDisableUserInput, ...func(*cip.Options)) (*cip.AdminDisableUserOutput, error)
+	AdminEnableUser(context.Context, *cip.AdminEnableUserInput, ...func(*cip.Options)) (*cip.AdminEnableUserOutput, error)
+	AdminSetUserPassword(context.Context, *cip.AdminSetUserPasswordInput, ...func(*cip.Options)) (*cip.AdminSetUserPasswordOutput, error)
+	AdminAddUserToGroup(context.Context, *cip.AdminAddUserToGroupInput, ...func(*cip.Options)) (*cip.AdminAddUserToGroupOutput, error)
+	AdminRemoveUserFromGroup(context.Context, *cip.AdminRemoveUserFromGroupInput, ...func(*cip.Options)) (*cip.AdminRemoveUserFromGroupOutput, error)
+	AdminListGroupsForUser(context.Context, *cip.AdminListGroupsForUserInput, ...func(*cip.Options)) (*cip.AdminListGroupsForUserOutput, error)
+	ListUsers(context.Context, *cip.ListUsersInput, ...func(*cip.Options)) (*cip.ListUsersOutput, error)
+	ListUsersInGroup(context.Context, *cip.ListUsersInGroupInput, ...func(*cip.Options)) (*cip.ListUsersInGroupOutput, error)
+	ListGroups(context.Context, *cip.ListGroupsInput, ...func(*cip.Options)) (*cip.ListGroupsOutput, error)
+	CreateGroup(context.Context, *cip.CreateGroupInput, ...func(*cip.Options)) (*cip.CreateGroupOutput, error)
+	DeleteGroup(context.Context, *cip.DeleteGroupInput, ...func(*cip.Options)) (*cip.DeleteGroupOutput, error)
 }
 
 type CognitoService struct {
@@ -22,14 +39,20 @@ type CognitoService struct {
 	clientID string
 
 	idProvider cognitoIdentityProviderAPI
+	verifier   *JWTVerifier
 }
 
 func NewCognitoService(cfg config.Config) *CognitoService {
+	poolID := cfg.GetString(config.AWSCognitoPoolID)
+	clientID := cfg.GetString(config.AWSCognitoClientID)
+	issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", cfg.AWS.Region, poolID)
+
 	return &CognitoService{
-		poolID:   cfg.GetString(config.AWSCognitoPoolID),
-		clientID: cfg.GetString(config.AWSCognitoClientID),
+		poolID:   poolID,
+		clientID: clientID,
 
 		idProvider: cip.NewFromConfig(cfg.AWS),
+		verifier:   NewJWTVerifier(issuer+"/.well-known/jwks.json", issuer, clientID),
 	}
 }
 
@@ -43,20 +66,10 @@ func (s *CognitoService) Login(user, pass string) (LoginResult, error) {
 		ClientId: aws.String(s.clientID),
 	})
 	if err != nil {
-		return LoginResult{}, fmt.Errorf("initiate auth: %w", err)
-	}
-
-	if resp.AuthenticationResult != nil {
-		return LoginResult{
-			Success: true,
-			Token:   *resp.AuthenticationResult.IdToken,
-		}, nil
+		return LoginResult{}, fmt.Errorf("initiate auth: %w", cognitoError(err))
 	}
 
-	return LoginResult{
-		Success:   true,
-		Challenge: string(resp.ChallengeName),
-	}, nil
+	return authenticationResult(resp.AuthenticationResult, resp.ChallengeName, ""), nil
 }
 
 func (s *CognitoService) AnswerChallenge(user, challenge, payload string) (LoginResult, error) {
@@ -70,15 +83,298 @@ func (s *CognitoService) AnswerChallenge(user, challenge, payload string) (Login
 	})
 
 	if err != nil {
-		return LoginResult{}, fmt.Errorf("respond to auth: %w", err)
+		return LoginResult{}, fmt.Errorf("respond to auth: %w", cognitoError(err))
+	}
+
+	return authenticationResult(resp.AuthenticationResult, resp.ChallengeName, ""), nil
+}
+
+// Refresh uses the REFRESH_TOKEN_AUTH flow to obtain a new ID token. Cognito
+// doesn't rotate refresh tokens, so the given one is returned again.
+func (s *CognitoService) Refresh(user, refreshToken string) (LoginResult, error) {
+	resp, err := s.idProvider.InitiateAuth(context.Background(), &cip.InitiateAuthInput{
+		AuthFlow: "REFRESH_TOKEN_AUTH",
+		AuthParameters: map[string]string{
+			"REFRESH_TOKEN": refreshToken,
+		},
+		ClientId: aws.String(s.clientID),
+	})
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("initiate auth: %w", cognitoError(err))
+	}
+	if resp.AuthenticationResult == nil {
+		return LoginResult{}, fmt.Errorf("unexpected challenge %v on refresh", resp.ChallengeName)
+	}
+
+	return authenticationResult(resp.AuthenticationResult, "", refreshToken), nil
+}
+
+// authenticationResult converts the result of an authentication flow. If
+// Cognito didn't issue a new refresh token, the given one is used.
+func authenticationResult(res *types.AuthenticationResultType, challenge types.ChallengeNameType, refreshToken string) LoginResult {
+	if res == nil {
+		return LoginResult{
+			Success:   true,
+			Challenge: string(challenge),
+		}
+	}
+
+	result := LoginResult{
+		Success:      true,
+		Token:        aws.ToString(res.IdToken),
+		RefreshToken: refreshToken,
+		Groups:       idTokenGroups(aws.ToString(res.IdToken)),
+	}
+	if res.RefreshToken != nil {
+		result.RefreshToken = *res.RefreshToken
+	}
+	if res.ExpiresIn > 0 {
+		result.ExpiresAt = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	return result
+}
+
+// idTokenGroups returns the groups of the cognito:groups claim of an ID
+// token. The token isn't verified, since it was just received from Cognito.
+func idTokenGroups(token string) []string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil
+	}
+	sort.Strings(claims.Groups)
+	return claims.Groups
+}
+
+// TokenValid reports whether the token is an ID token of the user pool that
+// was issued for this client and has not expired yet.
+func (s *CognitoService) TokenValid(token string) bool {
+	_, err := s.verifier.Verify(token)
+	return err == nil
+}
+
+// cognitoError wraps the errors that are caused by the user instead of
+// Cognito with the matching domain error.
+func cognitoError(err error) error {
+	switch {
+	case smithyCodeIs(err, "NotAuthorizedException"),
+		smithyCodeIs(err, "UserNotFoundException"),
+		smithyCodeIs(err, "CodeMismatchException"),
+		smithyCodeIs(err, "ExpiredCodeException"):
+		return fmt.Errorf("%v: %w", err, ErrUnauthorized)
+	case smithyCodeIs(err, "InvalidParameterException"),
+		smithyCodeIs(err, "InvalidPasswordException"):
+		return fmt.Errorf("%v: %w", err, ErrValidation)
+	}
+	return err
+}
+
+func (s *CognitoService) Users() ([]UserInfo, error) {
+	ctx := context.Background()
+
+	// Cognito doesn't return the groups of listed users, so they are
+	// collected from the members of each group
+	groups, err := s.Groups()
+	if err != nil {
+		return nil, err
+	}
+	memberships := map[string][]string{}
+	for _, group := range groups {
+		p := cip.NewListUsersInGroupPaginator(s.idProvider, &cip.ListUsersInGroupInput{
+			UserPoolId: aws.String(s.poolID),
+			GroupName:  aws.String(group),
+		})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("list users in group: %w", cognitoAdminError(err))
+			}
+			for _, u := range page.Users {
+				name := aws.ToString(u.Username)
+				memberships[name] = append(memberships[name], group)
+			}
+		}
+	}
+
+	var users []UserInfo
+	p := cip.NewListUsersPaginator(s.idProvider, &cip.ListUsersInput{
+		UserPoolId: aws.String(s.poolID),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list users: %w", cognitoAdminError(err))
+		}
+		for _, u := range page.Users {
+			name := aws.ToString(u.Username)
+			users = append(users, UserInfo{
+				Username: name,
+				Enabled:  u.Enabled,
+				Groups:   memberships[name],
+			})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+// CreateUser creates a user with the password as temporary password. The
+// user has to choose a new password with the first login, which results in
+// the NEW_PASSWORD_REQUIRED challenge. No invitation is sent.
+func (s *CognitoService) CreateUser(user, pass string) error {
+	_, err := s.idProvider.AdminCreateUser(context.Background(), &cip.AdminCreateUserInput{
+		UserPoolId:        aws.String(s.poolID),
+		Username:          aws.String(user),
+		TemporaryPassword: aws.String(pass),
+		MessageAction:     types.MessageActionTypeSuppress,
+	})
+	if err != nil {
+		return fmt.Errorf("admin create user: %w", cognitoAdminError(err))
+	}
+	return nil
+}
+
+func (s *CognitoService) DeleteUser(user string) error {
+	_, err := s.idProvider.AdminDeleteUser(context.Background(), &cip.AdminDeleteUserInput{
+		UserPoolId: aws.String(s.poolID),
+		Username:   aws.String(user),
+	})
+	if err != nil {
+		return fmt.Errorf("admin delete user: %w", cognitoAdminError(err))
+	}
+	return nil
+}
+
+func (s *CognitoService) SetUserEnabled(user string, enabled bool) error {
+	var err error
+	if enabled {
+		_, err = s.idProvider.AdminEnableUser(context.Background(), &cip.AdminEnableUserInput{
+			UserPoolId: aws.String(s.poolID),
+			Username:   aws.String(user),
+		})
+	} else {
+		_, err = s.idProvider.AdminDisableUser(context.Background(), &cip.AdminDisableUserInput{
+			UserPoolId: aws.String(s.poolID),
+			Username:   aws.String(user),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("admin set user enabled: %w", cognitoAdminError(err))
+	}
+	return nil
+}
+
+func (s *CognitoService) SetPassword(user, pass string) error {
+	_, err := s.idProvider.AdminSetUserPassword(context.Background(), &cip.AdminSetUserPasswordInput{
+		UserPoolId: aws.String(s.poolID),
+		Username:   aws.String(user),
+		Password:   aws.String(pass),
+		Permanent:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("admin set user password: %w", cognitoAdminError(err))
+	}
+	return nil
+}
+
+func (s *CognitoService) Groups() ([]string, error) {
+	var groups []string
+	p := cip.NewListGroupsPaginator(s.idProvider, &cip.ListGroupsInput{
+		UserPoolId: aws.String(s.poolID),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("list groups: %w", cognitoAdminError(err))
+		}
+		for _, g := range page.Groups {
+			groups = append(groups, aws.ToString(g.GroupName))
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (s *CognitoService) UserGroups(user string) ([]string, error) {
+	var groups []string
+	p := cip.NewAdminListGroupsForUserPaginator(s.idProvider, &cip.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(s.poolID),
+		Username:   aws.String(user),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("admin list groups for user: %w", cognitoAdminError(err))
+		}
+		for _, g := range page.Groups {
+			groups = append(groups, aws.ToString(g.GroupName))
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (s *CognitoService) CreateGroup(group string) error {
+	_, err := s.idProvider.CreateGroup(context.Background(), &cip.CreateGroupInput{
+		UserPoolId: aws.String(s.poolID),
+		GroupName:  aws.String(group),
+	})
+	if err != nil {
+		return fmt.Errorf("create group: %w", cognitoAdminError(err))
 	}
+	return nil
+}
 
-	return LoginResult{
-		Success: true,
-		Token:   *resp.AuthenticationResult.IdToken,
-	}, nil
+func (s *CognitoService) DeleteGroup(group string) error {
+	_, err := s.idProvider.DeleteGroup(context.Background(), &cip.DeleteGroupInput{
+		UserPoolId: aws.String(s.poolID),
+		GroupName:  aws.String(group),
+	})
+	if err != nil {
+		return fmt.Errorf("delete group: %w", cognitoAdminError(err))
+	}
+	return nil
 }
 
-func (s *CognitoService) TokenValid(s2 string) bool {
-	panic("implement me")
+func (s *CognitoService) AddUserToGroup(user, group string) error {
+	_, err := s.idProvider.AdminAddUserToGroup(context.Background(), &cip.AdminAddUserToGroupInput{
+		UserPoolId: aws.String(s.poolID),
+		Username:   aws.String(user),
+		GroupName:  aws.String(group),
+	})
+	if err != nil {
+		return fmt.Errorf("admin add user to group: %w", cognitoAdminError(err))
+	}
+	return nil
+}
+
+func (s *CognitoService) RemoveUserFromGroup(user, group string) error {
+	_, err := s.idProvider.AdminRemoveUserFromGroup(context.Background(), &cip.AdminRemoveUserFromGroupInput{
+		UserPoolId: aws.String(s.poolID),
+		Username:   aws.String(user),
+		GroupName:  aws.String(group),
+	})
+	if err != nil {
+		return fmt.Errorf("admin remove user from group: %w", cognitoAdminError(err))
+	}
+	return nil
+}
+
+// cognitoAdminError is like cognitoError, for the admin API, where unknown
+// users are not an authentication failure.
+func cognitoAdminError(err error) error {
+	switch {
+	case smithyCodeIs(err, "UserNotFoundException"),
+		smithyCodeIs(err, "ResourceNotFoundException"):
+		return fmt.Errorf("%v: %w", err, ErrNotFound)
+	case smithyCodeIs(err, "UsernameExistsException"),
+		smithyCodeIs(err, "GroupExistsException"):
+		return fmt.Errorf("%v: %w", err, ErrConflict)
+	}
+	return cognitoError(err)
 }
@@ -2,11 +2,18 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
@@ -51,7 +58,8 @@ func (suite *CognitoServiceTestSuite) TestLogin() {
 		).
 		Return(&cognitoidentityprovider.InitiateAuthOutput{
 			AuthenticationResult: &types.AuthenticationResultType{
-				IdToken: aws.String("testtoken"),
+				IdToken:      aws.String("testtoken"),
+				RefreshToken: aws.String("refreshtoken"),
 			},
 		}, nil).
 		Once()
@@ -59,9 +67,10 @@ func (suite *CognitoServiceTestSuite) TestLogin() {
 	res, err := suite.service.Login("testuser", "testpass")
 	suite.NoError(err)
 	suite.Equal(LoginResult{
-		Success:   true,
-		Challenge: "",
-		Token:     "testtoken",
+		Success:      true,
+		Challenge:    "",
+		Token:        "testtoken",
+		RefreshToken: "refreshtoken",
 	}, res)
 }
 
@@ -91,3 +100,299 @@ func (suite *CognitoServiceTestSuite) TestLoginWithChallenge() {
 		Token:     "",
 	}, res)
 }
+
+func (suite *CognitoServiceTestSuite) TestLoginInvalidCredentials() {
+	suite.client.
+		On("InitiateAuth",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.InitiateAuthInput{}),
+		).
+		Return(nil, &smithy.GenericAPIError{
+			Code:    "NotAuthorizedException",
+			Message: "Incorrect username or password.",
+		}).
+		Once()
+
+	_, err := suite.service.Login("testuser", "wrongpass")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *CognitoServiceTestSuite) TestAnswerChallengeInvalidParameter() {
+	suite.client.
+		On("RespondToAuthChallenge",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.RespondToAuthChallengeInput{}),
+		).
+		Return(nil, &smithy.GenericAPIError{
+			Code:    "InvalidPasswordException",
+			Message: "Password does not conform to policy.",
+		}).
+		Once()
+
+	_, err := suite.service.AnswerChallenge("testuser", "NEW_PASSWORD_REQUIRED", "short")
+	suite.ErrorIs(err, ErrValidation)
+}
+
+func (suite *CognitoServiceTestSuite) TestTokenValid() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+
+	issuer := "https://cognito-idp.eu-central-1.amazonaws.com/" + suite.poolID
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/.well-known/jwks.json", r.URL.Path)
+		_ = json.NewEncoder(w).Encode(M{
+			"keys": []M{jwk("kid", &key.PublicKey)},
+		})
+	}))
+	defer srv.Close()
+	suite.service.verifier = NewJWTVerifier(srv.URL+"/.well-known/jwks.json", issuer, suite.clientID)
+
+	claims := M{
+		"iss":       issuer,
+		"aud":       suite.clientID,
+		"token_use": "id",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}
+	suite.True(suite.service.TokenValid(signJWT(key, "kid", "RS256", claims)))
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	suite.False(suite.service.TokenValid(signJWT(key, "kid", "RS256", claims)))
+	suite.False(suite.service.TokenValid(""))
+}
+
+func (suite *CognitoServiceTestSuite) TestRefresh() {
+	suite.client.
+		On("InitiateAuth",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.InitiateAuthInput) bool {
+				return suite.EqualValues("REFRESH_TOKEN_AUTH", i.AuthFlow) &&
+					suite.Equal(map[string]string{
+						"REFRESH_TOKEN": "refreshtoken",
+					}, i.AuthParameters) &&
+					suite.Equal(suite.clientID, *i.ClientId)
+			}),
+		).
+		Return(&cognitoidentityprovider.InitiateAuthOutput{
+			AuthenticationResult: &types.AuthenticationResultType{
+				IdToken:   aws.String("newtoken"),
+				ExpiresIn: 3600,
+			},
+		}, nil).
+		Once()
+
+	res, err := suite.service.Refresh("testuser", "refreshtoken")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.Equal("newtoken", res.Token)
+	suite.Equal("refreshtoken", res.RefreshToken)
+	suite.WithinDuration(time.Now().Add(time.Hour), res.ExpiresAt, time.Minute)
+}
+
+func (suite *CognitoServiceTestSuite) TestRefreshRevoked() {
+	suite.client.
+		On("InitiateAuth",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.InitiateAuthInput{}),
+		).
+		Return(nil, &smithy.GenericAPIError{
+			Code:    "NotAuthorizedException",
+			Message: "Refresh Token has been revoked",
+		}).
+		Once()
+
+	_, err := suite.service.Refresh("testuser", "refreshtoken")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *CognitoServiceTestSuite) TestLoginGroups() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	token := signJWT(key, "kid", "RS256", M{
+		"cognito:groups": []string{"users", "admin"},
+	})
+
+	suite.client.
+		On("InitiateAuth",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.InitiateAuthInput{}),
+		).
+		Return(&cognitoidentityprovider.InitiateAuthOutput{
+			AuthenticationResult: &types.AuthenticationResultType{
+				IdToken: aws.String(token),
+			},
+		}, nil).
+		Once()
+
+	res, err := suite.service.Login("testuser", "testpass")
+	suite.NoError(err)
+	suite.Equal([]string{"admin", "users"}, res.Groups)
+}
+
+func (suite *CognitoServiceTestSuite) TestCreateUser() {
+	suite.client.
+		On("AdminCreateUser",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.AdminCreateUserInput) bool {
+				return suite.Equal(suite.poolID, *i.UserPoolId) &&
+					suite.Equal("testuser", *i.Username) &&
+					suite.Equal("testpass", *i.TemporaryPassword) &&
+					suite.Equal(types.MessageActionTypeSuppress, i.MessageAction)
+			}),
+		).
+		Return(&cognitoidentityprovider.AdminCreateUserOutput{}, nil).
+		Once()
+
+	suite.NoError(suite.service.CreateUser("testuser", "testpass"))
+}
+
+func (suite *CognitoServiceTestSuite) TestCreateUserAlreadyExists() {
+	suite.client.
+		On("AdminCreateUser",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.AdminCreateUserInput{}),
+		).
+		Return(nil, &smithy.GenericAPIError{
+			Code:    "UsernameExistsException",
+			Message: "User account already exists.",
+		}).
+		Once()
+
+	suite.ErrorIs(suite.service.CreateUser("testuser", "testpass"), ErrConflict)
+}
+
+func (suite *CognitoServiceTestSuite) TestSetUserEnabled() {
+	suite.client.
+		On("AdminDisableUser",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.AdminDisableUserInput) bool {
+				return suite.Equal(suite.poolID, *i.UserPoolId) &&
+					suite.Equal("testuser", *i.Username)
+			}),
+		).
+		Return(&cognitoidentityprovider.AdminDisableUserOutput{}, nil).
+		Once()
+	suite.client.
+		On("AdminEnableUser",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.AdminEnableUserInput{}),
+		).
+		Return(nil, &smithy.GenericAPIError{
+			Code:    "UserNotFoundException",
+			Message: "User does not exist.",
+		}).
+		Once()
+
+	suite.NoError(suite.service.SetUserEnabled("testuser", false))
+	suite.ErrorIs(suite.service.SetUserEnabled("testuser", true), ErrNotFound)
+}
+
+func (suite *CognitoServiceTestSuite) TestUsers() {
+	suite.client.
+		On("ListGroups",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.ListGroupsInput{}),
+		).
+		Return(&cognitoidentityprovider.ListGroupsOutput{
+			Groups: []types.GroupType{
+				{GroupName: aws.String("admin")},
+			},
+		}, nil).
+		Once()
+	suite.client.
+		On("ListUsersInGroup",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.ListUsersInGroupInput) bool {
+				return suite.Equal("admin", *i.GroupName)
+			}),
+		).
+		Return(&cognitoidentityprovider.ListUsersInGroupOutput{
+			Users: []types.UserType{
+				{Username: aws.String("bob"), Enabled: true},
+			},
+		}, nil).
+		Once()
+	// the users are returned in two pages
+	suite.client.
+		On("ListUsers",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.ListUsersInput) bool {
+				return i.PaginationToken == nil
+			}),
+		).
+		Return(&cognitoidentityprovider.ListUsersOutput{
+			Users: []types.UserType{
+				{Username: aws.String("bob"), Enabled: true},
+			},
+			PaginationToken: aws.String("next"),
+		}, nil).
+		Once()
+	suite.client.
+		On("ListUsers",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.ListUsersInput) bool {
+				return i.PaginationToken != nil && *i.PaginationToken == "next"
+			}),
+		).
+		Return(&cognitoidentityprovider.ListUsersOutput{
+			Users: []types.UserType{
+				{Username: aws.String("alice"), Enabled: false},
+			},
+		}, nil).
+		Once()
+
+	users, err := suite.service.Users()
+	suite.NoError(err)
+	suite.Equal([]UserInfo{
+		{Username: "alice", Enabled: false},
+		{Username: "bob", Enabled: true, Groups: []string{"admin"}},
+	}, users)
+	suite.client.AssertExpectations(suite.T())
+}
+
+func (suite *CognitoServiceTestSuite) TestAddUserToGroup() {
+	suite.client.
+		On("AdminAddUserToGroup",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.AdminAddUserToGroupInput) bool {
+				return *i.UserPoolId == suite.poolID &&
+					*i.Username == "testuser" &&
+					*i.GroupName == "admin"
+			}),
+		).
+		Return(&cognitoidentityprovider.AdminAddUserToGroupOutput{}, nil).
+		Once()
+	suite.client.
+		On("AdminAddUserToGroup",
+			mock.IsType(context.Background()),
+			mock.IsType(&cognitoidentityprovider.AdminAddUserToGroupInput{}),
+		).
+		Return(nil, &smithy.GenericAPIError{
+			Code:    "ResourceNotFoundException",
+			Message: "Group not found.",
+		}).
+		Once()
+
+	suite.NoError(suite.service.AddUserToGroup("testuser", "admin"))
+	suite.ErrorIs(suite.service.AddUserToGroup("testuser", "unknown"), ErrNotFound)
+}
+
+func (suite *CognitoServiceTestSuite) TestUserGroups() {
+	suite.client.
+		On("AdminListGroupsForUser",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *cognitoidentityprovider.AdminListGroupsForUserInput) bool {
+				return *i.Username == "bob"
+			}),
+		).
+		Return(&cognitoidentityprovider.AdminListGroupsForUserOutput{
+			Groups: []types.GroupType{
+				{GroupName: aws.String("editors")},
+				{GroupName: aws.String("admin")},
+			},
+		}, nil).
+		Once()
+
+	groups, err := suite.service.UserGroups("bob")
+	suite.NoError(err)
+	suite.Equal([]string{"admin", "editors"}, groups)
+}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The objects of CompressedObjectStorage start with compressionMagic and the
+// encoding of the content that follows, one of the encoding* values. The
+// encoding is kept in the object rather than beside it, so that it always
+// matches the content, even while the content is replaced.
+const (
+	compressionMagic      = "VBZ1"
+	compressionHeaderSize = len(compressionMagic) + 1
+	// compressionMinSize is the size below which compressing isn't worth it.
+	compressionMinSize = 256
+)
+
+const (
+	encodingIdentity byte = 'i'
+	encodingGzip     byte = 'g'
+)
+
+// compressibleTypes are the prefixes of the MIME types that are compressed.
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// EncodedReader is implemented by object storages that can return the
+// content in the encoding that it's stored in. See HandlerGetContent.
+type EncodedReader interface {
+	// ReadEncoded returns the stored content and its HTTP content coding,
+	// which is empty if the content isn't encoded.
+	ReadEncoded(DocID) (rd io.ReadCloser, encoding string, err error)
+}
+
+// CompressedObjectStorage compresses the content of another object storage
+// with gzip, if its MIME type, which is detected from the start of the
+// content, is one of compressibleTypes.
+type CompressedObjectStorage struct {
+	objects ObjectStorage
+	level   int
+}
+
+var (
+	_ ObjectStorage = (*CompressedObjectStorage)(nil)
+	_ RangeReader   = (*CompressedObjectStorage)(nil)
+	_ EncodedReader = (*CompressedObjectStorage)(nil)
+)
+
+func NewCompressedObjectStorage(objects ObjectStorage) *CompressedObjectStorage {
+	return &CompressedObjectStorage{
+		objects: objects,
+		level:   gzip.DefaultCompression,
+	}
+}
+
+func (s *CompressedObjectStorage) Create(id DocID, rd io.Reader) error {
+	return s.write(rd, func(rd io.Reader) error {
+		return s.objects.Create(id, rd)
+	})
+}
+
+func (s *CompressedObjectStorage) Update(id DocID, rd io.Reader) error {
+	return s.write(rd, func(rd io.Reader) error {
+		return s.objects.Update(id, rd)
+	})
+}
+
+// write passes the encoded content to store.
+func (s *CompressedObjectStorage) write(rd io.Reader, store func(io.Reader) error) error {
+	br := bufio.NewReaderSize(rd, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if !compressible(head) {
+		header := strings.NewReader(compressionMagic + string(encodingIdentity))
+		return store(io.MultiReader(header, br))
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := s.compress(pw, br)
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err = store(pr)
+	// stops the compression if store returned before reading everything
+	_ = pr.Close()
+	if cerr := <-done; cerr != nil && !errors.Is(cerr, io.ErrClosedPipe) {
+		// the error of the content, e.g. ErrQuotaExceeded, rather than
+		// the one that store made of it
+		return cerr
+	}
+	return err
+}
+
+func (s *CompressedObjectStorage) compress(w io.Writer, rd io.Reader) error {
+	if _, err := w.Write([]byte(compressionMagic + string(encodingGzip))); err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(w, s.level)
+	if err != nil {
+		return fmt.Errorf("create gzip writer: %w", err)
+	}
+	if _, err := io.Copy(zw, rd); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func compressible(head []byte) bool {
+	if len(head) < compressionMinSize {
+		return false
+	}
+	contentType := http.DetectContentType(head)
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CompressedObjectStorage) ReadEncoded(id DocID) (io.ReadCloser, string, error) {
+	rd, err := s.objects.Read(id)
+	if err != nil {
+		return nil, "", err
+	}
+	encoding, err := readEncoding(rd)
+	if err != nil {
+		_ = rd.Close()
+		return nil, "", fmt.Errorf("object %v: %w", id, err)
+	}
+	if encoding == encodingGzip {
+		return rd, "gzip", nil
+	}
+	return rd, "", nil
+}
+
+func (s *CompressedObjectStorage) Read(id DocID) (io.ReadCloser, error) {
+	rd, encoding, err := s.ReadEncoded(id)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == "" {
+		return rd, nil
+	}
+
+	zr, err := gzip.NewReader(rd)
+	if err != nil {
+		_ = rd.Close()
+		return nil, fmt.Errorf("object %v: read gzip header: %w", id, err)
+	}
+	return readCloser{zr, rd}, nil
+}
+
+// ReadRange only reads the range of content that isn't compressed, the
+// compressed content is decompressed from the start.
+func (s *CompressedObjectStorage) ReadRange(id DocID, offset, length int64) (io.ReadCloser, error) {
+	rd, err := readRange(s.objects, id, 0, int64(compressionHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := readEncoding(rd)
+	_ = rd.Close()
+	if err != nil {
+		return nil, fmt.Errorf("object %v: %w", id, err)
+	}
+	if encoding == encodingIdentity {
+		return readRange(s.objects, id, int64(compressionHeaderSize)+offset, length)
+	}
+	return readRange(readOnlyStorage{s}, id, offset, length)
+}
+
+func (s *CompressedObjectStorage) Delete(id DocID) error {
+	return s.objects.Delete(id)
+}
+
+func readEncoding(rd io.Reader) (byte, error) {
+	header := make([]byte, compressionHeaderSize)
+	if _, err := io.ReadFull(rd, header); err != nil || !bytes.HasPrefix(header, []byte(compressionMagic)) {
+		return 0, fmt.Errorf("missing compression header")
+	}
+	switch encoding := header[len(compressionMagic)]; encoding {
+	case encodingIdentity, encodingGzip:
+		return encoding, nil
+	default:
+		return 0, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// readOnlyStorage hides the optional interfaces of an object storage, e.g.
+// to make readRange skip content rather than use ReadRange.
+type readOnlyStorage struct {
+	ObjectStorage
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of a request
+// allows the content coding.
+func acceptsEncoding(header, encoding string) bool {
+	// the coding itself takes precedence over the wildcard
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.TrimSpace(params[0])
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name != "*" {
+			return q > 0
+		}
+		wildcard = q > 0
+	}
+	return wildcard
+}
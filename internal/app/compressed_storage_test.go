@@ -0,0 +1,175 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCompressedObjectStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(CompressedObjectStorageTestSuite))
+}
+
+type CompressedObjectStorageTestSuite struct {
+	suite.Suite
+
+	objects *MemObjectStorage
+	storage *CompressedObjectStorage
+}
+
+func (suite *CompressedObjectStorageTestSuite) SetupTest() {
+	suite.objects = NewMemObjectStorage()
+	suite.storage = NewCompressedObjectStorage(suite.objects)
+}
+
+var compressibleContent = strings.Repeat("2021-05-01 12:00:00 INFO request handled\n", 100)
+
+func (suite *CompressedObjectStorageTestSuite) read(id DocID) string {
+	rd, err := suite.storage.Read(id)
+	suite.Require().NoError(err)
+	defer func() {
+		suite.NoError(rd.Close())
+	}()
+	data, err := io.ReadAll(rd)
+	suite.NoError(err)
+	return string(data)
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestText() {
+	suite.NoError(suite.storage.Create("abc", strings.NewReader(compressibleContent)))
+
+	stored := suite.objects.data["abc"]
+	suite.Equal(compressionMagic+"g", string(stored[:compressionHeaderSize]))
+	suite.Less(len(stored), len(compressibleContent)/10)
+	suite.Equal(compressibleContent, suite.read("abc"))
+
+	rd, encoding, err := suite.storage.ReadEncoded("abc")
+	suite.NoError(err)
+	suite.Equal("gzip", encoding)
+	zr, err := gzip.NewReader(rd)
+	suite.NoError(err)
+	data, err := io.ReadAll(zr)
+	suite.NoError(err)
+	suite.Equal(compressibleContent, string(data))
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestNotCompressible() {
+	// PDFs are compressed already, and short content isn't worth it
+	pdf := "%PDF-1.4\n" + strings.Repeat("x", 1000)
+	for id, content := range map[DocID]string{"pdf": pdf, "short": "hello"} {
+		suite.NoError(suite.storage.Create(id, strings.NewReader(content)))
+		suite.Equal(compressionMagic+"i"+content, string(suite.objects.data[id]))
+		suite.Equal(content, suite.read(id))
+
+		rd, encoding, err := suite.storage.ReadEncoded(id)
+		suite.NoError(err)
+		suite.Empty(encoding)
+		suite.NoError(rd.Close())
+	}
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestUpdate() {
+	suite.NoError(suite.storage.Create("abc", strings.NewReader("hello")))
+	suite.NoError(suite.storage.Update("abc", strings.NewReader(compressibleContent)))
+	suite.Equal(compressibleContent, suite.read("abc"))
+
+	err := suite.storage.Update("def", strings.NewReader(compressibleContent))
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestReadRange() {
+	suite.NoError(suite.storage.Create("text", strings.NewReader(compressibleContent)))
+	suite.NoError(suite.storage.Create("short", strings.NewReader("hello world")))
+
+	for id, content := range map[DocID]string{"text": compressibleContent, "short": "hello world"} {
+		rd, err := suite.storage.ReadRange(id, 6, 5)
+		suite.NoError(err)
+		data, err := io.ReadAll(rd)
+		suite.NoError(err)
+		suite.NoError(rd.Close())
+		suite.Equal(content[6:11], string(data))
+	}
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestContentError() {
+	err := suite.storage.Create("abc", io.MultiReader(
+		strings.NewReader(compressibleContent),
+		errorReader{ErrQuotaExceeded},
+	))
+	suite.True(errors.Is(err, ErrQuotaExceeded))
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestStoreError() {
+	suite.NoError(suite.storage.Create("abc", strings.NewReader("hello")))
+	err := suite.storage.Create("abc", strings.NewReader(compressibleContent))
+	suite.True(errors.Is(err, ErrConflict))
+}
+
+func (suite *CompressedObjectStorageTestSuite) TestAcceptsEncoding() {
+	for header, want := range map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, gzip;q=0.5":  true,
+		"GZIP":                 true,
+		"gzip;q=0":             false,
+		"*":                    true,
+		"*;q=0":                false,
+		"gzip;q=0, *":          false,
+		"*;q=0, gzip":          true,
+		"br, deflate":          false,
+		"identity, gzip;q=0.0": false,
+	} {
+		suite.Equal(want, acceptsEncoding(header, "gzip"), header)
+	}
+}
+
+func (suite *AppSuite) TestGetContentCompressed() {
+	suite.app.objects = NewCompressedObjectStorage(NewMemObjectStorage())
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:    "docID",
+		Name:  "log.txt",
+		Owner: user,
+	}, ACL{Permissions: map[Principal]Permission{
+		UserPrincipal(user): {Read: true, Write: true},
+	}}))
+	suite.
+		Post("/doc/docID/content").
+		File("file", "log.txt", []byte(compressibleContent)).
+		ExpectJSON(http.StatusOK, M{"success": true})
+
+	suite.
+		Get("/doc/docID/content").
+		Header("Accept-Encoding", "gzip").
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.Equal("gzip", res.Header.Get("Content-Encoding"))
+			suite.Equal("Accept-Encoding", res.Header.Get("Vary"))
+
+			zr, err := gzip.NewReader(res.Body)
+			suite.Require().NoError(err)
+			data, err := io.ReadAll(zr)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+			suite.Equal(compressibleContent, string(data))
+		})
+
+	suite.
+		Get("/doc/docID/content").
+		Header("Accept-Encoding", "identity").
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.Empty(res.Header.Get("Content-Encoding"))
+
+			data, err := io.ReadAll(res.Body)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+			suite.True(bytes.Equal([]byte(compressibleContent), data))
+		})
+}
@@ -22,6 +22,124 @@ const (
 	PGUsername         = "aws.postgres.username"
 	PGPassword         = "aws.postgres.password"
 	PGDatabase         = "aws.postgres.database"
+
+	// AuthBackend selects the AuthService, one of the Auth* values.
+	AuthBackend       = "app.auth.backend"
+	OIDCIssuer        = "oidc.issuer"
+	OIDCClientID      = "oidc.client.id"
+	OIDCClientSecret  = "oidc.client.secret"
+	OIDCRedirectURL   = "oidc.redirect.url"
+	OIDCScopes        = "oidc.scopes"
+	OIDCUsernameClaim = "oidc.claims.username"
+
+	LDAPURL            = "ldap.url"
+	LDAPStartTLS       = "ldap.starttls"
+	LDAPCAFile         = "ldap.tls.ca"
+	LDAPBindDN         = "ldap.bind.dn"
+	LDAPBindPassword   = "ldap.bind.password"
+	LDAPUserBaseDN     = "ldap.user.base"
+	LDAPUserFilter     = "ldap.user.filter"
+	LDAPUserAttribute  = "ldap.user.attribute"
+	LDAPGroupAttribute = "ldap.group.attribute"
+	// LDAPGroupMapping maps group DNs to the names of the groups in the app.
+	// If it's empty, the first RDN of every group is used as its name.
+	LDAPGroupMapping = "ldap.group.mapping"
+	// LDAPTokenSecret is the key that session tokens are signed with. If
+	// it's empty, a random key is used and sessions end with a restart.
+	LDAPTokenSecret = "ldap.token.secret"
+	LDAPTokenTTL    = "ldap.token.ttl"
+
+	// LocalTokenSecret is like LDAPTokenSecret, for the local backend.
+	LocalTokenSecret = "local.token.secret"
+	LocalTokenTTL    = "local.token.ttl"
+	// LocalTOTPIssuer is the name that authenticator apps show for the app.
+	LocalTOTPIssuer = "local.totp.issuer"
+	// LocalAdminPassword is the password of the first administrator, who
+	// is created if there are no users yet. Nothing is created if it's
+	// empty.
+	LocalAdminPassword = "local.admin.password"
+	LocalAdminUsername = "local.admin.username"
+
+	// AdminGroup is the group whose members may use the admin API.
+	AdminGroup = "app.admin.group"
+
+	// QuotaBytes and QuotaDocuments are the default quota of users that
+	// have no quota of their own or of one of their groups, 0 is unlimited.
+	QuotaBytes     = "app.quota.bytes"
+	QuotaDocuments = "app.quota.documents"
+
+	// TransferURLs enables short-lived URLs that content can be
+	// transferred with directly, e.g. presigned S3 URLs.
+	TransferURLs   = "app.transfer.urls"
+	TransferURLTTL = "app.transfer.ttl"
+	// TransferSecret is the key that the URLs are signed with that the app
+	// serves itself. If it's empty, a random key is used and the URLs
+	// become invalid with a restart.
+	TransferSecret = "app.transfer.secret"
+
+	// EncryptionKeys are the master keys that the keys of the encrypted
+	// document content are encrypted with. Each entry is an ID, followed by
+	// a colon and a base64 encoded key of 32 bytes. The first entry is used
+	// for new keys, the others are only used to read existing keys, which
+	// are re-encrypted with the first one at startup. If it's empty, the
+	// content isn't encrypted.
+	EncryptionKeys = "app.encryption.keys"
+
+	// Dedup stores documents with the same content only once.
+	Dedup = "app.dedup.enabled"
+	// DedupGCInterval is how often the content that no document refers to
+	// anymore is deleted.
+	DedupGCInterval = "app.dedup.gcinterval"
+
+	// Compression compresses the stored content of text documents.
+	Compression = "app.compression.enabled"
+
+	// DrainDelay is how long the healthcheck fails on shutdown before the
+	// server stops accepting requests, so that load balancers notice it.
+	// DrainTimeout is how long requests in flight and running jobs may
+	// take to finish after that, before they are interrupted.
+	DrainDelay   = "app.drain.delay"
+	DrainTimeout = "app.drain.timeout"
+
+	// JobWorkers is how many background jobs, e.g. imports, an instance
+	// runs at the same time.
+	JobWorkers = "app.jobs.workers"
+
+	// SessionStore selects where sessions are stored, one of the
+	// SessionStore* values.
+	SessionStore = "app.session.store"
+	// SessionKeys are the keys that session cookies are signed and
+	// encrypted with. Each entry is a base64 encoded signing key of at
+	// least 32 bytes, optionally followed by a colon and a base64 encoded
+	// encryption key of 16, 24 or 32 bytes. The first entry is used for new
+	// cookies, the others are only used to read existing cookies, which
+	// allows to rotate keys. If it's empty, random keys are used and
+	// sessions end with a restart.
+	SessionKeys           = "app.session.keys"
+	SessionCookieSecure   = "app.session.cookie.secure"
+	SessionCookieHTTPOnly = "app.session.cookie.httponly"
+	// SessionCookieSameSite is one of "lax", "strict" or "none".
+	SessionCookieSameSite = "app.session.cookie.samesite"
+	SessionCookieMaxAge   = "app.session.cookie.maxage"
+	SessionCookieDomain   = "app.session.cookie.domain"
+)
+
+// Values for SessionStore.
+const (
+	// SessionStoreCookie keeps the whole session in the cookie.
+	SessionStoreCookie = "cookie"
+	// SessionStorePostgres keeps the session in the database and only its
+	// ID in the cookie, so that sessions can be revoked.
+	SessionStorePostgres = "postgres"
+)
+
+// Values for AuthBackend.
+const (
+	AuthCognito = "cognito"
+	AuthOIDC    = "oidc"
+	AuthLDAP    = "ldap"
+	// AuthLocal stores users in the database.
+	AuthLocal = "local"
 )
 
 type Config struct {
@@ -47,6 +165,27 @@ func load(readFn func(*viper.Viper) error) (Config, error) {
 	// set default values
 	v.SetDefault(ListenerHost, "localhost")
 	v.SetDefault(ListenerPort, 8080)
+	v.SetDefault(AuthBackend, AuthCognito)
+	v.SetDefault(OIDCScopes, []string{"openid", "profile", "email"})
+	v.SetDefault(OIDCUsernameClaim, "preferred_username")
+	v.SetDefault(LDAPUserFilter, "(&(objectClass=person)(uid=%s))")
+	v.SetDefault(LDAPUserAttribute, "uid")
+	v.SetDefault(LDAPGroupAttribute, "memberOf")
+	v.SetDefault(LDAPTokenTTL, "1h")
+	v.SetDefault(LocalTokenTTL, "1h")
+	v.SetDefault(LocalTOTPIssuer, "verbose-broccoli")
+	v.SetDefault(LocalAdminUsername, "admin")
+	v.SetDefault(AdminGroup, "admin")
+	v.SetDefault(TransferURLTTL, "15m")
+	v.SetDefault(DedupGCInterval, "1h")
+	v.SetDefault(JobWorkers, 2)
+	v.SetDefault(DrainDelay, "5s")
+	v.SetDefault(DrainTimeout, "30s")
+	v.SetDefault(SessionStore, SessionStoreCookie)
+	v.SetDefault(SessionCookieSecure, true)
+	v.SetDefault(SessionCookieHTTPOnly, true)
+	v.SetDefault(SessionCookieSameSite, "lax")
+	v.SetDefault(SessionCookieMaxAge, "24h")
 
 	// bind env
 	v.AutomaticEnv()
@@ -61,17 +200,32 @@ func load(readFn func(*viper.Viper) error) (Config, error) {
 	notSet := func(s string) error {
 		return fmt.Errorf("%v not set", s)
 	}
-	for _, required := range []string{
-		AWSCognitoPoolID,
-		AWSCognitoClientID,
+	required := []string{
 		AWSS3Bucket,
 		PGEndpoint,
 		PGPort,
 		PGUsername,
 		PGPassword,
-	} {
-		if !v.IsSet(required) {
-			return Config{}, notSet(required)
+	}
+	switch backend := v.GetString(AuthBackend); backend {
+	case AuthCognito:
+		required = append(required, AWSCognitoPoolID, AWSCognitoClientID)
+	case AuthOIDC:
+		required = append(required, OIDCIssuer, OIDCClientID, OIDCRedirectURL)
+	case AuthLDAP:
+		required = append(required, LDAPURL, LDAPUserBaseDN)
+	case AuthLocal:
+	default:
+		return Config{}, fmt.Errorf("unknown %v %q", AuthBackend, backend)
+	}
+	switch store := v.GetString(SessionStore); store {
+	case SessionStoreCookie, SessionStorePostgres:
+	default:
+		return Config{}, fmt.Errorf("unknown %v %q", SessionStore, store)
+	}
+	for _, key := range required {
+		if !v.IsSet(key) {
+			return Config{}, notSet(key)
 		}
 	}
 
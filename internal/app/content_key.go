@@ -0,0 +1,24 @@
+package app
+
+// WrappedKey is the data key of a document, encrypted with a master key.
+type WrappedKey struct {
+	// MasterKeyID identifies the master key that Key is encrypted with.
+	MasterKeyID string
+	Key         []byte
+}
+
+// ContentKeyRepo stores the data keys of EncryptedObjectStorage. There can
+// be keys of documents without a header, because objects are stored before
+// their headers.
+type ContentKeyRepo interface {
+	// Create stores the key of a document that has none yet, ErrConflict
+	// if it has one.
+	Create(DocID, WrappedKey) error
+	// Set stores the key of a document, replacing any existing key.
+	Set(DocID, WrappedKey) error
+	Get(DocID) (WrappedKey, error)
+	Delete(DocID) error
+	// Stale returns the IDs of the documents whose keys are encrypted with
+	// another than the given master key.
+	Stale(masterKeyID string) ([]DocID, error)
+}
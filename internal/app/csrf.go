@@ -0,0 +1,119 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// CSRFTokenHeader is the header that requests which change something
+	// have to send the token of HandlerCSRFToken in.
+	CSRFTokenHeader = "X-CSRF-Token"
+	csrfTokenKey    = "CSRFToken"
+)
+
+// middlewareCSRF protects the routes that use the session cookie against
+// cross-site request forgery. Requests that change something must come
+// from this server or one of the CORS origins, and must send the token of
+// the session in the CSRFTokenHeader (synchronizer token pattern).
+// Requests with an API token don't use the cookie, so they are exempt.
+func (a *App) middlewareCSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if _, ok := currentAPIToken(c); ok {
+			return
+		}
+
+		if err := a.checkOrigin(c.Request); err != nil {
+			abortWithError(c, err, "cross-site request")
+			return
+		}
+
+		switch c.FullPath() {
+		case "/rest/auth/login",
+			"/rest/auth/challenge":
+			// there's no session yet
+			return
+		case "/rest/dav",
+			"/rest/dav/*path":
+			// WebDAV clients can't send the token
+			return
+		case "/rest/transfer/:token":
+			// the signed URL is the credential
+			return
+		}
+
+		token, _ := sessions.Default(c).Get(csrfTokenKey).(string)
+		got := c.GetHeader(CSRFTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(got)) != 1 {
+			abortWithError(c, ErrForbidden, "invalid CSRF token")
+			return
+		}
+	}
+}
+
+// checkOrigin checks that the Origin, or if there is none the Referer, of a
+// request is this server or one of the CORS origins. Requests without both
+// headers don't come from a browser.
+func (a *App) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return nil
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return fmt.Errorf("invalid referer: %w", ErrForbidden)
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return nil
+	}
+	for _, allowed := range a.corsOrigins {
+		if origin == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q: %w", origin, ErrForbidden)
+}
+
+// HandlerCSRFToken returns the CSRF token of the session, see
+// middlewareCSRF.
+func (a *App) HandlerCSRFToken() gin.HandlerFunc {
+	type response struct {
+		Success bool   `json:"success"`
+		Token   string `json:"token"`
+	}
+	return func(c *gin.Context) {
+		sess := sessions.Default(c)
+		token, _ := sess.Get(csrfTokenKey).(string)
+		if token == "" {
+			var err error
+			if token, err = randomToken(); err != nil {
+				abortWithError(c, err, "unable to create CSRF token")
+				return
+			}
+			sess.Set(csrfTokenKey, token)
+			if err := sess.Save(); err != nil {
+				abortWithError(c, err, "unable to save session")
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Token:   token,
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (suite *AppSuite) TestCSRFMissingToken() {
+	_ = suite.login()
+	suite.csrfToken = ""
+
+	suite.
+		Post("/doc").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "invalid CSRF token",
+		})
+}
+
+func (suite *AppSuite) TestCSRFWrongToken() {
+	_ = suite.login()
+
+	suite.
+		Post("/doc").
+		Header(CSRFTokenHeader, "wrong").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "invalid CSRF token",
+		})
+}
+
+func (suite *AppSuite) TestCSRFTokenStable() {
+	_ = suite.login()
+	token := suite.csrfToken
+
+	suite.fetchCSRFToken()
+	suite.Equal(token, suite.csrfToken)
+}
+
+func (suite *AppSuite) TestCSRFTokenRotatesOnLogin() {
+	_ = suite.login()
+	token := suite.csrfToken
+
+	// log in as another user without logging out
+	_ = suite.login()
+	suite.NotEqual(token, suite.csrfToken)
+
+	suite.
+		Post("/doc").
+		Header(CSRFTokenHeader, token).
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "invalid CSRF token",
+		})
+}
+
+func (suite *AppSuite) TestCSRFCrossOrigin() {
+	_ = suite.login()
+
+	suite.
+		Post("/doc").
+		Header("Origin", "https://evil.example").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "cross-site request",
+		})
+	suite.
+		Post("/doc").
+		Header("Referer", "https://evil.example/page").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "cross-site request",
+		})
+
+	// login CSRF
+	user, pass := uuid.New().String(), uuid.New().String()
+	suite.createUser(user, pass)
+	suite.
+		Post("/auth/login").
+		Header("Origin", "https://evil.example").
+		BodyJSON(M{
+			"username": user,
+			"password": pass,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "cross-site request",
+		})
+}
+
+func (suite *AppSuite) TestCSRFAllowedOrigin() {
+	_ = suite.login()
+	suite.app.corsOrigins = []string{"https://docs.example.com"}
+
+	suite.
+		Post("/doc").
+		Header("Origin", "https://docs.example.com").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.NoError(res.Body.Close())
+		})
+	suite.
+		Post("/doc").
+		Header("Origin", "http://"+suite.app.listener.Addr().String()).
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.NoError(res.Body.Close())
+		})
+}
+
+func (suite *AppSuite) TestCSRFAPITokenExempt() {
+	_ = suite.login()
+	token := suite.createToken("write")
+	suite.logout()
+
+	suite.
+		Post("/doc").
+		Header("Authorization", "Bearer "+token).
+		Header("Origin", "https://evil.example").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.NoError(res.Body.Close())
+		})
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// blobClaim is how long an upload may take to store the content of a
+	// blob before another upload of the same content takes over.
+	blobClaim = time.Hour
+	// blobPollInterval is how often uploads check whether the content that
+	// another upload stores is stored.
+	blobPollInterval = 100 * time.Millisecond
+)
+
+// DedupObjectStorage stores the content of documents as blobs in another
+// object storage, one per distinct content, which are identified by the
+// SHA-256 hash of the content. Documents with the same content share the
+// blob, and the content of a blob is only stored once. Blobs that no
+// document refers to anymore are deleted by CollectGarbage.
+//
+// The content is buffered in a temporary file to hash it before it's
+// stored. Like EncryptedObjectStorage, it isn't a URLPresigner, since
+// clients must not store content past the hashing.
+type DedupObjectStorage struct {
+	objects      ObjectStorage
+	blobs        BlobRepo
+	tempDir      string
+	pollInterval time.Duration
+}
+
+var (
+	_ ObjectStorage = (*DedupObjectStorage)(nil)
+	_ RangeReader   = (*DedupObjectStorage)(nil)
+)
+
+func NewDedupObjectStorage(objects ObjectStorage, blobs BlobRepo) *DedupObjectStorage {
+	return &DedupObjectStorage{
+		objects:      objects,
+		blobs:        blobs,
+		pollInterval: blobPollInterval,
+	}
+}
+
+// blobID is the ID of the object of a blob in the underlying storage.
+func blobID(hash string) DocID {
+	return DocID("blob/" + hash)
+}
+
+func (s *DedupObjectStorage) Create(id DocID, rd io.Reader) error {
+	if _, err := s.blobs.Blob(id); err == nil {
+		return fmt.Errorf("object %v: %w", id, ErrConflict)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return s.store(id, rd)
+}
+
+func (s *DedupObjectStorage) Update(id DocID, rd io.Reader) error {
+	if _, err := s.blobs.Blob(id); err != nil {
+		return err
+	}
+	return s.store(id, rd)
+}
+
+// store stores the blob of the content, unless it's stored already, and
+// makes the document refer to it.
+func (s *DedupObjectStorage) store(id DocID, rd io.Reader) error {
+	f, err := os.CreateTemp(s.tempDir, "blob-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), rd); err != nil {
+		return err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	stored, err := s.blobs.Acquire(hash)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		if err := s.awaitBlob(hash, f); err != nil {
+			_ = s.blobs.Release(hash)
+			return err
+		}
+	}
+	if err := s.blobs.Link(id, hash); err != nil {
+		_ = s.blobs.Release(hash)
+		return err
+	}
+	return nil
+}
+
+// awaitBlob stores the content of the blob if no other upload of the same
+// content does, or waits until the other upload stored it. Only one upload
+// may store it, since the content may be encrypted with a key that is
+// created for the blob, see EncryptedObjectStorage.
+func (s *DedupObjectStorage) awaitBlob(hash string, f *os.File) error {
+	for {
+		now := time.Now()
+		claimed, stored, err := s.blobs.Claim(hash, now, now.Add(blobClaim))
+		if err != nil {
+			return err
+		}
+		if stored {
+			return nil
+		}
+		if claimed {
+			if err := s.storeBlob(hash, f); err != nil {
+				_ = s.blobs.Unclaim(hash)
+				return err
+			}
+			return nil
+		}
+		time.Sleep(s.pollInterval)
+	}
+}
+
+func (s *DedupObjectStorage) storeBlob(hash string, f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind temp file: %w", err)
+	}
+	// the object is left over if an upload whose claim ran out stored it
+	// after all or failed halfway, so it's overwritten
+	err := s.objects.Create(blobID(hash), f)
+	if errors.Is(err, ErrConflict) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewind temp file: %w", err)
+		}
+		err = s.objects.Update(blobID(hash), f)
+	}
+	if err != nil {
+		return err
+	}
+	return s.blobs.Stored(hash)
+}
+
+func (s *DedupObjectStorage) Read(id DocID) (io.ReadCloser, error) {
+	hash, err := s.blobs.Blob(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.objects.Read(blobID(hash))
+}
+
+func (s *DedupObjectStorage) ReadRange(id DocID, offset, length int64) (io.ReadCloser, error) {
+	hash, err := s.blobs.Blob(id)
+	if err != nil {
+		return nil, err
+	}
+	return readRange(s.objects, blobID(hash), offset, length)
+}
+
+// Delete only removes the reference of the document, the blob is deleted
+// by CollectGarbage.
+func (s *DedupObjectStorage) Delete(id DocID) error {
+	err := s.blobs.Unlink(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// CollectGarbage deletes the blobs that no document refers to, and returns
+// how many there were. Blobs that can't be deleted are skipped, so that they
+// don't keep the others from being deleted.
+func (s *DedupObjectStorage) CollectGarbage() (int, error) {
+	return s.blobs.Collect(func(hash string) error {
+		err := s.objects.Delete(blobID(hash))
+		if errors.Is(err, ErrNotFound) {
+			// the upload that stored it failed
+			return nil
+		}
+		return err
+	})
+}
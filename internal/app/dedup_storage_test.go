@@ -0,0 +1,271 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDedupObjectStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(DedupObjectStorageTestSuite))
+}
+
+type DedupObjectStorageTestSuite struct {
+	suite.Suite
+
+	objects *MemObjectStorage
+	blobs   *MemBlobRepo
+	storage *DedupObjectStorage
+}
+
+func (suite *DedupObjectStorageTestSuite) SetupTest() {
+	suite.objects = NewMemObjectStorage()
+	suite.blobs = NewMemBlobRepo()
+	suite.storage = NewDedupObjectStorage(suite.objects, suite.blobs)
+	suite.storage.tempDir = suite.T().TempDir()
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func (suite *DedupObjectStorageTestSuite) read(id DocID) string {
+	rd, err := suite.storage.Read(id)
+	suite.Require().NoError(err)
+	defer func() {
+		suite.NoError(rd.Close())
+	}()
+	data, err := io.ReadAll(rd)
+	suite.NoError(err)
+	return string(data)
+}
+
+func (suite *DedupObjectStorageTestSuite) TestSameContent() {
+	suite.NoError(suite.storage.Create("a", strings.NewReader("hello")))
+	suite.NoError(suite.storage.Create("b", strings.NewReader("hello")))
+
+	suite.Len(suite.objects.data, 1)
+	suite.Equal("hello", string(suite.objects.data[blobID(sha256Hex("hello"))]))
+	suite.Equal("hello", suite.read("a"))
+	suite.Equal("hello", suite.read("b"))
+	suite.EqualValues(2, suite.blobs.blobs[sha256Hex("hello")].refs)
+}
+
+func (suite *DedupObjectStorageTestSuite) TestCreateConflict() {
+	suite.NoError(suite.storage.Create("a", strings.NewReader("hello")))
+	err := suite.storage.Create("a", strings.NewReader("world"))
+	suite.True(errors.Is(err, ErrConflict))
+	suite.Equal("hello", suite.read("a"))
+}
+
+func (suite *DedupObjectStorageTestSuite) TestUpdate() {
+	suite.NoError(suite.storage.Create("a", strings.NewReader("hello")))
+	suite.NoError(suite.storage.Update("a", strings.NewReader("world")))
+	suite.Equal("world", suite.read("a"))
+
+	// the same content again keeps the single reference
+	suite.NoError(suite.storage.Update("a", strings.NewReader("world")))
+	suite.EqualValues(1, suite.blobs.blobs[sha256Hex("world")].refs)
+	suite.EqualValues(0, suite.blobs.blobs[sha256Hex("hello")].refs)
+
+	err := suite.storage.Update("b", strings.NewReader("world"))
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *DedupObjectStorageTestSuite) TestReadRange() {
+	suite.NoError(suite.storage.Create("a", strings.NewReader("hello world")))
+
+	rd, err := suite.storage.ReadRange("a", 6, 3)
+	suite.NoError(err)
+	data, err := io.ReadAll(rd)
+	suite.NoError(err)
+	suite.Equal("wor", string(data))
+}
+
+func (suite *DedupObjectStorageTestSuite) TestCollectGarbage() {
+	suite.NoError(suite.storage.Create("a", strings.NewReader("hello")))
+	suite.NoError(suite.storage.Create("b", strings.NewReader("hello")))
+	suite.NoError(suite.storage.Create("c", strings.NewReader("world")))
+
+	suite.NoError(suite.storage.Delete("a"))
+	suite.NoError(suite.storage.Delete("c"))
+	_, err := suite.storage.Read("a")
+	suite.True(errors.Is(err, ErrNotFound))
+
+	n, err := suite.storage.CollectGarbage()
+	suite.NoError(err)
+	suite.Equal(1, n)
+	suite.Len(suite.objects.data, 1)
+	suite.Equal("hello", suite.read("b"))
+
+	// a deleted blob is stored again
+	suite.NoError(suite.storage.Create("d", strings.NewReader("world")))
+	suite.Equal("world", suite.read("d"))
+}
+
+func (suite *DedupObjectStorageTestSuite) TestCollectGarbageSkipsFailed() {
+	suite.NoError(suite.storage.Create("a", strings.NewReader("hello")))
+	suite.NoError(suite.storage.Create("b", strings.NewReader("world")))
+	suite.NoError(suite.storage.Delete("a"))
+	suite.NoError(suite.storage.Delete("b"))
+
+	broken := errors.New("broken")
+	suite.storage.objects = failingDeleteStorage{suite.objects, blobID(sha256Hex("hello")), broken}
+	n, err := suite.storage.CollectGarbage()
+	suite.ErrorIs(err, broken)
+	suite.Equal(1, n)
+	suite.Len(suite.objects.data, 1)
+	suite.Contains(suite.blobs.blobs, sha256Hex("hello"))
+	suite.NotContains(suite.blobs.blobs, sha256Hex("world"))
+}
+
+func (suite *DedupObjectStorageTestSuite) TestFailedUpload() {
+	err := suite.storage.Create("a", io.MultiReader(
+		strings.NewReader("hello"),
+		errorReader{fmt.Errorf("broken")},
+	))
+	suite.Error(err)
+	_, err = suite.storage.Read("a")
+	suite.True(errors.Is(err, ErrNotFound))
+	suite.Empty(suite.blobs.blobs)
+}
+
+func (suite *DedupObjectStorageTestSuite) TestConcurrent() {
+	objects := &lockedObjectStorage{objects: suite.objects}
+	suite.storage.objects = objects
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		id := DocID(fmt.Sprintf("doc%d", i))
+		go func() {
+			defer wg.Done()
+			suite.NoError(suite.storage.Create(id, bytes.NewReader([]byte("same"))))
+			suite.NoError(suite.storage.Delete(id))
+			suite.NoError(suite.storage.Create(id, bytes.NewReader([]byte("same"))))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := suite.storage.CollectGarbage()
+			suite.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		suite.Equal("same", suite.read(DocID(fmt.Sprintf("doc%d", i))))
+	}
+	suite.EqualValues(20, suite.blobs.blobs[sha256Hex("same")].refs)
+}
+
+func (suite *DedupObjectStorageTestSuite) TestWaitsForClaim() {
+	suite.storage.pollInterval = time.Millisecond
+	hash := sha256Hex("hello")
+
+	// another upload of the same content stores the blob
+	_, err := suite.blobs.Acquire(hash)
+	suite.Require().NoError(err)
+	claimed, _, err := suite.blobs.Claim(hash, time.Now(), time.Now().Add(time.Hour))
+	suite.Require().NoError(err)
+	suite.Require().True(claimed)
+
+	created := make(chan error, 1)
+	go func() {
+		created <- suite.storage.Create("a", strings.NewReader("hello"))
+	}()
+	select {
+	case err := <-created:
+		suite.Failf("created while the blob is claimed", "%v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	suite.NoError(suite.objects.Create(blobID(hash), strings.NewReader("hello")))
+	suite.NoError(suite.blobs.Stored(hash))
+	suite.NoError(<-created)
+	suite.Equal("hello", suite.read("a"))
+	suite.EqualValues(2, suite.blobs.blobs[hash].refs)
+}
+
+func (suite *DedupObjectStorageTestSuite) TestTakesOverClaim() {
+	suite.storage.pollInterval = time.Millisecond
+	hash := sha256Hex("hello")
+
+	_, err := suite.blobs.Acquire(hash)
+	suite.Require().NoError(err)
+	_, _, err = suite.blobs.Claim(hash, time.Now(), time.Now().Add(time.Hour))
+	suite.Require().NoError(err)
+
+	created := make(chan error, 1)
+	go func() {
+		created <- suite.storage.Create("a", strings.NewReader("hello"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the other upload failed
+	suite.NoError(suite.blobs.Unclaim(hash))
+	suite.NoError(suite.blobs.Release(hash))
+	suite.NoError(<-created)
+	suite.Equal("hello", suite.read("a"))
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// lockedObjectStorage makes a MemObjectStorage safe for concurrent use.
+type lockedObjectStorage struct {
+	mu      sync.Mutex
+	objects *MemObjectStorage
+}
+
+func (s *lockedObjectStorage) Create(id DocID, rd io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects.Create(id, rd)
+}
+
+func (s *lockedObjectStorage) Read(id DocID) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects.Read(id)
+}
+
+func (s *lockedObjectStorage) Update(id DocID, rd io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects.Update(id, rd)
+}
+
+func (s *lockedObjectStorage) Delete(id DocID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects.Delete(id)
+}
+
+// failingDeleteStorage fails to delete one object.
+type failingDeleteStorage struct {
+	ObjectStorage
+	id  DocID
+	err error
+}
+
+func (s failingDeleteStorage) Delete(id DocID) error {
+	if id == s.id {
+		return s.err
+	}
+	return s.ObjectStorage.Delete(id)
+}
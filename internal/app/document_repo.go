@@ -9,27 +9,91 @@ type (
 		ID      DocID
 		Name    string
 		Owner   string
+		Size    int64
 		Created time.Time
 		Updated time.Time
 	}
 
 	ACL struct {
-		Permissions map[string]Permission
+		Permissions map[Principal]Permission
 	}
 
 	Permission struct {
-		Username string
-		Read     bool
-		Write    bool
-		Delete   bool
-		Share    bool
+		Principal Principal
+		Read      bool
+		Write     bool
+		Delete    bool
+		Share     bool
 	}
+
+	// Principal is who a Permission is granted to, either a single user or
+	// all members of a group.
+	Principal struct {
+		Type PrincipalType
+		Name string
+	}
+
+	PrincipalType string
+)
+
+const (
+	PrincipalUser  PrincipalType = "user"
+	PrincipalGroup PrincipalType = "group"
 )
 
+func UserPrincipal(name string) Principal {
+	return Principal{Type: PrincipalUser, Name: name}
+}
+
+func GroupPrincipal(name string) Principal {
+	return Principal{Type: PrincipalGroup, Name: name}
+}
+
+// Effective combines the permissions that are granted to the user and to any
+// of the groups. ok is false if none of them is in the ACL.
+func (acl ACL) Effective(user string, groups []string) (perm Permission, ok bool) {
+	perm, ok = acl.Permissions[UserPrincipal(user)]
+	for _, g := range groups {
+		p, found := acl.Permissions[GroupPrincipal(g)]
+		if !found {
+			continue
+		}
+		ok = true
+		perm.Read = perm.Read || p.Read
+		perm.Write = perm.Write || p.Write
+		perm.Delete = perm.Delete || p.Delete
+		perm.Share = perm.Share || p.Share
+	}
+	perm.Principal = UserPrincipal(user)
+	return perm, ok
+}
+
 type DocumentRepo interface {
 	Create(DocumentHeader, ACL) error
 	Update(DocumentHeader, ACL) error
 	Get(DocID) (DocumentHeader, error)
 	Delete(DocID) error
 	ACL(DocID) (ACL, error)
+	// List returns the headers of all documents that the given
+	// user or any of the groups is allowed to read.
+	List(username string, groups []string) ([]DocumentHeader, error)
+	// Usage returns the storage that the documents of the owner take up.
+	// It's tracked when headers are created, updated and deleted.
+	Usage(owner string) (Usage, error)
+}
+
+// ownerACL returns an ACL that grants all permissions to the given user
+// and nobody else.
+func ownerACL(user string) ACL {
+	return ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal(user): {
+				Principal: UserPrincipal(user),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
+			},
+		},
+	}
 }
@@ -0,0 +1,492 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+// The content of encrypted objects starts with encryptionMagic and a random
+// nonce prefix, followed by the chunks of the content, each encrypted with
+// AES-GCM. The nonce of a chunk is the prefix, followed by the index of the
+// chunk, whose highest bit is set for the last chunk, so that chunks can't
+// be reordered and truncated content is detected.
+const (
+	encryptionMagic       = "VBE1"
+	encryptionNoncePrefix = 8
+	encryptionHeaderSize  = len(encryptionMagic) + encryptionNoncePrefix
+	encryptionChunkSize   = 64 * 1024
+	encryptionFinalChunk  = 1 << 31
+	dataKeySize           = 32
+)
+
+var errDecrypt = errors.New("decrypt content")
+
+// MasterKey encrypts the data keys of EncryptedObjectStorage.
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseMasterKeys parses the keys of config.EncryptionKeys.
+func ParseMasterKeys(entries []string) ([]MasterKey, error) {
+	keys := make([]MasterKey, 0, len(entries))
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("encryption key %d: must be ID:KEY", i)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("encryption key %d: duplicate ID %v", i, parts[0])
+		}
+		seen[parts[0]] = true
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: decode: %w", i, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %d: must have 32 bytes", i)
+		}
+		keys = append(keys, MasterKey{ID: parts[0], Key: key})
+	}
+	return keys, nil
+}
+
+// EncryptedObjectStorage encrypts the content of another object storage.
+// Every document has its own data key, which is encrypted with a master key
+// and stored in a ContentKeyRepo. New data keys are encrypted with the first
+// master key, the others are only used to decrypt existing data keys until
+// RotateKeys re-encrypted them.
+//
+// It deliberately isn't a URLPresigner or an ObjectStater, since clients
+// must not transfer content past the encryption, and the stored objects
+// differ from the content.
+type EncryptedObjectStorage struct {
+	objects   ObjectStorage
+	keys      ContentKeyRepo
+	master    []MasterKey
+	chunkSize int
+}
+
+var (
+	_ ObjectStorage = (*EncryptedObjectStorage)(nil)
+	_ RangeReader   = (*EncryptedObjectStorage)(nil)
+)
+
+func NewEncryptedObjectStorage(objects ObjectStorage, keys ContentKeyRepo, master []MasterKey) (*EncryptedObjectStorage, error) {
+	if len(master) == 0 {
+		return nil, fmt.Errorf("no master key")
+	}
+	return &EncryptedObjectStorage{
+		objects:   objects,
+		keys:      keys,
+		master:    master,
+		chunkSize: encryptionChunkSize,
+	}, nil
+}
+
+// NewEncryptedObjectStorageFromConfig encrypts the object storage with the
+// keys of config.EncryptionKeys. Without keys, the object storage is
+// returned as it is.
+func NewEncryptedObjectStorageFromConfig(cfg config.Config, objects ObjectStorage, keys ContentKeyRepo) (ObjectStorage, error) {
+	master, err := ParseMasterKeys(cfg.GetStringSlice(config.EncryptionKeys))
+	if err != nil {
+		return nil, err
+	}
+	if len(master) == 0 {
+		return objects, nil
+	}
+	return NewEncryptedObjectStorage(objects, keys, master)
+}
+
+func (s *EncryptedObjectStorage) Create(id DocID, rd io.Reader) error {
+	enc, err := s.encrypter(id, rd)
+	if err != nil {
+		return err
+	}
+	return s.objects.Create(id, enc)
+}
+
+func (s *EncryptedObjectStorage) Update(id DocID, rd io.Reader) error {
+	enc, err := s.encrypter(id, rd)
+	if err != nil {
+		return err
+	}
+	return s.objects.Update(id, enc)
+}
+
+func (s *EncryptedObjectStorage) Read(id DocID) (io.ReadCloser, error) {
+	return s.ReadRange(id, 0, -1)
+}
+
+func (s *EncryptedObjectStorage) ReadRange(id DocID, offset, length int64) (io.ReadCloser, error) {
+	aead, err := s.existingKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rr, ranged := s.objects.(RangeReader)
+	var header []byte
+	var rd io.ReadCloser
+	if ranged && offset >= int64(s.chunkSize) {
+		// only the chunks from the one that contains the offset are read
+		if header, err = readObjectRange(rr, id, 0, int64(encryptionHeaderSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		if rd, err = s.objects.Read(id); err != nil {
+			return nil, err
+		}
+		header = make([]byte, encryptionHeaderSize)
+		if _, err := io.ReadFull(rd, header); err != nil {
+			_ = rd.Close()
+			return nil, fmt.Errorf("read header of %v: %w", id, errDecrypt)
+		}
+	}
+	if !bytes.HasPrefix(header, []byte(encryptionMagic)) {
+		if rd != nil {
+			_ = rd.Close()
+		}
+		return nil, fmt.Errorf("object %v isn't encrypted: %w", id, errDecrypt)
+	}
+
+	chunk := offset / int64(s.chunkSize)
+	sealed := int64(s.chunkSize + aead.Overhead())
+	if rd == nil {
+		if rd, err = rr.ReadRange(id, int64(encryptionHeaderSize)+chunk*sealed, -1); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(io.Discard, rd, chunk*sealed); err != nil {
+		_ = rd.Close()
+		return nil, fmt.Errorf("skip chunks of %v: %w", id, errDecrypt)
+	}
+
+	d := &decryptingReader{
+		rd:     rd,
+		aead:   aead,
+		prefix: header[len(encryptionMagic):],
+		index:  uint32(chunk),
+		buf:    make([]byte, sealed),
+		plain:  make([]byte, 0, s.chunkSize),
+		skip:   int(offset % int64(s.chunkSize)),
+	}
+	if length < 0 {
+		return d, nil
+	}
+	return readCloser{io.LimitReader(d, length), d}, nil
+}
+
+func (s *EncryptedObjectStorage) Delete(id DocID) error {
+	if err := s.objects.Delete(id); err != nil {
+		return err
+	}
+	return s.keys.Delete(id)
+}
+
+// RotateKeys encrypts the data keys that are encrypted with another than
+// the first master key with the first one, and returns how many there were.
+// The content of the documents isn't changed.
+func (s *EncryptedObjectStorage) RotateKeys() (int, error) {
+	ids, err := s.keys.Stale(s.master[0].ID)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		wrapped, err := s.keys.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			// deleted in the meantime
+			continue
+		} else if err != nil {
+			return i, err
+		}
+		key, err := s.unwrap(id, wrapped)
+		if err != nil {
+			return i, err
+		}
+		if wrapped, err = s.wrap(id, key); err != nil {
+			return i, err
+		}
+		if err := s.keys.Set(id, wrapped); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// encrypter returns a reader of the encrypted content of rd. The data key
+// of the document is created if it doesn't have one yet. Existing keys are
+// kept, so that the key of the stored content is never lost if storing new
+// content fails.
+func (s *EncryptedObjectStorage) encrypter(id DocID, rd io.Reader) (io.Reader, error) {
+	aead, err := s.existingKey(id)
+	if errors.Is(err, ErrNotFound) {
+		aead, err = s.newKey(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	if _, err := rand.Read(header[len(encryptionMagic):]); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return &encryptingReader{
+		rd:     rd,
+		aead:   aead,
+		prefix: header[len(encryptionMagic):],
+		out:    header,
+		buf:    make([]byte, s.chunkSize+1),
+		size:   s.chunkSize,
+	}, nil
+}
+
+func (s *EncryptedObjectStorage) newKey(id DocID) (cipher.AEAD, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	wrapped, err := s.wrap(id, key)
+	if err != nil {
+		return nil, err
+	}
+	// another upload may have created a key in the meantime, which must
+	// not be replaced, since its content may be encrypted with it already
+	if err := s.keys.Create(id, wrapped); err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func (s *EncryptedObjectStorage) existingKey(id DocID) (cipher.AEAD, error) {
+	wrapped, err := s.keys.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.unwrap(id, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// wrap encrypts the data key with the first master key. The document ID is
+// authenticated with it, so that keys can't be swapped between documents.
+func (s *EncryptedObjectStorage) wrap(id DocID, key []byte) (WrappedKey, error) {
+	master := s.master[0]
+	aead, err := newGCM(master.Key)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return WrappedKey{}, fmt.Errorf("generate nonce: %w", err)
+	}
+	return WrappedKey{
+		MasterKeyID: master.ID,
+		Key:         aead.Seal(nonce, nonce, key, []byte(id)),
+	}, nil
+}
+
+func (s *EncryptedObjectStorage) unwrap(id DocID, wrapped WrappedKey) ([]byte, error) {
+	for _, master := range s.master {
+		if master.ID != wrapped.MasterKeyID {
+			continue
+		}
+		aead, err := newGCM(master.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped.Key) < aead.NonceSize() {
+			return nil, fmt.Errorf("data key of %v: %w", id, errDecrypt)
+		}
+		nonce, sealed := wrapped.Key[:aead.NonceSize()], wrapped.Key[aead.NonceSize():]
+		key, err := aead.Open(nil, nonce, sealed, []byte(id))
+		if err != nil {
+			return nil, fmt.Errorf("data key of %v: %w", id, errDecrypt)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("data key of %v: unknown master key %v", id, wrapped.MasterKeyID)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+func chunkNonce(prefix []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, encryptionNoncePrefix+4)
+	copy(nonce, prefix)
+	if final {
+		index |= encryptionFinalChunk
+	}
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefix:], index)
+	return nonce
+}
+
+// encryptingReader encrypts the content of rd chunk by chunk. It reads one
+// byte past each chunk to find out if the chunk is the last one.
+type encryptingReader struct {
+	rd     io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	size   int
+
+	buf    []byte // the next chunk and one byte of the one after it
+	n      int    // the number of bytes in buf
+	sealed []byte
+	out    []byte // encrypted, but not yet read
+	done   bool
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptingReader) seal() error {
+	n, err := io.ReadFull(r.rd, r.buf[r.n:])
+	r.n += n
+	final := false
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		final = true
+	} else if err != nil {
+		return err
+	}
+	if r.index&encryptionFinalChunk != 0 {
+		return fmt.Errorf("content too large to encrypt")
+	}
+
+	chunk := r.buf[:r.n]
+	if !final {
+		chunk = r.buf[:r.size]
+	}
+	r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.prefix, r.index, final), chunk, nil)
+	r.out = r.sealed
+	r.index++
+	r.done = final
+	if !final {
+		// keep the byte of the next chunk
+		r.buf[0] = r.buf[r.size]
+		r.n = 1
+	}
+	return nil
+}
+
+// decryptingReader decrypts the chunks of rd. A chunk of the full size
+// might be the last one, so both nonces are tried.
+type decryptingReader struct {
+	rd     io.ReadCloser
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+
+	buf   []byte
+	plain []byte
+	out   []byte
+	skip  int // bytes at the start of the first chunk that aren't returned
+	done  bool
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) open() error {
+	n, err := io.ReadFull(r.rd, r.buf)
+	full := true
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		full = false
+	} else if err != nil {
+		return err
+	}
+
+	sealed := r.buf[:n]
+	out, err := r.aead.Open(r.plain[:0], chunkNonce(r.prefix, r.index, true), sealed, nil)
+	if err == nil {
+		// nothing may follow the last chunk
+		if full {
+			var b [1]byte
+			if m, _ := r.rd.Read(b[:]); m > 0 {
+				return errDecrypt
+			}
+		}
+		r.done = true
+	} else if full {
+		if out, err = r.aead.Open(r.plain[:0], chunkNonce(r.prefix, r.index, false), sealed, nil); err != nil {
+			return errDecrypt
+		}
+	} else {
+		// a chunk that is too short or missing must be the last one
+		return errDecrypt
+	}
+
+	r.index++
+	if r.skip > len(out) {
+		// the offset is past the end of the content
+		r.skip = len(out)
+	}
+	r.out = out[r.skip:]
+	r.skip = 0
+	return nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.rd.Close()
+}
+
+// readObjectRange reads a range of the object into memory.
+func readObjectRange(rr RangeReader, id DocID, offset, length int64) ([]byte, error) {
+	rd, err := rr.ReadRange(id, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		return nil, fmt.Errorf("read range of %v: %w", id, errDecrypt)
+	}
+	return buf, nil
+}
@@ -0,0 +1,330 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEncryptedObjectStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(EncryptedObjectStorageTestSuite))
+}
+
+type EncryptedObjectStorageTestSuite struct {
+	suite.Suite
+
+	objects *MemObjectStorage
+	keys    *MemContentKeyRepo
+	storage *EncryptedObjectStorage
+}
+
+func (suite *EncryptedObjectStorageTestSuite) SetupTest() {
+	suite.objects = NewMemObjectStorage()
+	suite.keys = NewMemContentKeyRepo()
+	suite.storage = suite.newStorage(testMasterKey("k1"))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) newStorage(master ...MasterKey) *EncryptedObjectStorage {
+	s, err := NewEncryptedObjectStorage(suite.objects, suite.keys, master)
+	suite.Require().NoError(err)
+	// small chunks, so that the tests cover content of several chunks
+	s.chunkSize = 16
+	return s
+}
+
+func testMasterKey(id string) MasterKey {
+	key := make([]byte, 32)
+	copy(key, id)
+	return MasterKey{ID: id, Key: key}
+}
+
+func randomContent(n int) []byte {
+	data := make([]byte, n)
+	_, _ = rand.Read(data)
+	return data
+}
+
+func (suite *EncryptedObjectStorageTestSuite) read(id DocID) ([]byte, error) {
+	rd, err := suite.storage.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		suite.NoError(rd.Close())
+	}()
+	return io.ReadAll(rd)
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestRoundTrip() {
+	for _, n := range []int{0, 1, 15, 16, 17, 32, 100} {
+		id := DocID(string(rune('a' + n)))
+		content := randomContent(n)
+		suite.NoError(suite.storage.Create(id, bytes.NewReader(content)))
+
+		stored := suite.objects.data[id]
+		chunks := (n + 15) / 16
+		if chunks == 0 {
+			chunks = 1
+		}
+		suite.Equal(encryptionHeaderSize+n+chunks*16, len(stored), "size %d", n)
+		// short content may appear in the ciphertext by chance
+		if n >= 16 {
+			suite.False(bytes.Contains(stored, content), "size %d", n)
+		}
+
+		data, err := suite.read(id)
+		suite.NoError(err, "size %d", n)
+		suite.Equal(content, data, "size %d", n)
+	}
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestUpdateKeepsKey() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader([]byte("hello"))))
+	key, err := suite.keys.Get("abc")
+	suite.NoError(err)
+	first := suite.objects.data["abc"]
+
+	suite.NoError(suite.storage.Update("abc", bytes.NewReader([]byte("hello"))))
+	updated, err := suite.keys.Get("abc")
+	suite.NoError(err)
+	suite.Equal(key, updated)
+	// the same content is encrypted with another nonce
+	suite.NotEqual(first, suite.objects.data["abc"])
+
+	data, err := suite.read("abc")
+	suite.NoError(err)
+	suite.Equal("hello", string(data))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestCreateConflictKeepsKey() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader([]byte("hello"))))
+	err := suite.storage.Create("abc", bytes.NewReader([]byte("other")))
+	suite.True(errors.Is(err, ErrConflict))
+
+	data, err := suite.read("abc")
+	suite.NoError(err)
+	suite.Equal("hello", string(data))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestNewKeyConflict() {
+	suite.NoError(suite.keys.Create("doc", WrappedKey{MasterKeyID: "k1", Key: []byte("other")}))
+
+	// the key that another upload created in the meantime is kept
+	_, err := suite.storage.newKey("doc")
+	suite.ErrorIs(err, ErrConflict)
+	key, err := suite.keys.Get("doc")
+	suite.NoError(err)
+	suite.Equal([]byte("other"), key.Key)
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestConcurrentCreate() {
+	keys := &barrierKeyRepo{MemContentKeyRepo: suite.keys, waiting: 2, ready: make(chan struct{})}
+	suite.storage.keys = keys
+	suite.storage.objects = &lockedObjectStorage{objects: suite.objects}
+
+	// both uploads look for the key before either creates one
+	contents := [][]byte{randomContent(40), randomContent(40)}
+	errs := make(chan error, len(contents))
+	for _, content := range contents {
+		content := content
+		go func() {
+			errs <- suite.storage.Create("doc", bytes.NewReader(content))
+		}()
+	}
+	failed := 0
+	for range contents {
+		if err := <-errs; err != nil {
+			suite.ErrorIs(err, ErrConflict)
+			failed++
+		}
+	}
+	suite.Equal(1, failed)
+
+	// the content can be decrypted with the key that was kept
+	data, err := suite.read("doc")
+	suite.Require().NoError(err)
+	suite.Contains(contents, data)
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestReadRange() {
+	content := randomContent(50)
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader(content)))
+
+	for _, r := range [][2]int64{{0, -1}, {5, 3}, {16, 16}, {20, -1}, {47, 10}, {48, -1}, {50, -1}} {
+		rd, err := suite.storage.ReadRange("abc", r[0], r[1])
+		suite.Require().NoError(err, "range %v", r)
+		data, err := io.ReadAll(rd)
+		suite.NoError(err, "range %v", r)
+		suite.NoError(rd.Close())
+
+		want := content[r[0]:]
+		if r[1] >= 0 && int(r[1]) < len(want) {
+			want = want[:r[1]]
+		}
+		suite.Equal(want, data, "range %v", r)
+	}
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestDelete() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader([]byte("hello"))))
+	suite.NoError(suite.storage.Delete("abc"))
+
+	_, err := suite.keys.Get("abc")
+	suite.True(errors.Is(err, ErrNotFound))
+	_, err = suite.storage.Read("abc")
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestTampered() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader(randomContent(40))))
+	suite.objects.data["abc"][encryptionHeaderSize+20] ^= 1
+
+	_, err := suite.read("abc")
+	suite.True(errors.Is(err, errDecrypt))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestTruncated() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader(randomContent(40))))
+	stored := suite.objects.data["abc"]
+
+	// without the last chunk, the one before it isn't marked as the last one
+	suite.objects.data["abc"] = stored[:encryptionHeaderSize+2*32]
+	_, err := suite.read("abc")
+	suite.True(errors.Is(err, errDecrypt))
+
+	suite.objects.data["abc"] = stored[:len(stored)-1]
+	_, err = suite.read("abc")
+	suite.True(errors.Is(err, errDecrypt))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestSwappedKeys() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader([]byte("hello"))))
+	suite.NoError(suite.storage.Create("def", bytes.NewReader([]byte("world"))))
+	key, err := suite.keys.Get("def")
+	suite.NoError(err)
+	suite.NoError(suite.keys.Set("abc", key))
+
+	_, err = suite.storage.Read("abc")
+	suite.True(errors.Is(err, errDecrypt))
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestRotateKeys() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader([]byte("hello"))))
+	suite.NoError(suite.storage.Create("def", bytes.NewReader([]byte("world"))))
+
+	suite.storage = suite.newStorage(testMasterKey("k2"), testMasterKey("k1"))
+	suite.NoError(suite.storage.Create("ghi", bytes.NewReader([]byte("new"))))
+	data, err := suite.read("abc")
+	suite.NoError(err)
+	suite.Equal("hello", string(data))
+
+	n, err := suite.storage.RotateKeys()
+	suite.NoError(err)
+	suite.Equal(2, n)
+	stale, err := suite.keys.Stale("k2")
+	suite.NoError(err)
+	suite.Empty(stale)
+
+	// the old key isn't needed anymore
+	suite.storage = suite.newStorage(testMasterKey("k2"))
+	for id, want := range map[DocID]string{"abc": "hello", "def": "world", "ghi": "new"} {
+		data, err := suite.read(id)
+		suite.NoError(err)
+		suite.Equal(want, string(data))
+	}
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestUnknownMasterKey() {
+	suite.NoError(suite.storage.Create("abc", bytes.NewReader([]byte("hello"))))
+
+	suite.storage = suite.newStorage(testMasterKey("k2"))
+	_, err := suite.storage.Read("abc")
+	suite.Error(err)
+}
+
+func (suite *EncryptedObjectStorageTestSuite) TestParseMasterKeys() {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	keys, err := ParseMasterKeys([]string{"new:" + key, "old:" + key})
+	suite.NoError(err)
+	suite.Equal([]MasterKey{
+		{ID: "new", Key: make([]byte, 32)},
+		{ID: "old", Key: make([]byte, 32)},
+	}, keys)
+
+	for _, entries := range [][]string{
+		{key},
+		{":" + key},
+		{"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{"k1:not base64"},
+		{"k1:" + key, "k1:" + key},
+	} {
+		_, err := ParseMasterKeys(entries)
+		suite.Error(err, "%v", entries)
+	}
+}
+
+func (suite *AppSuite) TestEncryptedContent() {
+	objects := NewMemObjectStorage()
+	storage, err := NewEncryptedObjectStorage(objects, NewMemContentKeyRepo(), []MasterKey{testMasterKey("k1")})
+	suite.Require().NoError(err)
+	storage.chunkSize = 4
+	suite.app.objects = storage
+
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+
+	suite.
+		Request("PUT", "/dav/hello.txt").
+		Header("Authorization", auth).
+		Body([]byte("hello world")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+	headers, err := suite.app.documents.List("testuser", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	suite.EqualValues(11, headers[0].Size)
+	suite.NotContains(string(objects.data[headers[0].ID]), "hello")
+
+	suite.
+		Get("/dav/hello.txt").
+		Header("Authorization", auth).
+		ExpectRaw(http.StatusOK, []byte("hello world"))
+	suite.
+		Get("/dav/hello.txt").
+		Header("Authorization", auth).
+		Header("Range", "bytes=6-").
+		ExpectRaw(http.StatusPartialContent, []byte("world"))
+}
+
+// barrierKeyRepo lets Get return only once the given number of callers
+// looked up the key.
+type barrierKeyRepo struct {
+	*MemContentKeyRepo
+
+	mu      sync.Mutex
+	waiting int
+	ready   chan struct{}
+}
+
+func (r *barrierKeyRepo) Get(id DocID) (WrappedKey, error) {
+	key, err := r.MemContentKeyRepo.Get(id)
+
+	r.mu.Lock()
+	r.waiting--
+	if r.waiting == 0 {
+		close(r.ready)
+	}
+	r.mu.Unlock()
+
+	<-r.ready
+	return key, err
+}
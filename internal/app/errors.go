@@ -0,0 +1,78 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Domain errors. Implementations of DocumentRepo, ObjectStorage and
+// AuthService wrap these, so that the handlers can tell what went wrong
+// without knowing the implementation.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrForbidden    = errors.New("forbidden")
+	ErrValidation   = errors.New("validation failed")
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrQuotaExceeded is returned if a change would exceed the quota of
+	// the owner of the documents.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrTooLarge is returned if a request body is larger than allowed.
+	ErrTooLarge = errors.New("too large")
+)
+
+// Error codes as returned in the code field of a Response. These are part
+// of the API and must not change.
+const (
+	CodeNotFound      = "not_found"
+	CodeConflict      = "conflict"
+	CodeForbidden     = "forbidden"
+	CodeValidation    = "validation"
+	CodeUnauthorized  = "unauthorized"
+	CodeQuotaExceeded = "quota_exceeded"
+	CodeTooLarge      = "too_large"
+	CodeInternal      = "internal"
+	// CodeUnavailable is returned by the healthcheck while the app shuts
+	// down.
+	CodeUnavailable = "unavailable"
+)
+
+// errorStatus maps an error to an HTTP status and an error code. Errors that
+// don't wrap one of the domain errors are internal errors.
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, CodeForbidden
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, CodeUnauthorized
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusForbidden, CodeQuotaExceeded
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge, CodeTooLarge
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
+
+// abortWithError aborts the request with the status and code for the given
+// error. The message is returned to the client, internal errors are only
+// logged, since they may contain details that the client shouldn't see.
+func abortWithError(c *gin.Context, err error, message string) {
+	status, code := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		_ = c.Error(err)
+	}
+	c.AbortWithStatusJSON(status, Response{
+		Success: false,
+		Code:    code,
+		Message: message,
+	})
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("document abc: %w", ErrNotFound), http.StatusNotFound, CodeNotFound},
+		{fmt.Errorf("object abc: %w", ErrConflict), http.StatusConflict, CodeConflict},
+		{ErrForbidden, http.StatusForbidden, CodeForbidden},
+		{fmt.Errorf("form file: %w", ErrValidation), http.StatusBadRequest, CodeValidation},
+		{ErrUnauthorized, http.StatusUnauthorized, CodeUnauthorized},
+		{fmt.Errorf("content: %w", ErrQuotaExceeded), http.StatusForbidden, CodeQuotaExceeded},
+		{fmt.Errorf("body: %w", ErrTooLarge), http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{errors.New("connection refused"), http.StatusInternalServerError, CodeInternal},
+	} {
+		status, code := errorStatus(tt.err)
+		assert.Equal(t, tt.status, status, tt.err.Error())
+		assert.Equal(t, tt.code, code, tt.err.Error())
+	}
+}
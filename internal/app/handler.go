@@ -1,11 +1,62 @@
 package app
 
+import "github.com/gin-gonic/gin"
+
 const (
-	UserIDKey      = "UserID"
-	UserIDTokenKey = "IDToken"
+	UserIDKey       = "UserID"
+	UserIDTokenKey  = "IDToken"
+	RefreshTokenKey = "RefreshToken"
+	TokenExpiryKey  = "TokenExpiry"
+	GroupsKey       = "Groups"
+)
+
+// apiTokenKey is the key of the APIToken in the gin.Context of requests
+// that are authenticated with an API token instead of a session.
+const apiTokenKey = "APIToken"
+
+// challengeUserKey is the key of the user whose login is waiting for the
+// answer to a challenge in the session. After maxChallengeAttempts wrong
+// answers, the login has to be started again.
+const (
+	challengeUserKey     = "ChallengeUser"
+	challengeAttemptsKey = "ChallengeAttempts"
+	maxChallengeAttempts = 5
 )
 
 type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
+	Success bool              `json:"success"`
+	Code    string            `json:"code,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Errors  []ValidationError `json:"errors,omitempty"`
+}
+
+// currentUser returns the user that the auth middleware in setupRoutes
+// authenticated, either by the session or by an API token.
+func currentUser(c *gin.Context) string {
+	return c.GetString(UserIDKey)
+}
+
+// currentGroups returns the groups of the current user, which grant the
+// permissions of the group entries in ACLs.
+func currentGroups(c *gin.Context) []string {
+	return c.GetStringSlice(GroupsKey)
+}
+
+// currentAPIToken returns the API token that the request was authenticated
+// with, if any.
+func currentAPIToken(c *gin.Context) (APIToken, bool) {
+	t, ok := c.Get(apiTokenKey)
+	if !ok {
+		return APIToken{}, false
+	}
+	return t.(APIToken), true
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
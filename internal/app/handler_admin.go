@@ -0,0 +1,257 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// middlewareAdmin only lets members of the admin group pass. The groups of
+// the session are those at the time of the login, so they are resolved again
+// if the auth service supports it.
+func (a *App) middlewareAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groups := currentGroups(c)
+		if r, ok := a.auth.(GroupResolver); ok {
+			var err error
+			if groups, err = r.UserGroups(currentUser(c)); err != nil {
+				abortWithError(c, err, "unable to resolve groups")
+				return
+			}
+		}
+		if !containsString(groups, a.adminGroup) {
+			abortWithError(c, ErrForbidden, "admin role required")
+			return
+		}
+	}
+}
+
+// middlewareUserAdmin only lets requests pass if the auth service supports
+// the admin API. Handlers after it can rely on a.auth being a UserAdmin.
+func (a *App) middlewareUserAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := a.auth.(UserAdmin); !ok {
+			abortWithError(c, ErrNotFound, "user administration is not supported")
+			return
+		}
+	}
+}
+
+func (a *App) HandlerGetUsers() gin.HandlerFunc {
+	type user struct {
+		Username string   `json:"username"`
+		Enabled  bool     `json:"enabled"`
+		Groups   []string `json:"groups,omitempty"`
+	}
+	type response struct {
+		Success bool   `json:"success"`
+		Users   []user `json:"users"`
+	}
+	return func(c *gin.Context) {
+		users, err := a.auth.(UserAdmin).Users()
+		if err != nil {
+			abortWithError(c, err, "failed to list users")
+			return
+		}
+
+		res := make([]user, len(users))
+		for i, u := range users {
+			res[i] = user{
+				Username: u.Username,
+				Enabled:  u.Enabled,
+				Groups:   u.Groups,
+			}
+		}
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Users:   res,
+		})
+	}
+}
+
+func (a *App) HandlerPostUser() gin.HandlerFunc {
+	type request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	return func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" || req.Password == "" {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		if err := a.auth.(UserAdmin).CreateUser(req.Username, req.Password); err != nil {
+			abortWithError(c, err, "failed to create user")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerDeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if name == currentUser(c) {
+			abortWithError(c, ErrValidation, "admins can't delete themselves")
+			return
+		}
+
+		if err := a.auth.(UserAdmin).DeleteUser(name); err != nil {
+			abortWithError(c, err, "failed to delete user")
+			return
+		}
+		// the tokens don't depend on the auth service and would keep working
+		if err := a.apiTokens.DeleteAll(name); err != nil {
+			abortWithError(c, err, "failed to revoke tokens")
+			return
+		}
+		if err := a.shareLinks.DeleteCreatedBy(name); err != nil {
+			abortWithError(c, err, "failed to delete share links")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+// HandlerPostUserEnabled enables or disables the user of the request path.
+func (a *App) HandlerPostUserEnabled(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if !enabled && name == currentUser(c) {
+			abortWithError(c, ErrValidation, "admins can't disable themselves")
+			return
+		}
+
+		if err := a.auth.(UserAdmin).SetUserEnabled(name, enabled); err != nil {
+			abortWithError(c, err, "failed to update user")
+			return
+		}
+		// the tokens don't depend on the auth service and would keep working
+		if !enabled {
+			if err := a.apiTokens.DeleteAll(name); err != nil {
+				abortWithError(c, err, "failed to revoke tokens")
+				return
+			}
+			if err := a.shareLinks.DeleteCreatedBy(name); err != nil {
+				abortWithError(c, err, "failed to delete share links")
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerPostUserPassword() gin.HandlerFunc {
+	type request struct {
+		Password string `json:"password"`
+	}
+	return func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		if err := a.auth.(UserAdmin).SetPassword(c.Param("name"), req.Password); err != nil {
+			abortWithError(c, err, "failed to set password")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerGetGroups() gin.HandlerFunc {
+	type response struct {
+		Success bool     `json:"success"`
+		Groups  []string `json:"groups"`
+	}
+	return func(c *gin.Context) {
+		groups, err := a.auth.(UserAdmin).Groups()
+		if err != nil {
+			abortWithError(c, err, "failed to list groups")
+			return
+		}
+		if groups == nil {
+			groups = []string{}
+		}
+
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Groups:  groups,
+		})
+	}
+}
+
+func (a *App) HandlerPostGroup() gin.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+	return func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		if err := a.auth.(UserAdmin).CreateGroup(req.Name); err != nil {
+			abortWithError(c, err, "failed to create group")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerDeleteGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := a.auth.(UserAdmin).DeleteGroup(c.Param("name")); err != nil {
+			abortWithError(c, err, "failed to delete group")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerPutGroupMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := a.auth.(UserAdmin).AddUserToGroup(c.Param("user"), c.Param("name")); err != nil {
+			abortWithError(c, err, "failed to add member")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerDeleteGroupMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := a.auth.(UserAdmin).RemoveUserFromGroup(c.Param("user"), c.Param("name")); err != nil {
+			abortWithError(c, err, "failed to remove member")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
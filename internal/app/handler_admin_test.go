@@ -0,0 +1,325 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// loginAdmin logs in as a new member of the admin group.
+func (suite *AppSuite) loginAdmin() string {
+	mem := suite.app.auth.(*MemAuthService)
+	if err := mem.CreateGroup(DefaultAdminGroup); err != nil {
+		suite.Require().ErrorIs(err, ErrConflict)
+	}
+
+	user := suite.login()
+	suite.Require().NoError(mem.AddUserToGroup(user, DefaultAdminGroup))
+	// the groups are stored in the session with the login
+	suite.logout()
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": user,
+			"password": mem.data[user],
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.fetchCSRFToken()
+	return user
+}
+
+func (suite *AppSuite) TestAdminRequiresAdminGroup() {
+	suite.login()
+
+	suite.
+		Get("/admin/users").
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "admin role required",
+		})
+}
+
+func (suite *AppSuite) TestAdminRemovedFromAdminGroup() {
+	admin := suite.loginAdmin()
+	mem := suite.app.auth.(*MemAuthService)
+	suite.NoError(mem.RemoveUserFromGroup(admin, DefaultAdminGroup))
+
+	// the groups of the session are outdated
+	suite.
+		Get("/admin/users").
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "admin role required",
+		})
+}
+
+func (suite *AppSuite) TestAdminWithAPIToken() {
+	suite.loginAdmin()
+	token := suite.createToken(ScopeRead)
+
+	suite.
+		Get("/admin/users").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "route can't be used with a token",
+		})
+}
+
+func (suite *AppSuite) TestAdminNotSupported() {
+	suite.loginAdmin()
+
+	auth := new(MockAuthService)
+	auth.On("TokenValid", mock.Anything).Return(true)
+	suite.app.auth = auth
+
+	suite.
+		Get("/admin/users").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "user administration is not supported",
+		})
+}
+
+func (suite *AppSuite) TestAdminUsers() {
+	admin := suite.loginAdmin()
+
+	suite.
+		Post("/admin/users").
+		BodyJSON(M{
+			"username": "zoe",
+			"password": "zoepass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Post("/admin/users/zoe/disable").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	// users are sorted by name, and the admin has a UUID as name
+	suite.
+		Get("/admin/users").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"users": []M{
+				{
+					"username": admin,
+					"enabled":  true,
+					"groups":   []string{"admin"},
+				},
+				{
+					"username": "zoe",
+					"enabled":  false,
+				},
+			},
+		})
+
+	suite.
+		Post("/admin/users/zoe/enable").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Post("/admin/users/zoe/password").
+		BodyJSON(M{
+			"password": "newpass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/users/zoe").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/users/zoe").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "failed to delete user",
+		})
+}
+
+func (suite *AppSuite) TestAdminDisabledUserCantLogin() {
+	suite.loginAdmin()
+	suite.createUser("alice", "alicepass")
+
+	suite.
+		Post("/admin/users/alice/disable").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.logout()
+
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": "alice",
+			"password": "alicepass",
+		}).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "invalid credentials",
+		})
+}
+
+func (suite *AppSuite) TestAdminRevokesTokens() {
+	disabled := suite.login()
+	disabledToken := suite.createToken(ScopeRead)
+	deleted := suite.login()
+	deletedToken := suite.createToken(ScopeRead)
+	suite.loginAdmin()
+
+	suite.
+		Post("/admin/users/"+disabled+"/disable").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/users/"+deleted).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	for _, token := range []string{disabledToken, deletedToken} {
+		suite.
+			Get("/user").
+			Header("Authorization", "Bearer "+token).
+			ExpectJSON(http.StatusUnauthorized, M{
+				"success": false,
+				"code":    "unauthorized",
+				"message": "invalid token",
+			})
+	}
+}
+
+func (suite *AppSuite) TestAdminDeletesShareLinks() {
+	disabled := suite.login()
+	suite.createSharedDocument(disabled)
+	disabledToken := suite.createShareLink("doc", M{})
+	deleted := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc2",
+		Name:    "myfile",
+		Owner:   deleted,
+		Created: time.Now(),
+	}, ownerACL(deleted)))
+	suite.createContent("doc2", []byte("hello"))
+	deletedToken := suite.createShareLink("doc2", M{})
+	suite.loginAdmin()
+
+	suite.
+		Post("/admin/users/"+disabled+"/disable").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/users/"+deleted).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	for _, token := range []string{disabledToken, deletedToken} {
+		suite.
+			Get("/public/"+token).
+			ExpectJSON(http.StatusNotFound, M{
+				"success": false,
+				"code":    "not_found",
+				"message": "link not found",
+			})
+	}
+}
+
+func (suite *AppSuite) TestAdminCantRemoveThemselves() {
+	admin := suite.loginAdmin()
+
+	suite.
+		Request(http.MethodDelete, "/admin/users/"+admin).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "admins can't delete themselves",
+		})
+	suite.
+		Post("/admin/users/"+admin+"/disable").
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "admins can't disable themselves",
+		})
+}
+
+func (suite *AppSuite) TestAdminGroups() {
+	suite.loginAdmin()
+	suite.createUser("alice", "alicepass")
+
+	suite.
+		Post("/admin/groups").
+		BodyJSON(M{
+			"name": "editors",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Post("/admin/groups").
+		BodyJSON(M{
+			"name": "editors",
+		}).
+		ExpectJSON(http.StatusConflict, M{
+			"success": false,
+			"code":    "conflict",
+			"message": "failed to create group",
+		})
+	suite.
+		Request(http.MethodPut, "/admin/groups/editors/members/alice").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Get("/admin/groups").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"groups":  []string{"admin", "editors"},
+		})
+
+	res, err := suite.app.auth.(*MemAuthService).Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.Equal([]string{"editors"}, res.Groups)
+
+	suite.
+		Request(http.MethodDelete, "/admin/groups/editors/members/alice").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/groups/editors/members/alice").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "failed to remove member",
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/groups/editors").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodPut, "/admin/groups/editors/members/alice").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "failed to add member",
+		})
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archiveManifest is the name of the entry of an archive that lists the
+// included and the skipped documents.
+const archiveManifest = "manifest.json"
+
+type archiveManifestEntry struct {
+	ID   DocID  `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Reason is why the document was skipped.
+	Reason string `json:"reason,omitempty"`
+}
+
+// HandlerPostArchive streams a ZIP archive of the content of the documents.
+// Documents that the user can't read or that have no content are skipped
+// rather than failing the request, and listed in the manifest at the end
+// of the archive.
+func (a *App) HandlerPostArchive() gin.HandlerFunc {
+	type request struct {
+		IDs []DocID `json:"ids"`
+	}
+	return func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		var manifest struct {
+			Included []archiveManifestEntry `json:"included"`
+			Skipped  []archiveManifestEntry `json:"skipped"`
+		}
+		manifest.Included = []archiveManifestEntry{}
+		manifest.Skipped = []archiveManifestEntry{}
+
+		// the documents are checked before anything is sent, so that the
+		// whole request fails for errors other than permissions
+		var headers []DocumentHeader
+		seen := map[DocID]bool{}
+		for _, id := range req.IDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			header, _, err := a.authorize(c, id, canRead)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				manifest.Skipped = append(manifest.Skipped, archiveManifestEntry{ID: id, Reason: "not found"})
+			case errors.Is(err, ErrForbidden):
+				manifest.Skipped = append(manifest.Skipped, archiveManifestEntry{ID: id, Reason: "forbidden"})
+			case err != nil:
+				abortWithError(c, err, "read document")
+				return
+			default:
+				headers = append(headers, header)
+			}
+		}
+
+		if err := clearWriteDeadline(c); err != nil {
+			abortWithError(c, err, "unable to send archive")
+			return
+		}
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": "documents.zip",
+		}))
+		c.Status(http.StatusOK)
+
+		zw := zip.NewWriter(c.Writer)
+		names := archiveNames{archiveManifest: true}
+		for _, header := range headers {
+			name := names.add(header)
+			if reason, err := a.writeArchiveEntry(zw, header, name); err != nil {
+				// the response is sent already, the archive ends here
+				_ = c.Error(err)
+				return
+			} else if reason != "" {
+				manifest.Skipped = append(manifest.Skipped, archiveManifestEntry{ID: header.ID, Name: header.Name, Reason: reason})
+				continue
+			}
+			manifest.Included = append(manifest.Included, archiveManifestEntry{ID: header.ID, Name: name})
+		}
+
+		w, err := zw.Create(archiveManifest)
+		if err == nil {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(manifest)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// writeArchiveEntry writes the content of the document to the archive. It
+// returns why the document is skipped, or an error if the archive can't be
+// written anymore.
+func (a *App) writeArchiveEntry(zw *zip.Writer, header DocumentHeader, name string) (string, error) {
+	content, err := a.objects.Read(header.ID)
+	if errors.Is(err, ErrNotFound) {
+		return "no content", nil
+	} else if err != nil {
+		a.log.Error().Err(err).Str("doc", string(header.ID)).Msg("read content for archive")
+		return "read failed", nil
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+
+	// content that is compressed already is only stored
+	br := bufio.NewReaderSize(content, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "read failed", nil
+	}
+	fh := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: header.Created,
+	}
+	if !header.Updated.IsZero() {
+		fh.Modified = header.Updated
+	}
+	if compressible(head) {
+		fh.Method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return "", fmt.Errorf("create archive entry: %w", err)
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		return "", fmt.Errorf("write archive entry: %w", err)
+	}
+	return "", nil
+}
+
+// archiveNames makes the names of documents unique in an archive. Names
+// that differ in case only collide as well, since they do on some file
+// systems.
+type archiveNames map[string]bool
+
+func (n archiveNames) add(header DocumentHeader) string {
+	name := archiveEntryName(header)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; n[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	n[strings.ToLower(name)] = true
+	return name
+}
+
+// archiveEntryName is the name of the document as an entry of an archive,
+// or the document ID if the name is empty. See cleanEntryName.
+func archiveEntryName(header DocumentHeader) string {
+	if name := cleanEntryName(header.Name); name != "" {
+		return name
+	}
+	return string(header.ID)
+}
+
+// cleanEntryName turns the name of an archive entry into a relative path
+// without ".." elements, which would be extracted outside of the target
+// directory. Backslashes are separators as well.
+func cleanEntryName(name string) string {
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			parts = append(parts, "_")
+		default:
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
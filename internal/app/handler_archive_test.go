@@ -0,0 +1,169 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// createArchiveDocument creates a document that the owner may read, as
+// well as whoever the permission is granted to, if it's given.
+func (suite *AppSuite) createArchiveDocument(id DocID, name, owner string, perm *Permission, content string) {
+	acl := ACL{Permissions: map[Principal]Permission{
+		UserPrincipal(owner): {Read: true, Write: true, Delete: true, Share: true},
+	}}
+	if perm != nil {
+		acl.Permissions[perm.Principal] = *perm
+	}
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      id,
+		Name:    name,
+		Owner:   owner,
+		Size:    int64(len(content)),
+		Created: time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC),
+	}, acl))
+	if content != "" {
+		suite.createContent(string(id), []byte(content))
+	}
+}
+
+// readArchive returns the content of the entries of the archive.
+func (suite *AppSuite) readArchive(data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	suite.Require().NoError(err)
+
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rd, err := f.Open()
+		suite.Require().NoError(err)
+		content, err := io.ReadAll(rd)
+		suite.NoError(err)
+		suite.NoError(rd.Close())
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func (suite *AppSuite) TestPostArchive() {
+	user := suite.login()
+	suite.createArchiveDocument("a", "report.txt", user, nil, "first")
+	suite.createArchiveDocument("b", "Report.txt", "other", &Permission{Principal: UserPrincipal(user), Read: true}, "second")
+	suite.createArchiveDocument("c", "../../etc/passwd", user, nil, "third")
+	suite.createArchiveDocument("d", "empty.txt", user, nil, "")
+	suite.createArchiveDocument("e", "secret.txt", "other", &Permission{Principal: UserPrincipal(user), Write: true}, "secret")
+	suite.createArchiveDocument("f", "hidden.txt", "other", nil, "hidden")
+
+	suite.
+		Post("/doc/archive").
+		BodyJSON(M{"ids": []string{"a", "b", "a", "c", "d", "e", "f", "unknown"}}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.Equal("application/zip", res.Header.Get("Content-Type"))
+			suite.Equal("attachment; filename=documents.zip", res.Header.Get("Content-Disposition"))
+
+			data, err := io.ReadAll(res.Body)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+
+			entries := suite.readArchive(data)
+			suite.Len(entries, 4)
+			suite.Equal("first", entries["report.txt"])
+			suite.Equal("second", entries["Report (1).txt"])
+			suite.Equal("third", entries["_/_/etc/passwd"])
+
+			manifest, err := json.Marshal(M{
+				"included": []M{
+					{"id": "a", "name": "report.txt"},
+					{"id": "b", "name": "Report (1).txt"},
+					{"id": "c", "name": "_/_/etc/passwd"},
+				},
+				"skipped": []M{
+					{"id": "e", "reason": "forbidden"},
+					{"id": "f", "reason": "not found"},
+					{"id": "unknown", "reason": "not found"},
+					{"id": "d", "name": "empty.txt", "reason": "no content"},
+				},
+			})
+			suite.NoError(err)
+			suite.JSONEq(string(manifest), entries["manifest.json"])
+		})
+}
+
+func (suite *AppSuite) TestPostArchiveWriteTimeout() {
+	user := suite.login()
+	suite.createArchiveDocument("a", "report.txt", user, nil, "first")
+	suite.createArchiveDocument("b", "notes.txt", user, nil, "second")
+
+	// the archive takes longer than the server may write a response
+	suite.app.srv.WriteTimeout = 50 * time.Millisecond
+	suite.app.objects = slowObjectStorage{suite.app.objects, 50 * time.Millisecond}
+
+	suite.
+		Post("/doc/archive").
+		BodyJSON(M{"ids": []string{"a", "b"}}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+
+			data, err := io.ReadAll(res.Body)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+
+			entries := suite.readArchive(data)
+			suite.Equal("first", entries["report.txt"])
+			suite.Equal("second", entries["notes.txt"])
+		})
+}
+
+func (suite *AppSuite) TestPostArchiveInvalid() {
+	suite.login()
+
+	suite.
+		Post("/doc/archive").
+		BodyJSON(M{"ids": []string{}}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusBadRequest, res.StatusCode)
+		})
+}
+
+func (suite *AppSuite) TestPostArchiveNotLoggedIn() {
+	suite.
+		Post("/doc/archive").
+		BodyJSON(M{"ids": []string{"a"}}).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    CodeUnauthorized,
+			"message": "not logged in",
+		})
+}
+
+func (suite *AppSuite) TestArchiveNames() {
+	names := archiveNames{archiveManifest: true}
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"a.txt", "a.txt"},
+		{"A.TXT", "A (1).TXT"},
+		{"a.txt", "a (2).txt"},
+		{"manifest.json", "manifest (1).json"},
+		{"/dir//b", "dir/b"},
+		{`dir\..\c`, "dir/_/c"},
+		{"", "docID"},
+		{"./..", "_"},
+	} {
+		suite.Equal(tc.want, names.add(DocumentHeader{ID: "docID", Name: tc.name}), tc.name)
+	}
+}
+
+// slowObjectStorage takes a while to open objects for reading.
+type slowObjectStorage struct {
+	ObjectStorage
+	delay time.Duration
+}
+
+func (s slowObjectStorage) Read(id DocID) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	return s.ObjectStorage.Read(id)
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"encoding/gob"
 	"net/http"
 
 	"github.com/gin-contrib/sessions"
@@ -21,30 +22,32 @@ func (a *App) HandlerAuthLogin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req request
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Success: false,
-				Message: "invalid JSON payload",
-			})
+			abortWithError(c, ErrValidation, "invalid JSON payload")
 			return
 		}
 
 		res, err := a.auth.Login(req.Username, req.Password)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Success: false,
-			})
+			abortWithError(c, err, "login failed")
 			return
 		}
 
 		if !res.Success {
-			c.JSON(http.StatusUnauthorized, response{
-				Success: false,
-				Message: "invalid credentials",
-			})
+			abortWithError(c, ErrUnauthorized, "invalid credentials")
 			return
 		}
 
+		sess := sessions.Default(c)
 		if res.Challenge != "" {
+			// the challenge may only be answered in this session, so that
+			// the password can't be skipped
+			sess.Set(challengeUserKey, req.Username)
+			sess.Delete(challengeAttemptsKey)
+			if err := sess.Save(); err != nil {
+				abortWithError(c, err, "unable to save session")
+				return
+			}
+
 			c.JSON(http.StatusOK, response{
 				Success:   true,
 				Challenge: res.Challenge,
@@ -52,14 +55,12 @@ func (a *App) HandlerAuthLogin() gin.HandlerFunc {
 			return
 		}
 
-		sess := sessions.Default(c)
-		sess.Set(UserIDKey, req.Username)
-		sess.Set(UserIDTokenKey, res.Token)
+		sess.Delete(challengeUserKey)
+		sess.Delete(challengeAttemptsKey)
+		setSessionLogin(sess, res.user(req.Username), res)
+		a.renewSession(sess)
 		if err := sess.Save(); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Success: false,
-				Message: "unable to save session",
-			})
+			abortWithError(c, err, "unable to save session")
 			return
 		}
 
@@ -84,26 +85,38 @@ func (a *App) HandlerAuthChallenge() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req request
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Success: false,
-				Message: "invalid JSON payload",
-			})
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		sess := sessions.Default(c)
+		if user, _ := sess.Get(challengeUserKey).(string); user == "" || user != req.Username {
+			abortWithError(c, ErrUnauthorized, "no login in progress")
 			return
 		}
 
 		res, err := a.auth.AnswerChallenge(req.Username, req.Challenge, req.ClientResponse)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Success: false,
-			})
+			abortWithError(c, err, "answer challenge failed")
 			return
 		}
 
 		if !res.Success {
-			c.JSON(http.StatusUnauthorized, response{
-				Success: false,
-				Message: "invalid credentials",
-			})
+			// limit the guesses, the password has to be entered again
+			// afterwards
+			attempts, _ := sess.Get(challengeAttemptsKey).(int)
+			if attempts+1 >= maxChallengeAttempts {
+				sess.Delete(challengeUserKey)
+				sess.Delete(challengeAttemptsKey)
+			} else {
+				sess.Set(challengeAttemptsKey, attempts+1)
+			}
+			if err := sess.Save(); err != nil {
+				abortWithError(c, err, "unable to save session")
+				return
+			}
+
+			abortWithError(c, ErrUnauthorized, "invalid credentials")
 			return
 		}
 
@@ -116,14 +129,12 @@ func (a *App) HandlerAuthChallenge() gin.HandlerFunc {
 			return
 		}
 
-		sess := sessions.Default(c)
-		sess.Set(UserIDKey, req.Username)
-		sess.Set(UserIDTokenKey, res.Token)
+		sess.Delete(challengeUserKey)
+		sess.Delete(challengeAttemptsKey)
+		setSessionLogin(sess, res.user(req.Username), res)
+		a.renewSession(sess)
 		if err := sess.Save(); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Success: false,
-				Message: "unable to save session",
-			})
+			abortWithError(c, err, "unable to save session")
 			return
 		}
 
@@ -135,14 +146,8 @@ func (a *App) HandlerAuthChallenge() gin.HandlerFunc {
 
 func (a *App) HandlerAuthLogout() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sess := sessions.Default(c)
-		sess.Clear()
-
-		if err := sess.Save(); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Success: false,
-				Message: "unable to save session",
-			})
+		if err := a.endSession(sessions.Default(c)); err != nil {
+			abortWithError(c, err, "unable to save session")
 			return
 		}
 
@@ -154,13 +159,53 @@ func (a *App) HandlerAuthLogout() gin.HandlerFunc {
 
 func (a *App) HandlerUser() gin.HandlerFunc {
 	type response struct {
-		Username string `json:"username"`
+		Username string        `json:"username"`
+		Groups   []string      `json:"groups,omitempty"`
+		Quota    quotaResponse `json:"quota"`
 	}
 	return func(c *gin.Context) {
-		sess := sessions.Default(c)
+		user := currentUser(c)
+		groups := currentGroups(c)
+		quota, usage, err := a.quotaOf(user, user, groups)
+		if err != nil {
+			abortWithError(c, err, "unable to get quota")
+			return
+		}
 
 		c.JSON(http.StatusOK, response{
-			Username: sess.Get(UserIDKey).(string),
+			Username: user,
+			Groups:   groups,
+			Quota:    newQuotaResponse(quota, usage),
 		})
 	}
 }
+
+// setSessionLogin stores the result of a successful login or refresh in the
+// session.
+func setSessionLogin(sess sessions.Session, user string, res LoginResult) {
+	if sess.Get(UserIDKey) != user {
+		// a token that was obtained before the login must not be valid
+		// for the user
+		sess.Delete(csrfTokenKey)
+	}
+	sess.Set(UserIDKey, user)
+	sess.Set(UserIDTokenKey, res.Token)
+	if res.RefreshToken != "" {
+		sess.Set(RefreshTokenKey, res.RefreshToken)
+	}
+	if res.ExpiresAt.IsZero() {
+		sess.Delete(TokenExpiryKey)
+	} else {
+		sess.Set(TokenExpiryKey, res.ExpiresAt.Unix())
+	}
+	if len(res.Groups) == 0 {
+		sess.Delete(GroupsKey)
+	} else {
+		sess.Set(GroupsKey, res.Groups)
+	}
+}
+
+func init() {
+	// the session is gob encoded, and values are stored as interface{}
+	gob.Register([]string{})
+}
@@ -1,6 +1,9 @@
 package app
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 func (suite *AppSuite) TestLogin() {
 	suite.createUser("testuser", "testpass")
@@ -64,6 +67,7 @@ func (suite *AppSuite) TestLoginNoUser() {
 		}).
 		ExpectJSON(http.StatusUnauthorized, M{
 			"success": false,
+			"code":    "unauthorized",
 			"message": "invalid credentials",
 		})
 }
@@ -79,6 +83,7 @@ func (suite *AppSuite) TestLoginInvalid() {
 		}).
 		ExpectJSON(http.StatusUnauthorized, M{
 			"success": false,
+			"code":    "unauthorized",
 			"message": "invalid credentials",
 		})
 }
@@ -106,6 +111,90 @@ func (suite *AppSuite) TestLogout() {
 		Get("/auth/logout").
 		ExpectJSON(http.StatusUnauthorized, M{
 			"success": false,
+			"code":    "unauthorized",
 			"message": "not logged in",
 		})
 }
+
+func (suite *AppSuite) TestSessionExpired() {
+	suite.login()
+
+	auth := suite.app.auth.(*MemAuthService)
+	auth.mu.Lock()
+	auth.tokens = map[string]memToken{}
+	auth.mu.Unlock()
+
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "session expired",
+		})
+
+	// the session was cleared
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "not logged in",
+		})
+}
+
+func (suite *AppSuite) TestSessionRefresh() {
+	auth := suite.app.auth.(*MemAuthService)
+	auth.mu.Lock()
+	auth.ttl = 2 * time.Minute
+	auth.mu.Unlock()
+
+	user := suite.login()
+
+	// the token is about to expire, so it's refreshed before it's checked
+	auth.mu.Lock()
+	auth.tokens = map[string]memToken{}
+	auth.mu.Unlock()
+
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"quota":    unlimitedQuota,
+		})
+
+	auth.mu.RLock()
+	suite.Len(auth.tokens, 1)
+	auth.mu.RUnlock()
+}
+
+func (suite *AppSuite) TestSessionRefreshFailed() {
+	auth := suite.app.auth.(*MemAuthService)
+	auth.mu.Lock()
+	auth.ttl = 2 * time.Minute
+	auth.mu.Unlock()
+
+	suite.login()
+
+	auth.mu.Lock()
+	auth.tokens = map[string]memToken{}
+	auth.refreshTokens = map[string]string{}
+	auth.mu.Unlock()
+
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "session expired",
+		})
+}
+
+func (suite *AppSuite) TestOIDCNotConfigured() {
+	suite.
+		Get("/auth/oidc/start").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "OpenID Connect is not configured",
+		})
+}
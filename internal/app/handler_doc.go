@@ -1,27 +1,48 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
 func (a *App) HandlerGetContent() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := DocID(c.Param("id"))
-		// sess := sessions.Default(c)
-		// userID := sess.Get(UserIDKey).(string)
 
-		content, err := a.objects.Read(id)
+		if _, _, err := a.authorize(c, id, canRead); err != nil {
+			abortWithError(c, err, "read content")
+			return
+		}
+
+		// compressed content is sent as it's stored to clients that accept
+		// the encoding
+		var content io.ReadCloser
+		var encoding string
+		var err error
+		er, ok := a.objects.(EncodedReader)
+		if ok {
+			c.Header("Vary", "Accept-Encoding")
+		}
+		if ok && acceptsEncoding(c.GetHeader("Accept-Encoding"), "gzip") {
+			content, encoding, err = er.ReadEncoded(id)
+		} else {
+			content, err = a.objects.Read(id)
+		}
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Message: "no content for id",
-			})
+			abortWithError(c, err, "no content for id")
 			return
 		}
+		defer func() {
+			_ = content.Close()
+		}()
+		if encoding != "" {
+			c.Header("Content-Encoding", encoding)
+		}
 
 		_, err = io.Copy(c.Writer, content)
 		if err != nil {
@@ -34,69 +55,61 @@ func (a *App) HandlerGetContent() gin.HandlerFunc {
 func (a *App) HandlerPostContent() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := DocID(c.Param("id"))
-		sess := sessions.Default(c)
-		userID := sess.Get(UserIDKey).(string)
 
-		docHeader, err := a.documents.Get(id)
+		docHeader, acl, err := a.authorize(c, id, canWrite)
 		if err != nil {
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Message: "get header for document",
-			})
+			abortWithError(c, err, "write content")
 			return
 		}
 
-		acl, err := a.documents.ACL(id)
+		ff, err := c.FormFile("file")
 		if err != nil {
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Message: "get ACL for document",
-			})
-			return
-		}
-		if _, ok := acl.Permissions[userID]; !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, Response{
-				Message: "write content",
-			})
+			abortWithError(c, fmt.Errorf("form file: %v: %w", err, ErrValidation), "failed to receive file")
 			return
 		}
 
-		ff, err := c.FormFile("file")
+		// the replaced content doesn't count against the quota
+		quota, usage, err := a.quotaOf(docHeader.Owner, currentUser(c), currentGroups(c))
 		if err != nil {
-			fmt.Println(err)
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Message: "failed to receive file",
-			})
+			abortWithError(c, err, "unable to check quota")
+			return
+		}
+		remaining := quota.remainingBytes(usage, docHeader.Size)
+		if remaining >= 0 && ff.Size > remaining {
+			abortWithError(c, ErrQuotaExceeded, "storage quota exceeded")
 			return
 		}
 
 		f, err := ff.Open()
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Message: "failed to open file",
-			})
+			abortWithError(c, fmt.Errorf("open form file: %v: %w", err, ErrValidation), "failed to open file")
 			return
 		}
 		defer func() {
 			_ = f.Close()
 		}()
 
-		rd := io.LimitReader(f, 1<<29) // 512MB
+		// the size of the form file is checked again while streaming, in
+		// case another upload used up the quota in the meantime
+		rd := &quotaReader{rd: io.LimitReader(f, maxContentSize), limit: remaining}
 
-		if err := a.objects.Create(id, rd); err != nil {
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Message: "failed to create object",
-			})
+		if docHeader.Updated.IsZero() {
+			err = a.objects.Create(id, rd)
+		} else {
+			err = a.objects.Update(id, rd)
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			abortWithError(c, err, "storage quota exceeded")
+			return
+		} else if err != nil {
+			abortWithError(c, err, "failed to store object")
 			return
 		}
 
+		docHeader.Size = rd.n
 		docHeader.Updated = a.clock.Now()
 		if err := a.documents.Update(docHeader, acl); err != nil {
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Message: "failed to update document header",
-			})
+			abortWithError(c, err, "failed to update document header")
 			return
 		}
 
@@ -107,61 +120,70 @@ func (a *App) HandlerPostContent() gin.HandlerFunc {
 }
 
 func (a *App) HandlerGetDocument() gin.HandlerFunc {
-	type response struct {
-		Name string `json:"name"`
-	}
-
 	return func(c *gin.Context) {
 		id := DocID(c.Param("id"))
-		// sess := sessions.Default(c)
-		// userID := sess.Get(UserIDKey).(string)
 
-		header, err := a.documents.Get(id)
+		header, _, err := a.authorize(c, id, canRead)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusNotFound, Response{
-				Message: "failed to obtain document",
-			})
+			abortWithError(c, err, "failed to obtain document")
 			return
 		}
 
-		c.JSON(http.StatusOK, response{
-			Name: header.Name,
-		})
+		c.JSON(http.StatusOK, newDocumentResponse(header))
 	}
 }
 
 func (a *App) HandlerDeleteDocument() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := DocID(c.Param("id"))
-		// sess := sessions.Default(c)
-		// userID := sess.Get(UserIDKey).(string)
 
-		// if err := a.permissions.Delete(userID, id); err != nil {
-		// 	c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-		// 		Message: "failed to delete permission",
-		// 	})
-		// 	return
-		// }
+		header, _, err := a.authorize(c, id, canDelete)
+		if err != nil {
+			abortWithError(c, err, "delete document")
+			return
+		}
 
 		if err := a.documents.Delete(id); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Message: "failed to delete documents entry",
-			})
+			abortWithError(c, err, "failed to delete documents entry")
 			return
 		}
 
-		if err := a.objects.Delete(id); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Message: "failed to delete object",
-			})
-			return
+		// documents without content don't have an object
+		if !header.Updated.IsZero() {
+			if err := a.objects.Delete(id); err != nil {
+				abortWithError(c, err, "failed to delete object")
+				return
+			}
 		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
 	}
 }
 
 func (a *App) HandlerGetDocuments() gin.HandlerFunc {
+	type response struct {
+		Success   bool               `json:"success"`
+		Documents []documentResponse `json:"documents"`
+	}
 	return func(c *gin.Context) {
-		c.Status(http.StatusNotImplemented)
+		userID := currentUser(c)
+
+		headers, err := a.documents.List(userID, currentGroups(c))
+		if err != nil {
+			abortWithError(c, err, "failed to list documents")
+			return
+		}
+
+		docs := make([]documentResponse, len(headers))
+		for i, h := range headers {
+			docs[i] = newDocumentResponse(h)
+		}
+		c.JSON(http.StatusOK, response{
+			Success:   true,
+			Documents: docs,
+		})
 	}
 }
 
@@ -175,15 +197,21 @@ func (a *App) HandlerPostDocument() gin.HandlerFunc {
 		Message string `json:"message,omitempty"`
 	}
 	return func(c *gin.Context) {
-		sess := sessions.Default(c)
-		userID := sess.Get(UserIDKey).(string)
+		userID := currentUser(c)
 
 		var req request
 		if err := c.ShouldBindJSON(&req); err != nil || req.Filename == "" {
-			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
-				Success: false,
-				Message: "invalid JSON payload",
-			})
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		quota, usage, err := a.quotaOf(userID, userID, currentGroups(c))
+		if err != nil {
+			abortWithError(c, err, "unable to check quota")
+			return
+		}
+		if err := quota.checkDocuments(usage); err != nil {
+			abortWithError(c, err, "document quota exceeded")
 			return
 		}
 
@@ -194,22 +222,8 @@ func (a *App) HandlerPostDocument() gin.HandlerFunc {
 			Name:    req.Filename,
 			Owner:   userID,
 			Created: a.clock.Now(),
-		}, ACL{
-			Permissions: map[string]Permission{
-				userID: {
-					Username: userID,
-					Read:     true,
-					Write:    true,
-					Delete:   true,
-					Share:    true,
-				},
-			},
-		}); err != nil {
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, Response{
-				Success: false,
-				Message: "unable to create documents entry",
-			})
+		}, ownerACL(userID)); err != nil {
+			abortWithError(c, err, "unable to create documents entry")
 			return
 		}
 
@@ -219,3 +233,140 @@ func (a *App) HandlerPostDocument() gin.HandlerFunc {
 		})
 	}
 }
+
+func (a *App) HandlerPostShare() gin.HandlerFunc {
+	// exactly one of Username and Group must be set
+	type request struct {
+		Username string `json:"username"`
+		Group    string `json:"group"`
+		Read     bool   `json:"read"`
+		Write    bool   `json:"write"`
+		Delete   bool   `json:"delete"`
+		Share    bool   `json:"share"`
+	}
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Username == "") == (req.Group == "") {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+		principal := UserPrincipal(req.Username)
+		if req.Group != "" {
+			principal = GroupPrincipal(req.Group)
+		}
+
+		docHeader, acl, err := a.authorize(c, id, canShare)
+		if err != nil {
+			abortWithError(c, err, "share document")
+			return
+		}
+
+		perm := Permission{
+			Principal: principal,
+			Read:      req.Read,
+			Write:     req.Write,
+			Delete:    req.Delete,
+			Share:     req.Share,
+		}
+		// nobody may lock the owner out, and nobody may grant rights that
+		// they don't have themselves
+		own, _ := effectivePermission(c, acl)
+		if principal == UserPrincipal(docHeader.Owner) || !covers(own, perm) {
+			abortWithError(c, fmt.Errorf("share document %v: %w", id, ErrForbidden), "share document")
+			return
+		}
+		if perm == (Permission{Principal: principal}) {
+			delete(acl.Permissions, principal)
+		} else {
+			acl.Permissions[principal] = perm
+		}
+
+		if err := a.documents.Update(docHeader, acl); err != nil {
+			abortWithError(c, err, "failed to update ACL")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+// authorize returns the header and the ACL of the document, or an error
+// wrapping ErrForbidden if the effective permission of the current user and
+// its groups is not allowed. Requests with an API token only have the rights
+// of its scopes.
+func (a *App) authorize(c *gin.Context, id DocID, allowed func(Permission) bool) (DocumentHeader, ACL, error) {
+	header, err := a.documents.Get(id)
+	if err != nil {
+		return DocumentHeader{}, ACL{}, err
+	}
+
+	acl, err := a.documents.ACL(id)
+	if err != nil {
+		return DocumentHeader{}, ACL{}, err
+	}
+
+	perm, ok := effectivePermission(c, acl)
+	if !ok {
+		// don't reveal the existence of documents that the user can't see
+		return DocumentHeader{}, ACL{}, fmt.Errorf("document %v: %w", id, ErrNotFound)
+	}
+	if !allowed(perm) {
+		return DocumentHeader{}, ACL{}, fmt.Errorf("document %v: %w", id, ErrForbidden)
+	}
+	return header, acl, nil
+}
+
+// effectivePermission returns the permission of the current user and its
+// groups, restricted to the scopes of the API token of the request.
+func effectivePermission(c *gin.Context, acl ACL) (Permission, bool) {
+	perm, ok := acl.Effective(currentUser(c), currentGroups(c))
+	if t, found := currentAPIToken(c); found {
+		perm = t.restrictPermission(perm)
+	}
+	return perm, ok
+}
+
+// covers reports whether p has all the rights of other.
+func covers(p, other Permission) bool {
+	return (p.Read || !other.Read) &&
+		(p.Write || !other.Write) &&
+		(p.Delete || !other.Delete) &&
+		(p.Share || !other.Share)
+}
+
+func canRead(p Permission) bool   { return p.Read }
+func canWrite(p Permission) bool  { return p.Write }
+func canDelete(p Permission) bool { return p.Delete }
+func canShare(p Permission) bool  { return p.Share }
+
+func canShareRead(p Permission) bool { return p.Read && p.Share }
+
+type documentResponse struct {
+	ID      DocID      `json:"id"`
+	Name    string     `json:"name"`
+	Owner   string     `json:"owner"`
+	Size    int64      `json:"size"`
+	Created time.Time  `json:"created"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+func newDocumentResponse(h DocumentHeader) documentResponse {
+	res := documentResponse{
+		ID:      h.ID,
+		Name:    h.Name,
+		Owner:   h.Owner,
+		Size:    h.Size,
+		Created: h.Created,
+	}
+	if !h.Updated.IsZero() {
+		res.Updated = &h.Updated
+	}
+	return res
+}
+
+// maxContentSize is the maximum size of the content of documents.
+const maxContentSize = 1 << 29 // 512MB
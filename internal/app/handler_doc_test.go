@@ -1,6 +1,7 @@
 package app
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"time"
@@ -16,6 +17,7 @@ func (suite *AppSuite) TestPostDocumentNoLogin() {
 			"size":     1234,
 		}).
 		ExpectJSON(http.StatusUnauthorized, M{
+			"code":    "unauthorized",
 			"message": "not logged in",
 			"success": false,
 		})
@@ -29,7 +31,7 @@ func (suite *AppSuite) TestPostDocument() {
 		return testUUID
 	}
 	clock := SingleTimestampClock{time.Now()}
-	suite.app.clock = clock
+	suite.clock.set(clock)
 
 	suite.
 		Post("/doc").
@@ -55,13 +57,13 @@ func (suite *AppSuite) TestPostDocument() {
 	acl, err := suite.app.documents.ACL(DocID(testUUID.String()))
 	suite.NoError(err)
 	suite.Equal(ACL{
-		Permissions: map[string]Permission{
-			user: {
-				Username: user,
-				Read:     true,
-				Write:    true,
-				Delete:   true,
-				Share:    true,
+		Permissions: map[Principal]Permission{
+			UserPrincipal(user): {
+				Principal: UserPrincipal(user),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
 			},
 		},
 	}, acl)
@@ -77,7 +79,7 @@ func (suite *AppSuite) TestPostContent() {
 		return testUUID
 	}
 	clock := SingleTimestampClock{time.Now()}
-	suite.app.clock = clock
+	suite.clock.set(clock)
 
 	// create required document header
 	suite.
@@ -119,3 +121,396 @@ func (suite *AppSuite) TestPostContent() {
 	suite.EqualTime(want, doc.Created)
 	suite.EqualTime(want, doc.Updated)
 }
+
+func (suite *AppSuite) TestGetDocuments() {
+	user := suite.login()
+
+	testUUID := uuid.New()
+	suite.app.genUUID = func() uuid.UUID {
+		return testUUID
+	}
+	clock := SingleTimestampClock{time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)}
+	suite.clock.set(clock)
+
+	suite.
+		Get("/doc").
+		ExpectJSON(http.StatusOK, M{
+			"success":   true,
+			"documents": []M{},
+		})
+
+	suite.
+		Post("/doc").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"id":      testUUID.String(),
+		})
+
+	doc := M{
+		"id":      testUUID.String(),
+		"name":    "myfile",
+		"owner":   user,
+		"size":    0,
+		"created": "2021-05-01T12:00:00Z",
+	}
+	suite.
+		Get("/doc").
+		ExpectJSON(http.StatusOK, M{
+			"success":   true,
+			"documents": []M{doc},
+		})
+	suite.
+		Get("/doc/"+testUUID.String()).
+		ExpectJSON(http.StatusOK, doc)
+}
+
+func (suite *AppSuite) TestPostShare() {
+	user := suite.login()
+
+	testUUID := uuid.New()
+	suite.app.genUUID = func() uuid.UUID {
+		return testUUID
+	}
+	id := DocID(testUUID.String())
+
+	suite.
+		Post("/doc").
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"id":      testUUID.String(),
+		})
+
+	suite.
+		Post("/doc/"+testUUID.String()+"/share").
+		BodyJSON(M{
+			"username": "otheruser",
+			"read":     true,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	acl, err := suite.app.documents.ACL(id)
+	suite.NoError(err)
+	suite.Equal(Permission{Principal: UserPrincipal("otheruser"), Read: true}, acl.Permissions[UserPrincipal("otheruser")])
+	suite.True(acl.Permissions[UserPrincipal(user)].Share)
+
+	// revoke all permissions again
+	suite.
+		Post("/doc/"+testUUID.String()+"/share").
+		BodyJSON(M{
+			"username": "otheruser",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	acl, err = suite.app.documents.ACL(id)
+	suite.NoError(err)
+	suite.NotContains(acl.Permissions, UserPrincipal("otheruser"))
+}
+
+func (suite *AppSuite) TestPostShareGroup() {
+	owner := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   owner,
+		Created: time.Now(),
+	}, ownerACL(owner)))
+
+	suite.
+		Post("/doc/doc/share").
+		BodyJSON(M{
+			"group": "editors",
+			"read":  true,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	acl, err := suite.app.documents.ACL("doc")
+	suite.NoError(err)
+	suite.Equal(Permission{Principal: GroupPrincipal("editors"), Read: true}, acl.Permissions[GroupPrincipal("editors")])
+
+	// the grants of the user and of its groups add up
+	suite.
+		Post("/doc/doc/share").
+		BodyJSON(M{
+			"username": "member",
+			"delete":   true,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	mem := suite.app.auth.(*MemAuthService)
+	suite.createUser("member", "memberpass")
+	suite.Require().NoError(mem.CreateGroup("editors"))
+	suite.Require().NoError(mem.AddUserToGroup("member", "editors"))
+	suite.logout()
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": "member",
+			"password": "memberpass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.fetchCSRFToken()
+
+	var res struct {
+		Documents []struct {
+			ID string `json:"id"`
+		} `json:"documents"`
+	}
+	suite.
+		Get("/doc").
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+		})
+	suite.Require().Len(res.Documents, 1)
+	suite.Equal("doc", res.Documents[0].ID)
+	suite.
+		Post("/doc/doc/share").
+		BodyJSON(M{
+			"username": "member",
+			"share":    true,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"code":    "forbidden",
+			"message": "share document",
+			"success": false,
+		})
+	suite.
+		Request(http.MethodDelete, "/doc/doc").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+}
+
+func (suite *AppSuite) TestPostShareGroupWithAPIToken() {
+	owner := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   owner,
+		Created: time.Now(),
+	}, ownerACL(owner)))
+	suite.
+		Post("/doc/doc/share").
+		BodyJSON(M{
+			"group": "readers",
+			"read":  true,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	member := suite.login()
+	token := suite.createToken(ScopeRead)
+	suite.logout()
+
+	// the groups are resolved for every request with a token
+	suite.
+		Get("/doc/doc").
+		Header("Authorization", "Bearer "+token).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusNotFound, res.StatusCode)
+		})
+
+	mem := suite.app.auth.(*MemAuthService)
+	suite.Require().NoError(mem.CreateGroup("readers"))
+	suite.Require().NoError(mem.AddUserToGroup(member, "readers"))
+	suite.
+		Get("/doc/doc").
+		Header("Authorization", "Bearer "+token).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+		})
+}
+
+func (suite *AppSuite) TestPostShareUserAndGroup() {
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	suite.
+		Post("/doc/doc/share").
+		BodyJSON(M{
+			"username": "otheruser",
+			"group":    "editors",
+			"read":     true,
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusBadRequest, res.StatusCode)
+		})
+}
+
+func (suite *AppSuite) TestPostShareForbidden() {
+	user := suite.login()
+
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "foreign",
+		Name:    "foreign",
+		Owner:   "otheruser",
+		Created: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal("otheruser"): {Principal: UserPrincipal("otheruser"), Read: true, Write: true, Delete: true, Share: true},
+			UserPrincipal(user):        {Principal: UserPrincipal(user), Read: true},
+		},
+	}))
+
+	suite.
+		Post("/doc/foreign/share").
+		BodyJSON(M{
+			"username": user,
+			"read":     true,
+			"share":    true,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"code":    "forbidden",
+			"message": "share document",
+			"success": false,
+		})
+}
+
+func (suite *AppSuite) TestGetDocumentNotFound() {
+	suite.login()
+
+	suite.
+		Get("/doc/doesnotexist").
+		ExpectJSON(http.StatusNotFound, M{
+			"code":    "not_found",
+			"message": "failed to obtain document",
+			"success": false,
+		})
+}
+
+func (suite *AppSuite) TestGetContentNotUploaded() {
+	user := suite.login()
+
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "empty",
+		Name:    "empty",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	suite.
+		Get("/doc/empty/content").
+		ExpectJSON(http.StatusNotFound, M{
+			"code":    "not_found",
+			"message": "no content for id",
+			"success": false,
+		})
+}
+
+func (suite *AppSuite) TestPostShareBeyondOwnRights() {
+	user := suite.login()
+
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "foreign",
+		Name:    "foreign",
+		Owner:   "otheruser",
+		Created: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal("otheruser"): {Principal: UserPrincipal("otheruser"), Read: true, Write: true, Delete: true, Share: true},
+			UserPrincipal(user):        {Principal: UserPrincipal(user), Read: true, Share: true},
+		},
+	}))
+
+	for _, req := range []M{
+		{"username": "thirduser", "read": true, "write": true},
+		{"username": user, "delete": true, "read": true, "share": true},
+		{"group": "editors", "write": true},
+	} {
+		suite.
+			Post("/doc/foreign/share").
+			BodyJSON(req).
+			ExpectJSON(http.StatusForbidden, M{
+				"code":    "forbidden",
+				"message": "share document",
+				"success": false,
+			})
+	}
+
+	// rights that the user has can be passed on
+	suite.
+		Post("/doc/foreign/share").
+		BodyJSON(M{
+			"group": "editors",
+			"read":  true,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	acl, err := suite.app.documents.ACL("foreign")
+	suite.NoError(err)
+	suite.Equal(Permission{Principal: GroupPrincipal("editors"), Read: true}, acl.Permissions[GroupPrincipal("editors")])
+}
+
+func (suite *AppSuite) TestPostShareOwner() {
+	user := suite.login()
+
+	ownerPerm := Permission{Principal: UserPrincipal("otheruser"), Read: true, Write: true, Delete: true, Share: true}
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "foreign",
+		Name:    "foreign",
+		Owner:   "otheruser",
+		Created: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal("otheruser"): ownerPerm,
+			UserPrincipal(user):        {Principal: UserPrincipal(user), Read: true, Write: true, Delete: true, Share: true},
+		},
+	}))
+
+	// neither revoking nor restricting the rights of the owner is allowed
+	for _, req := range []M{
+		{"username": "otheruser"},
+		{"username": "otheruser", "read": true},
+	} {
+		suite.
+			Post("/doc/foreign/share").
+			BodyJSON(req).
+			ExpectJSON(http.StatusForbidden, M{
+				"code":    "forbidden",
+				"message": "share document",
+				"success": false,
+			})
+	}
+
+	acl, err := suite.app.documents.ACL("foreign")
+	suite.NoError(err)
+	suite.Equal(ownerPerm, acl.Permissions[UserPrincipal("otheruser")])
+}
+
+func (suite *AppSuite) TestUpdateUnknownDocument() {
+	err := suite.app.documents.Update(DocumentHeader{
+		ID:      "unknown",
+		Name:    "myfile",
+		Owner:   "user",
+		Created: time.Now(),
+	}, ownerACL("user"))
+	suite.ErrorIs(err, ErrNotFound)
+
+	_, err = suite.app.documents.Get("unknown")
+	suite.ErrorIs(err, ErrNotFound)
+	usage, err := suite.app.documents.Usage("user")
+	suite.NoError(err)
+	suite.Zero(usage.Documents)
+}
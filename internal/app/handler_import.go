@@ -0,0 +1,330 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandlerPostImport starts the import of a ZIP, tar or gzip compressed tar
+// archive. Every regular file of the archive becomes a document of the
+// user, whose name is the slash separated path of the entry, so that the
+// directories of the archive are kept like they are with WebDAV. The import
+// runs as a job of JobTypeImport, see HandlerGetImport.
+func (a *App) HandlerPostImport() gin.HandlerFunc {
+	type response struct {
+		Success bool   `json:"success"`
+		ID      string `json:"id"`
+	}
+	return func(c *gin.Context) {
+		user := currentUser(c)
+
+		ff, err := c.FormFile("file")
+		if err != nil {
+			abortWithError(c, fmt.Errorf("form file: %v: %w", err, ErrValidation), "failed to receive file")
+			return
+		}
+		if ff.Size > maxImportSize {
+			abortWithError(c, fmt.Errorf("%d bytes: %w", ff.Size, ErrValidation), "archive too large")
+			return
+		}
+		f, err := ff.Open()
+		if err != nil {
+			abortWithError(c, fmt.Errorf("open form file: %v: %w", err, ErrValidation), "failed to open file")
+			return
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		br := bufio.NewReaderSize(f, 512)
+		head, err := br.Peek(512)
+		if err != nil && !errors.Is(err, io.EOF) {
+			abortWithError(c, fmt.Errorf("read form file: %v: %w", err, ErrValidation), "failed to receive file")
+			return
+		}
+		if detectArchiveFormat(head) == archiveUnknown {
+			abortWithError(c, ErrValidation, "unsupported archive format")
+			return
+		}
+
+		// the archive is kept in the object storage, since the job may run
+		// on another instance
+		object := DocID("import/" + a.genUUID().String())
+		if err := a.objects.Create(object, br); err != nil {
+			abortWithError(c, err, "failed to store archive")
+			return
+		}
+		job, err := a.EnqueueJob(JobTypeImport, user, importPayload{
+			Object: object,
+			Size:   ff.Size,
+			Groups: currentGroups(c),
+		})
+		if err != nil {
+			if delErr := a.objects.Delete(object); delErr != nil {
+				a.log.Error().Err(delErr).Str("object", string(object)).Msg("delete archive")
+			}
+			abortWithError(c, err, "unable to start import")
+			return
+		}
+
+		c.JSON(http.StatusAccepted, response{
+			Success: true,
+			ID:      job.ID,
+		})
+	}
+}
+
+// runImportJob imports the entries of the archive one after another. Entries
+// that fail are reported in the results, only an invalid archive fails the
+// whole import. The archive is deleted in any case.
+func (a *App) runImportJob(ctx context.Context, run *JobRun) error {
+	var p importPayload
+	if err := run.Payload(&p); err != nil {
+		return err
+	}
+	defer func() {
+		if err := a.objects.Delete(p.Object); err != nil {
+			a.log.Error().Err(err).Str("object", string(p.Object)).Msg("delete archive")
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, err := a.spoolImport(p.Object)
+	if err != nil {
+		a.log.Error().Err(err).Str("object", string(p.Object)).Msg("read archive")
+		return errors.New("import failed")
+	}
+	defer closeImportFile(f)
+
+	owner := run.Job().Owner
+	res := importResult{Results: []ImportResult{}}
+	run.SetResult(res)
+	run.Progress(0, p.Size)
+	err = walkArchive(f, p.Size, func(e importEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// directories are only kept in the names of the documents
+		if e.dir {
+			return nil
+		}
+		res.Results = append(res.Results, a.importEntry(owner, p.Groups, e))
+		run.SetResult(res)
+		run.Progress(e.read(), p.Size)
+		return nil
+	})
+	switch {
+	case err == nil:
+		run.Progress(p.Size, p.Size)
+		return nil
+	case ctx.Err() != nil:
+		return err
+	case errors.Is(err, ErrValidation):
+		a.log.Warn().Err(err).Str("object", string(p.Object)).Msg("invalid archive")
+		return errors.New("invalid archive")
+	default:
+		a.log.Error().Err(err).Str("object", string(p.Object)).Msg("import archive")
+		return errors.New("import failed")
+	}
+}
+
+// spoolImport copies the archive into a temporary file, since ZIP archives
+// can't be read sequentially.
+func (a *App) spoolImport(object DocID) (*os.File, error) {
+	rd, err := a.objects.Read(object)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	f, err := os.CreateTemp("", "import-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	if _, err := io.Copy(f, rd); err != nil {
+		closeImportFile(f)
+		return nil, fmt.Errorf("spool archive: %w", err)
+	}
+	return f, nil
+}
+
+func closeImportFile(f *os.File) {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
+
+// importEntry creates a document for the entry and stores its content. A
+// document that was created already is deleted again if the content can't
+// be stored.
+func (a *App) importEntry(owner string, groups []string, e importEntry) ImportResult {
+	res := ImportResult{Name: cleanEntryName(e.name)}
+	switch {
+	case res.Name == "":
+		res.Error = "invalid name"
+		return res
+	case !e.regular:
+		res.Error = "not a regular file"
+		return res
+	case e.size > maxContentSize:
+		res.Error = "file too large"
+		return res
+	}
+
+	quota, usage, err := a.quotaOf(owner, owner, groups)
+	if err == nil {
+		err = quota.checkDocuments(usage)
+	}
+	if err != nil {
+		res.Error = a.importError(err, res.Name)
+		return res
+	}
+
+	rd, err := e.open()
+	if err != nil {
+		res.Error = a.importError(err, res.Name)
+		return res
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	header := DocumentHeader{
+		ID:      DocID(a.genUUID().String()),
+		Name:    res.Name,
+		Owner:   owner,
+		Created: a.clock.Now(),
+	}
+	acl := ownerACL(owner)
+	if err := a.documents.Create(header, acl); err != nil {
+		res.Error = a.importError(err, res.Name)
+		return res
+	}
+
+	qr := &quotaReader{rd: io.LimitReader(rd, maxContentSize), limit: quota.remainingBytes(usage, 0)}
+	err = a.objects.Create(header.ID, qr)
+	if err == nil {
+		header.Size = qr.n
+		header.Updated = a.clock.Now()
+		err = a.documents.Update(header, acl)
+	}
+	if err != nil {
+		if delErr := a.documents.Delete(header.ID); delErr != nil {
+			a.log.Error().Err(delErr).Str("doc", string(header.ID)).Msg("delete document of failed import")
+		}
+		res.Error = a.importError(err, res.Name)
+		return res
+	}
+
+	res.ID = header.ID
+	res.Size = header.Size
+	return res
+}
+
+// importError returns the message of the result of an entry that failed.
+func (a *App) importError(err error, name string) string {
+	if errors.Is(err, ErrQuotaExceeded) {
+		return "storage quota exceeded"
+	}
+	a.log.Error().Err(err).Str("entry", name).Msg("import entry")
+	return "import failed"
+}
+
+// HandlerGetImport returns the progress of an import of the user, and the
+// results of the entries that are imported so far. It's the job of the
+// import, see HandlerGetJob, in the terms of an import.
+func (a *App) HandlerGetImport() gin.HandlerFunc {
+	type response struct {
+		Success bool           `json:"success"`
+		Import  importResponse `json:"import"`
+	}
+	return func(c *gin.Context) {
+		job, err := a.jobs.Get(c.Param("id"))
+		if err == nil && (job.Owner != currentUser(c) || job.Type != JobTypeImport) {
+			err = fmt.Errorf("import %v: %w", job.ID, ErrNotFound)
+		}
+		if err != nil {
+			abortWithError(c, err, "import not found")
+			return
+		}
+
+		res, err := newImportResponse(job)
+		if err != nil {
+			abortWithError(c, err, "invalid import")
+			return
+		}
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Import:  res,
+		})
+	}
+}
+
+// States of imports, which are those of their jobs, except that a queued
+// import is running already.
+const (
+	importRunning  = "running"
+	importFinished = "finished"
+	importFailed   = "failed"
+	importCanceled = "canceled"
+)
+
+type importResponse struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	// Size is the size of the archive, Read how much of it is imported.
+	Size     int64          `json:"size"`
+	Read     int64          `json:"read"`
+	Error    string         `json:"error,omitempty"`
+	Results  []ImportResult `json:"results"`
+	Created  time.Time      `json:"created"`
+	Finished *time.Time     `json:"finished,omitempty"`
+}
+
+func newImportResponse(job Job) (importResponse, error) {
+	var p importPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return importResponse{}, fmt.Errorf("decode payload: %w", err)
+	}
+	result := importResult{Results: []ImportResult{}}
+	if len(job.Result) > 0 {
+		if err := json.Unmarshal(job.Result, &result); err != nil {
+			return importResponse{}, fmt.Errorf("decode result: %w", err)
+		}
+	}
+
+	res := importResponse{
+		ID:      job.ID,
+		State:   importRunning,
+		Size:    p.Size,
+		Read:    job.Done,
+		Results: result.Results,
+		Created: job.Created,
+	}
+	switch job.State {
+	case JobSucceeded:
+		res.State = importFinished
+	case JobFailed:
+		res.State = importFailed
+		res.Error = job.Error
+	case JobCanceled:
+		res.State = importCanceled
+		res.Error = job.Error
+	}
+	if !job.Finished.IsZero() {
+		res.Finished = &job.Finished
+	}
+	return res, nil
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+)
+
+// startImport uploads the archive and waits until its import is finished.
+func (suite *AppSuite) startImport(name string, data []byte) importResponse {
+	var started struct {
+		ID string `json:"id"`
+	}
+	suite.
+		Post("/doc/import").
+		File("file", name, data).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusAccepted, res.StatusCode)
+			suite.NoError(json.NewDecoder(res.Body).Decode(&started))
+			suite.NoError(res.Body.Close())
+		})
+	suite.Require().NotEmpty(started.ID)
+	suite.waitJob(started.ID)
+
+	var res struct {
+		Import importResponse `json:"import"`
+	}
+	suite.
+		Get("/doc/import/" + started.ID).
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	return res.Import
+}
+
+// importedContent returns the name and the content of the imported document.
+func (suite *AppSuite) importedContent(id DocID) (string, string) {
+	header, err := suite.app.documents.Get(id)
+	suite.Require().NoError(err)
+	return header.Name, string(suite.app.objects.(*MemObjectStorage).data[id])
+}
+
+func (suite *AppSuite) TestPostImportZip() {
+	user := suite.login()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range []struct{ name, content string }{
+		{"docs/", ""},
+		{"docs/a.txt", "first"},
+		{"../b.txt", "second"},
+		{".", ""},
+	} {
+		w, err := zw.Create(entry.name)
+		suite.NoError(err)
+		_, err = w.Write([]byte(entry.content))
+		suite.NoError(err)
+	}
+	suite.NoError(zw.Close())
+
+	imp := suite.startImport("legacy.zip", buf.Bytes())
+	suite.Equal(importFinished, imp.State)
+	suite.Equal(int64(buf.Len()), imp.Read)
+	suite.NotNil(imp.Finished)
+	suite.Require().Len(imp.Results, 3)
+
+	for i, want := range []struct{ name, content string }{
+		{"docs/a.txt", "first"},
+		{"_/b.txt", "second"},
+	} {
+		res := imp.Results[i]
+		suite.Equal(want.name, res.Name)
+		suite.Equal(int64(len(want.content)), res.Size)
+		suite.Empty(res.Error)
+		name, content := suite.importedContent(res.ID)
+		suite.Equal(want.name, name)
+		suite.Equal(want.content, content)
+	}
+	suite.Equal(ImportResult{Error: "invalid name"}, imp.Results[2])
+
+	docs, err := suite.app.documents.List(user, nil)
+	suite.NoError(err)
+	suite.Len(docs, 2)
+}
+
+func (suite *AppSuite) TestPostImportTarGzip() {
+	suite.login()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	suite.NoError(tw.WriteHeader(&tar.Header{Name: "a.txt", Typeflag: tar.TypeReg, Size: 5, Mode: 0644}))
+	_, err := tw.Write([]byte("hello"))
+	suite.NoError(err)
+	suite.NoError(tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+	suite.NoError(tw.Close())
+	suite.NoError(gw.Close())
+
+	imp := suite.startImport("legacy.tar.gz", buf.Bytes())
+	suite.Equal(importFinished, imp.State)
+	suite.Require().Len(imp.Results, 2)
+	suite.Equal("a.txt", imp.Results[0].Name)
+	_, content := suite.importedContent(imp.Results[0].ID)
+	suite.Equal("hello", content)
+	suite.Equal(ImportResult{Name: "link", Error: "not a regular file"}, imp.Results[1])
+}
+
+func (suite *AppSuite) TestPostImportQuota() {
+	user := suite.login()
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal(user), MaxBytes: 8}))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		suite.NoError(tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 5, Mode: 0644}))
+		_, err := tw.Write([]byte("hello"))
+		suite.NoError(err)
+	}
+	suite.NoError(tw.Close())
+
+	imp := suite.startImport("legacy.tar", buf.Bytes())
+	suite.Require().Len(imp.Results, 2)
+	suite.Empty(imp.Results[0].Error)
+	suite.Equal(ImportResult{Name: "b.txt", Error: "storage quota exceeded"}, imp.Results[1])
+
+	docs, err := suite.app.documents.List(user, nil)
+	suite.NoError(err)
+	suite.Len(docs, 1)
+}
+
+func (suite *AppSuite) TestPostImportUnsupported() {
+	suite.login()
+
+	suite.
+		Post("/doc/import").
+		File("file", "notes.txt", []byte("not an archive")).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    CodeValidation,
+			"message": "unsupported archive format",
+		})
+}
+
+func (suite *AppSuite) TestGetImportNotFound() {
+	user := suite.login()
+	// a job of the user that isn't an import
+	suite.NoError(suite.app.jobs.Enqueue(Job{ID: "job", Type: "other", Owner: user, State: JobQueued, RunAt: farFuture}))
+	suite.NoError(suite.app.jobs.Enqueue(Job{ID: "other", Type: JobTypeImport, Owner: "other", State: JobQueued, RunAt: farFuture}))
+
+	for _, id := range []string{"job", "other", "unknown"} {
+		suite.
+			Get("/doc/import/"+id).
+			ExpectJSON(http.StatusNotFound, M{
+				"success": false,
+				"code":    CodeNotFound,
+				"message": "import not found",
+			})
+	}
+}
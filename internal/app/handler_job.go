@@ -0,0 +1,130 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *App) HandlerGetJobs() gin.HandlerFunc {
+	type response struct {
+		Success bool          `json:"success"`
+		Jobs    []jobResponse `json:"jobs"`
+	}
+	return func(c *gin.Context) {
+		jobs, err := a.jobs.List(currentUser(c))
+		if err != nil {
+			abortWithError(c, err, "failed to list jobs")
+			return
+		}
+
+		res := make([]jobResponse, len(jobs))
+		for i, j := range jobs {
+			res[i] = newJobResponse(j)
+		}
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Jobs:    res,
+		})
+	}
+}
+
+func (a *App) HandlerGetJob() gin.HandlerFunc {
+	type response struct {
+		Success bool        `json:"success"`
+		Job     jobResponse `json:"job"`
+	}
+	return func(c *gin.Context) {
+		job, err := a.jobOf(c)
+		if err != nil {
+			abortWithError(c, err, "job not found")
+			return
+		}
+
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Job:     newJobResponse(job),
+		})
+	}
+}
+
+// HandlerPostJobCancel requests the cancellation of a job of the user. The
+// job is canceled by its worker, so it may still finish in the meantime.
+func (a *App) HandlerPostJobCancel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := a.jobOf(c)
+		if err != nil {
+			abortWithError(c, err, "job not found")
+			return
+		}
+		if err := a.jobs.Cancel(job.ID, a.clock.Now()); err != nil {
+			abortWithError(c, err, "unable to cancel job")
+			return
+		}
+		// queued jobs are canceled by a worker as well
+		a.wakeJobWorker()
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+// jobOf returns the job of the request, if it's one of the current user.
+func (a *App) jobOf(c *gin.Context) (Job, error) {
+	job, err := a.jobs.Get(c.Param("id"))
+	if err != nil {
+		return Job{}, err
+	}
+	if job.Owner != currentUser(c) {
+		return Job{}, fmt.Errorf("job %v: %w", job.ID, ErrNotFound)
+	}
+	return job, nil
+}
+
+type jobResponse struct {
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	State           JobState        `json:"state"`
+	Done            int64           `json:"done"`
+	Total           int64           `json:"total"`
+	Attempts        int             `json:"attempts"`
+	MaxAttempts     int             `json:"max_attempts"`
+	CancelRequested bool            `json:"cancel_requested"`
+	Error           string          `json:"error,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Created         time.Time       `json:"created"`
+	Updated         time.Time       `json:"updated"`
+	// RunAt is only set while the job is queued.
+	RunAt    *time.Time `json:"run_at,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func newJobResponse(j Job) jobResponse {
+	res := jobResponse{
+		ID:              j.ID,
+		Type:            j.Type,
+		State:           j.State,
+		Done:            j.Done,
+		Total:           j.Total,
+		Attempts:        j.Attempts,
+		MaxAttempts:     j.MaxAttempts,
+		CancelRequested: j.CancelRequested,
+		Error:           j.Error,
+		Created:         j.Created,
+		Updated:         j.Updated,
+	}
+	if len(j.Result) > 0 {
+		res.Result = j.Result
+	}
+	if j.State == JobQueued {
+		res.RunAt = &j.RunAt
+	}
+	if !j.Finished.IsZero() {
+		res.Finished = &j.Finished
+	}
+	return res
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// farFuture is when jobs are due that the workers must not run in tests.
+var farFuture = time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// waitJob waits until the job with the given ID is finished.
+func (suite *AppSuite) waitJob(id string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, err := suite.app.jobs.Get(id)
+		suite.Require().NoError(err)
+		if j.State.final() {
+			return j
+		}
+		suite.Require().True(time.Now().Before(deadline), "job %v is %v", id, j.State)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (suite *AppSuite) TestGetJobs() {
+	user := suite.login()
+	created := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.NoError(suite.app.jobs.Enqueue(Job{ID: "queued", Type: "export", Owner: user, State: JobQueued, MaxAttempts: 3, RunAt: farFuture, Created: created, Updated: created}))
+	suite.NoError(suite.app.jobs.Enqueue(Job{ID: "other", Type: "export", Owner: "other", State: JobQueued, MaxAttempts: 3, RunAt: farFuture, Created: created, Updated: created}))
+
+	suite.
+		Get("/jobs").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"jobs": []M{{
+				"id":               "queued",
+				"type":             "export",
+				"state":            "queued",
+				"done":             0,
+				"total":            0,
+				"attempts":         0,
+				"max_attempts":     3,
+				"cancel_requested": false,
+				"created":          created,
+				"updated":          created,
+				"run_at":           farFuture,
+			}},
+		})
+
+	suite.
+		Get("/jobs/other").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    CodeNotFound,
+			"message": "job not found",
+		})
+}
+
+func (suite *AppSuite) TestPostJobCancel() {
+	user := suite.login()
+	suite.NoError(suite.app.jobs.Enqueue(Job{ID: "job", Type: JobTypeImport, Owner: user, Payload: []byte(`{"object":"import/job"}`), State: JobQueued, MaxAttempts: 1, RunAt: farFuture}))
+
+	suite.
+		Post("/jobs/job/cancel").
+		ExpectJSON(http.StatusOK, M{"success": true})
+
+	// the job is due right away, so that the worker cancels it
+	j := suite.waitJob("job")
+	suite.Equal(JobCanceled, j.State)
+
+	suite.
+		Post("/jobs/job/cancel").
+		ExpectJSON(http.StatusConflict, M{
+			"success": false,
+			"code":    CodeConflict,
+			"message": "unable to cancel job",
+		})
+}
+
+func (suite *AppSuite) TestGetJobImport() {
+	suite.login()
+
+	var started struct {
+		ID string `json:"id"`
+	}
+	suite.
+		Post("/doc/import").
+		File("file", "empty.zip", []byte("PK\x05\x06"+string(make([]byte, 18)))).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusAccepted, res.StatusCode)
+			suite.NoError(json.NewDecoder(res.Body).Decode(&started))
+			suite.NoError(res.Body.Close())
+		})
+	suite.waitJob(started.ID)
+
+	var res struct {
+		Job jobResponse `json:"job"`
+	}
+	suite.
+		Get("/jobs/" + started.ID).
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	suite.Equal(JobTypeImport, res.Job.Type)
+	suite.Equal(JobSucceeded, res.Job.State)
+	suite.Equal(1, res.Job.Attempts)
+	suite.JSONEq(`{"results":[]}`, string(res.Job.Result))
+
+	// the archive is deleted once it's imported
+	suite.Empty(suite.app.objects.(*MemObjectStorage).data)
+}
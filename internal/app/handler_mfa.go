@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *App) HandlerPostTOTP() gin.HandlerFunc {
+	type response struct {
+		Success bool   `json:"success"`
+		Secret  string `json:"secret"`
+		URI     string `json:"uri"`
+	}
+	return func(c *gin.Context) {
+		enroller, ok := a.auth.(TOTPEnroller)
+		if !ok {
+			abortWithError(c, ErrNotFound, "authenticators are not supported")
+			return
+		}
+
+		secret, uri, err := enroller.EnrollTOTP(currentUser(c))
+		if err != nil {
+			abortWithError(c, err, "failed to enroll authenticator")
+			return
+		}
+
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Secret:  secret,
+			URI:     uri,
+		})
+	}
+}
+
+func (a *App) HandlerPostTOTPConfirm() gin.HandlerFunc {
+	type request struct {
+		Code string `json:"code"`
+	}
+	return func(c *gin.Context) {
+		enroller, ok := a.auth.(TOTPEnroller)
+		if !ok {
+			abortWithError(c, ErrNotFound, "authenticators are not supported")
+			return
+		}
+
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		if err := enroller.ConfirmTOTP(currentUser(c), req.Code); err != nil {
+			abortWithError(c, err, "failed to confirm authenticator")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
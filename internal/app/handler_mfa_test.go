@@ -0,0 +1,216 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+	appcfg "github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+// useLocalAuth replaces the auth service with a LocalAuthService that has
+// the user alice, and returns it.
+func (suite *AppSuite) useLocalAuth(now time.Time) *LocalAuthService {
+	s, err := NewLocalAuthService(appcfg.Config{Viper: viper.New()}, NewMemLocalUserRepo())
+	suite.Require().NoError(err)
+	s.clock = SingleTimestampClock{now}
+	suite.Require().NoError(s.CreateUser("alice", "alicepass"))
+	suite.app.auth = s
+	return s
+}
+
+func (suite *AppSuite) loginLocal() {
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": "alice",
+			"password": "alicepass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.fetchCSRFToken()
+}
+
+// enrollTOTP enrolls an authenticator for the logged in user and returns
+// its secret.
+func (suite *AppSuite) enrollTOTP(now time.Time) []byte {
+	var res struct {
+		Success bool   `json:"success"`
+		Secret  string `json:"secret"`
+		URI     string `json:"uri"`
+	}
+	suite.
+		Post("/user/mfa/totp").
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	suite.True(res.Success)
+	suite.Equal(totpProvisioningURI("verbose-broccoli", "alice", res.Secret), res.URI)
+
+	key, err := totpEncoding.DecodeString(res.Secret)
+	suite.Require().NoError(err)
+
+	suite.
+		Post("/user/mfa/totp/confirm").
+		BodyJSON(M{
+			"code": totpCode(key, totpStep(now)),
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	return key
+}
+
+func (suite *AppSuite) TestLoginWithTOTP() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := suite.useLocalAuth(now)
+	suite.clock.set(SingleTimestampClock{now})
+	suite.loginLocal()
+	key := suite.enrollTOTP(now)
+	suite.logout()
+
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": "alice",
+			"password": "alicepass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success":   true,
+			"challenge": ChallengeSoftwareTokenMFA,
+		})
+	// not logged in until the challenge is answered
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "not logged in",
+		})
+
+	later := now.Add(totpPeriod)
+	s.clock = SingleTimestampClock{later}
+	suite.
+		Post("/auth/challenge").
+		BodyJSON(M{
+			"username":        "alice",
+			"challenge":       ChallengeSoftwareTokenMFA,
+			"client_response": totpCode(key, totpStep(later)),
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusOK, M{
+			"username": "alice",
+			"quota":    unlimitedQuota,
+		})
+}
+
+func (suite *AppSuite) TestChallengeWithoutLogin() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := suite.useLocalAuth(now)
+	suite.loginLocal()
+	key := suite.enrollTOTP(now)
+	suite.logout()
+
+	// the password can't be skipped by answering the challenge directly
+	later := now.Add(totpPeriod)
+	s.clock = SingleTimestampClock{later}
+	suite.
+		Post("/auth/challenge").
+		BodyJSON(M{
+			"username":        "alice",
+			"challenge":       ChallengeSoftwareTokenMFA,
+			"client_response": totpCode(key, totpStep(later)),
+		}).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "no login in progress",
+		})
+}
+
+func (suite *AppSuite) TestChallengeAttemptsLimited() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.useLocalAuth(now)
+	suite.loginLocal()
+	suite.enrollTOTP(now)
+	suite.logout()
+
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": "alice",
+			"password": "alicepass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success":   true,
+			"challenge": ChallengeSoftwareTokenMFA,
+		})
+	for i := 0; i < maxChallengeAttempts; i++ {
+		suite.
+			Post("/auth/challenge").
+			BodyJSON(M{
+				"username":        "alice",
+				"challenge":       ChallengeSoftwareTokenMFA,
+				"client_response": "000000",
+			}).
+			ExpectJSON(http.StatusUnauthorized, M{
+				"success": false,
+				"code":    "unauthorized",
+				"message": "invalid credentials",
+			})
+	}
+	suite.
+		Post("/auth/challenge").
+		BodyJSON(M{
+			"username":        "alice",
+			"challenge":       ChallengeSoftwareTokenMFA,
+			"client_response": "000000",
+		}).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "no login in progress",
+		})
+}
+
+func (suite *AppSuite) TestConfirmTOTPInvalidCode() {
+	suite.useLocalAuth(time.Now())
+	suite.loginLocal()
+
+	suite.
+		Post("/user/mfa/totp").
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(r.Body.Close())
+		})
+	suite.
+		Post("/user/mfa/totp/confirm").
+		BodyJSON(M{
+			"code": "abcdef",
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "failed to confirm authenticator",
+		})
+}
+
+func (suite *AppSuite) TestEnrollTOTPNotSupported() {
+	suite.login()
+
+	suite.
+		Post("/user/mfa/totp").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "authenticators are not supported",
+		})
+}
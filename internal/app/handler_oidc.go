@@ -0,0 +1,157 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// Session keys of a login with a RedirectAuthService that is in progress.
+const (
+	oidcStateKey    = "OIDCState"
+	oidcVerifierKey = "OIDCVerifier"
+	oidcNonceKey    = "OIDCNonce"
+	oidcReturnToKey = "OIDCReturnTo"
+)
+
+// HandlerOIDCStart redirects the user to the identity provider. The
+// optional query parameter return_to is a local path that the callback
+// redirects to after a successful login.
+func (a *App) HandlerOIDCStart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth, ok := a.auth.(RedirectAuthService)
+		if !ok {
+			abortWithError(c, ErrNotFound, "OpenID Connect is not configured")
+			return
+		}
+
+		returnTo := c.Query("return_to")
+		if returnTo != "" && !isLocalPath(returnTo) {
+			abortWithError(c, ErrValidation, "return_to must be a local path")
+			return
+		}
+
+		var values [3]string
+		for i := range values {
+			v, err := randomToken()
+			if err != nil {
+				abortWithError(c, err, "unable to start login")
+				return
+			}
+			values[i] = v
+		}
+		state, verifier, nonce := values[0], values[1], values[2]
+
+		u, err := auth.AuthCodeURL(state, pkceChallenge(verifier), nonce)
+		if err != nil {
+			abortWithError(c, err, "unable to start login")
+			return
+		}
+
+		sess := sessions.Default(c)
+		sess.Set(oidcStateKey, state)
+		sess.Set(oidcVerifierKey, verifier)
+		sess.Set(oidcNonceKey, nonce)
+		sess.Set(oidcReturnToKey, returnTo)
+		if err := sess.Save(); err != nil {
+			abortWithError(c, err, "unable to save session")
+			return
+		}
+
+		c.Redirect(http.StatusFound, u)
+	}
+}
+
+// HandlerOIDCCallback is where the identity provider redirects the user to
+// after the login.
+func (a *App) HandlerOIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth, ok := a.auth.(RedirectAuthService)
+		if !ok {
+			abortWithError(c, ErrNotFound, "OpenID Connect is not configured")
+			return
+		}
+
+		sess := sessions.Default(c)
+		state, _ := sess.Get(oidcStateKey).(string)
+		verifier, _ := sess.Get(oidcVerifierKey).(string)
+		nonce, _ := sess.Get(oidcNonceKey).(string)
+		returnTo, _ := sess.Get(oidcReturnToKey).(string)
+		// the values must only be used once
+		sess.Delete(oidcStateKey)
+		sess.Delete(oidcVerifierKey)
+		sess.Delete(oidcNonceKey)
+		sess.Delete(oidcReturnToKey)
+		if err := sess.Save(); err != nil {
+			abortWithError(c, err, "unable to save session")
+			return
+		}
+
+		if e := c.Query("error"); e != "" {
+			abortWithError(c, ErrUnauthorized, fmt.Sprintf("identity provider returned %s", e))
+			return
+		}
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+			abortWithError(c, ErrValidation, "invalid state")
+			return
+		}
+		code := c.Query("code")
+		if code == "" {
+			abortWithError(c, ErrValidation, "missing code")
+			return
+		}
+
+		user, res, err := auth.Exchange(code, verifier, nonce)
+		if err != nil {
+			abortWithError(c, err, "login failed")
+			return
+		}
+
+		setSessionLogin(sess, user, res)
+		a.renewSession(sess)
+		if err := sess.Save(); err != nil {
+			abortWithError(c, err, "unable to save session")
+			return
+		}
+
+		if returnTo != "" {
+			c.Redirect(http.StatusFound, returnTo)
+			return
+		}
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+// randomToken returns 32 random bytes, encoded so that they can be used in
+// URLs.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge returns the S256 code challenge for a code verifier, see
+// RFC 7636.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// isLocalPath reports whether p is a path on this server, so that it can't
+// be used to redirect users to another site.
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") &&
+		!strings.HasPrefix(p, "//") &&
+		!strings.HasPrefix(p, "/\\")
+}
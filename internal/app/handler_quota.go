@@ -0,0 +1,115 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type quotaLimit struct {
+	Used int64 `json:"used"`
+	// Limit is omitted if unlimited.
+	Limit int64 `json:"limit,omitempty"`
+}
+
+type quotaResponse struct {
+	Bytes     quotaLimit `json:"bytes"`
+	Documents quotaLimit `json:"documents"`
+}
+
+func newQuotaResponse(q Quota, u Usage) quotaResponse {
+	return quotaResponse{
+		Bytes:     quotaLimit{Used: u.Bytes, Limit: q.MaxBytes},
+		Documents: quotaLimit{Used: u.Documents, Limit: q.MaxDocuments},
+	}
+}
+
+type adminQuota struct {
+	Type         PrincipalType `json:"type"`
+	Name         string        `json:"name"`
+	MaxBytes     int64         `json:"max_bytes"`
+	MaxDocuments int64         `json:"max_documents"`
+}
+
+// quotaPrincipal returns the principal of the quota routes.
+func quotaPrincipal(c *gin.Context) (Principal, bool) {
+	p := Principal{Type: PrincipalType(c.Param("type")), Name: c.Param("name")}
+	return p, p.Type == PrincipalUser || p.Type == PrincipalGroup
+}
+
+func (a *App) HandlerGetQuotas() gin.HandlerFunc {
+	type response struct {
+		Success bool         `json:"success"`
+		Quotas  []adminQuota `json:"quotas"`
+	}
+	return func(c *gin.Context) {
+		quotas, err := a.quotas.List()
+		if err != nil {
+			abortWithError(c, err, "failed to list quotas")
+			return
+		}
+
+		res := make([]adminQuota, len(quotas))
+		for i, q := range quotas {
+			res[i] = adminQuota{
+				Type:         q.Principal.Type,
+				Name:         q.Principal.Name,
+				MaxBytes:     q.MaxBytes,
+				MaxDocuments: q.MaxDocuments,
+			}
+		}
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Quotas:  res,
+		})
+	}
+}
+
+// HandlerPutQuota sets the quota of a user or a group. The principal doesn't
+// have to exist, so that quotas can be set up before the first login.
+func (a *App) HandlerPutQuota() gin.HandlerFunc {
+	type request struct {
+		MaxBytes     int64 `json:"max_bytes"`
+		MaxDocuments int64 `json:"max_documents"`
+	}
+	return func(c *gin.Context) {
+		p, ok := quotaPrincipal(c)
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || !ok || req.MaxBytes < 0 || req.MaxDocuments < 0 {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		if err := a.quotas.Set(Quota{
+			Principal:    p,
+			MaxBytes:     req.MaxBytes,
+			MaxDocuments: req.MaxDocuments,
+		}); err != nil {
+			abortWithError(c, err, "failed to set quota")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+func (a *App) HandlerDeleteQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := quotaPrincipal(c)
+		if !ok {
+			abortWithError(c, ErrNotFound, "failed to delete quota")
+			return
+		}
+
+		if err := a.quotas.Delete(p); err != nil {
+			abortWithError(c, err, "failed to delete quota")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// unlimitedQuota is the quota of GET /user for users without documents and
+// quota.
+var unlimitedQuota = M{
+	"bytes":     M{"used": 0},
+	"documents": M{"used": 0},
+}
+
+func (suite *AppSuite) TestQuotaDocuments() {
+	user := suite.login()
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal(user), MaxDocuments: 1}))
+
+	suite.
+		Post("/doc").
+		BodyJSON(M{
+			"filename": "first",
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+		})
+	suite.
+		Post("/doc").
+		BodyJSON(M{
+			"filename": "second",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "quota_exceeded",
+			"message": "document quota exceeded",
+		})
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"quota": M{
+				"bytes":     M{"used": 0},
+				"documents": M{"used": 1, "limit": 1},
+			},
+		})
+}
+
+func (suite *AppSuite) TestQuotaBytes() {
+	user := suite.login()
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal(user), MaxBytes: 10}))
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	suite.
+		Post("/doc/doc/content").
+		File("file", "myfile", []byte("0123456789a")).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "quota_exceeded",
+			"message": "storage quota exceeded",
+		})
+	suite.
+		Post("/doc/doc/content").
+		File("file", "myfile", []byte("0123456789")).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	// the replaced content doesn't count
+	suite.
+		Post("/doc/doc/content").
+		File("file", "myfile", []byte("9876543210")).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"quota": M{
+				"bytes":     M{"used": 10, "limit": 10},
+				"documents": M{"used": 1},
+			},
+		})
+
+	// deleting documents frees their storage
+	suite.
+		Request(http.MethodDelete, "/doc/doc").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"quota": M{
+				"bytes":     M{"used": 0, "limit": 10},
+				"documents": M{"used": 0},
+			},
+		})
+}
+
+func (suite *AppSuite) TestQuotaStreaming() {
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	// the upload passes the check of the announced size, but another
+	// upload used up the quota in the meantime
+	rd := &quotaReader{rd: bytes.NewReader([]byte("0123456789a")), limit: 10}
+	err := suite.app.objects.Create("doc", rd)
+	suite.ErrorIs(err, ErrQuotaExceeded)
+	_, err = suite.app.objects.Read("doc")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *AppSuite) TestQuotaGroups() {
+	owner := suite.login()
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: GroupPrincipal("small"), MaxBytes: 5, MaxDocuments: 10}))
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: GroupPrincipal("large"), MaxBytes: 100, MaxDocuments: 1}))
+	mem := suite.app.auth.(*MemAuthService)
+	suite.Require().NoError(mem.CreateGroup("small"))
+	suite.Require().NoError(mem.CreateGroup("large"))
+	suite.Require().NoError(mem.AddUserToGroup(owner, "small"))
+	suite.Require().NoError(mem.AddUserToGroup(owner, "large"))
+
+	// the quota of the owner applies, even if another user uploads
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   owner,
+		Created: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal(owner):   {Principal: UserPrincipal(owner), Read: true, Write: true},
+			UserPrincipal("other"): {Principal: UserPrincipal("other"), Read: true, Write: true},
+		},
+	}))
+	suite.logout()
+	suite.createUser("other", "otherpass")
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": "other",
+			"password": "otherpass",
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.fetchCSRFToken()
+
+	// the most generous limit of the groups applies
+	suite.
+		Post("/doc/doc/content").
+		File("file", "myfile", make([]byte, 101)).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "quota_exceeded",
+			"message": "storage quota exceeded",
+		})
+	suite.
+		Post("/doc/doc/content").
+		File("file", "myfile", make([]byte, 100)).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	// a quota of the user takes precedence
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal(owner), MaxBytes: 50}))
+	suite.
+		Post("/doc/doc/content").
+		File("file", "myfile", make([]byte, 51)).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "quota_exceeded",
+			"message": "storage quota exceeded",
+		})
+}
+
+func (suite *AppSuite) TestQuotaDefault() {
+	suite.app.defaultQuota = Quota{MaxDocuments: 1}
+	user := suite.login()
+
+	suite.
+		Get("/user").
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"quota": M{
+				"bytes":     M{"used": 0},
+				"documents": M{"used": 0, "limit": 1},
+			},
+		})
+}
+
+func (suite *AppSuite) TestQuotaTransferURL() {
+	suite.enableTransferURLs()
+	user := suite.login()
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal(user), MaxBytes: 10}))
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	suite.
+		Post("/doc/doc/content/url").
+		BodyJSON(M{
+			"size": 11,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "quota_exceeded",
+			"message": "storage quota exceeded",
+		})
+}
+
+func (suite *AppSuite) TestAdminQuotas() {
+	suite.loginAdmin()
+
+	suite.
+		Request(http.MethodPut, "/admin/quotas/group/staff").
+		BodyJSON(M{
+			"max_bytes":     1000,
+			"max_documents": 10,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodPut, "/admin/quotas/user/alice").
+		BodyJSON(M{
+			"max_bytes": 100,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Get("/admin/quotas").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"quotas": []M{
+				{"type": "group", "name": "staff", "max_bytes": 1000, "max_documents": 10},
+				{"type": "user", "name": "alice", "max_bytes": 100, "max_documents": 0},
+			},
+		})
+
+	suite.
+		Request(http.MethodDelete, "/admin/quotas/user/alice").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request(http.MethodDelete, "/admin/quotas/user/alice").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "failed to delete quota",
+		})
+	suite.
+		Request(http.MethodPut, "/admin/quotas/role/staff").
+		BodyJSON(M{
+			"max_bytes": 100,
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusBadRequest, res.StatusCode)
+		})
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// defaultShareLinkLifetime is the lifetime of share links that are
+	// created without an expiry.
+	defaultShareLinkLifetime = 7 * 24 * time.Hour
+	maxShareLinkLifetime     = 90 * 24 * time.Hour
+)
+
+type shareLinkResponse struct {
+	ID           string     `json:"id"`
+	CreatedBy    string     `json:"created_by"`
+	Password     bool       `json:"password"`
+	MaxDownloads int        `json:"max_downloads"`
+	Downloads    int        `json:"downloads"`
+	Created      time.Time  `json:"created"`
+	Expires      time.Time  `json:"expires"`
+	LastAccess   *time.Time `json:"last_access,omitempty"`
+}
+
+func newShareLinkResponse(l ShareLink) shareLinkResponse {
+	res := shareLinkResponse{
+		ID:           l.ID,
+		CreatedBy:    l.CreatedBy,
+		Password:     l.PasswordHash != "",
+		MaxDownloads: l.MaxDownloads,
+		Downloads:    l.Downloads,
+		Created:      l.Created,
+		Expires:      l.Expires,
+	}
+	if !l.LastAccess.IsZero() {
+		res.LastAccess = &l.LastAccess
+	}
+	return res
+}
+
+func (a *App) HandlerGetShareLinks() gin.HandlerFunc {
+	type response struct {
+		Success bool                `json:"success"`
+		Links   []shareLinkResponse `json:"links"`
+	}
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		if _, _, err := a.authorize(c, id, canShare); err != nil {
+			abortWithError(c, err, "list links")
+			return
+		}
+
+		links, err := a.shareLinks.List(id)
+		if err != nil {
+			abortWithError(c, err, "failed to list links")
+			return
+		}
+
+		res := make([]shareLinkResponse, len(links))
+		for i, l := range links {
+			res[i] = newShareLinkResponse(l)
+		}
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Links:   res,
+		})
+	}
+}
+
+func (a *App) HandlerPostShareLink() gin.HandlerFunc {
+	type request struct {
+		Expires      *time.Time `json:"expires"`
+		MaxDownloads int        `json:"max_downloads"`
+		Password     string     `json:"password"`
+	}
+	type response struct {
+		Success bool      `json:"success"`
+		ID      string    `json:"id"`
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || req.MaxDownloads < 0 {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		// links give read access, which nobody may share without having it
+		if _, _, err := a.authorize(c, id, canShareRead); err != nil {
+			abortWithError(c, err, "create link")
+			return
+		}
+
+		now := a.clock.Now()
+		expires := now.Add(defaultShareLinkLifetime)
+		if req.Expires != nil {
+			expires = *req.Expires
+		}
+		if !expires.After(now) || expires.Sub(now) > maxShareLinkLifetime {
+			abortWithError(c, ErrValidation, fmt.Sprintf("expires must be in the future and at most %v days from now", int(maxShareLinkLifetime/(24*time.Hour))))
+			return
+		}
+
+		var passwordHash string
+		if req.Password != "" {
+			var err error
+			if passwordHash, err = hashPassword(req.Password); errors.Is(err, ErrValidation) {
+				abortWithError(c, err, fmt.Sprintf("the password must have between %d and 72 characters", minPasswordLength))
+				return
+			} else if err != nil {
+				abortWithError(c, err, "unable to create link")
+				return
+			}
+		}
+
+		secret, err := randomToken()
+		if err != nil {
+			abortWithError(c, err, "unable to create link")
+			return
+		}
+
+		l := ShareLink{
+			ID:           a.genUUID().String(),
+			DocID:        id,
+			CreatedBy:    currentUser(c),
+			Hash:         hashAPIToken(secret),
+			PasswordHash: passwordHash,
+			MaxDownloads: req.MaxDownloads,
+			Created:      now,
+			Expires:      expires,
+		}
+		if err := a.shareLinks.Create(l); err != nil {
+			abortWithError(c, err, "unable to create link")
+			return
+		}
+
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			ID:      l.ID,
+			Token:   secret,
+			Expires: l.Expires,
+		})
+	}
+}
+
+func (a *App) HandlerDeleteShareLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		if _, _, err := a.authorize(c, id, canShare); err != nil {
+			abortWithError(c, err, "revoke link")
+			return
+		}
+
+		if err := a.shareLinks.Delete(id, c.Param("link")); err != nil {
+			abortWithError(c, err, "revoke link")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+// HandlerGetPublic streams the content of the document of a share link to
+// anybody who knows the link. The password of a link is given with HTTP
+// Basic authentication, so that browsers ask for it. Links that are
+// expired, used up, locked by wrong passwords or whose creator can't share
+// the document anymore look like links that never existed.
+func (a *App) HandlerGetPublic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := a.clock.Now()
+
+		link, err := a.shareLinks.Get(hashAPIToken(c.Param("token")))
+		if err == nil && !link.usable(now) {
+			err = fmt.Errorf("link %v: %w", link.ID, ErrNotFound)
+		}
+		if err == nil {
+			err = a.checkShareLinkCreator(link)
+		}
+		if err != nil {
+			abortWithError(c, err, "link not found")
+			return
+		}
+
+		if link.PasswordHash != "" {
+			_, pass, ok := c.Request.BasicAuth()
+			if ok && bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(pass)) != nil {
+				if err := a.shareLinks.CountFailure(link.Hash); err != nil {
+					abortWithError(c, err, "link not found")
+					return
+				}
+				ok = false
+			}
+			if !ok {
+				c.Header("WWW-Authenticate", `Basic realm="verbose-broccoli"`)
+				abortWithError(c, ErrUnauthorized, "password required")
+				return
+			}
+		}
+
+		header, err := a.documents.Get(link.DocID)
+		if err != nil {
+			abortWithError(c, err, "link not found")
+			return
+		}
+		content, err := a.objects.Read(link.DocID)
+		if err != nil {
+			abortWithError(c, err, "no content for link")
+			return
+		}
+		defer func() {
+			_ = content.Close()
+		}()
+
+		if err := a.shareLinks.CountAccess(link.Hash, now); err != nil {
+			abortWithError(c, err, "link not found")
+			return
+		}
+
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": path.Base(header.Name),
+		}))
+		if _, err := io.Copy(c.Writer, content); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+}
+
+// checkShareLinkCreator fails with ErrNotFound if the creator of the link
+// can't read and share the document anymore. The groups of the creator are
+// resolved if the auth service supports it.
+func (a *App) checkShareLinkCreator(link ShareLink) error {
+	var groups []string
+	if r, ok := a.auth.(GroupResolver); ok {
+		var err error
+		if groups, err = r.UserGroups(link.CreatedBy); err != nil {
+			return fmt.Errorf("resolve groups: %w", err)
+		}
+	}
+	acl, err := a.documents.ACL(link.DocID)
+	if err != nil {
+		return fmt.Errorf("get acl: %w", err)
+	}
+	if perm, _ := acl.Effective(link.CreatedBy, groups); !canShareRead(perm) {
+		return fmt.Errorf("link %v: creator can't share the document: %w", link.ID, ErrNotFound)
+	}
+	return nil
+}
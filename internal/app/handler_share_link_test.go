@@ -0,0 +1,313 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// createShareLink creates a link for the document and returns its token.
+func (suite *AppSuite) createShareLink(id string, req M) string {
+	var res struct {
+		Token string `json:"token"`
+	}
+	suite.
+		Post("/doc/" + id + "/links").
+		BodyJSON(req).
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	suite.Require().NotEmpty(res.Token)
+	return res.Token
+}
+
+// createSharedDocument creates a document with content that is owned by the
+// given user.
+func (suite *AppSuite) createSharedDocument(owner string) {
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "reports/q1.txt",
+		Owner:   owner,
+		Created: time.Now(),
+		Updated: time.Now(),
+	}, ownerACL(owner)))
+	suite.createContent("doc", []byte("hello"))
+}
+
+func (suite *AppSuite) TestShareLink() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	suite.clock.set(SingleTimestampClock{now})
+	testUUID := uuid.New()
+	suite.app.genUUID = func() uuid.UUID {
+		return testUUID
+	}
+
+	token := suite.createShareLink("doc", M{})
+
+	// the public route doesn't need the session, but doesn't mind it either
+	suite.
+		Get("/public/" + token).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.Equal(`attachment; filename=q1.txt`, res.Header.Get("Content-Disposition"))
+		})
+	suite.
+		Get("/public/"+token).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+
+	suite.
+		Get("/doc/doc/links").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"links": []M{
+				{
+					"id":            testUUID.String(),
+					"created_by":    user,
+					"password":      false,
+					"max_downloads": 0,
+					"downloads":     2,
+					"created":       now,
+					"expires":       now.Add(defaultShareLinkLifetime),
+					"last_access":   now,
+				},
+			},
+		})
+
+	suite.
+		Request(http.MethodDelete, "/doc/doc/links/"+testUUID.String()).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Get("/public/"+token).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "link not found",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkWithoutSession() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+	token := suite.createShareLink("doc", M{})
+	suite.logout()
+
+	suite.
+		Get("/public/"+token).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+	suite.
+		Get("/public/invalid").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "link not found",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkMaxDownloads() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+	token := suite.createShareLink("doc", M{
+		"max_downloads": 1,
+	})
+
+	suite.
+		Get("/public/"+token).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+	suite.
+		Get("/public/"+token).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "link not found",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkExpired() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+
+	now := time.Now()
+	token := suite.createShareLink("doc", M{
+		"expires": now.Add(time.Hour),
+	})
+
+	suite.clock.set(SingleTimestampClock{now.Add(2 * time.Hour)})
+	suite.
+		Get("/public/"+token).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "link not found",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkPassword() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+	token := suite.createShareLink("doc", M{
+		"password": "secret password",
+	})
+	suite.logout()
+
+	suite.
+		Get("/public/" + token).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusUnauthorized, res.StatusCode)
+			suite.Equal(`Basic realm="verbose-broccoli"`, res.Header.Get("WWW-Authenticate"))
+		})
+	suite.
+		Get("/public/"+token).
+		Header("Authorization", suite.basicAuth("", "wrong password")).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "password required",
+		})
+	suite.
+		Get("/public/"+token).
+		Header("Authorization", suite.basicAuth("", "secret password")).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+}
+
+func (suite *AppSuite) TestShareLinkPasswordLocked() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+	token := suite.createShareLink("doc", M{
+		"password": "secret password",
+	})
+	suite.logout()
+
+	// asking for the password isn't a failed attempt
+	suite.
+		Get("/public/" + token).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusUnauthorized, res.StatusCode)
+		})
+	for i := 0; i < maxShareLinkFailures; i++ {
+		suite.
+			Get("/public/"+token).
+			Header("Authorization", suite.basicAuth("", "wrong password")).
+			ExpectCustom(func(res *http.Response) {
+				suite.Equal(http.StatusUnauthorized, res.StatusCode)
+			})
+	}
+	suite.
+		Get("/public/"+token).
+		Header("Authorization", suite.basicAuth("", "secret password")).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "link not found",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkCreatorLostAccess() {
+	user := suite.login()
+	header := DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   "otheruser",
+		Created: time.Now(),
+	}
+	suite.NoError(suite.app.documents.Create(header, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal(user): {Principal: UserPrincipal(user), Read: true, Share: true},
+		},
+	}))
+	suite.createContent("doc", []byte("hello"))
+	token := suite.createShareLink("doc", M{})
+
+	suite.
+		Get("/public/"+token).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+
+	suite.NoError(suite.app.documents.Update(header, ownerACL("otheruser")))
+	suite.
+		Get("/public/"+token).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "link not found",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkInvalid() {
+	user := suite.login()
+	suite.createSharedDocument(user)
+
+	suite.
+		Post("/doc/doc/links").
+		BodyJSON(M{
+			"password": "short",
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "the password must have between 8 and 72 characters",
+		})
+	suite.
+		Post("/doc/doc/links").
+		BodyJSON(M{
+			"expires": time.Now().Add(-time.Hour),
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "expires must be in the future and at most 90 days from now",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkRequiresSharePermission() {
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   "otheruser",
+		Created: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal(user): {Principal: UserPrincipal(user), Read: true},
+		},
+	}))
+
+	suite.
+		Post("/doc/doc/links").
+		BodyJSON(M{}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "create link",
+		})
+}
+
+func (suite *AppSuite) TestShareLinkRequiresReadPermission() {
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   "otheruser",
+		Created: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal(user): {Principal: UserPrincipal(user), Share: true},
+		},
+	}))
+
+	suite.
+		Post("/doc/doc/links").
+		BodyJSON(M{}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "create link",
+		})
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultAPITokenLifetime is the lifetime of API tokens that are
+	// created without an expiry.
+	defaultAPITokenLifetime = 30 * 24 * time.Hour
+	maxAPITokenLifetime     = 365 * 24 * time.Hour
+)
+
+type apiTokenResponse struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+func newAPITokenResponse(t APIToken) apiTokenResponse {
+	return apiTokenResponse{
+		ID:      t.ID,
+		Name:    t.Name,
+		Scopes:  t.Scopes,
+		Created: t.Created,
+		Expires: t.Expires,
+	}
+}
+
+func (a *App) HandlerGetTokens() gin.HandlerFunc {
+	type response struct {
+		Success bool               `json:"success"`
+		Tokens  []apiTokenResponse `json:"tokens"`
+	}
+	return func(c *gin.Context) {
+		tokens, err := a.apiTokens.List(currentUser(c))
+		if err != nil {
+			abortWithError(c, err, "failed to list tokens")
+			return
+		}
+
+		res := make([]apiTokenResponse, len(tokens))
+		for i, t := range tokens {
+			res[i] = newAPITokenResponse(t)
+		}
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			Tokens:  res,
+		})
+	}
+}
+
+func (a *App) HandlerPostToken() gin.HandlerFunc {
+	type request struct {
+		Name    string     `json:"name"`
+		Scopes  []string   `json:"scopes"`
+		Expires *time.Time `json:"expires"`
+	}
+	type response struct {
+		Success bool      `json:"success"`
+		ID      string    `json:"id"`
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}
+	return func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+		if len(req.Scopes) == 0 {
+			abortWithError(c, ErrValidation, "a token needs at least one scope")
+			return
+		}
+
+		now := a.clock.Now()
+		expires := now.Add(defaultAPITokenLifetime)
+		if req.Expires != nil {
+			expires = *req.Expires
+		}
+		if !expires.After(now) || expires.Sub(now) > maxAPITokenLifetime {
+			abortWithError(c, ErrValidation, fmt.Sprintf("expires must be in the future and at most %v days from now", int(maxAPITokenLifetime/(24*time.Hour))))
+			return
+		}
+
+		secret, err := randomToken()
+		if err != nil {
+			abortWithError(c, err, "unable to create token")
+			return
+		}
+		secret = apiTokenPrefix + secret
+
+		t := APIToken{
+			ID:      a.genUUID().String(),
+			Name:    req.Name,
+			Owner:   currentUser(c),
+			Hash:    hashAPIToken(secret),
+			Scopes:  req.Scopes,
+			Created: now,
+			Expires: expires,
+		}
+		if err := a.apiTokens.Create(t); err != nil {
+			abortWithError(c, err, "unable to create token")
+			return
+		}
+
+		c.JSON(http.StatusOK, response{
+			Success: true,
+			ID:      t.ID,
+			Token:   secret,
+			Expires: t.Expires,
+		})
+	}
+}
+
+func (a *App) HandlerDeleteToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := a.apiTokens.Delete(currentUser(c), c.Param("id")); err != nil {
+			abortWithError(c, err, "revoke token")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// createToken creates an API token for the logged in user and returns its
+// secret.
+func (suite *AppSuite) createToken(scopes ...string) string {
+	var res struct {
+		Token string `json:"token"`
+	}
+	suite.
+		Post("/user/tokens").
+		BodyJSON(M{
+			"name":   "test",
+			"scopes": scopes,
+		}).
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	suite.Require().NotEmpty(res.Token)
+	return res.Token
+}
+
+func (suite *AppSuite) TestPostToken() {
+	user := suite.login()
+
+	testUUID := uuid.New()
+	suite.app.genUUID = func() uuid.UUID {
+		return testUUID
+	}
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.clock.set(SingleTimestampClock{now})
+
+	var res struct {
+		Success bool      `json:"success"`
+		ID      string    `json:"id"`
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}
+	suite.
+		Post("/user/tokens").
+		BodyJSON(M{
+			"name":   "backup",
+			"scopes": []string{"read"},
+		}).
+		ExpectCustom(func(r *http.Response) {
+			suite.Equal(http.StatusOK, r.StatusCode)
+			suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+			suite.NoError(r.Body.Close())
+		})
+	suite.True(res.Success)
+	suite.Equal(testUUID.String(), res.ID)
+	suite.EqualTime(now.Add(30*24*time.Hour), res.Expires)
+
+	// only the hash of the secret is stored
+	tokens, err := suite.app.apiTokens.List(user)
+	suite.NoError(err)
+	suite.Require().Len(tokens, 1)
+	suite.Equal(hashAPIToken(res.Token), tokens[0].Hash)
+	suite.NotContains(tokens[0].Hash, res.Token)
+
+	suite.
+		Get("/user/tokens").
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+			"tokens": []M{
+				{
+					"id":      testUUID.String(),
+					"name":    "backup",
+					"scopes":  []string{"read"},
+					"created": "2021-05-01T12:00:00Z",
+					"expires": "2021-05-31T12:00:00Z",
+				},
+			},
+		})
+}
+
+func (suite *AppSuite) TestPostTokenInvalid() {
+	_ = suite.login()
+	suite.clock.set(SingleTimestampClock{time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)})
+
+	suite.
+		Post("/user/tokens").
+		BodyJSON(M{
+			"name":   "backup",
+			"scopes": []string{},
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "a token needs at least one scope",
+		})
+	suite.
+		Post("/user/tokens").
+		BodyJSON(M{
+			"name":   "backup",
+			"scopes": []string{"admin"},
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "request does not match schema",
+			"errors": []M{
+				{"field": "body.scopes[0]", "message": "must be one of [read write delete share]"},
+			},
+		})
+	suite.
+		Post("/user/tokens").
+		BodyJSON(M{
+			"name":    "backup",
+			"scopes":  []string{"read"},
+			"expires": "2023-05-01T12:00:00Z",
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "expires must be in the future and at most 365 days from now",
+		})
+}
+
+func (suite *AppSuite) TestTokenAuth() {
+	user := suite.login()
+	token := suite.createToken("read")
+	suite.logout()
+
+	suite.
+		Get("/user").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"quota":    unlimitedQuota,
+		})
+	suite.
+		Get("/doc").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusOK, M{
+			"success":   true,
+			"documents": []M{},
+		})
+	suite.
+		Post("/doc").
+		Header("Authorization", "Bearer "+token).
+		BodyJSON(M{
+			"filename": "myfile",
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": `token lacks scope "write"`,
+		})
+	// tokens can't be used to manage tokens
+	suite.
+		Get("/user/tokens").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "route can't be used with a token",
+		})
+}
+
+func (suite *AppSuite) TestTokenAuthGroups() {
+	user := suite.login()
+	mem := suite.app.auth.(*MemAuthService)
+	suite.NoError(mem.CreateGroup("editors"))
+	suite.NoError(mem.AddUserToGroup(user, "editors"))
+	token := suite.createToken("read")
+	suite.logout()
+
+	// the groups are resolved without a session
+	suite.
+		Get("/user").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusOK, M{
+			"username": user,
+			"groups":   []string{"editors"},
+			"quota":    unlimitedQuota,
+		})
+}
+
+func (suite *AppSuite) TestTokenRestrictsPermission() {
+	user := suite.login()
+	token := suite.createToken("share")
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:    "doc",
+		Name:  "myfile",
+		Owner: user,
+	}, ownerACL(user)))
+
+	// the scope doesn't allow more than the ACL
+	acl := ownerACL(user)
+	acl.Permissions[UserPrincipal(user)] = Permission{Principal: UserPrincipal(user), Read: true}
+	suite.NoError(suite.app.documents.Update(DocumentHeader{ID: "doc", Name: "myfile", Owner: user}, acl))
+	suite.
+		Post("/doc/doc/share").
+		Header("Authorization", "Bearer "+token).
+		BodyJSON(M{
+			"username": "other",
+			"read":     true,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "share document",
+		})
+
+	// nor does the ACL allow more than the scope, so the token can't pass
+	// on rights that it doesn't have itself
+	suite.NoError(suite.app.documents.Update(DocumentHeader{ID: "doc", Name: "myfile", Owner: user}, ownerACL(user)))
+	suite.
+		Post("/doc/doc/share").
+		Header("Authorization", "Bearer "+token).
+		BodyJSON(M{
+			"username": "other",
+			"read":     true,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "share document",
+		})
+
+	token = suite.createToken("read", "share")
+	suite.
+		Post("/doc/doc/share").
+		Header("Authorization", "Bearer "+token).
+		BodyJSON(M{
+			"username": "other",
+			"read":     true,
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+}
+
+func (suite *AppSuite) TestTokenRevoked() {
+	user := suite.login()
+	token := suite.createToken("read")
+
+	tokens, err := suite.app.apiTokens.List(user)
+	suite.NoError(err)
+	suite.Require().Len(tokens, 1)
+
+	suite.
+		Request("DELETE", "/user/tokens/"+tokens[0].ID).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+	suite.
+		Request("DELETE", "/user/tokens/"+tokens[0].ID).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "revoke token",
+		})
+	suite.
+		Get("/doc").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "invalid token",
+		})
+}
+
+func (suite *AppSuite) TestTokenExpired() {
+	_ = suite.login()
+	token := suite.createToken("read")
+
+	suite.clock.set(SingleTimestampClock{time.Now().Add(31 * 24 * time.Hour)})
+	suite.
+		Get("/doc").
+		Header("Authorization", "Bearer "+token).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "token expired",
+		})
+}
+
+func (suite *AppSuite) TestRevokeTokenOfOtherUser() {
+	other := suite.login()
+	_ = suite.createToken("read")
+	tokens, err := suite.app.apiTokens.List(other)
+	suite.NoError(err)
+	suite.Require().Len(tokens, 1)
+	suite.logout()
+
+	_ = suite.login()
+	suite.
+		Request("DELETE", "/user/tokens/"+tokens[0].ID).
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "revoke token",
+		})
+}
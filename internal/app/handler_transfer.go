@@ -0,0 +1,361 @@
+package app
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transferToken is the payload of the transfer URLs that the app signs for
+// object storages that aren't URLPresigners, see HandlerTransfer. Uploads
+// have an ID, so that they can only be used once.
+type transferToken struct {
+	ID      string `json:"i,omitempty"`
+	Doc     DocID  `json:"d"`
+	Method  string `json:"m"`
+	Size    int64  `json:"s,omitempty"`
+	MD5     string `json:"h,omitempty"`
+	Expires int64  `json:"e"`
+}
+
+type transferURLResponse struct {
+	Success bool              `json:"success"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Expires time.Time         `json:"expires"`
+}
+
+func newTransferURLResponse(req PresignedRequest, expires time.Time) transferURLResponse {
+	res := transferURLResponse{
+		Success: true,
+		Method:  req.Method,
+		URL:     req.URL,
+		Expires: expires,
+	}
+	for k := range req.Header {
+		if res.Headers == nil {
+			res.Headers = map[string]string{}
+		}
+		res.Headers[k] = req.Header.Get(k)
+	}
+	return res
+}
+
+// middlewareTransferURLs only lets requests pass if transfer URLs are
+// enabled, see WithTransferURLs.
+func (a *App) middlewareTransferURLs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.transferTTL <= 0 {
+			abortWithError(c, ErrNotFound, "transfer URLs are disabled")
+			return
+		}
+	}
+}
+
+// HandlerGetContentURL returns a short-lived URL from which the content of
+// the document can be downloaded, without streaming it through the API.
+func (a *App) HandlerGetContentURL() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		header, _, err := a.authorize(c, id, canRead)
+		if err != nil {
+			abortWithError(c, err, "read content")
+			return
+		}
+		if header.Updated.IsZero() {
+			abortWithError(c, fmt.Errorf("document %v: %w", id, ErrNotFound), "no content for id")
+			return
+		}
+
+		expires := a.clock.Now().Add(a.transferTTL)
+		var req PresignedRequest
+		if p, ok := a.objects.(URLPresigner); ok {
+			req, err = p.PresignGet(id, a.transferTTL)
+		} else {
+			req, err = a.signTransfer(transferToken{Doc: id, Method: http.MethodGet}, expires)
+		}
+		if err != nil {
+			abortWithError(c, err, "unable to create URL")
+			return
+		}
+
+		c.JSON(http.StatusOK, newTransferURLResponse(req, expires))
+	}
+}
+
+// HandlerPostContentURL returns a short-lived URL that content of the given
+// size can be uploaded to. The upload has to be confirmed with
+// HandlerPostContentComplete, which updates the document.
+func (a *App) HandlerPostContentURL() gin.HandlerFunc {
+	type request struct {
+		Size int64  `json:"size"`
+		MD5  string `json:"md5"`
+	}
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || !validMD5(req.MD5) {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+		if req.Size < 0 || req.Size > maxContentSize {
+			abortWithError(c, ErrValidation, fmt.Sprintf("the size must be between 0 and %d bytes", maxContentSize))
+			return
+		}
+
+		header, _, err := a.authorize(c, id, canWrite)
+		if err != nil {
+			abortWithError(c, err, "write content")
+			return
+		}
+
+		// the size can't change anymore once the URL is signed
+		quota, usage, err := a.quotaOf(header.Owner, currentUser(c), currentGroups(c))
+		if err != nil {
+			abortWithError(c, err, "unable to check quota")
+			return
+		}
+		if remaining := quota.remainingBytes(usage, header.Size); remaining >= 0 && req.Size > remaining {
+			abortWithError(c, ErrQuotaExceeded, "storage quota exceeded")
+			return
+		}
+
+		expires := a.clock.Now().Add(a.transferTTL)
+		var presigned PresignedRequest
+		if p, ok := a.objects.(URLPresigner); ok {
+			presigned, err = p.PresignPut(id, req.Size, req.MD5, a.transferTTL)
+		} else {
+			presigned, err = a.signTransfer(transferToken{
+				ID:     a.genUUID().String(),
+				Doc:    id,
+				Method: http.MethodPut,
+				Size:   req.Size,
+				MD5:    strings.ToLower(req.MD5),
+			}, expires)
+		}
+		if err != nil {
+			abortWithError(c, err, "unable to create URL")
+			return
+		}
+
+		c.JSON(http.StatusOK, newTransferURLResponse(presigned, expires))
+	}
+}
+
+// HandlerPostContentComplete checks that the content that was uploaded to
+// the URL of HandlerPostContentURL has the expected size and hash, and
+// updates the document accordingly.
+func (a *App) HandlerPostContentComplete() gin.HandlerFunc {
+	type request struct {
+		Size int64  `json:"size"`
+		MD5  string `json:"md5"`
+	}
+	return func(c *gin.Context) {
+		id := DocID(c.Param("id"))
+
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil || !validMD5(req.MD5) {
+			abortWithError(c, ErrValidation, "invalid JSON payload")
+			return
+		}
+
+		header, acl, err := a.authorize(c, id, canWrite)
+		if err != nil {
+			abortWithError(c, err, "write content")
+			return
+		}
+
+		info, err := statObject(a.objects, id)
+		if err != nil {
+			abortWithError(c, err, "no content for id")
+			return
+		}
+		if info.Size != req.Size {
+			abortWithError(c, ErrValidation, fmt.Sprintf("the stored content has %d bytes", info.Size))
+			return
+		}
+		// the hash is unknown for some objects, but then the storage
+		// checked it during the upload already
+		if req.MD5 != "" && info.MD5 != "" && !strings.EqualFold(req.MD5, info.MD5) {
+			abortWithError(c, ErrValidation, "the stored content has another MD5 hash")
+			return
+		}
+
+		header.Size = info.Size
+		header.Updated = a.clock.Now()
+		if err := a.documents.Update(header, acl); err != nil {
+			abortWithError(c, err, "failed to update document header")
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+		})
+	}
+}
+
+// HandlerTransfer serves the transfer URLs that the app signs itself. The
+// signature of the URL replaces the authentication, the ACL was checked
+// when the URL was created. Upload URLs can only be used once, even if the
+// upload fails.
+func (a *App) HandlerTransfer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tok transferToken
+		if err := verifyPayload(a.transferSecret, c.Param("token"), &tok); err != nil {
+			abortWithError(c, err, "invalid transfer URL")
+			return
+		}
+		if tok.Method != c.Request.Method || !a.clock.Now().Before(time.Unix(tok.Expires, 0)) {
+			abortWithError(c, ErrUnauthorized, "invalid transfer URL")
+			return
+		}
+
+		if tok.Method == http.MethodGet {
+			a.transferGet(c, tok)
+		} else {
+			a.transferPut(c, tok)
+		}
+	}
+}
+
+func (a *App) transferGet(c *gin.Context, tok transferToken) {
+	content, err := a.objects.Read(tok.Doc)
+	if err != nil {
+		abortWithError(c, err, "no content for id")
+		return
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+
+	if _, err := io.Copy(c.Writer, content); err != nil {
+		_ = c.Error(err)
+		return
+	}
+}
+
+func (a *App) transferPut(c *gin.Context, tok transferToken) {
+	if tok.ID == "" {
+		abortWithError(c, ErrUnauthorized, "invalid transfer URL")
+		return
+	}
+	if err := a.transferTokens.Use(tok.ID, time.Unix(tok.Expires, 0), a.clock.Now()); err != nil {
+		abortWithError(c, err, "transfer URL was already used")
+		return
+	}
+
+	header, err := a.documents.Get(tok.Doc)
+	if err != nil {
+		abortWithError(c, err, "failed to obtain document")
+		return
+	}
+	acl, err := a.documents.ACL(tok.Doc)
+	if err != nil {
+		abortWithError(c, err, "failed to obtain document")
+		return
+	}
+
+	// other content may have been stored since the URL was signed
+	quota, usage, err := a.quotaOf(header.Owner, "", nil)
+	if err != nil {
+		abortWithError(c, err, "unable to check quota")
+		return
+	}
+	if remaining := quota.remainingBytes(usage, header.Size); remaining >= 0 && tok.Size > remaining {
+		abortWithError(c, ErrQuotaExceeded, "storage quota exceeded")
+		return
+	}
+
+	rd := &verifyingReader{
+		rd:   io.LimitReader(c.Request.Body, tok.Size+1),
+		size: tok.Size,
+		md5:  tok.MD5,
+		hash: md5.New(),
+	}
+	if header.Updated.IsZero() {
+		err = a.objects.Create(tok.Doc, rd)
+	} else {
+		err = a.objects.Update(tok.Doc, rd)
+	}
+	if err != nil {
+		abortWithError(c, err, "failed to store object")
+		return
+	}
+
+	// the upload is complete, so the document is updated right away,
+	// HandlerPostContentComplete only confirms it
+	header.Size = rd.n
+	header.Updated = a.clock.Now()
+	if err := a.documents.Update(header, acl); err != nil {
+		abortWithError(c, err, "failed to update document header")
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// signTransfer returns a request to HandlerTransfer for the token.
+func (a *App) signTransfer(tok transferToken, expires time.Time) (PresignedRequest, error) {
+	tok.Expires = expires.Unix()
+	signed, err := signPayload(a.transferSecret, tok)
+	if err != nil {
+		return PresignedRequest{}, err
+	}
+	req := PresignedRequest{
+		Method: tok.Method,
+		// relative to the URL of the API, since the app doesn't know
+		// under which URL clients reach it
+		URL: "/rest/transfer/" + url.PathEscape(signed),
+	}
+	if tok.Method == http.MethodPut {
+		req.Header = http.Header{"Content-Type": []string{"application/octet-stream"}}
+	}
+	return req, nil
+}
+
+func validMD5(s string) bool {
+	if s == "" {
+		return true
+	}
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == md5.Size
+}
+
+// verifyingReader fails at the end of the content if it doesn't have the
+// expected size and, if set, MD5 hash. The storage must not keep content
+// whose reader failed.
+type verifyingReader struct {
+	rd   io.Reader
+	size int64
+	md5  string
+	hash hash.Hash
+	n    int64
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n += int64(n)
+	_, _ = r.hash.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+
+	if r.n != r.size {
+		return n, fmt.Errorf("expected %d bytes, got %d: %w", r.size, r.n, ErrValidation)
+	}
+	if r.md5 != "" && hex.EncodeToString(r.hash.Sum(nil)) != r.md5 {
+		return n, fmt.Errorf("MD5 hash doesn't match: %w", ErrValidation)
+	}
+	return n, io.EOF
+}
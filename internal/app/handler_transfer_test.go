@@ -0,0 +1,305 @@
+package app
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"time"
+)
+
+type transferURL struct {
+	Success bool              `json:"success"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Expires time.Time         `json:"expires"`
+}
+
+// requestTransferURL requests a transfer URL and returns it relative to
+// /rest, like the other requests of the suite.
+func (suite *AppSuite) requestTransferURL(req TestRequest) transferURL {
+	var res transferURL
+	req.ExpectCustom(func(r *http.Response) {
+		suite.Equal(http.StatusOK, r.StatusCode)
+		suite.NoError(json.NewDecoder(r.Body).Decode(&res))
+		suite.NoError(r.Body.Close())
+	})
+	suite.Require().True(strings.HasPrefix(res.URL, "/rest/transfer/"), res.URL)
+	res.URL = strings.TrimPrefix(res.URL, "/rest")
+	return res
+}
+
+func (suite *AppSuite) enableTransferURLs() {
+	suite.app.transferTTL = 15 * time.Minute
+	suite.app.transferSecret = []byte("secret")
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (suite *AppSuite) TestTransferURLsDisabled() {
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	suite.
+		Get("/doc/doc/content/url").
+		ExpectJSON(http.StatusNotFound, M{
+			"success": false,
+			"code":    "not_found",
+			"message": "transfer URLs are disabled",
+		})
+}
+
+func (suite *AppSuite) TestTransferUpload() {
+	suite.enableTransferURLs()
+	user := suite.login()
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.clock.set(SingleTimestampClock{now})
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: now,
+	}, ownerACL(user)))
+
+	data := []byte("hello")
+	upload := suite.requestTransferURL(suite.
+		Post("/doc/doc/content/url").
+		BodyJSON(M{
+			"size": len(data),
+			"md5":  md5Hex(data),
+		}))
+	suite.Equal(http.MethodPut, upload.Method)
+	suite.EqualTime(now.Add(15*time.Minute), upload.Expires)
+
+	// the URL is the credential, no session is needed
+	cookies := suite.cookies
+	suite.cookies, _ = cookiejar.New(nil)
+	suite.
+		Request(http.MethodPut, upload.URL).
+		Header("Content-Type", upload.Headers["Content-Type"]).
+		Body([]byte("other")).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "failed to store object",
+		})
+	// the URL can't be used again, even though the upload failed
+	suite.
+		Request(http.MethodPut, upload.URL).
+		Header("Content-Type", upload.Headers["Content-Type"]).
+		Body(data).
+		ExpectJSON(http.StatusConflict, M{
+			"success": false,
+			"code":    "conflict",
+			"message": "transfer URL was already used",
+		})
+	suite.cookies = cookies
+
+	upload = suite.requestTransferURL(suite.
+		Post("/doc/doc/content/url").
+		BodyJSON(M{
+			"size": len(data),
+			"md5":  md5Hex(data),
+		}))
+	suite.cookies, _ = cookiejar.New(nil)
+	suite.
+		Request(http.MethodPut, upload.URL).
+		Header("Content-Type", upload.Headers["Content-Type"]).
+		Body(data).
+		ExpectRaw(http.StatusOK, []byte{})
+	suite.
+		Request(http.MethodPut, upload.URL).
+		Header("Content-Type", upload.Headers["Content-Type"]).
+		Body(data).
+		ExpectJSON(http.StatusConflict, M{
+			"success": false,
+			"code":    "conflict",
+			"message": "transfer URL was already used",
+		})
+	suite.
+		Get(upload.URL).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "invalid transfer URL",
+		})
+	suite.cookies = cookies
+
+	suite.
+		Post("/doc/doc/content/complete").
+		BodyJSON(M{
+			"size": 4,
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "the stored content has 5 bytes",
+		})
+	suite.
+		Post("/doc/doc/content/complete").
+		BodyJSON(M{
+			"size": len(data),
+			"md5":  md5Hex(data),
+		}).
+		ExpectJSON(http.StatusOK, M{
+			"success": true,
+		})
+
+	doc, err := suite.app.documents.Get("doc")
+	suite.NoError(err)
+	suite.Equal(int64(len(data)), doc.Size)
+	suite.EqualTime(now, doc.Updated)
+
+	download := suite.requestTransferURL(suite.Get("/doc/doc/content/url"))
+	suite.Equal(http.MethodGet, download.Method)
+	suite.
+		Get(download.URL).
+		ExpectRaw(http.StatusOK, data)
+}
+
+func (suite *AppSuite) TestTransferUploadQuota() {
+	suite.enableTransferURLs()
+	user := suite.login()
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal(user), MaxBytes: 8}))
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+	}, ownerACL(user)))
+
+	data := []byte("hello")
+	upload := suite.requestTransferURL(suite.
+		Post("/doc/doc/content/url").
+		BodyJSON(M{
+			"size": len(data),
+		}))
+
+	// the quota is used up before the content is uploaded
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "other",
+		Name:    "otherfile",
+		Owner:   user,
+		Size:    4,
+		Created: time.Now(),
+		Updated: time.Now(),
+	}, ownerACL(user)))
+	suite.
+		Request(http.MethodPut, upload.URL).
+		Header("Content-Type", upload.Headers["Content-Type"]).
+		Body(data).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "quota_exceeded",
+			"message": "storage quota exceeded",
+		})
+
+	doc, err := suite.app.documents.Get("doc")
+	suite.NoError(err)
+	suite.True(doc.Updated.IsZero())
+}
+
+func (suite *AppSuite) TestTransferURLExpired() {
+	suite.enableTransferURLs()
+	user := suite.login()
+	now := time.Now()
+	suite.clock.set(SingleTimestampClock{now})
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: now,
+		Updated: now,
+	}, ownerACL(user)))
+	suite.createContent("doc", []byte("hello"))
+
+	download := suite.requestTransferURL(suite.Get("/doc/doc/content/url"))
+
+	suite.clock.set(SingleTimestampClock{now.Add(16 * time.Minute)})
+	suite.
+		Get(download.URL).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "invalid transfer URL",
+		})
+	suite.
+		Get("/transfer/forged").
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "invalid transfer URL",
+		})
+}
+
+func (suite *AppSuite) TestTransferURLForbidden() {
+	suite.enableTransferURLs()
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   "otheruser",
+		Created: time.Now(),
+		Updated: time.Now(),
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal("otheruser"): {Principal: UserPrincipal("otheruser"), Read: true, Write: true},
+			UserPrincipal(user):        {Principal: UserPrincipal(user), Read: true},
+		},
+	}))
+
+	suite.
+		Post("/doc/doc/content/url").
+		BodyJSON(M{
+			"size": 5,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": "write content",
+		})
+}
+
+func (suite *AppSuite) TestTransferURLWithAPIToken() {
+	suite.enableTransferURLs()
+	user := suite.login()
+	suite.NoError(suite.app.documents.Create(DocumentHeader{
+		ID:      "doc",
+		Name:    "myfile",
+		Owner:   user,
+		Created: time.Now(),
+		Updated: time.Now(),
+	}, ownerACL(user)))
+	suite.createContent("doc", []byte("hello"))
+	token := suite.createToken(ScopeRead)
+	suite.logout()
+
+	download := suite.requestTransferURL(suite.
+		Get("/doc/doc/content/url").
+		Header("Authorization", "Bearer "+token))
+	suite.
+		Get(download.URL).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+	suite.
+		Post("/doc/doc/content/url").
+		Header("Authorization", "Bearer "+token).
+		BodyJSON(M{
+			"size": 5,
+		}).
+		ExpectJSON(http.StatusForbidden, M{
+			"success": false,
+			"code":    "forbidden",
+			"message": `token lacks scope "write"`,
+		})
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVMethods are the HTTP methods that are routed to HandlerWebDAV.
+var WebDAVMethods = []string{
+	"OPTIONS", "GET", "HEAD", "PUT", "DELETE",
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// HandlerWebDAV serves the documents of the user as a WebDAV share. Since
+// WebDAV clients don't support the cookie based login, users authenticate
+// with HTTP Basic authentication against the AuthService on every request.
+func (a *App) HandlerWebDAV(prefix string) gin.HandlerFunc {
+	locks := webdav.NewMemLS()
+
+	return func(c *gin.Context) {
+		user, pass, ok := c.Request.BasicAuth()
+		if !ok {
+			c.Header("WWW-Authenticate", `Basic realm="verbose-broccoli"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		res, err := a.auth.Login(user, pass)
+		if err != nil {
+			if status, _ := errorStatus(err); status == http.StatusUnauthorized {
+				c.Header("WWW-Authenticate", `Basic realm="verbose-broccoli"`)
+			}
+			abortWithError(c, err, "login failed")
+			return
+		}
+		// challenges can't be answered with basic authentication
+		if !res.Success || res.Challenge != "" {
+			c.Header("WWW-Authenticate", `Basic realm="verbose-broccoli"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		body := &webdavBody{ReadCloser: c.Request.Body}
+		c.Request.Body = body
+
+		h := &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: a.newWebDAVFileSystem(res.user(user), res.Groups, body),
+			LockSystem: locks,
+			Logger: func(_ *http.Request, err error) {
+				if err != nil {
+					_ = c.Error(err)
+				}
+			},
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,400 @@
+package app
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+func (suite *AppSuite) basicAuth(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+func (suite *AppSuite) davURL(p string) string {
+	return "http://" + suite.app.listener.Addr().String() + "/rest/dav" + p
+}
+
+func (suite *AppSuite) TestWebDAVNoAuth() {
+	suite.
+		Request("PROPFIND", "/dav/").
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusUnauthorized, res.StatusCode)
+			suite.Contains(res.Header.Get("WWW-Authenticate"), "Basic")
+		})
+}
+
+func (suite *AppSuite) TestWebDAVInvalidCredentials() {
+	suite.createUser("testuser", "testpass")
+
+	suite.
+		Request("PROPFIND", "/dav/").
+		Header("Authorization", suite.basicAuth("testuser", "wrongpass")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusUnauthorized, res.StatusCode)
+		})
+}
+
+func (suite *AppSuite) TestWebDAVLoginError() {
+	auth := new(MockAuthService)
+	auth.On("Login", "alice", "alicepass").Return(LoginResult{}, fmt.Errorf("login: %w", ErrUnauthorized))
+	auth.On("Login", "", "alicepass").Return(LoginResult{}, fmt.Errorf("username: %w", ErrValidation))
+	auth.On("Login", "bob", "bobpass").Return(LoginResult{}, errors.New("directory unavailable"))
+	suite.app.auth = auth
+
+	suite.
+		Request("PROPFIND", "/dav/").
+		Header("Authorization", suite.basicAuth("alice", "alicepass")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusUnauthorized, res.StatusCode)
+			suite.Contains(res.Header.Get("WWW-Authenticate"), "Basic")
+		})
+	suite.
+		Request("PROPFIND", "/dav/").
+		Header("Authorization", suite.basicAuth("", "alicepass")).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    CodeValidation,
+			"message": "login failed",
+		})
+	suite.
+		Request("PROPFIND", "/dav/").
+		Header("Authorization", suite.basicAuth("bob", "bobpass")).
+		ExpectJSON(http.StatusInternalServerError, M{
+			"success": false,
+			"code":    CodeInternal,
+			"message": "login failed",
+		})
+}
+
+func (suite *AppSuite) TestWebDAVPutGet() {
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+
+	suite.
+		Request("PUT", "/dav/hello.txt").
+		Header("Authorization", auth).
+		Body([]byte("hello")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+
+	suite.
+		Get("/dav/hello.txt").
+		Header("Authorization", auth).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+
+	headers, err := suite.app.documents.List("testuser", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	suite.Equal("hello.txt", headers[0].Name)
+	suite.Equal("testuser", headers[0].Owner)
+	suite.EqualValues(5, headers[0].Size)
+
+	// overwrite the existing document
+	suite.
+		Request("PUT", "/dav/hello.txt").
+		Header("Authorization", auth).
+		Body([]byte("hello world")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+	suite.
+		Get("/dav/hello.txt").
+		Header("Authorization", auth).
+		Header("Range", "bytes=6-").
+		ExpectRaw(http.StatusPartialContent, []byte("world"))
+}
+
+// putTruncated sends a PUT request whose body ends before its Content-Length
+// and returns the status of the response.
+func (suite *AppSuite) putTruncated(p, auth string) int {
+	conn, err := net.Dial("tcp", suite.app.listener.Addr().String())
+	suite.Require().NoError(err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = fmt.Fprintf(conn, "PUT /rest/dav%s HTTP/1.1\r\nHost: test\r\nAuthorization: %s\r\nContent-Length: 11\r\n\r\nworld", p, auth)
+	suite.Require().NoError(err)
+	suite.Require().NoError(conn.(*net.TCPConn).CloseWrite())
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	suite.Require().NoError(err)
+	_ = res.Body.Close()
+	return res.StatusCode
+}
+
+func (suite *AppSuite) TestWebDAVPutTruncated() {
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+
+	suite.
+		Request("PUT", "/dav/hello.txt").
+		Header("Authorization", auth).
+		Body([]byte("hello")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+
+	suite.NotEqual(http.StatusCreated, suite.putTruncated("/hello.txt", auth))
+	suite.NotEqual(http.StatusCreated, suite.putTruncated("/other.txt", auth))
+
+	// neither the existing document nor a new one has the truncated content
+	suite.
+		Get("/dav/hello.txt").
+		Header("Authorization", auth).
+		ExpectRaw(http.StatusOK, []byte("hello"))
+	headers, err := suite.app.documents.List("testuser", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	suite.EqualValues(5, headers[0].Size)
+}
+
+func (suite *AppSuite) TestWebDAVCanonicalUsername() {
+	auth := new(MockAuthService)
+	auth.On("Login", "ALICE", "alicepass").Return(LoginResult{Success: true, Username: "alice"}, nil)
+	suite.app.auth = auth
+
+	suite.
+		Request("PUT", "/dav/hello.txt").
+		Header("Authorization", suite.basicAuth("ALICE", "alicepass")).
+		Body([]byte("hello")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+
+	headers, err := suite.app.documents.List("alice", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	suite.Equal("alice", headers[0].Owner)
+}
+
+func (suite *AppSuite) TestWebDAVPropfind() {
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+
+	suite.
+		Request("MKCOL", "/dav/folder").
+		Header("Authorization", auth).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+	suite.
+		Request("PUT", "/dav/folder/a.txt").
+		Header("Authorization", auth).
+		Body([]byte("a")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+
+	suite.
+		Request("PROPFIND", "/dav/").
+		Header("Authorization", auth).
+		Header("Depth", "1").
+		ExpectCustom(func(res *http.Response) {
+			data, err := io.ReadAll(res.Body)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+
+			suite.Equal(http.StatusMultiStatus, res.StatusCode)
+			suite.Contains(string(data), "<D:href>/rest/dav/folder/</D:href>")
+			suite.NotContains(string(data), "a.txt")
+		})
+
+	suite.
+		Request("PROPFIND", "/dav/folder/").
+		Header("Authorization", auth).
+		Header("Depth", "1").
+		ExpectCustom(func(res *http.Response) {
+			data, err := io.ReadAll(res.Body)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+
+			suite.Equal(http.StatusMultiStatus, res.StatusCode)
+			suite.Contains(string(data), "<D:href>/rest/dav/folder/a.txt</D:href>")
+			suite.Contains(string(data), "<D:getcontentlength>1</D:getcontentlength>")
+		})
+}
+
+func (suite *AppSuite) TestWebDAVMoveCopyDelete() {
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+
+	suite.
+		Request("PUT", "/dav/a.txt").
+		Header("Authorization", auth).
+		Body([]byte("content")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+	suite.
+		Request("MOVE", "/dav/a.txt").
+		Header("Authorization", auth).
+		Header("Destination", suite.davURL("/b.txt")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+	suite.
+		Request("COPY", "/dav/b.txt").
+		Header("Authorization", auth).
+		Header("Destination", suite.davURL("/c.txt")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+
+	suite.
+		Get("/dav/a.txt").
+		Header("Authorization", auth).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusNotFound, res.StatusCode)
+		})
+	suite.
+		Get("/dav/b.txt").
+		Header("Authorization", auth).
+		ExpectRaw(http.StatusOK, []byte("content"))
+	suite.
+		Get("/dav/c.txt").
+		Header("Authorization", auth).
+		ExpectRaw(http.StatusOK, []byte("content"))
+
+	suite.
+		Request("DELETE", "/dav/b.txt").
+		Header("Authorization", auth).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusNoContent, res.StatusCode)
+		})
+
+	headers, err := suite.app.documents.List("testuser", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	suite.Equal("c.txt", headers[0].Name)
+}
+
+func (suite *AppSuite) TestWebDAVLock() {
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+
+	var token string
+	suite.
+		Request("LOCK", "/dav/locked.txt").
+		Header("Authorization", auth).
+		Body([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`)).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+			token = res.Header.Get("Lock-Token")
+		})
+	suite.NotEmpty(token)
+
+	suite.
+		Request("PUT", "/dav/locked.txt").
+		Header("Authorization", auth).
+		Body([]byte("content")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusLocked, res.StatusCode)
+		})
+
+	suite.
+		Request("UNLOCK", "/dav/locked.txt").
+		Header("Authorization", auth).
+		Header("Lock-Token", token).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusNoContent, res.StatusCode)
+		})
+}
+
+func (suite *AppSuite) TestWebDAVACL() {
+	suite.createUser("owner", "ownerpass")
+	suite.createUser("reader", "readerpass")
+	suite.createUser("other", "otherpass")
+
+	suite.
+		Request("PUT", "/dav/shared.txt").
+		Header("Authorization", suite.basicAuth("owner", "ownerpass")).
+		Body([]byte("shared")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+
+	headers, err := suite.app.documents.List("owner", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	acl, err := suite.app.documents.ACL(headers[0].ID)
+	suite.NoError(err)
+	acl.Permissions[UserPrincipal("reader")] = Permission{
+		Principal: UserPrincipal("reader"),
+		Read:      true,
+	}
+	suite.NoError(suite.app.documents.Update(headers[0], acl))
+
+	suite.
+		Get("/dav/shared.txt").
+		Header("Authorization", suite.basicAuth("reader", "readerpass")).
+		ExpectRaw(http.StatusOK, []byte("shared"))
+	suite.
+		Get("/dav/shared.txt").
+		Header("Authorization", suite.basicAuth("other", "otherpass")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusNotFound, res.StatusCode)
+		})
+
+	suite.
+		Request("PUT", "/dav/shared.txt").
+		Header("Authorization", suite.basicAuth("reader", "readerpass")).
+		Body([]byte("overwritten")).
+		ExpectCustom(func(res *http.Response) {
+			suite.NotEqual(http.StatusCreated, res.StatusCode)
+		})
+	suite.
+		Request("DELETE", "/dav/shared.txt").
+		Header("Authorization", suite.basicAuth("reader", "readerpass")).
+		ExpectCustom(func(res *http.Response) {
+			suite.NotEqual(http.StatusNoContent, res.StatusCode)
+		})
+
+	suite.
+		Get("/dav/shared.txt").
+		Header("Authorization", suite.basicAuth("owner", "ownerpass")).
+		ExpectRaw(http.StatusOK, []byte("shared"))
+}
+
+func (suite *AppSuite) TestWebDAVQuota() {
+	suite.createUser("testuser", "testpass")
+	auth := suite.basicAuth("testuser", "testpass")
+	suite.NoError(suite.app.quotas.Set(Quota{Principal: UserPrincipal("testuser"), MaxBytes: 5, MaxDocuments: 1}))
+
+	suite.
+		Request("PUT", "/dav/large.txt").
+		Header("Authorization", auth).
+		Body([]byte("hello world")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusMethodNotAllowed, res.StatusCode)
+		})
+	suite.
+		Request("PUT", "/dav/hello.txt").
+		Header("Authorization", auth).
+		Body([]byte("hello")).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusCreated, res.StatusCode)
+		})
+	suite.
+		Request("PUT", "/dav/other.txt").
+		Header("Authorization", auth).
+		Body([]byte("a")).
+		ExpectCustom(func(res *http.Response) {
+			suite.NotEqual(http.StatusCreated, res.StatusCode)
+		})
+
+	// nothing of the rejected uploads is kept
+	headers, err := suite.app.documents.List("testuser", nil)
+	suite.NoError(err)
+	suite.Require().Len(headers, 1)
+	suite.Equal("hello.txt", headers[0].Name)
+	usage, err := suite.app.documents.Usage("testuser")
+	suite.NoError(err)
+	suite.Equal(Usage{Bytes: 5, Documents: 1}, usage)
+}
@@ -2,12 +2,23 @@ package app
 
 import (
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 )
 
+// HandlerHealthcheck reports the app as unhealthy once it shuts down, so
+// that load balancers stop sending requests to it while it drains.
 func (a *App) HandlerHealthcheck() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if atomic.LoadInt32(&a.draining) != 0 {
+			c.JSON(http.StatusServiceUnavailable, Response{
+				Success: false,
+				Code:    CodeUnavailable,
+				Message: "shutting down",
+			})
+			return
+		}
 		c.JSON(http.StatusOK, Response{
 			Success: true,
 		})
@@ -1,6 +1,9 @@
 package app
 
-import "net/http"
+import (
+	"net/http"
+	"sync/atomic"
+)
 
 func (suite *AppSuite) TestHealthcheck() {
 	suite.
@@ -9,3 +12,15 @@ func (suite *AppSuite) TestHealthcheck() {
 			"success": true,
 		})
 }
+
+func (suite *AppSuite) TestHealthcheckDraining() {
+	atomic.StoreInt32(&suite.app.draining, 1)
+
+	suite.
+		Get("/healthcheck").
+		ExpectJSON(http.StatusServiceUnavailable, M{
+			"success": false,
+			"code":    CodeUnavailable,
+			"message": "shutting down",
+		})
+}
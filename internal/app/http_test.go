@@ -107,6 +107,13 @@ func (r TestRequest) ExpectCustom(validate func(*http.Response)) {
 	for _, header := range r.header {
 		req.Header.Add(header[0], header[1])
 	}
+	if r.suite.csrfToken != "" && req.Header.Get(CSRFTokenHeader) == "" {
+		switch r.method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			req.Header.Set(CSRFTokenHeader, r.suite.csrfToken)
+		}
+	}
 
 	res, err := c.Do(req)
 	r.suite.NoError(err)
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxImportSize is the maximum size of an archive that is imported.
+const maxImportSize = 1 << 32 // 4GB
+
+// JobTypeImport is the type of the jobs that import archives. They aren't
+// retried, since the entries that were imported already would be imported
+// again.
+const JobTypeImport = "import"
+
+// importPayload is the payload of the jobs of JobTypeImport.
+type importPayload struct {
+	// Object is where the archive is kept in the object storage until the
+	// job finished.
+	Object DocID    `json:"object"`
+	Size   int64    `json:"size"`
+	Groups []string `json:"groups"`
+}
+
+// importResult is the result of the jobs of JobTypeImport.
+type importResult struct {
+	Results []ImportResult `json:"results"`
+}
+
+// ImportResult is the result of importing a single entry of an archive.
+type ImportResult struct {
+	Name string `json:"name"`
+	// ID is the document that was created, empty if the import of the
+	// entry failed.
+	ID    DocID  `json:"id,omitempty"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+type archiveFormat int
+
+const (
+	archiveUnknown archiveFormat = iota
+	archiveZip
+	archiveTar
+	archiveTarGzip
+)
+
+// detectArchiveFormat detects the format of the archive from its start.
+func detectArchiveFormat(head []byte) archiveFormat {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return archiveZip
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return archiveTarGzip
+	case len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")):
+		return archiveTar
+	}
+	return archiveUnknown
+}
+
+// importEntry is an entry of an archive that is imported.
+type importEntry struct {
+	name string
+	// size is the size of the content as declared by the archive.
+	size    int64
+	dir     bool
+	regular bool
+	open    func() (io.ReadCloser, error)
+	// read returns how much of the archive is read, once the entry is.
+	read func() int64
+}
+
+// walkArchive calls fn for every entry of the archive, which fails with
+// ErrValidation if it isn't a ZIP, tar or gzip compressed tar archive.
+func walkArchive(f *os.File, size int64, fn func(importEntry) error) error {
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read archive: %w", err)
+	}
+
+	switch detectArchiveFormat(head[:n]) {
+	case archiveZip:
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			return fmt.Errorf("open zip: %v: %w", err, ErrValidation)
+		}
+		for _, file := range zr.File {
+			read := size
+			if offset, err := file.DataOffset(); err == nil {
+				read = offset + int64(file.CompressedSize64)
+			}
+			if err := fn(importEntry{
+				name:    file.Name,
+				size:    int64(file.UncompressedSize64),
+				dir:     file.Mode().IsDir(),
+				regular: file.Mode().IsRegular(),
+				open:    file.Open,
+				read:    func() int64 { return read },
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case archiveTar:
+		cr := &countingReader{rd: io.NewSectionReader(f, 0, size)}
+		return walkTar(cr, cr, fn)
+	case archiveTarGzip:
+		cr := &countingReader{rd: io.NewSectionReader(f, 0, size)}
+		zr, err := gzip.NewReader(cr)
+		if err != nil {
+			return fmt.Errorf("open gzip: %v: %w", err, ErrValidation)
+		}
+		return walkTar(cr, zr, fn)
+	default:
+		return fmt.Errorf("unsupported archive format: %w", ErrValidation)
+	}
+}
+
+// walkTar calls fn for every entry of the tar archive that is read from rd.
+// cr counts the bytes that are read of the archive file.
+func walkTar(cr *countingReader, rd io.Reader, fn func(importEntry) error) error {
+	tr := tar.NewReader(rd)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read tar: %v: %w", err, ErrValidation)
+		}
+		if err := fn(importEntry{
+			name:    h.Name,
+			size:    h.Size,
+			dir:     h.Typeflag == tar.TypeDir,
+			regular: h.Typeflag == tar.TypeReg,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			},
+			read: func() int64 { return cr.n },
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -1,3 +1,16 @@
+DROP TABLE IF EXISTS "au_jobs";
+DROP TABLE IF EXISTS "au_document_blobs";
+DROP TABLE IF EXISTS "au_blobs";
+DROP TABLE IF EXISTS "au_content_keys";
+DROP TABLE IF EXISTS "au_quotas";
+DROP TABLE IF EXISTS "au_usage";
+DROP TABLE IF EXISTS "au_group_members";
+DROP TABLE IF EXISTS "au_groups";
+DROP TABLE IF EXISTS "au_users";
+DROP TABLE IF EXISTS "au_sessions";
+DROP TABLE IF EXISTS "au_api_tokens";
+DROP TABLE IF EXISTS "au_transfer_tokens";
+DROP TABLE IF EXISTS "au_share_links";
 DROP TABLE IF EXISTS "au_document_acls";
 DROP TABLE IF EXISTS "au_document_headers";
 
@@ -7,21 +20,182 @@ CREATE TABLE "au_document_headers"
     "doc_id"  varchar(255) not null unique, -- the document ID used by the application
     "name"    text         not null,
     "owner"   varchar(255) not null,
+    "size"    bigint       not null default 0,
     "created" timestamptz  not null,
     "updated" timestamptz                   -- null when there's no content stored yet
 );
 
+-- no foreign key to the headers, because the content of a document is
+-- stored before its header
+CREATE TABLE "au_content_keys"
+(
+    "id"            bigserial primary key,
+    "doc_id"        varchar(255) not null unique,
+    "master_key_id" varchar(255) not null, -- the ID of the master key that the data key is encrypted with
+    "wrapped_key"   bytea        not null
+);
+
+CREATE TABLE "au_blobs"
+(
+    "id"            bigserial primary key,
+    "hash"          varchar(64) not null unique, -- the hex encoded SHA-256 hash of the content
+    "refs"          bigint      not null default 0,
+    "stored"        bool        not null default false,
+    "claimed_until" timestamptz                      -- until when an upload stores the content
+);
+
+-- no foreign key to the headers, because the content of a document is
+-- stored before its header
+CREATE TABLE "au_document_blobs"
+(
+    "id"     bigserial primary key,
+    "doc_id" varchar(255) not null unique,
+    "hash"   varchar(64)  not null,
+
+    CONSTRAINT fk_hash
+        FOREIGN KEY (hash)
+            REFERENCES au_blobs (hash)
+);
+
+CREATE TABLE "au_usage"
+(
+    "id"        bigserial primary key,
+    "owner"     varchar(255) not null unique,
+    "bytes"     bigint       not null default 0, -- the sum of the sizes of the documents of the owner
+    "documents" bigint       not null default 0
+);
+
+CREATE TABLE "au_quotas"
+(
+    "id"             bigserial primary key,
+    "principal_type" varchar(16)  not null, -- 'user' or 'group'
+    "principal"      varchar(255) not null, -- the username or the group name
+    "max_bytes"      bigint       not null default 0, -- 0 is unlimited
+    "max_documents"  bigint       not null default 0, -- 0 is unlimited
+
+    UNIQUE (principal_type, principal),
+    CHECK (principal_type IN ('user', 'group'))
+);
+
 CREATE TABLE "au_document_acls"
 (
-    "id"       bigserial primary key,
-    "doc_id"   varchar(255) not null,
-    "username" varchar(255) not null,
-    "read"     bool         not null,
-    "write"    bool         not null,
-    "delete"   bool         not null,
-    "share"    bool         not null,
+    "id"             bigserial primary key,
+    "doc_id"         varchar(255) not null,
+    "principal_type" varchar(16)  not null, -- 'user' or 'group'
+    "principal"      varchar(255) not null, -- the username or the group name
+    "read"           bool         not null,
+    "write"          bool         not null,
+    "delete"         bool         not null,
+    "share"          bool         not null,
+
+    UNIQUE (doc_id, principal_type, principal),
+    CHECK (principal_type IN ('user', 'group')),
+    CONSTRAINT fk_doc_id
+        FOREIGN KEY (doc_id)
+            REFERENCES au_document_headers (doc_id)
+);
+
+CREATE TABLE "au_share_links"
+(
+    "id"              bigserial primary key,
+    "link_id"         varchar(255) not null unique,
+    "doc_id"          varchar(255) not null,
+    "created_by"      varchar(255) not null,
+    "hash"            varchar(64)  not null unique, -- SHA-256 of the secret, the secret itself is not stored
+    "password_hash"   text         not null default '', -- bcrypt, empty if the link has no password
+    "max_downloads"   integer      not null default 0,  -- 0 is unlimited
+    "downloads"       integer      not null default 0,
+    "failed_attempts" integer      not null default 0,  -- wrong passwords, the link is locked after too many
+    "created"         timestamptz  not null,
+    "expires"         timestamptz  not null,
+    "last_access"     timestamptz,                      -- null if the link was never used
 
     CONSTRAINT fk_doc_id
         FOREIGN KEY (doc_id)
             REFERENCES au_document_headers (doc_id)
-);
\ No newline at end of file
+            ON DELETE CASCADE
+);
+
+CREATE TABLE "au_api_tokens"
+(
+    "id"       bigserial primary key,
+    "token_id" varchar(255) not null unique,
+    "name"     text         not null,
+    "owner"    varchar(255) not null,
+    "hash"     varchar(64)  not null unique, -- SHA-256 of the secret, the secret itself is not stored
+    "scopes"   text[]       not null,
+    "created"  timestamptz  not null,
+    "expires"  timestamptz  not null
+);
+
+-- the used upload URLs, which can only be used once
+CREATE TABLE "au_transfer_tokens"
+(
+    "id"       bigserial primary key,
+    "token_id" varchar(255) not null unique,
+    "expires"  timestamptz  not null -- the token is deleted once it expired
+);
+
+CREATE TABLE "au_sessions"
+(
+    "id"         bigserial primary key,
+    "session_id" varchar(255) not null unique,
+    "data"       bytea        not null, -- the gob encoded values of the session
+    "expires"    timestamptz  not null
+);
+
+CREATE TABLE "au_users"
+(
+    "id"             bigserial primary key,
+    "username"       varchar(255) not null unique,
+    "password_hash"  text         not null, -- bcrypt
+    "totp_secret"    text         not null default '',
+    "totp_pending"   text         not null default '', -- secret of an unconfirmed enrollment
+    "totp_last_step" bigint       not null default 0,  -- time step of the last used code, against replays
+    "disabled"       bool         not null default false
+);
+
+CREATE TABLE "au_groups"
+(
+    "id"   bigserial primary key,
+    "name" varchar(255) not null unique
+);
+
+CREATE TABLE "au_group_members"
+(
+    "id"         bigserial primary key,
+    "group_name" varchar(255) not null,
+    "username"   varchar(255) not null,
+
+    UNIQUE (group_name, username),
+    CONSTRAINT fk_group_name
+        FOREIGN KEY (group_name)
+            REFERENCES au_groups (name)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_username
+        FOREIGN KEY (username)
+            REFERENCES au_users (username)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE "au_jobs"
+(
+    "id"               bigserial primary key,
+    "job_id"           varchar(255) not null unique,
+    "type"             varchar(255) not null,
+    "owner"            varchar(255) not null,               -- empty for jobs of the app itself
+    "payload"          bytea,                               -- JSON
+    "result"           bytea,                               -- JSON
+    "state"            varchar(16)  not null,
+    "done"             bigint       not null default 0,
+    "total"            bigint       not null default 0,
+    "error"            text         not null default '',
+    "attempts"         int          not null default 0,
+    "max_attempts"     int          not null,
+    "cancel_requested" bool         not null default false,
+    "run_at"           timestamptz  not null,               -- when a queued job is due
+    "lease_until"      timestamptz,                         -- when a running job is given up by its worker
+    "created"          timestamptz  not null,
+    "updated"          timestamptz  not null,
+    "finished"         timestamptz
+);
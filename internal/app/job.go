@@ -0,0 +1,78 @@
+package app
+
+import "time"
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// final reports whether the job doesn't run anymore.
+func (s JobState) final() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// Job is an operation that runs in the background, see App.EnqueueJob. Jobs
+// are only ever finished by a worker, so that the JobFunc can clean up, even
+// if the job is canceled before it runs.
+type Job struct {
+	ID   string
+	Type string
+	// Owner is the user who started the job, empty for jobs of the app.
+	Owner string
+	// Payload and Result are JSON encoded, Result may be nil.
+	Payload []byte
+	Result  []byte
+	State   JobState
+	// Done and Total are the progress of the job in units of its type.
+	// Total is 0 if unknown.
+	Done  int64
+	Total int64
+	// Error is why the last attempt failed.
+	Error string
+	// Attempts is how often the job was started. It's retried until
+	// MaxAttempts is reached.
+	Attempts    int
+	MaxAttempts int
+	// CancelRequested is set when the job is to be canceled by its worker.
+	CancelRequested bool
+	// RunAt is when the job is due, which is after its creation while it
+	// waits for a retry.
+	RunAt time.Time
+	// LeaseUntil is when a running job is given up by its worker, e.g.
+	// because the instance crashed, so that it's claimed again.
+	LeaseUntil time.Time
+	Created    time.Time
+	Updated    time.Time
+	Finished   time.Time
+}
+
+type JobQueue interface {
+	Enqueue(Job) error
+	Get(id string) (Job, error)
+	// List returns the jobs of the owner, newest first.
+	List(owner string) ([]Job, error)
+	// Claim returns the job that is due for the longest time and marks it
+	// as running until leaseUntil, counting the attempt. Queued jobs whose
+	// cancellation is requested are due right away, running jobs whose
+	// lease expired are claimed again. ErrNotFound if there is none.
+	Claim(now, leaseUntil time.Time) (Job, error)
+	// Heartbeat stores the progress and the result of a running job and
+	// extends its lease. It returns whether the job is to be canceled.
+	// ErrNotFound if the job isn't running the same attempt anymore.
+	Heartbeat(Job) (cancel bool, err error)
+	// Finish stores the outcome of an attempt: the state, the progress, the
+	// result, the error and when the job is due again if it's retried.
+	// ErrNotFound if the job isn't running the same attempt anymore.
+	Finish(Job) error
+	// Cancel requests the cancellation of a job. ErrConflict if the job is
+	// finished already.
+	Cancel(id string, now time.Time) error
+	// DeleteFinished deletes the jobs that finished before the given time.
+	DeleteFinished(before time.Time) (int, error)
+}
@@ -0,0 +1,395 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// jobLease is how long a job may run without a heartbeat of its worker
+	// before it's claimed by another one.
+	jobLease            = 2 * time.Minute
+	defaultJobHeartbeat = 10 * time.Second
+	// jobPollInterval is how often idle workers look for jobs that other
+	// instances enqueued.
+	jobPollInterval   = 5 * time.Second
+	jobRetention      = 7 * 24 * time.Hour
+	jobBaseBackoff    = 10 * time.Second
+	jobMaxBackoff     = time.Hour
+	defaultJobWorkers = 2
+)
+
+// JobFunc runs a job. Its error is shown to the owner of the job, and the
+// job is retried unless it was its last attempt. The context is canceled if
+// the cancellation of the job is requested or the app is closed. Jobs that
+// are canceled before they run, and jobs whose last attempt was abandoned,
+// are run with a canceled context, so that they can clean up.
+type JobFunc func(ctx context.Context, run *JobRun) error
+
+type jobType struct {
+	fn          JobFunc
+	maxAttempts int
+}
+
+type jobSchedule struct {
+	typ      string
+	interval time.Duration
+}
+
+// JobRun is a job while its JobFunc runs.
+type JobRun struct {
+	mu     sync.Mutex
+	job    Job
+	result interface{}
+}
+
+// Job returns the job as it was claimed, with the progress so far.
+func (r *JobRun) Job() Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return copyJob(r.job)
+}
+
+// snapshot returns the job with the progress and the result so far.
+func (r *JobRun) snapshot() (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j := copyJob(r.job)
+	if r.result != nil {
+		data, err := json.Marshal(r.result)
+		if err != nil {
+			return j, fmt.Errorf("encode result: %w", err)
+		}
+		j.Result = data
+	}
+	return j, nil
+}
+
+// Payload decodes the payload of the job into v.
+func (r *JobRun) Payload(v interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := json.Unmarshal(r.job.Payload, v); err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+	return nil
+}
+
+// Progress sets the progress of the job, total is 0 if unknown.
+func (r *JobRun) Progress(done, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.job.Done = done
+	r.job.Total = total
+}
+
+// SetResult sets the result of the job, which is encoded as JSON whenever
+// the job is stored. It must not be changed afterwards, but appending to a
+// slice and setting the new slice is fine.
+func (r *JobRun) SetResult(v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.result = v
+}
+
+// EnqueueJob queues a job of a type that is registered with WithJob. The
+// payload is encoded as JSON. Owner is empty for jobs of the app.
+func (a *App) EnqueueJob(typ, owner string, payload interface{}) (Job, error) {
+	t, ok := a.jobTypes[typ]
+	if !ok {
+		return Job{}, fmt.Errorf("unknown job type %q", typ)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("encode payload: %w", err)
+	}
+
+	now := a.clock.Now()
+	j := Job{
+		ID:          a.genUUID().String(),
+		Type:        typ,
+		Owner:       owner,
+		Payload:     data,
+		State:       JobQueued,
+		MaxAttempts: t.maxAttempts,
+		RunAt:       now,
+		Created:     now,
+		Updated:     now,
+	}
+	if err := a.jobs.Enqueue(j); err != nil {
+		return Job{}, err
+	}
+
+	a.wakeJobWorker()
+	return j, nil
+}
+
+// wakeJobWorker wakes an idle worker of this instance, so that it doesn't
+// wait for the next poll.
+func (a *App) wakeJobWorker() {
+	select {
+	case a.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// startJobs starts the workers and the schedules, unless the app is closed
+// already.
+func (a *App) startJobs() {
+	a.jobsMu.Lock()
+	defer a.jobsMu.Unlock()
+
+	if a.jobsClosed || a.stopJobs != nil {
+		return
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	drain, cancelDrain := context.WithCancel(ctx)
+	a.stopJobs = stop
+	a.drainJobs = cancelDrain
+
+	for i := 0; i < a.jobWorkers; i++ {
+		a.jobsRunning.Add(1)
+		go func() {
+			defer a.jobsRunning.Done()
+			a.runJobWorker(ctx, drain)
+		}()
+	}
+
+	for _, s := range a.jobSchedules {
+		typ := s.typ
+		a.every(drain, s.interval, func() {
+			if _, err := a.EnqueueJob(typ, "", nil); err != nil {
+				a.log.Error().Err(err).Str("type", typ).Msg("enqueue scheduled job")
+			}
+		})
+	}
+	a.every(drain, time.Hour, a.deleteFinishedJobs)
+}
+
+// every calls fn at the interval until the context is canceled.
+func (a *App) every(ctx context.Context, interval time.Duration, fn func()) {
+	a.jobsRunning.Add(1)
+	go func() {
+		defer a.jobsRunning.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				fn()
+			}
+		}
+	}()
+}
+
+func (a *App) deleteFinishedJobs() {
+	n, err := a.jobs.DeleteFinished(a.clock.Now().Add(-jobRetention))
+	if err != nil {
+		a.log.Error().Err(err).Msg("delete finished jobs")
+	} else if n > 0 {
+		a.log.Info().Int("deleted", n).Msg("deleted finished jobs")
+	}
+}
+
+// closeJobs stops the workers from claiming jobs and waits until the running
+// jobs are finished. Once the context is done, the running jobs are canceled.
+func (a *App) closeJobs(ctx context.Context) {
+	a.jobsMu.Lock()
+	a.jobsClosed = true
+	drain, stop := a.drainJobs, a.stopJobs
+	a.jobsMu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	drain()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		a.jobsRunning.Wait()
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+	stop()
+	<-stopped
+}
+
+// runJobWorker runs jobs with the context until drain is done.
+func (a *App) runJobWorker(ctx, drain context.Context) {
+	for drain.Err() == nil {
+		ran, err := a.runNextJob(ctx)
+		if err != nil {
+			a.log.Error().Err(err).Msg("run job")
+		}
+		if ran {
+			continue
+		}
+
+		select {
+		case <-drain.Done():
+		case <-a.jobWake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// runNextJob runs the next job that is due, if there is one, and stores its
+// outcome. Failed jobs are retried with an exponential backoff.
+func (a *App) runNextJob(ctx context.Context) (bool, error) {
+	if ctx.Err() != nil {
+		return false, nil
+	}
+	now := a.clock.Now()
+	job, err := a.jobs.Claim(now, now.Add(jobLease))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("claim job: %w", err)
+	}
+
+	run := &JobRun{job: job}
+	canceled, err := a.runJob(ctx, run)
+	job, encErr := run.snapshot()
+	if err == nil {
+		err = encErr
+	}
+	if err != nil && ctx.Err() != nil {
+		err = errors.New("interrupted by shutdown")
+	}
+
+	now = a.clock.Now()
+	job.Updated = now
+	job.Error = ""
+	switch {
+	case err == nil:
+		job.State = JobSucceeded
+		job.Finished = now
+	case canceled:
+		job.State = JobCanceled
+		job.Error = "canceled"
+		job.Finished = now
+	case job.Attempts < job.MaxAttempts:
+		job.State = JobQueued
+		job.Error = err.Error()
+		job.RunAt = now.Add(jobBackoff(job.Attempts))
+	default:
+		job.State = JobFailed
+		job.Error = err.Error()
+		job.Finished = now
+	}
+	if err := a.jobs.Finish(job); err != nil {
+		return true, fmt.Errorf("finish job %v: %w", job.ID, err)
+	}
+	return true, nil
+}
+
+// runJob runs the JobFunc of the job while a heartbeat extends its lease.
+// The context of the function is canceled if the cancellation of the job is
+// requested, which canceled reports.
+func (a *App) runJob(ctx context.Context, run *JobRun) (canceled bool, err error) {
+	job := run.job
+	t, ok := a.jobTypes[job.Type]
+	if !ok {
+		return false, fmt.Errorf("unknown job type %q", job.Type)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if job.Attempts > job.MaxAttempts {
+		// the lease of the last attempt expired, e.g. because the instance
+		// crashed
+		cancel()
+		_ = callJobFunc(ctx, t.fn, run)
+		return false, errors.New("interrupted")
+	}
+
+	var mu sync.Mutex
+	setCanceled := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		canceled = true
+		cancel()
+	}
+	if job.CancelRequested {
+		setCanceled()
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(a.jobHeartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+
+			j, err := run.snapshot()
+			if err != nil {
+				a.log.Error().Err(err).Str("job", j.ID).Msg("job heartbeat")
+				continue
+			}
+			now := a.clock.Now()
+			j.LeaseUntil = now.Add(jobLease)
+			j.Updated = now
+			cancelRequested, err := a.jobs.Heartbeat(j)
+			if errors.Is(err, ErrNotFound) {
+				// another worker took over the job
+				cancel()
+				continue
+			} else if err != nil {
+				a.log.Error().Err(err).Str("job", j.ID).Msg("job heartbeat")
+				continue
+			}
+			if cancelRequested {
+				setCanceled()
+			}
+		}
+	}()
+
+	err = callJobFunc(ctx, t.fn, run)
+	close(stop)
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+	return canceled, err
+}
+
+func callJobFunc(ctx context.Context, fn JobFunc, run *JobRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, run)
+}
+
+// jobBackoff is how long a job waits for the retry after the given attempt.
+func jobBackoff(attempt int) time.Duration {
+	d := jobBaseBackoff
+	for i := 1; i < attempt && d < jobMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > jobMaxBackoff {
+		return jobMaxBackoff
+	}
+	return d
+}
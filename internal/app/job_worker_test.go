@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJobWorkerSuite(t *testing.T) {
+	suite.Run(t, new(JobWorkerSuite))
+}
+
+type JobWorkerSuite struct {
+	suite.Suite
+
+	app *App
+	now time.Time
+	fn  JobFunc
+}
+
+func (suite *JobWorkerSuite) SetupTest() {
+	suite.now = time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.fn = nil
+	suite.app = New(nil,
+		WithClock(SingleTimestampClock{suite.now}),
+		WithJob("test", 3, func(ctx context.Context, run *JobRun) error {
+			return suite.fn(ctx, run)
+		}),
+	)
+}
+
+// advance moves the clock of the app forward.
+func (suite *JobWorkerSuite) advance(d time.Duration) {
+	suite.now = suite.now.Add(d)
+	suite.app.clock = SingleTimestampClock{suite.now}
+}
+
+func (suite *JobWorkerSuite) enqueue() Job {
+	j, err := suite.app.EnqueueJob("test", "user", M{"n": 1})
+	suite.Require().NoError(err)
+	return j
+}
+
+func (suite *JobWorkerSuite) run() Job {
+	ran, err := suite.app.runNextJob(context.Background())
+	suite.Require().NoError(err)
+	suite.Require().True(ran)
+	return suite.job()
+}
+
+func (suite *JobWorkerSuite) job() Job {
+	jobs, err := suite.app.jobs.List("user")
+	suite.Require().NoError(err)
+	suite.Require().Len(jobs, 1)
+	return jobs[0]
+}
+
+func (suite *JobWorkerSuite) TestSucceeded() {
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		var payload struct {
+			N int `json:"n"`
+		}
+		suite.NoError(run.Payload(&payload))
+		suite.Equal(1, payload.N)
+
+		run.Progress(1, 2)
+		run.SetResult([]string{"a"})
+		return nil
+	}
+	enqueued := suite.enqueue()
+
+	j := suite.run()
+	suite.Equal(enqueued.ID, j.ID)
+	suite.Equal(JobSucceeded, j.State)
+	suite.Equal(1, j.Attempts)
+	suite.EqualValues(1, j.Done)
+	suite.EqualValues(2, j.Total)
+	suite.JSONEq(`["a"]`, string(j.Result))
+	suite.Empty(j.Error)
+	suite.Equal(suite.now, j.Finished)
+
+	ran, err := suite.app.runNextJob(context.Background())
+	suite.NoError(err)
+	suite.False(ran)
+}
+
+func (suite *JobWorkerSuite) TestRetried() {
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		return errors.New("boom")
+	}
+	suite.enqueue()
+
+	j := suite.run()
+	suite.Equal(JobQueued, j.State)
+	suite.Equal("boom", j.Error)
+	suite.Equal(suite.now.Add(jobBaseBackoff), j.RunAt)
+
+	// the job isn't due before its backoff
+	ran, err := suite.app.runNextJob(context.Background())
+	suite.NoError(err)
+	suite.False(ran)
+
+	suite.advance(jobBaseBackoff)
+	j = suite.run()
+	suite.Equal(JobQueued, j.State)
+	suite.Equal(2, j.Attempts)
+	suite.Equal(suite.now.Add(2*jobBaseBackoff), j.RunAt)
+
+	suite.advance(2 * jobBaseBackoff)
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		return nil
+	}
+	j = suite.run()
+	suite.Equal(JobSucceeded, j.State)
+	suite.Equal(3, j.Attempts)
+	suite.Empty(j.Error)
+}
+
+func (suite *JobWorkerSuite) TestFailed() {
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		panic("boom")
+	}
+	suite.enqueue()
+
+	for i := 0; i < 3; i++ {
+		suite.advance(jobMaxBackoff)
+		suite.run()
+	}
+	j := suite.job()
+	suite.Equal(JobFailed, j.State)
+	suite.Equal("panic: boom", j.Error)
+	suite.Equal(3, j.Attempts)
+	suite.Equal(suite.now, j.Finished)
+}
+
+func (suite *JobWorkerSuite) TestCanceledQueued() {
+	called := false
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		called = true
+		return ctx.Err()
+	}
+	j := suite.enqueue()
+	suite.NoError(suite.app.jobs.Cancel(j.ID, suite.now))
+
+	j = suite.run()
+	suite.True(called)
+	suite.Equal(JobCanceled, j.State)
+	suite.Equal("canceled", j.Error)
+
+	suite.ErrorIs(suite.app.jobs.Cancel(j.ID, suite.now), ErrConflict)
+}
+
+func (suite *JobWorkerSuite) TestCanceledRunning() {
+	suite.app.jobHeartbeat = time.Millisecond
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		run.Progress(1, 0)
+		suite.NoError(suite.app.jobs.Cancel(run.Job().ID, suite.now))
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	suite.enqueue()
+
+	j := suite.run()
+	suite.Equal(JobCanceled, j.State)
+	suite.EqualValues(1, j.Done)
+}
+
+func (suite *JobWorkerSuite) TestShutdown() {
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.fn = func(_ context.Context, run *JobRun) error {
+		cancel()
+		return errors.New("aborted")
+	}
+	suite.enqueue()
+
+	ran, err := suite.app.runNextJob(ctx)
+	suite.NoError(err)
+	suite.True(ran)
+	j := suite.job()
+	suite.Equal(JobQueued, j.State)
+	suite.Equal("interrupted by shutdown", j.Error)
+}
+
+func (suite *JobWorkerSuite) TestLeaseExpired() {
+	var ctxErr error
+	calls := 0
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		calls++
+		ctxErr = ctx.Err()
+		return nil
+	}
+	suite.enqueue()
+	for i := 0; i < 3; i++ {
+		_, err := suite.app.jobs.Claim(suite.now, suite.now.Add(jobLease))
+		suite.Require().NoError(err)
+		suite.advance(jobLease + time.Second)
+	}
+
+	// the last attempt was abandoned, but the job can still clean up
+	j := suite.run()
+	suite.Equal(JobFailed, j.State)
+	suite.Equal("interrupted", j.Error)
+	suite.Equal(4, j.Attempts)
+	suite.Equal(1, calls)
+	suite.ErrorIs(ctxErr, context.Canceled)
+}
+
+func (suite *JobWorkerSuite) TestEnqueueUnknown() {
+	_, err := suite.app.EnqueueJob("unknown", "user", nil)
+	suite.Error(err)
+}
+
+func (suite *JobWorkerSuite) TestDeleteFinished() {
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		return nil
+	}
+	suite.enqueue()
+	suite.run()
+
+	suite.advance(jobRetention)
+	suite.app.deleteFinishedJobs()
+	suite.job()
+
+	suite.advance(time.Second)
+	suite.app.deleteFinishedJobs()
+	jobs, err := suite.app.jobs.List("user")
+	suite.NoError(err)
+	suite.Empty(jobs)
+}
+
+func (suite *JobWorkerSuite) TestBackoff() {
+	suite.Equal(jobBaseBackoff, jobBackoff(1))
+	suite.Equal(4*jobBaseBackoff, jobBackoff(3))
+	suite.Equal(jobMaxBackoff, jobBackoff(100))
+}
+
+func (suite *JobWorkerSuite) TestCloseJobsWaits() {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		close(started)
+		<-release
+		return ctx.Err()
+	}
+	suite.app.startJobs()
+	suite.enqueue()
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		suite.app.closeJobs(context.Background())
+	}()
+	select {
+	case <-closed:
+		suite.Fail("jobs closed while a job runs")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-closed
+	suite.Equal(JobSucceeded, suite.job().State)
+}
+
+func (suite *JobWorkerSuite) TestCloseJobsInterrupts() {
+	started := make(chan struct{})
+	suite.fn = func(ctx context.Context, run *JobRun) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	suite.app.startJobs()
+	suite.enqueue()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	suite.app.closeJobs(ctx)
+
+	j := suite.job()
+	suite.Equal(JobQueued, j.State)
+	suite.Equal("interrupted by shutdown", j.Error)
+}
@@ -0,0 +1,306 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTClaims are the claims of a token that are used by the app.
+type JWTClaims struct {
+	Subject   string      `json:"sub"`
+	Username  string      `json:"cognito:username"`
+	Email     string      `json:"email"`
+	Groups    []string    `json:"cognito:groups"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ClientID  string      `json:"client_id"`
+	TokenUse  string      `json:"token_use"`
+	ExpiresAt int64       `json:"exp"`
+	IssuedAt  int64       `json:"iat"`
+	NotBefore int64       `json:"nbf"`
+	Nonce     string      `json:"nonce"`
+
+	// Raw contains all claims of the token, including the ones above.
+	Raw map[string]interface{} `json:"-"`
+}
+
+// jwtAudience is either a single string or an array of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a jwtAudience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+type JWTVerifierOption func(*JWTVerifier)
+
+// WithJWTHTTPClient sets the client that is used to fetch the JWKS.
+func WithJWTHTTPClient(c *http.Client) JWTVerifierOption {
+	return func(v *JWTVerifier) {
+		v.client = c
+	}
+}
+
+func WithJWTClock(c Clock) JWTVerifierOption {
+	return func(v *JWTVerifier) {
+		v.clock = c
+	}
+}
+
+// WithJWTTokenUse sets the expected token_use claim, "id" by default. An
+// empty token use disables the check.
+func WithJWTTokenUse(use string) JWTVerifierOption {
+	return func(v *JWTVerifier) {
+		v.tokenUse = use
+	}
+}
+
+// WithJWKSRefreshInterval sets how long fetched keys are used before the
+// JWKS is fetched again.
+func WithJWKSRefreshInterval(d time.Duration) JWTVerifierOption {
+	return func(v *JWTVerifier) {
+		v.refreshInterval = d
+	}
+}
+
+// JWTVerifier verifies RS256 signed tokens against the keys of a JWKS, as
+// issued by Cognito or any other OpenID provider.
+type JWTVerifier struct {
+	jwksURL  string
+	issuer   string
+	audience string
+	tokenUse string
+
+	client *http.Client
+	clock  Clock
+	// leeway is the allowed clock skew between the issuer and the app.
+	leeway time.Duration
+	// refreshInterval is the maximum age of the cached keys.
+	refreshInterval time.Duration
+	// minRefreshInterval limits how often an unknown key ID causes the JWKS
+	// to be fetched, so that invalid tokens can't be used to flood the
+	// issuer with requests.
+	minRefreshInterval time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func NewJWTVerifier(jwksURL, issuer, audience string, opts ...JWTVerifierOption) *JWTVerifier {
+	v := &JWTVerifier{
+		jwksURL:            jwksURL,
+		issuer:             issuer,
+		audience:           audience,
+		tokenUse:           "id",
+		client:             &http.Client{Timeout: 10 * time.Second},
+		clock:              TimeClock{},
+		leeway:             time.Minute,
+		refreshInterval:    time.Hour,
+		minRefreshInterval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify checks the signature and the claims of the token. All errors wrap
+// ErrUnauthorized, except for errors while fetching the JWKS.
+func (v *JWTVerifier) Verify(token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return JWTClaims{}, fmt.Errorf("malformed token: %w", ErrUnauthorized)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return JWTClaims{}, fmt.Errorf("decode header: %v: %w", err, ErrUnauthorized)
+	}
+	// never trust the algorithm of the token, otherwise "none" or HS256
+	// with the public key as secret would be accepted
+	if header.Alg != "RS256" {
+		return JWTClaims{}, fmt.Errorf("unsupported algorithm %q: %w", header.Alg, ErrUnauthorized)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return JWTClaims{}, fmt.Errorf("decode signature: %v: %w", err, ErrUnauthorized)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return JWTClaims{}, fmt.Errorf("invalid signature: %w", ErrUnauthorized)
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return JWTClaims{}, fmt.Errorf("decode claims: %v: %w", err, ErrUnauthorized)
+	}
+	if err := decodeJWTPart(parts[1], &claims.Raw); err != nil {
+		return JWTClaims{}, fmt.Errorf("decode claims: %v: %w", err, ErrUnauthorized)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return JWTClaims{}, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) checkClaims(claims JWTClaims) error {
+	if claims.Issuer != v.issuer {
+		return fmt.Errorf("unexpected issuer %q: %w", claims.Issuer, ErrUnauthorized)
+	}
+	// ID tokens carry the client in the audience, access tokens in client_id
+	if !claims.Audience.contains(v.audience) && claims.ClientID != v.audience {
+		return fmt.Errorf("unexpected audience %v: %w", claims.Audience, ErrUnauthorized)
+	}
+	if v.tokenUse != "" && claims.TokenUse != v.tokenUse {
+		return fmt.Errorf("unexpected token use %q: %w", claims.TokenUse, ErrUnauthorized)
+	}
+
+	now := v.clock.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(v.leeway)) {
+		return fmt.Errorf("token expired: %w", ErrUnauthorized)
+	}
+	if claims.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("token not valid yet: %w", ErrUnauthorized)
+	}
+	if claims.IssuedAt != 0 && now.Add(v.leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return fmt.Errorf("token issued in the future: %w", ErrUnauthorized)
+	}
+	return nil
+}
+
+// key returns the key with the given ID. The JWKS is fetched again if the
+// cached keys are too old or if the key is unknown, since the issuer may
+// have rotated its keys.
+func (v *JWTVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.clock.Now()
+	if v.keys == nil || now.Sub(v.fetched) > v.refreshInterval {
+		if err := v.refresh(now); err != nil && v.keys == nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	if now.Sub(v.fetched) > v.minRefreshInterval {
+		if err := v.refresh(now); err != nil {
+			return nil, err
+		}
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q: %w", kid, ErrUnauthorized)
+}
+
+// refresh fetches the JWKS. The caller must hold the lock.
+func (v *JWTVerifier) refresh(now time.Time) error {
+	keys, err := v.fetchJWKS()
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	v.keys = keys
+	v.fetched = now
+	return nil
+}
+
+func (v *JWTVerifier) fetchJWKS() (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", res.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode exponent of key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
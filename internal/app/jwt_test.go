@@ -0,0 +1,205 @@
+package app
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJWTVerifierSuite(t *testing.T) {
+	suite.Run(t, new(JWTVerifierSuite))
+}
+
+type JWTVerifierSuite struct {
+	suite.Suite
+
+	now      time.Time
+	key      *rsa.PrivateKey
+	kid      string
+	fetches  int32
+	server   *httptest.Server
+	verifier *JWTVerifier
+}
+
+func (suite *JWTVerifierSuite) SetupTest() {
+	var err error
+	suite.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	suite.kid = "key1"
+	suite.now = time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.fetches = 0
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&suite.fetches, 1)
+		_ = json.NewEncoder(w).Encode(M{
+			"keys": []M{jwk(suite.kid, &suite.key.PublicKey)},
+		})
+	}))
+
+	suite.verifier = NewJWTVerifier(suite.server.URL, "https://issuer.example", "client-id",
+		WithJWTHTTPClient(suite.server.Client()),
+		WithJWTClock(SingleTimestampClock{suite.now}),
+	)
+}
+
+func (suite *JWTVerifierSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *JWTVerifierSuite) claims() M {
+	return M{
+		"sub":              "1234",
+		"cognito:username": "testuser",
+		"iss":              "https://issuer.example",
+		"aud":              "client-id",
+		"token_use":        "id",
+		"iat":              suite.now.Add(-time.Minute).Unix(),
+		"exp":              suite.now.Add(time.Hour).Unix(),
+	}
+}
+
+func (suite *JWTVerifierSuite) TestValid() {
+	claims, err := suite.verifier.Verify(signJWT(suite.key, suite.kid, "RS256", suite.claims()))
+	suite.NoError(err)
+	suite.Equal("1234", claims.Subject)
+	suite.Equal("testuser", claims.Username)
+	suite.Equal(jwtAudience{"client-id"}, claims.Audience)
+}
+
+func (suite *JWTVerifierSuite) TestInvalidClaims() {
+	for name, change := range map[string]func(M){
+		"expired":      func(m M) { m["exp"] = suite.now.Add(-2 * time.Minute).Unix() },
+		"no expiry":    func(m M) { delete(m, "exp") },
+		"future":       func(m M) { m["iat"] = suite.now.Add(time.Hour).Unix() },
+		"not before":   func(m M) { m["nbf"] = suite.now.Add(time.Hour).Unix() },
+		"issuer":       func(m M) { m["iss"] = "https://evil.example" },
+		"audience":     func(m M) { m["aud"] = "other-client" },
+		"access token": func(m M) { m["token_use"] = "access" },
+	} {
+		claims := suite.claims()
+		change(claims)
+
+		_, err := suite.verifier.Verify(signJWT(suite.key, suite.kid, "RS256", claims))
+		suite.ErrorIsf(err, ErrUnauthorized, name)
+	}
+}
+
+func (suite *JWTVerifierSuite) TestLeeway() {
+	claims := suite.claims()
+	claims["exp"] = suite.now.Add(-30 * time.Second).Unix()
+
+	_, err := suite.verifier.Verify(signJWT(suite.key, suite.kid, "RS256", claims))
+	suite.NoError(err)
+}
+
+func (suite *JWTVerifierSuite) TestInvalidSignature() {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+
+	_, err = suite.verifier.Verify(signJWT(other, suite.kid, "RS256", suite.claims()))
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *JWTVerifierSuite) TestAlgorithmNone() {
+	token := signJWT(suite.key, suite.kid, "none", suite.claims())
+
+	_, err := suite.verifier.Verify(token)
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *JWTVerifierSuite) TestMalformed() {
+	for _, token := range []string{"", "abc", "a.b.c", "a.b.c.d"} {
+		_, err := suite.verifier.Verify(token)
+		suite.ErrorIs(err, ErrUnauthorized, token)
+	}
+}
+
+func (suite *JWTVerifierSuite) TestKeysAreCached() {
+	token := signJWT(suite.key, suite.kid, "RS256", suite.claims())
+	for i := 0; i < 3; i++ {
+		_, err := suite.verifier.Verify(token)
+		suite.NoError(err)
+	}
+	suite.EqualValues(1, atomic.LoadInt32(&suite.fetches))
+
+	// the keys are fetched again once they are too old
+	suite.verifier.clock = SingleTimestampClock{suite.now.Add(2 * time.Hour)}
+	claims := suite.claims()
+	claims["exp"] = suite.now.Add(3 * time.Hour).Unix()
+	_, err := suite.verifier.Verify(signJWT(suite.key, suite.kid, "RS256", claims))
+	suite.NoError(err)
+	suite.EqualValues(2, atomic.LoadInt32(&suite.fetches))
+}
+
+func (suite *JWTVerifierSuite) TestKeyRotation() {
+	_, err := suite.verifier.Verify(signJWT(suite.key, suite.kid, "RS256", suite.claims()))
+	suite.NoError(err)
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	suite.key = rotated
+	suite.kid = "key2"
+
+	// an unknown key is only fetched once per minRefreshInterval
+	token := signJWT(rotated, "key2", "RS256", suite.claims())
+	_, err = suite.verifier.Verify(token)
+	suite.ErrorIs(err, ErrUnauthorized)
+	suite.EqualValues(1, atomic.LoadInt32(&suite.fetches))
+
+	suite.verifier.clock = SingleTimestampClock{suite.now.Add(2 * time.Minute)}
+	_, err = suite.verifier.Verify(token)
+	suite.NoError(err)
+	suite.EqualValues(2, atomic.LoadInt32(&suite.fetches))
+}
+
+func (suite *JWTVerifierSuite) TestJWKSUnavailable() {
+	suite.server.Close()
+
+	_, err := suite.verifier.Verify(signJWT(suite.key, suite.kid, "RS256", suite.claims()))
+	suite.Error(err)
+	suite.NotErrorIs(err, ErrUnauthorized)
+}
+
+func jwk(kid string, key *rsa.PublicKey) M {
+	return M{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func signJWT(key *rsa.PrivateKey, kid, alg string, claims M) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	unsigned := encode(M{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	if alg == "none" {
+		return unsigned + "."
+	}
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,267 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+// LDAPService authenticates users against a directory such as OpenLDAP or
+// Active Directory. It searches the user with a service account and then
+// binds as the user to check the password. Since LDAP has no tokens, the
+// service issues its own tokens, signed with a secret key.
+type LDAPService struct {
+	url          string
+	startTLS     bool
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+	baseDN       string
+	userFilter   string
+	userAttr     string
+	groupAttr    string
+	// groupMapping maps lower case group DNs to group names.
+	groupMapping map[string]string
+
+	secret     []byte
+	tokenTTL   time.Duration
+	refreshTTL time.Duration
+	clock      Clock
+}
+
+func NewLDAPService(cfg config.Config) (*LDAPService, error) {
+	s := &LDAPService{
+		url:          cfg.GetString(config.LDAPURL),
+		startTLS:     cfg.GetBool(config.LDAPStartTLS),
+		tlsConfig:    &tls.Config{MinVersion: tls.VersionTLS12},
+		bindDN:       cfg.GetString(config.LDAPBindDN),
+		bindPassword: cfg.GetString(config.LDAPBindPassword),
+		baseDN:       cfg.GetString(config.LDAPUserBaseDN),
+		userFilter:   cfg.GetString(config.LDAPUserFilter),
+		userAttr:     cfg.GetString(config.LDAPUserAttribute),
+		groupAttr:    cfg.GetString(config.LDAPGroupAttribute),
+		groupMapping: map[string]string{},
+		tokenTTL:     cfg.GetDuration(config.LDAPTokenTTL),
+		refreshTTL:   12 * time.Hour,
+		clock:        TimeClock{},
+	}
+
+	// StartTLS, unlike ldaps, doesn't know the name of the server
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+	s.tlsConfig.ServerName = u.Hostname()
+
+	if caFile := cfg.GetString(config.LDAPCAFile); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA file %v", caFile)
+		}
+		s.tlsConfig.RootCAs = pool
+	}
+
+	for dn, name := range cfg.GetStringMapString(config.LDAPGroupMapping) {
+		s.groupMapping[strings.ToLower(dn)] = name
+	}
+
+	if s.secret, err = tokenSecret(cfg.GetString(config.LDAPTokenSecret)); err != nil {
+		return nil, err
+	}
+	if s.tokenTTL <= 0 {
+		s.tokenTTL = time.Hour
+	}
+	return s, nil
+}
+
+// connect opens a connection and binds as the service account.
+func (s *LDAPService) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(s.url, ldap.DialWithTLSConfig(s.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	conn.SetTimeout(10 * time.Second)
+
+	if s.startTLS {
+		if err := conn.StartTLS(s.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start TLS: %w", err)
+		}
+	}
+
+	if s.bindDN != "" {
+		if err := conn.Bind(s.bindDN, s.bindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("bind service account: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// search returns the entry of the user, or nil if there is no such user.
+func (s *LDAPService) search(conn *ldap.Conn, user string) (*ldap.Entry, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(
+		s.baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 10, false,
+		fmt.Sprintf(s.userFilter, ldap.EscapeFilter(user)),
+		[]string{s.userAttr, s.groupAttr},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("search user: %w", err)
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return res.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("user filter matches %d entries for %q", len(res.Entries), user)
+	}
+}
+
+func (s *LDAPService) Login(user, pass string) (LoginResult, error) {
+	// an empty password would be an unauthenticated bind, which succeeds
+	// for every existing user
+	if user == "" || pass == "" {
+		return LoginResult{Success: false}, nil
+	}
+
+	conn, err := s.connect()
+	if err != nil {
+		return LoginResult{}, err
+	}
+	defer conn.Close()
+
+	entry, err := s.search(conn, user)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if entry == nil {
+		return LoginResult{Success: false}, nil
+	}
+
+	if err := conn.Bind(entry.DN, pass); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return LoginResult{Success: false}, nil
+	} else if err != nil {
+		return LoginResult{}, fmt.Errorf("bind user: %w", err)
+	}
+
+	return s.loginResult(entry)
+}
+
+func (s *LDAPService) AnswerChallenge(user, challenge, payload string) (LoginResult, error) {
+	return LoginResult{}, fmt.Errorf("challenges are not supported by LDAP: %w", ErrValidation)
+}
+
+// Refresh issues a new token if the user still exists in the directory.
+// Group memberships are looked up again.
+func (s *LDAPService) Refresh(user, refreshToken string) (LoginResult, error) {
+	tok, err := verifyToken(s.secret, s.clock.Now(), refreshToken)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if !tok.Refresh || tok.User != user {
+		return LoginResult{}, fmt.Errorf("not a refresh token for %q: %w", user, ErrUnauthorized)
+	}
+
+	conn, err := s.connect()
+	if err != nil {
+		return LoginResult{}, err
+	}
+	defer conn.Close()
+
+	entry, err := s.search(conn, user)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if entry == nil {
+		return LoginResult{}, fmt.Errorf("user %q no longer exists: %w", user, ErrUnauthorized)
+	}
+	return s.loginResult(entry)
+}
+
+func (s *LDAPService) UserGroups(user string) ([]string, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := s.search(conn, user)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return s.groups(entry), nil
+}
+
+func (s *LDAPService) TokenValid(token string) bool {
+	tok, err := verifyToken(s.secret, s.clock.Now(), token)
+	return err == nil && !tok.Refresh
+}
+
+// loginResult issues the tokens for the user of the entry. The directory
+// usually matches the username case insensitively, so the username is taken
+// from the entry rather than the login.
+func (s *LDAPService) loginResult(entry *ldap.Entry) (LoginResult, error) {
+	user := entry.GetAttributeValue(s.userAttr)
+	if user == "" {
+		return LoginResult{}, fmt.Errorf("entry %v has no attribute %v", entry.DN, s.userAttr)
+	}
+
+	now := s.clock.Now()
+	expires := now.Add(s.tokenTTL)
+
+	token, err := signToken(s.secret, signedToken{User: user, Expires: expires.Unix()})
+	if err != nil {
+		return LoginResult{}, err
+	}
+	refreshToken, err := signToken(s.secret, signedToken{User: user, Refresh: true, Expires: now.Add(s.refreshTTL).Unix()})
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	return LoginResult{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expires,
+		Groups:       s.groups(entry),
+		Username:     user,
+	}, nil
+}
+
+// groups maps the group memberships of an entry to group names.
+func (s *LDAPService) groups(entry *ldap.Entry) []string {
+	var groups []string
+	for _, dn := range entry.GetAttributeValues(s.groupAttr) {
+		if len(s.groupMapping) > 0 {
+			if name, ok := s.groupMapping[strings.ToLower(dn)]; ok {
+				groups = append(groups, name)
+			}
+			continue
+		}
+
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+			continue
+		}
+		groups = append(groups, parsed.RDNs[0].Attributes[0].Value)
+	}
+	sort.Strings(groups)
+	return groups
+}
@@ -0,0 +1,479 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+	appcfg "github.com/tsatke/verbose-broccoli/internal/app/config"
+	"golang.org/x/net/nettest"
+)
+
+type ldapStubEntry struct {
+	dn       string
+	uid      string
+	password string
+	groups   []string
+}
+
+// ldapStub is an LDAP server that understands just enough of the protocol
+// for the LDAPService: simple binds, searches by uid, StartTLS and unbind.
+type ldapStub struct {
+	lis          net.Listener
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+
+	mu       sync.Mutex
+	entries  []ldapStubEntry
+	filters  []string
+	upgrades int
+}
+
+var ldapStubUIDFilter = regexp.MustCompile(`\(uid=([^)]*)\)`)
+
+func newLDAPStub() (*ldapStub, error) {
+	lis, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ldapStub{
+		lis:          lis,
+		bindDN:       "cn=service,dc=example,dc=org",
+		bindPassword: "servicepass",
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *ldapStub) url() string {
+	return "ldap://" + s.lis.Addr().String()
+}
+
+func (s *ldapStub) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var bound string
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id, _ := p.Children[0].Value.(int64)
+		op := p.Children[1]
+
+		write := func(packets ...*ber.Packet) bool {
+			for _, packet := range packets {
+				if _, err := conn.Write(packet.Bytes()); err != nil {
+					return false
+				}
+			}
+			return true
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, _ := op.Children[1].Value.(string)
+			pass := op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if s.authenticate(name, pass) {
+				bound = name
+				code = ldap.LDAPResultSuccess
+			}
+			if !write(ldapStubResult(id, ldap.ApplicationBindResponse, code)) {
+				return
+			}
+		case ldap.ApplicationSearchRequest:
+			if bound != s.bindDN {
+				if !write(ldapStubResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)) {
+					return
+				}
+				continue
+			}
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			var packets []*ber.Packet
+			for _, e := range s.search(filter) {
+				packets = append(packets, ldapStubEntryPacket(id, e))
+			}
+			packets = append(packets, ldapStubResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+			if !write(packets...) {
+				return
+			}
+		case ldap.ApplicationExtendedRequest:
+			if op.Children[0].Data.String() != "1.3.6.1.4.1.1466.20037" || s.tlsConfig == nil {
+				write(ldapStubResult(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				return
+			}
+			if !write(ldapStubResult(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)) {
+				return
+			}
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.upgrades++
+			s.mu.Unlock()
+			conn = tlsConn
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapStub) authenticate(dn, pass string) bool {
+	if dn == s.bindDN {
+		return pass == s.bindPassword
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.dn == dn {
+			return e.password == pass
+		}
+	}
+	return false
+}
+
+func (s *ldapStub) search(filter string) []ldapStubEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filters = append(s.filters, filter)
+	m := ldapStubUIDFilter.FindStringSubmatch(filter)
+	if m == nil {
+		return nil
+	}
+	var found []ldapStubEntry
+	// like most directories, uids are matched case insensitively
+	for _, e := range s.entries {
+		if strings.EqualFold(e.uid, m[1]) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func ldapStubResult(id int64, tag ber.Tag, code int64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(res)
+	return p
+}
+
+func ldapStubEntryPacket(id int64, e ldapStubEntry) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	attrs.AppendChild(ldapStubAttribute("uid", e.uid))
+	attrs.AppendChild(ldapStubAttribute("memberOf", e.groups...))
+	entry.AppendChild(attrs)
+	p.AppendChild(entry)
+	return p
+}
+
+func ldapStubAttribute(name string, values ...string) *ber.Packet {
+	attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+	set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+	for _, v := range values {
+		set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+	}
+	attr.AppendChild(set)
+	return attr
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 and its PEM encoding.
+func selfSignedCert() (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldap stub"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func TestLDAPServiceSuite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	suite.Run(t, new(LDAPServiceSuite))
+}
+
+type LDAPServiceSuite struct {
+	suite.Suite
+
+	stub *ldapStub
+	vp   *viper.Viper
+}
+
+func (suite *LDAPServiceSuite) SetupTest() {
+	var err error
+	suite.stub, err = newLDAPStub()
+	suite.Require().NoError(err)
+	suite.stub.entries = []ldapStubEntry{
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=org",
+			uid:      "alice",
+			password: "alicepass",
+			groups: []string{
+				"cn=users,ou=groups,dc=example,dc=org",
+				"cn=admins,ou=groups,dc=example,dc=org",
+			},
+		},
+		{
+			dn:       "uid=bob,ou=people,dc=example,dc=org",
+			uid:      "bob",
+			password: "bobpass",
+		},
+	}
+
+	suite.vp = viper.New()
+	suite.vp.Set(appcfg.LDAPURL, suite.stub.url())
+	suite.vp.Set(appcfg.LDAPBindDN, suite.stub.bindDN)
+	suite.vp.Set(appcfg.LDAPBindPassword, suite.stub.bindPassword)
+	suite.vp.Set(appcfg.LDAPUserBaseDN, "ou=people,dc=example,dc=org")
+	suite.vp.Set(appcfg.LDAPUserFilter, "(&(objectClass=person)(uid=%s))")
+	suite.vp.Set(appcfg.LDAPUserAttribute, "uid")
+	suite.vp.Set(appcfg.LDAPGroupAttribute, "memberOf")
+	suite.vp.Set(appcfg.LDAPTokenSecret, "secret")
+}
+
+func (suite *LDAPServiceSuite) TearDownTest() {
+	suite.NoError(suite.stub.lis.Close())
+}
+
+func (suite *LDAPServiceSuite) service() *LDAPService {
+	s, err := NewLDAPService(appcfg.Config{Viper: suite.vp})
+	suite.Require().NoError(err)
+	return s
+}
+
+func (suite *LDAPServiceSuite) TestLogin() {
+	s := suite.service()
+
+	res, err := s.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.Equal([]string{"admins", "users"}, res.Groups)
+	suite.WithinDuration(time.Now().Add(time.Hour), res.ExpiresAt, time.Minute)
+	suite.True(s.TokenValid(res.Token))
+	// a refresh token must not be usable as session token
+	suite.False(s.TokenValid(res.RefreshToken))
+
+	suite.Equal([]string{"(&(objectClass=person)(uid=alice))"}, suite.stub.filters)
+}
+
+func (suite *LDAPServiceSuite) TestLoginCanonicalName() {
+	s := suite.service()
+
+	// the tokens are issued for the uid of the directory rather than the
+	// name as it was typed
+	res, err := s.Login("ALICE", "alicepass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.Equal("alice", res.Username)
+
+	_, err = s.Refresh("alice", res.RefreshToken)
+	suite.NoError(err)
+	_, err = s.Refresh("ALICE", res.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *LDAPServiceSuite) TestLoginInvalidCredentials() {
+	s := suite.service()
+
+	for _, creds := range [][2]string{
+		{"alice", "wrongpass"},
+		{"alice", ""},
+		{"", "alicepass"},
+		{"carol", "carolpass"},
+	} {
+		res, err := s.Login(creds[0], creds[1])
+		suite.NoError(err, creds[0])
+		suite.False(res.Success, creds[0])
+	}
+}
+
+func (suite *LDAPServiceSuite) TestLoginFilterEscaped() {
+	s := suite.service()
+
+	res, err := s.Login("*", "alicepass")
+	suite.NoError(err)
+	suite.False(res.Success)
+	suite.Equal([]string{`(&(objectClass=person)(uid=\2a))`}, suite.stub.filters)
+}
+
+func (suite *LDAPServiceSuite) TestLoginInvalidServiceAccount() {
+	suite.vp.Set(appcfg.LDAPBindPassword, "wrong")
+	s := suite.service()
+
+	_, err := s.Login("alice", "alicepass")
+	suite.Error(err)
+}
+
+func (suite *LDAPServiceSuite) TestGroupMapping() {
+	suite.vp.Set(appcfg.LDAPGroupMapping, map[string]string{
+		"CN=Admins,OU=Groups,DC=Example,DC=Org": "administrators",
+	})
+	s := suite.service()
+
+	res, err := s.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.Equal([]string{"administrators"}, res.Groups)
+
+	res, err = s.Login("bob", "bobpass")
+	suite.NoError(err)
+	suite.Empty(res.Groups)
+}
+
+func (suite *LDAPServiceSuite) TestStartTLS() {
+	cert, certPEM, err := selfSignedCert()
+	suite.Require().NoError(err)
+	suite.stub.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	caFile := filepath.Join(suite.T().TempDir(), "ca.pem")
+	suite.Require().NoError(os.WriteFile(caFile, certPEM, 0600))
+
+	suite.vp.Set(appcfg.LDAPStartTLS, true)
+	suite.vp.Set(appcfg.LDAPCAFile, caFile)
+	s := suite.service()
+
+	res, err := s.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.stub.mu.Lock()
+	suite.Equal(1, suite.stub.upgrades)
+	suite.stub.mu.Unlock()
+
+	// the certificate of the server must be trusted
+	suite.vp.Set(appcfg.LDAPCAFile, "")
+	_, err = suite.service().Login("alice", "alicepass")
+	suite.Error(err)
+}
+
+func (suite *LDAPServiceSuite) TestRefresh() {
+	s := suite.service()
+
+	res, err := s.Login("alice", "alicepass")
+	suite.Require().NoError(err)
+
+	refreshed, err := s.Refresh("alice", res.RefreshToken)
+	suite.NoError(err)
+	suite.True(s.TokenValid(refreshed.Token))
+	suite.Equal([]string{"admins", "users"}, refreshed.Groups)
+
+	_, err = s.Refresh("bob", res.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+	_, err = s.Refresh("alice", res.Token)
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	// users that were removed from the directory can't refresh
+	suite.stub.mu.Lock()
+	suite.stub.entries = suite.stub.entries[1:]
+	suite.stub.mu.Unlock()
+	_, err = s.Refresh("alice", res.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *LDAPServiceSuite) TestTokenValid() {
+	s := suite.service()
+
+	res, err := s.Login("bob", "bobpass")
+	suite.Require().NoError(err)
+	suite.True(s.TokenValid(res.Token))
+
+	suite.False(s.TokenValid(""))
+	suite.False(s.TokenValid(res.Token + "x"))
+
+	// tokens of another secret are invalid
+	suite.vp.Set(appcfg.LDAPTokenSecret, "othersecret")
+	suite.False(suite.service().TokenValid(res.Token))
+
+	s.clock = SingleTimestampClock{time.Now().Add(2 * time.Hour)}
+	suite.False(s.TokenValid(res.Token))
+}
+
+func (suite *LDAPServiceSuite) TestUserGroups() {
+	lis, err := nettest.NewLocalListener("tcp")
+	suite.Require().NoError(err)
+	base := "http://" + lis.Addr().String() + "/rest"
+
+	a := New(lis, WithAuthService(suite.service()))
+	go func() {
+		_ = a.Run()
+	}()
+	defer func() {
+		_ = a.Close()
+	}()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	// the session belongs to the user of the directory
+	body, _ := json.Marshal(M{"username": "Alice", "password": "alicepass"})
+	res, err := client.Post(base+"/auth/login", "application/json", bytes.NewReader(body))
+	suite.Require().NoError(err)
+	suite.NoError(res.Body.Close())
+	suite.Require().Equal(http.StatusOK, res.StatusCode)
+
+	res, err = client.Get(base + "/user")
+	suite.Require().NoError(err)
+	data, err := io.ReadAll(res.Body)
+	suite.NoError(err)
+	suite.NoError(res.Body.Close())
+	suite.JSONEq(`{"username":"alice","groups":["admins","users"],"quota":{"bytes":{"used":0},"documents":{"used":0}}}`, string(data))
+}
@@ -0,0 +1,336 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChallengeSoftwareTokenMFA is the challenge of a login that requires the
+// code of an authenticator app. It has the same name as in Cognito.
+const ChallengeSoftwareTokenMFA = "SOFTWARE_TOKEN_MFA"
+
+// TOTPEnroller is implemented by auth services that let users enroll an
+// authenticator app, see HandlerPostTOTP.
+type TOTPEnroller interface {
+	// EnrollTOTP creates a new secret for the user and returns it along
+	// with its provisioning URI. The secret is used once it is confirmed.
+	EnrollTOTP(user string) (secret, uri string, err error)
+	// ConfirmTOTP activates the secret of EnrollTOTP, if the code matches.
+	ConfirmTOTP(user, code string) error
+}
+
+// LocalAuthService authenticates users that are stored in a LocalUserRepo,
+// optionally with a TOTP authenticator as second factor. Like the
+// LDAPService, it issues its own tokens.
+type LocalAuthService struct {
+	users      LocalUserRepo
+	issuer     string
+	secret     []byte
+	tokenTTL   time.Duration
+	refreshTTL time.Duration
+	clock      Clock
+}
+
+func NewLocalAuthService(cfg config.Config, users LocalUserRepo) (*LocalAuthService, error) {
+	secret, err := tokenSecret(cfg.GetString(config.LocalTokenSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LocalAuthService{
+		users:      users,
+		issuer:     cfg.GetString(config.LocalTOTPIssuer),
+		secret:     secret,
+		tokenTTL:   cfg.GetDuration(config.LocalTokenTTL),
+		refreshTTL: 12 * time.Hour,
+		clock:      TimeClock{},
+	}
+	if s.issuer == "" {
+		s.issuer = "verbose-broccoli"
+	}
+	if s.tokenTTL <= 0 {
+		s.tokenTTL = time.Hour
+	}
+	return s, nil
+}
+
+// Bootstrap creates the user as member of the group, if there are no users
+// yet. This creates the first administrator of a new installation.
+func (s *LocalAuthService) Bootstrap(user, pass, group string) (bool, error) {
+	users, err := s.users.List()
+	if err != nil {
+		return false, err
+	}
+	if len(users) > 0 {
+		return false, nil
+	}
+
+	if err := s.CreateUser(user, pass); err != nil {
+		return false, err
+	}
+	if err := s.users.CreateGroup(group); err != nil && !errors.Is(err, ErrConflict) {
+		return false, err
+	}
+	if err := s.users.AddMember(group, user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *LocalAuthService) Users() ([]UserInfo, error) {
+	users, err := s.users.List()
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := s.users.Memberships()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]UserInfo, len(users))
+	for i, u := range users {
+		res[i] = UserInfo{
+			Username: u.Username,
+			Enabled:  !u.Disabled,
+			Groups:   memberships[u.Username],
+		}
+	}
+	return res, nil
+}
+
+func (s *LocalAuthService) CreateUser(user, pass string) error {
+	hash, err := hashPassword(pass)
+	if err != nil {
+		return err
+	}
+	return s.users.Create(LocalUser{
+		Username:     user,
+		PasswordHash: hash,
+	})
+}
+
+func (s *LocalAuthService) DeleteUser(user string) error {
+	return s.users.Delete(user)
+}
+
+func (s *LocalAuthService) SetUserEnabled(user string, enabled bool) error {
+	u, err := s.users.Get(user)
+	if err != nil {
+		return err
+	}
+	u.Disabled = !enabled
+	return s.users.Update(u)
+}
+
+func (s *LocalAuthService) SetPassword(user, pass string) error {
+	u, err := s.users.Get(user)
+	if err != nil {
+		return err
+	}
+	if u.PasswordHash, err = hashPassword(pass); err != nil {
+		return err
+	}
+	return s.users.Update(u)
+}
+
+func (s *LocalAuthService) Groups() ([]string, error) {
+	return s.users.Groups()
+}
+
+func (s *LocalAuthService) CreateGroup(group string) error {
+	return s.users.CreateGroup(group)
+}
+
+func (s *LocalAuthService) DeleteGroup(group string) error {
+	return s.users.DeleteGroup(group)
+}
+
+func (s *LocalAuthService) AddUserToGroup(user, group string) error {
+	return s.users.AddMember(group, user)
+}
+
+func (s *LocalAuthService) RemoveUserFromGroup(user, group string) error {
+	return s.users.RemoveMember(group, user)
+}
+
+func (s *LocalAuthService) UserGroups(user string) ([]string, error) {
+	return s.users.GroupsOf(user)
+}
+
+// minPasswordLength is the minimum length of passwords of local users.
+const minPasswordLength = 8
+
+func hashPassword(pass string) (string, error) {
+	if len(pass) < minPasswordLength {
+		return "", fmt.Errorf("password must have at least %d characters: %w", minPasswordLength, ErrValidation)
+	}
+	// bcrypt ignores everything after 72 bytes
+	if len(pass) > 72 {
+		return "", fmt.Errorf("password must have at most 72 bytes: %w", ErrValidation)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     []byte
+)
+
+func (s *LocalAuthService) Login(user, pass string) (LoginResult, error) {
+	u, err := s.users.Get(user)
+	if errors.Is(err, ErrNotFound) {
+		// take as long as for existing users, so that the response time
+		// doesn't reveal which users exist
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(pass))
+		return LoginResult{Success: false}, nil
+	} else if err != nil {
+		return LoginResult{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(pass)); err != nil || u.Disabled {
+		return LoginResult{Success: false}, nil
+	}
+
+	if u.TOTPSecret != "" {
+		return LoginResult{
+			Success:   true,
+			Challenge: ChallengeSoftwareTokenMFA,
+		}, nil
+	}
+	return s.loginResult(user)
+}
+
+// AnswerChallenge checks the code of the authenticator app. The caller must
+// make sure that the password of the user was checked with Login before,
+// see HandlerAuthChallenge.
+func (s *LocalAuthService) AnswerChallenge(user, challenge, payload string) (LoginResult, error) {
+	if challenge != ChallengeSoftwareTokenMFA {
+		return LoginResult{}, fmt.Errorf("unknown challenge %q: %w", challenge, ErrValidation)
+	}
+
+	u, err := s.users.Get(user)
+	if errors.Is(err, ErrNotFound) {
+		return LoginResult{Success: false}, nil
+	} else if err != nil {
+		return LoginResult{}, err
+	}
+	if u.Disabled {
+		return LoginResult{Success: false}, nil
+	}
+	if u.TOTPSecret == "" {
+		return LoginResult{}, fmt.Errorf("user %q has no authenticator: %w", user, ErrValidation)
+	}
+
+	step, ok := matchTOTP(u.TOTPSecret, payload, s.clock.Now(), u.TOTPLastStep)
+	if !ok {
+		return LoginResult{Success: false}, nil
+	}
+	if err := s.users.UseTOTPStep(user, step); errors.Is(err, ErrConflict) {
+		return LoginResult{Success: false}, nil
+	} else if err != nil {
+		return LoginResult{}, err
+	}
+	return s.loginResult(user)
+}
+
+func (s *LocalAuthService) Refresh(user, refreshToken string) (LoginResult, error) {
+	tok, err := verifyToken(s.secret, s.clock.Now(), refreshToken)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if !tok.Refresh || tok.User != user {
+		return LoginResult{}, fmt.Errorf("not a refresh token for %q: %w", user, ErrUnauthorized)
+	}
+
+	u, err := s.users.Get(user)
+	if errors.Is(err, ErrNotFound) {
+		return LoginResult{}, fmt.Errorf("user %q no longer exists: %w", user, ErrUnauthorized)
+	} else if err != nil {
+		return LoginResult{}, err
+	}
+	if u.Disabled {
+		return LoginResult{}, fmt.Errorf("user %q is disabled: %w", user, ErrUnauthorized)
+	}
+	return s.loginResult(user)
+}
+
+func (s *LocalAuthService) TokenValid(token string) bool {
+	tok, err := verifyToken(s.secret, s.clock.Now(), token)
+	return err == nil && !tok.Refresh
+}
+
+func (s *LocalAuthService) EnrollTOTP(user string) (string, string, error) {
+	u, err := s.users.Get(user)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	u.TOTPPending = secret
+	if err := s.users.Update(u); err != nil {
+		return "", "", err
+	}
+	return secret, totpProvisioningURI(s.issuer, user, secret), nil
+}
+
+func (s *LocalAuthService) ConfirmTOTP(user, code string) error {
+	u, err := s.users.Get(user)
+	if err != nil {
+		return err
+	}
+	if u.TOTPPending == "" {
+		return fmt.Errorf("no enrollment in progress: %w", ErrValidation)
+	}
+
+	step, ok := matchTOTP(u.TOTPPending, code, s.clock.Now(), u.TOTPLastStep)
+	if !ok {
+		return fmt.Errorf("invalid code: %w", ErrValidation)
+	}
+	u.TOTPSecret = u.TOTPPending
+	u.TOTPPending = ""
+	u.TOTPLastStep = step
+	return s.users.Update(u)
+}
+
+func (s *LocalAuthService) loginResult(user string) (LoginResult, error) {
+	groups, err := s.users.GroupsOf(user)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	now := s.clock.Now()
+	expires := now.Add(s.tokenTTL)
+
+	token, err := signToken(s.secret, signedToken{User: user, Expires: expires.Unix()})
+	if err != nil {
+		return LoginResult{}, err
+	}
+	refreshToken, err := signToken(s.secret, signedToken{User: user, Refresh: true, Expires: now.Add(s.refreshTTL).Unix()})
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	return LoginResult{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expires,
+		Groups:       groups,
+	}, nil
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+	appcfg "github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+func TestLocalAuthServiceSuite(t *testing.T) {
+	suite.Run(t, new(LocalAuthServiceSuite))
+}
+
+type LocalAuthServiceSuite struct {
+	suite.Suite
+
+	now     time.Time
+	users   *MemLocalUserRepo
+	service *LocalAuthService
+}
+
+func (suite *LocalAuthServiceSuite) SetupTest() {
+	vp := viper.New()
+	vp.Set(appcfg.LocalTokenSecret, "secret")
+	vp.Set(appcfg.LocalTOTPIssuer, "broccoli")
+
+	suite.now = time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.users = NewMemLocalUserRepo()
+
+	var err error
+	suite.service, err = NewLocalAuthService(appcfg.Config{Viper: vp}, suite.users)
+	suite.Require().NoError(err)
+	suite.service.clock = SingleTimestampClock{suite.now}
+
+	suite.Require().NoError(suite.service.CreateUser("alice", "alicepass"))
+}
+
+// code returns the code of the authenticator of the user at the given time.
+func (suite *LocalAuthServiceSuite) code(secret string, t time.Time) string {
+	key, err := totpEncoding.DecodeString(secret)
+	suite.Require().NoError(err)
+	return totpCode(key, totpStep(t))
+}
+
+// enroll enrolls and confirms an authenticator for alice and returns its
+// secret.
+func (suite *LocalAuthServiceSuite) enroll() string {
+	secret, _, err := suite.service.EnrollTOTP("alice")
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.service.ConfirmTOTP("alice", suite.code(secret, suite.now)))
+	return secret
+}
+
+func (suite *LocalAuthServiceSuite) TestLogin() {
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.Empty(res.Challenge)
+	suite.Equal(suite.now.Add(time.Hour), res.ExpiresAt)
+	suite.True(suite.service.TokenValid(res.Token))
+	suite.False(suite.service.TokenValid(res.RefreshToken))
+
+	u, err := suite.users.Get("alice")
+	suite.NoError(err)
+	suite.NotEqual("alicepass", u.PasswordHash)
+}
+
+func (suite *LocalAuthServiceSuite) TestLoginInvalidCredentials() {
+	res, err := suite.service.Login("alice", "bobpass")
+	suite.NoError(err)
+	suite.False(res.Success)
+
+	res, err = suite.service.Login("bob", "bobpass")
+	suite.NoError(err)
+	suite.False(res.Success)
+}
+
+func (suite *LocalAuthServiceSuite) TestCreateUserAlreadyExists() {
+	suite.ErrorIs(suite.service.CreateUser("alice", "otherpass"), ErrConflict)
+}
+
+func (suite *LocalAuthServiceSuite) TestCreateUserShortPassword() {
+	suite.ErrorIs(suite.service.CreateUser("bob", "short"), ErrValidation)
+}
+
+func (suite *LocalAuthServiceSuite) TestBootstrap() {
+	// there is a user already
+	created, err := suite.service.Bootstrap("admin", "adminpass", "admin")
+	suite.NoError(err)
+	suite.False(created)
+
+	suite.service.users = NewMemLocalUserRepo()
+	created, err = suite.service.Bootstrap("admin", "adminpass", "admin")
+	suite.NoError(err)
+	suite.True(created)
+
+	res, err := suite.service.Login("admin", "adminpass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.Equal([]string{"admin"}, res.Groups)
+}
+
+func (suite *LocalAuthServiceSuite) TestDisabledUser() {
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.Require().NoError(err)
+
+	suite.NoError(suite.service.SetUserEnabled("alice", false))
+
+	disabled, err := suite.service.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.False(disabled.Success)
+	_, err = suite.service.Refresh("alice", res.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	suite.NoError(suite.service.SetUserEnabled("alice", true))
+	_, err = suite.service.Refresh("alice", res.RefreshToken)
+	suite.NoError(err)
+}
+
+func (suite *LocalAuthServiceSuite) TestSetPassword() {
+	suite.NoError(suite.service.SetPassword("alice", "newpassword"))
+
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.False(res.Success)
+	res, err = suite.service.Login("alice", "newpassword")
+	suite.NoError(err)
+	suite.True(res.Success)
+
+	suite.ErrorIs(suite.service.SetPassword("bob", "newpassword"), ErrNotFound)
+}
+
+func (suite *LocalAuthServiceSuite) TestUsersAndGroups() {
+	suite.Require().NoError(suite.service.CreateUser("bob", "bobpassword"))
+	suite.Require().NoError(suite.service.CreateGroup("editors"))
+	suite.Require().NoError(suite.service.AddUserToGroup("bob", "editors"))
+	suite.Require().NoError(suite.service.SetUserEnabled("alice", false))
+
+	users, err := suite.service.Users()
+	suite.NoError(err)
+	suite.Equal([]UserInfo{
+		{Username: "alice", Enabled: false},
+		{Username: "bob", Enabled: true, Groups: []string{"editors"}},
+	}, users)
+
+	res, err := suite.service.Login("bob", "bobpassword")
+	suite.NoError(err)
+	suite.Equal([]string{"editors"}, res.Groups)
+
+	suite.NoError(suite.service.DeleteGroup("editors"))
+	groups, err := suite.service.Groups()
+	suite.NoError(err)
+	suite.Empty(groups)
+
+	suite.NoError(suite.service.DeleteUser("bob"))
+	res, err = suite.service.Login("bob", "bobpassword")
+	suite.NoError(err)
+	suite.False(res.Success)
+}
+
+func (suite *LocalAuthServiceSuite) TestEnrollTOTP() {
+	secret, uri, err := suite.service.EnrollTOTP("alice")
+	suite.NoError(err)
+	suite.NotEmpty(secret)
+	suite.Equal(totpProvisioningURI("broccoli", "alice", secret), uri)
+
+	// the login doesn't change until the secret is confirmed
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.Empty(res.Challenge)
+
+	suite.ErrorIs(suite.service.ConfirmTOTP("alice", "000000"), ErrValidation)
+	suite.NoError(suite.service.ConfirmTOTP("alice", suite.code(secret, suite.now)))
+
+	res, err = suite.service.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.Equal(ChallengeSoftwareTokenMFA, res.Challenge)
+	suite.Empty(res.Token)
+}
+
+func (suite *LocalAuthServiceSuite) TestConfirmTOTPWithoutEnrollment() {
+	suite.ErrorIs(suite.service.ConfirmTOTP("alice", "000000"), ErrValidation)
+	suite.ErrorIs(suite.service.ConfirmTOTP("bob", "000000"), ErrNotFound)
+}
+
+func (suite *LocalAuthServiceSuite) TestAnswerChallenge() {
+	secret := suite.enroll()
+
+	// the code of the confirmation has been used already
+	res, err := suite.service.AnswerChallenge("alice", ChallengeSoftwareTokenMFA, suite.code(secret, suite.now))
+	suite.NoError(err)
+	suite.False(res.Success)
+
+	later := suite.now.Add(totpPeriod)
+	suite.service.clock = SingleTimestampClock{later}
+	res, err = suite.service.AnswerChallenge("alice", ChallengeSoftwareTokenMFA, suite.code(secret, later))
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.True(suite.service.TokenValid(res.Token))
+
+	// codes can't be replayed
+	res, err = suite.service.AnswerChallenge("alice", ChallengeSoftwareTokenMFA, suite.code(secret, later))
+	suite.NoError(err)
+	suite.False(res.Success)
+}
+
+func (suite *LocalAuthServiceSuite) TestAnswerChallengeInvalid() {
+	secret := suite.enroll()
+
+	res, err := suite.service.AnswerChallenge("alice", ChallengeSoftwareTokenMFA, "000000")
+	suite.NoError(err)
+	suite.False(res.Success)
+
+	_, err = suite.service.AnswerChallenge("alice", "NEW_PASSWORD_REQUIRED", suite.code(secret, suite.now))
+	suite.ErrorIs(err, ErrValidation)
+
+	res, err = suite.service.AnswerChallenge("bob", ChallengeSoftwareTokenMFA, "000000")
+	suite.NoError(err)
+	suite.False(res.Success)
+}
+
+func (suite *LocalAuthServiceSuite) TestRefresh() {
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.Require().NoError(err)
+
+	refreshed, err := suite.service.Refresh("alice", res.RefreshToken)
+	suite.NoError(err)
+	suite.True(suite.service.TokenValid(refreshed.Token))
+
+	_, err = suite.service.Refresh("bob", res.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+	_, err = suite.service.Refresh("alice", res.Token)
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	suite.service.clock = SingleTimestampClock{suite.now.Add(13 * time.Hour)}
+	_, err = suite.service.Refresh("alice", res.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+}
@@ -0,0 +1,47 @@
+package app
+
+// LocalUser is a user of the LocalAuthService.
+type LocalUser struct {
+	Username string
+	// PasswordHash is the bcrypt hash of the password.
+	PasswordHash string
+	// TOTPSecret is the base32 encoded secret of the authenticator of the
+	// user, empty if the user hasn't enrolled one.
+	TOTPSecret string
+	// TOTPPending is the secret of an enrollment that hasn't been confirmed
+	// with a code yet.
+	TOTPPending string
+	// TOTPLastStep is the time step of the last code that was used. Codes
+	// of this or earlier steps are rejected, so that they can't be replayed.
+	TOTPLastStep int64
+	// Disabled users can't log in.
+	Disabled bool
+}
+
+type LocalUserRepo interface {
+	Create(LocalUser) error
+	Get(username string) (LocalUser, error)
+	// List returns all users, sorted by name.
+	List() ([]LocalUser, error)
+	Update(LocalUser) error
+	// Delete deletes the user along with its group memberships.
+	Delete(username string) error
+	// UseTOTPStep records that a code of the given time step was used. It
+	// fails with ErrConflict if a code of the same or a later step was used
+	// before, which happens if the same code is sent twice concurrently.
+	UseTOTPStep(username string, step int64) error
+
+	// Groups returns the names of all groups, sorted.
+	Groups() ([]string, error)
+	CreateGroup(name string) error
+	DeleteGroup(name string) error
+	// AddMember adds the user to the group. Both have to exist, adding a
+	// member twice is not an error.
+	AddMember(group, username string) error
+	RemoveMember(group, username string) error
+	// GroupsOf returns the sorted groups of the user.
+	GroupsOf(username string) ([]string, error)
+	// Memberships returns the sorted groups of all users that are a member
+	// of at least one group.
+	Memberships() (map[string][]string, error)
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type MemAPITokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]APIToken // by hash
+}
+
+func NewMemAPITokenRepo() *MemAPITokenRepo {
+	return &MemAPITokenRepo{
+		tokens: map[string]APIToken{},
+	}
+}
+
+func (m *MemAPITokenRepo) Create(t APIToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.tokens {
+		if existing.ID == t.ID || existing.Hash == t.Hash {
+			return fmt.Errorf("token %v: %w", t.ID, ErrConflict)
+		}
+	}
+	t.Scopes = append([]string(nil), t.Scopes...)
+	m.tokens[t.Hash] = t
+	return nil
+}
+
+func (m *MemAPITokenRepo) Get(hash string) (APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tokens[hash]; ok {
+		return t, nil
+	}
+	return APIToken{}, fmt.Errorf("token: %w", ErrNotFound)
+}
+
+func (m *MemAPITokenRepo) List(owner string) ([]APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []APIToken
+	for _, t := range m.tokens {
+		if t.Owner == owner {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Created.Equal(result[j].Created) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result, nil
+}
+
+func (m *MemAPITokenRepo) Delete(owner, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, t := range m.tokens {
+		if t.ID == id && t.Owner == owner {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return fmt.Errorf("token %v: %w", id, ErrNotFound)
+}
+
+func (m *MemAPITokenRepo) DeleteAll(owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, t := range m.tokens {
+		if t.Owner == owner {
+			delete(m.tokens, hash)
+		}
+	}
+	return nil
+}
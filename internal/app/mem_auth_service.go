@@ -1,27 +1,50 @@
 package app
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memToken struct {
+	user    string
+	expires time.Time
+}
 
 type MemAuthService struct {
-	data   map[string]string
-	tokens map[string]string
+	mu            sync.RWMutex
+	clock         Clock
+	ttl           time.Duration
+	data          map[string]string
+	disabled      map[string]bool
+	groups        map[string]map[string]bool
+	tokens        map[string]memToken
+	refreshTokens map[string]string
 }
 
 func NewMemAuthService() *MemAuthService {
 	return &MemAuthService{
-		data:   map[string]string{},
-		tokens: map[string]string{},
+		clock:         TimeClock{},
+		ttl:           time.Hour,
+		data:          map[string]string{},
+		disabled:      map[string]bool{},
+		groups:        map[string]map[string]bool{},
+		tokens:        map[string]memToken{},
+		refreshTokens: map[string]string{},
 	}
 }
 
 func (m *MemAuthService) Login(user, pass string) (LoginResult, error) {
-	if m.data[user] == pass {
-		token := uuid.New().String()
-		m.tokens[token] = user
-		return LoginResult{
-			Success: true,
-			Token:   token,
-		}, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.data[user]; ok && p == pass && !m.disabled[user] {
+		refreshToken := uuid.New().String()
+		m.refreshTokens[refreshToken] = user
+		return m.issueToken(user, refreshToken), nil
 	}
 	return LoginResult{
 		Success: false,
@@ -32,11 +55,189 @@ func (m *MemAuthService) AnswerChallenge(user, challenge, payload string) (Login
 	return LoginResult{}, nil
 }
 
+func (m *MemAuthService) Refresh(user, refreshToken string) (LoginResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if owner, ok := m.refreshTokens[refreshToken]; !ok || owner != user {
+		return LoginResult{}, fmt.Errorf("invalid refresh token: %w", ErrUnauthorized)
+	}
+	if _, ok := m.data[user]; !ok || m.disabled[user] {
+		return LoginResult{}, fmt.Errorf("user %q can't log in: %w", user, ErrUnauthorized)
+	}
+	return m.issueToken(user, refreshToken), nil
+}
+
+// issueToken creates a new token for the user. The caller must hold the lock.
+func (m *MemAuthService) issueToken(user, refreshToken string) LoginResult {
+	token := uuid.New().String()
+	expires := m.clock.Now().Add(m.ttl)
+	m.tokens[token] = memToken{
+		user:    user,
+		expires: expires,
+	}
+	return LoginResult{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expires,
+		Groups:       m.groupsOf(user),
+	}
+}
+
+func (m *MemAuthService) UserGroups(user string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.groupsOf(user), nil
+}
+
+// groupsOf returns the sorted groups of the user. The caller must hold the
+// lock.
+func (m *MemAuthService) groupsOf(user string) []string {
+	var groups []string
+	for group, members := range m.groups {
+		if members[user] {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
 func (m *MemAuthService) TokenValid(s string) bool {
-	_, ok := m.tokens[s]
-	return ok
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.tokens[s]
+	return ok && m.clock.Now().Before(t.expires)
+}
+
+func (m *MemAuthService) Users() ([]UserInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]UserInfo, 0, len(m.data))
+	for user := range m.data {
+		users = append(users, UserInfo{
+			Username: user,
+			Enabled:  !m.disabled[user],
+			Groups:   m.groupsOf(user),
+		})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+// CreateUser creates a user or, unlike the other backends, changes the
+// password of an existing one, which is convenient for tests.
+func (m *MemAuthService) CreateUser(user, pass string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[user] = pass
+	return nil
+}
+
+func (m *MemAuthService) DeleteUser(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[user]; !ok {
+		return fmt.Errorf("user %v: %w", user, ErrNotFound)
+	}
+	delete(m.data, user)
+	delete(m.disabled, user)
+	for _, members := range m.groups {
+		delete(members, user)
+	}
+	return nil
+}
+
+func (m *MemAuthService) SetUserEnabled(user string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[user]; !ok {
+		return fmt.Errorf("user %v: %w", user, ErrNotFound)
+	}
+	if enabled {
+		delete(m.disabled, user)
+	} else {
+		m.disabled[user] = true
+	}
+	return nil
 }
 
-func (m *MemAuthService) CreateUser(user, pass string) {
+func (m *MemAuthService) SetPassword(user, pass string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[user]; !ok {
+		return fmt.Errorf("user %v: %w", user, ErrNotFound)
+	}
 	m.data[user] = pass
+	return nil
+}
+
+func (m *MemAuthService) Groups() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make([]string, 0, len(m.groups))
+	for group := range m.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (m *MemAuthService) CreateGroup(group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[group]; ok {
+		return fmt.Errorf("group %v: %w", group, ErrConflict)
+	}
+	m.groups[group] = map[string]bool{}
+	return nil
+}
+
+func (m *MemAuthService) DeleteGroup(group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[group]; !ok {
+		return fmt.Errorf("group %v: %w", group, ErrNotFound)
+	}
+	delete(m.groups, group)
+	return nil
+}
+
+func (m *MemAuthService) AddUserToGroup(user, group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members, ok := m.groups[group]
+	if !ok {
+		return fmt.Errorf("group %v: %w", group, ErrNotFound)
+	}
+	if _, ok := m.data[user]; !ok {
+		return fmt.Errorf("user %v: %w", user, ErrNotFound)
+	}
+	members[user] = true
+	return nil
+}
+
+func (m *MemAuthService) RemoveUserFromGroup(user, group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.groups[group][user] {
+		return fmt.Errorf("user %v in group %v: %w", user, group, ErrNotFound)
+	}
+	delete(m.groups[group], user)
+	return nil
 }
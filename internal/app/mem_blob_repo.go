@@ -0,0 +1,166 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ BlobRepo = (*MemBlobRepo)(nil)
+
+type MemBlobRepo struct {
+	mu    sync.Mutex
+	blobs map[string]*memBlob
+	docs  map[DocID]string
+}
+
+type memBlob struct {
+	refs         int64
+	stored       bool
+	claimedUntil time.Time
+}
+
+func NewMemBlobRepo() *MemBlobRepo {
+	return &MemBlobRepo{
+		blobs: map[string]*memBlob{},
+		docs:  map[DocID]string{},
+	}
+}
+
+func (r *MemBlobRepo) Acquire(hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.blobs[hash]
+	if !ok {
+		b = &memBlob{}
+		r.blobs[hash] = b
+	}
+	b.refs++
+	return b.stored, nil
+}
+
+func (r *MemBlobRepo) Claim(hash string, now, until time.Time) (bool, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.blobs[hash]
+	if !ok {
+		return false, false, fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	}
+	if b.stored || b.claimedUntil.After(now) {
+		return false, b.stored, nil
+	}
+	b.claimedUntil = until
+	return true, false, nil
+}
+
+func (r *MemBlobRepo) Unclaim(hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.blobs[hash]
+	if !ok {
+		return fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	}
+	b.claimedUntil = time.Time{}
+	return nil
+}
+
+func (r *MemBlobRepo) Stored(hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.blobs[hash]
+	if !ok {
+		return fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	}
+	b.stored = true
+	b.claimedUntil = time.Time{}
+	return nil
+}
+
+func (r *MemBlobRepo) Release(hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.release(hash)
+}
+
+func (r *MemBlobRepo) release(hash string) error {
+	b, ok := r.blobs[hash]
+	if !ok {
+		return fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	}
+	b.refs--
+	return nil
+}
+
+func (r *MemBlobRepo) Link(id DocID, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.docs[id]
+	r.docs[id] = hash
+	if ok {
+		return r.release(old)
+	}
+	return nil
+}
+
+func (r *MemBlobRepo) Unlink(id DocID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, ok := r.docs[id]
+	if !ok {
+		return fmt.Errorf("blob of %v: %w", id, ErrNotFound)
+	}
+	delete(r.docs, id)
+	return r.release(hash)
+}
+
+func (r *MemBlobRepo) Blob(id DocID) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, ok := r.docs[id]
+	if !ok {
+		return "", fmt.Errorf("blob of %v: %w", id, ErrNotFound)
+	}
+	return hash, nil
+}
+
+// Collect holds the lock while del runs, so that nothing else can use the
+// repo in the meantime.
+func (r *MemBlobRepo) Collect(del func(hash string) error) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var hashes []string
+	for hash, b := range r.blobs {
+		if b.refs <= 0 {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	n, failed := 0, 0
+	var firstErr error
+	for _, hash := range hashes {
+		if err := del(hash); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("blob %v: %w", hash, err)
+			}
+			continue
+		}
+		delete(r.blobs, hash)
+		n++
+	}
+	if firstErr != nil {
+		return n, fmt.Errorf("%d blobs not deleted: %w", failed, firstErr)
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var _ ContentKeyRepo = (*MemContentKeyRepo)(nil)
+
+type MemContentKeyRepo struct {
+	mu   sync.Mutex
+	keys map[DocID]WrappedKey
+}
+
+func NewMemContentKeyRepo() *MemContentKeyRepo {
+	return &MemContentKeyRepo{
+		keys: map[DocID]WrappedKey{},
+	}
+}
+
+func (r *MemContentKeyRepo) Create(id DocID, key WrappedKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[id]; ok {
+		return fmt.Errorf("content key of %v: %w", id, ErrConflict)
+	}
+	r.keys[id] = key
+	return nil
+}
+
+func (r *MemContentKeyRepo) Set(id DocID, key WrappedKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys[id] = key
+	return nil
+}
+
+func (r *MemContentKeyRepo) Get(id DocID) (WrappedKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return WrappedKey{}, fmt.Errorf("content key of %v: %w", id, ErrNotFound)
+	}
+	return key, nil
+}
+
+func (r *MemContentKeyRepo) Delete(id DocID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.keys, id)
+	return nil
+}
+
+func (r *MemContentKeyRepo) Stale(masterKeyID string) ([]DocID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []DocID
+	for id, key := range r.keys {
+		if key.MasterKeyID != masterKeyID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
@@ -2,11 +2,13 @@ package app
 
 import (
 	"fmt"
+	"sort"
 )
 
 type MemDocumentRepo struct {
-	data map[DocID]DocumentHeader
-	acls map[DocID]ACL
+	data  map[DocID]DocumentHeader
+	acls  map[DocID]ACL
+	usage map[string]Usage
 }
 
 func (m *MemDocumentRepo) ACL(id DocID) (ACL, error) {
@@ -14,24 +16,36 @@ func (m *MemDocumentRepo) ACL(id DocID) (ACL, error) {
 		return acl, nil
 	}
 
-	return ACL{}, fmt.Errorf("does not exist")
+	return ACL{}, fmt.Errorf("document %v: %w", id, ErrNotFound)
 }
 
 func NewMemDocumentRepo() *MemDocumentRepo {
 	return &MemDocumentRepo{
-		data: map[DocID]DocumentHeader{},
-		acls: map[DocID]ACL{},
+		data:  map[DocID]DocumentHeader{},
+		acls:  map[DocID]ACL{},
+		usage: map[string]Usage{},
 	}
 }
 
 func (m *MemDocumentRepo) Create(h DocumentHeader, acl ACL) error {
 	if _, ok := m.data[h.ID]; ok {
-		return fmt.Errorf("already exists")
+		return fmt.Errorf("document %v: %w", h.ID, ErrConflict)
 	}
-	return m.Update(h, acl)
+
+	m.addUsage(h.Owner, h.Size, 1)
+	m.data[h.ID] = h
+	m.acls[h.ID] = acl
+	return nil
 }
 
 func (m *MemDocumentRepo) Update(h DocumentHeader, acl ACL) error {
+	old, ok := m.data[h.ID]
+	if !ok {
+		return fmt.Errorf("document %v: %w", h.ID, ErrNotFound)
+	}
+
+	m.addUsage(old.Owner, -old.Size, -1)
+	m.addUsage(h.Owner, h.Size, 1)
 	m.data[h.ID] = h
 	m.acls[h.ID] = acl
 
@@ -43,15 +57,41 @@ func (m *MemDocumentRepo) Get(id DocID) (DocumentHeader, error) {
 		return h, nil
 	}
 
-	return DocumentHeader{}, fmt.Errorf("does not exist")
+	return DocumentHeader{}, fmt.Errorf("document %v: %w", id, ErrNotFound)
 }
 
 func (m *MemDocumentRepo) Delete(id DocID) error {
-	if _, ok := m.data[id]; !ok {
-		return fmt.Errorf("does not exists")
+	h, ok := m.data[id]
+	if !ok {
+		return fmt.Errorf("document %v: %w", id, ErrNotFound)
 	}
 
+	m.addUsage(h.Owner, -h.Size, -1)
 	delete(m.data, id)
 	delete(m.acls, id)
 	return nil
 }
+
+func (m *MemDocumentRepo) List(username string, groups []string) ([]DocumentHeader, error) {
+	var headers []DocumentHeader
+	for id, acl := range m.acls {
+		if perm, _ := acl.Effective(username, groups); perm.Read {
+			headers = append(headers, m.data[id])
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].ID < headers[j].ID
+	})
+	return headers, nil
+}
+
+func (m *MemDocumentRepo) Usage(owner string) (Usage, error) {
+	return m.usage[owner], nil
+}
+
+func (m *MemDocumentRepo) addUsage(owner string, bytes, documents int64) {
+	u := m.usage[owner]
+	u.Bytes += bytes
+	u.Documents += documents
+	m.usage[owner] = u
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemJobQueue struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func NewMemJobQueue() *MemJobQueue {
+	return &MemJobQueue{
+		jobs: map[string]Job{},
+	}
+}
+
+func (m *MemJobQueue) Enqueue(j Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[j.ID]; ok {
+		return fmt.Errorf("job %v: %w", j.ID, ErrConflict)
+	}
+	m.jobs[j.ID] = copyJob(j)
+	return nil
+}
+
+func (m *MemJobQueue) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job %v: %w", id, ErrNotFound)
+	}
+	return copyJob(j), nil
+}
+
+func (m *MemJobQueue) List(owner string) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []Job
+	for _, j := range m.jobs {
+		if j.Owner == owner {
+			result = append(result, copyJob(j))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Created.Equal(result[j].Created) {
+			return result[i].ID > result[j].ID
+		}
+		return result[i].Created.After(result[j].Created)
+	})
+	return result, nil
+}
+
+func (m *MemJobQueue) Claim(now, leaseUntil time.Time) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var next *Job
+	for _, j := range m.jobs {
+		j := j
+		due := j.State == JobQueued && (!j.RunAt.After(now) || j.CancelRequested)
+		abandoned := j.State == JobRunning && j.LeaseUntil.Before(now)
+		if !due && !abandoned {
+			continue
+		}
+		if next == nil || j.RunAt.Before(next.RunAt) || (j.RunAt.Equal(next.RunAt) && j.ID < next.ID) {
+			next = &j
+		}
+	}
+	if next == nil {
+		return Job{}, fmt.Errorf("job: %w", ErrNotFound)
+	}
+
+	next.State = JobRunning
+	next.Attempts++
+	next.LeaseUntil = leaseUntil
+	next.Updated = now
+	m.jobs[next.ID] = *next
+	return copyJob(*next), nil
+}
+
+func (m *MemJobQueue) Heartbeat(j Job) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.running(j)
+	if err != nil {
+		return false, err
+	}
+	stored.Done = j.Done
+	stored.Total = j.Total
+	stored.Result = append([]byte(nil), j.Result...)
+	stored.LeaseUntil = j.LeaseUntil
+	stored.Updated = j.Updated
+	m.jobs[j.ID] = stored
+	return stored.CancelRequested, nil
+}
+
+func (m *MemJobQueue) Finish(j Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.running(j)
+	if err != nil {
+		return err
+	}
+	stored.State = j.State
+	stored.Done = j.Done
+	stored.Total = j.Total
+	stored.Result = append([]byte(nil), j.Result...)
+	stored.Error = j.Error
+	stored.RunAt = j.RunAt
+	stored.LeaseUntil = time.Time{}
+	stored.Updated = j.Updated
+	stored.Finished = j.Finished
+	m.jobs[j.ID] = stored
+	return nil
+}
+
+// running returns the stored job if it's still running the same attempt.
+func (m *MemJobQueue) running(j Job) (Job, error) {
+	stored, ok := m.jobs[j.ID]
+	if !ok || stored.State != JobRunning || stored.Attempts != j.Attempts {
+		return Job{}, fmt.Errorf("running job %v: %w", j.ID, ErrNotFound)
+	}
+	return stored, nil
+}
+
+func (m *MemJobQueue) Cancel(id string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return fmt.Errorf("job %v: %w", id, ErrNotFound)
+	}
+	if j.State.final() {
+		return fmt.Errorf("job %v is %v: %w", id, j.State, ErrConflict)
+	}
+	j.CancelRequested = true
+	j.Updated = now
+	m.jobs[id] = j
+	return nil
+}
+
+func (m *MemJobQueue) DeleteFinished(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, j := range m.jobs {
+		if j.State.final() && j.Finished.Before(before) {
+			delete(m.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// copyJob copies the job, so that the byte slices aren't shared.
+func copyJob(j Job) Job {
+	j.Payload = append([]byte(nil), j.Payload...)
+	j.Result = append([]byte(nil), j.Result...)
+	return j
+}
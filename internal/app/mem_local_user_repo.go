@@ -0,0 +1,186 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type MemLocalUserRepo struct {
+	mu     sync.Mutex
+	users  map[string]LocalUser
+	groups map[string]map[string]bool
+}
+
+func NewMemLocalUserRepo() *MemLocalUserRepo {
+	return &MemLocalUserRepo{
+		users:  map[string]LocalUser{},
+		groups: map[string]map[string]bool{},
+	}
+}
+
+func (m *MemLocalUserRepo) Create(u LocalUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[u.Username]; ok {
+		return fmt.Errorf("user %v: %w", u.Username, ErrConflict)
+	}
+	m.users[u.Username] = u
+	return nil
+}
+
+func (m *MemLocalUserRepo) Get(username string) (LocalUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[username]; ok {
+		return u, nil
+	}
+	return LocalUser{}, fmt.Errorf("user %v: %w", username, ErrNotFound)
+}
+
+func (m *MemLocalUserRepo) List() ([]LocalUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make([]LocalUser, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+func (m *MemLocalUserRepo) Delete(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[username]; !ok {
+		return fmt.Errorf("user %v: %w", username, ErrNotFound)
+	}
+	delete(m.users, username)
+	for _, members := range m.groups {
+		delete(members, username)
+	}
+	return nil
+}
+
+func (m *MemLocalUserRepo) Update(u LocalUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[u.Username]; !ok {
+		return fmt.Errorf("user %v: %w", u.Username, ErrNotFound)
+	}
+	m.users[u.Username] = u
+	return nil
+}
+
+func (m *MemLocalUserRepo) UseTOTPStep(username string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[username]
+	if !ok {
+		return fmt.Errorf("user %v: %w", username, ErrNotFound)
+	}
+	if step <= u.TOTPLastStep {
+		return fmt.Errorf("TOTP step %v of user %v: %w", step, username, ErrConflict)
+	}
+	u.TOTPLastStep = step
+	m.users[username] = u
+	return nil
+}
+
+func (m *MemLocalUserRepo) Groups() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groups := make([]string, 0, len(m.groups))
+	for group := range m.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (m *MemLocalUserRepo) CreateGroup(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[name]; ok {
+		return fmt.Errorf("group %v: %w", name, ErrConflict)
+	}
+	m.groups[name] = map[string]bool{}
+	return nil
+}
+
+func (m *MemLocalUserRepo) DeleteGroup(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[name]; !ok {
+		return fmt.Errorf("group %v: %w", name, ErrNotFound)
+	}
+	delete(m.groups, name)
+	return nil
+}
+
+func (m *MemLocalUserRepo) AddMember(group, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members, ok := m.groups[group]
+	if !ok {
+		return fmt.Errorf("group %v: %w", group, ErrNotFound)
+	}
+	if _, ok := m.users[username]; !ok {
+		return fmt.Errorf("user %v: %w", username, ErrNotFound)
+	}
+	members[username] = true
+	return nil
+}
+
+func (m *MemLocalUserRepo) RemoveMember(group, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.groups[group][username] {
+		return fmt.Errorf("user %v in group %v: %w", username, group, ErrNotFound)
+	}
+	delete(m.groups[group], username)
+	return nil
+}
+
+func (m *MemLocalUserRepo) GroupsOf(username string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var groups []string
+	for group, members := range m.groups {
+		if members[username] {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (m *MemLocalUserRepo) Memberships() (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	memberships := map[string][]string{}
+	for group, members := range m.groups {
+		for username := range members {
+			memberships[username] = append(memberships[username], group)
+		}
+	}
+	for _, groups := range memberships {
+		sort.Strings(groups)
+	}
+	return memberships, nil
+}
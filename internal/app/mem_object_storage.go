@@ -2,6 +2,8 @@ package app
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
 )
@@ -19,7 +21,7 @@ func NewMemObjectStorage() *MemObjectStorage {
 func (s *MemObjectStorage) Create(id DocID, rd io.Reader) error {
 	_, ok := s.data[id]
 	if ok {
-		return fmt.Errorf("already exists")
+		return fmt.Errorf("object %v: %w", id, ErrConflict)
 	}
 
 	data, err := io.ReadAll(rd)
@@ -33,7 +35,22 @@ func (s *MemObjectStorage) Create(id DocID, rd io.Reader) error {
 func (s *MemObjectStorage) Read(id DocID) (io.ReadCloser, error) {
 	data, ok := s.data[id]
 	if !ok {
-		return nil, fmt.Errorf("does not exist")
+		return nil, fmt.Errorf("object %v: %w", id, ErrNotFound)
+	}
+	return readCloserWrapper{bytes.NewReader(data)}, nil
+}
+
+func (s *MemObjectStorage) ReadRange(id DocID, offset, length int64) (io.ReadCloser, error) {
+	data, ok := s.data[id]
+	if !ok {
+		return nil, fmt.Errorf("object %v: %w", id, ErrNotFound)
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
 	}
 	return readCloserWrapper{bytes.NewReader(data)}, nil
 }
@@ -41,7 +58,7 @@ func (s *MemObjectStorage) Read(id DocID) (io.ReadCloser, error) {
 func (s *MemObjectStorage) Update(id DocID, rd io.Reader) error {
 	_, ok := s.data[id]
 	if !ok {
-		return fmt.Errorf("does not exist")
+		return fmt.Errorf("object %v: %w", id, ErrNotFound)
 	}
 
 	data, err := io.ReadAll(rd)
@@ -52,6 +69,18 @@ func (s *MemObjectStorage) Update(id DocID, rd io.Reader) error {
 	return nil
 }
 
+func (s *MemObjectStorage) Stat(id DocID) (ObjectInfo, error) {
+	data, ok := s.data[id]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("object %v: %w", id, ErrNotFound)
+	}
+	sum := md5.Sum(data)
+	return ObjectInfo{
+		Size: int64(len(data)),
+		MD5:  hex.EncodeToString(sum[:]),
+	}, nil
+}
+
 func (s *MemObjectStorage) Delete(id DocID) error {
 	delete(s.data, id)
 	return nil
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type MemQuotaRepo struct {
+	mu     sync.Mutex
+	quotas map[Principal]Quota
+}
+
+func NewMemQuotaRepo() *MemQuotaRepo {
+	return &MemQuotaRepo{
+		quotas: map[Principal]Quota{},
+	}
+}
+
+func (m *MemQuotaRepo) Set(q Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotas[q.Principal] = q
+	return nil
+}
+
+func (m *MemQuotaRepo) Delete(p Principal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.quotas[p]; !ok {
+		return fmt.Errorf("quota of %v %v: %w", p.Type, p.Name, ErrNotFound)
+	}
+	delete(m.quotas, p)
+	return nil
+}
+
+func (m *MemQuotaRepo) List() ([]Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var quotas []Quota
+	for _, q := range m.quotas {
+		quotas = append(quotas, q)
+	}
+	sortQuotas(quotas)
+	return quotas, nil
+}
+
+func (m *MemQuotaRepo) Find(user string, groups []string) ([]Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var quotas []Quota
+	if q, ok := m.quotas[UserPrincipal(user)]; ok {
+		quotas = append(quotas, q)
+	}
+	for _, g := range groups {
+		if q, ok := m.quotas[GroupPrincipal(g)]; ok {
+			quotas = append(quotas, q)
+		}
+	}
+	sortQuotas(quotas)
+	return quotas, nil
+}
+
+func sortQuotas(quotas []Quota) {
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Principal.Type != quotas[j].Principal.Type {
+			return quotas[i].Principal.Type < quotas[j].Principal.Type
+		}
+		return quotas[i].Principal.Name < quotas[j].Principal.Name
+	})
+}
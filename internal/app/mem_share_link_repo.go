@@ -0,0 +1,113 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemShareLinkRepo struct {
+	mu    sync.Mutex
+	links map[string]ShareLink // by hash
+}
+
+func NewMemShareLinkRepo() *MemShareLinkRepo {
+	return &MemShareLinkRepo{
+		links: map[string]ShareLink{},
+	}
+}
+
+func (m *MemShareLinkRepo) Create(l ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.links {
+		if existing.ID == l.ID || existing.Hash == l.Hash {
+			return fmt.Errorf("link %v: %w", l.ID, ErrConflict)
+		}
+	}
+	m.links[l.Hash] = l
+	return nil
+}
+
+func (m *MemShareLinkRepo) Get(hash string) (ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, ok := m.links[hash]; ok {
+		return l, nil
+	}
+	return ShareLink{}, fmt.Errorf("link: %w", ErrNotFound)
+}
+
+func (m *MemShareLinkRepo) List(doc DocID) ([]ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []ShareLink
+	for _, l := range m.links {
+		if l.DocID == doc {
+			result = append(result, l)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Created.Equal(result[j].Created) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result, nil
+}
+
+func (m *MemShareLinkRepo) Delete(doc DocID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, l := range m.links {
+		if l.ID == id && l.DocID == doc {
+			delete(m.links, hash)
+			return nil
+		}
+	}
+	return fmt.Errorf("link %v: %w", id, ErrNotFound)
+}
+
+func (m *MemShareLinkRepo) CountAccess(hash string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.links[hash]
+	if !ok || !l.usable(at) {
+		return fmt.Errorf("link: %w", ErrNotFound)
+	}
+	l.Downloads++
+	l.LastAccess = at
+	m.links[hash] = l
+	return nil
+}
+
+func (m *MemShareLinkRepo) CountFailure(hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.links[hash]
+	if !ok {
+		return fmt.Errorf("link: %w", ErrNotFound)
+	}
+	l.FailedAttempts++
+	m.links[hash] = l
+	return nil
+}
+
+func (m *MemShareLinkRepo) DeleteCreatedBy(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, l := range m.links {
+		if l.CreatedBy == user {
+			delete(m.links, hash)
+		}
+	}
+	return nil
+}
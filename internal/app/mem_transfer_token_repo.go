@@ -0,0 +1,34 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type MemTransferTokenRepo struct {
+	mu   sync.Mutex
+	used map[string]time.Time // expiry by ID
+}
+
+func NewMemTransferTokenRepo() *MemTransferTokenRepo {
+	return &MemTransferTokenRepo{
+		used: map[string]time.Time{},
+	}
+}
+
+func (m *MemTransferTokenRepo) Use(id string, expires, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for usedID, usedExpires := range m.used {
+		if !now.Before(usedExpires) {
+			delete(m.used, usedID)
+		}
+	}
+	if _, ok := m.used[id]; ok {
+		return fmt.Errorf("transfer token %v: %w", id, ErrConflict)
+	}
+	m.used[id] = expires
+	return nil
+}
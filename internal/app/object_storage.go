@@ -1,6 +1,14 @@
 package app
 
-import "io"
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
 
 type ObjectStorage interface {
 	Create(DocID, io.Reader) error
@@ -8,3 +16,97 @@ type ObjectStorage interface {
 	Update(DocID, io.Reader) error
 	Delete(DocID) error
 }
+
+// PresignedRequest is a request that clients can send without any other
+// credentials until it expires.
+type PresignedRequest struct {
+	Method string
+	URL    string
+	// Header contains the headers that have to be sent with the request.
+	Header http.Header
+}
+
+// URLPresigner is implemented by object storages that clients can transfer
+// content to and from directly, without streaming it through the app. See
+// HandlerGetContentURL.
+type URLPresigner interface {
+	PresignGet(id DocID, ttl time.Duration) (PresignedRequest, error)
+	// PresignPut returns a request that stores content of the given size.
+	// If md5 isn't empty, it is the hex encoded MD5 hash that the content
+	// must have.
+	PresignPut(id DocID, size int64, md5 string, ttl time.Duration) (PresignedRequest, error)
+}
+
+// ObjectInfo describes the content of an object.
+type ObjectInfo struct {
+	Size int64
+	// MD5 is the hex encoded MD5 hash of the content, empty if unknown.
+	MD5 string
+}
+
+// ObjectStater is implemented by object storages that can describe objects
+// without reading them.
+type ObjectStater interface {
+	Stat(DocID) (ObjectInfo, error)
+}
+
+// RangeReader is implemented by object storages that can read a part of an
+// object without reading the content before it.
+type RangeReader interface {
+	// ReadRange reads length bytes from the offset on, or up to the end
+	// of the object if length is negative.
+	ReadRange(id DocID, offset, length int64) (io.ReadCloser, error)
+}
+
+// readRange reads a range of the object like RangeReader.ReadRange, and
+// skips the content before the offset if the storage isn't a RangeReader.
+func readRange(s ObjectStorage, id DocID, offset, length int64) (io.ReadCloser, error) {
+	if rr, ok := s.(RangeReader); ok {
+		return rr.ReadRange(id, offset, length)
+	}
+
+	rd, err := s.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rd, offset); err != nil && !errors.Is(err, io.EOF) {
+		_ = rd.Close()
+		return nil, fmt.Errorf("skip content: %w", err)
+	}
+	if length < 0 {
+		return rd, nil
+	}
+	return readCloser{io.LimitReader(rd, length), rd}, nil
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// statObject returns the info of the object, reading the whole content if
+// the storage isn't an ObjectStater.
+func statObject(s ObjectStorage, id DocID) (ObjectInfo, error) {
+	if st, ok := s.(ObjectStater); ok {
+		return st.Stat(id)
+	}
+
+	rd, err := s.Read(id)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	h := md5.New()
+	n, err := io.Copy(h, rd)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("read object: %w", err)
+	}
+	return ObjectInfo{
+		Size: n,
+		MD5:  hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
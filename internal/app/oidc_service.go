@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+// RedirectAuthService is implemented by auth services that log users in by
+// redirecting them to an identity provider, see HandlerOIDCStart.
+type RedirectAuthService interface {
+	AuthService
+	// AuthCodeURL returns the URL of the identity provider that the user
+	// is redirected to.
+	AuthCodeURL(state, codeChallenge, nonce string) (string, error)
+	// Exchange exchanges the code that the identity provider passed to the
+	// callback for a token and returns the name of the user.
+	Exchange(code, codeVerifier, nonce string) (string, LoginResult, error)
+}
+
+// OIDCService authenticates users against an OpenID Connect provider, e.g.
+// Keycloak. Browsers use the authorization code flow with PKCE, Login uses
+// the password grant for clients that can't follow redirects, such as
+// WebDAV clients. The ID token is the token of the session.
+type OIDCService struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	usernameClaim string
+
+	client *http.Client
+	clock  Clock
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	verifier  *JWTVerifier
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func NewOIDCService(cfg config.Config) *OIDCService {
+	return &OIDCService{
+		issuer:        strings.TrimSuffix(cfg.GetString(config.OIDCIssuer), "/"),
+		clientID:      cfg.GetString(config.OIDCClientID),
+		clientSecret:  cfg.GetString(config.OIDCClientSecret),
+		redirectURL:   cfg.GetString(config.OIDCRedirectURL),
+		scopes:        cfg.GetStringSlice(config.OIDCScopes),
+		usernameClaim: cfg.GetString(config.OIDCUsernameClaim),
+
+		client: &http.Client{Timeout: 10 * time.Second},
+		clock:  TimeClock{},
+	}
+}
+
+// discover fetches the discovery document of the issuer on first use.
+func (s *OIDCService) discover() (*oidcDiscovery, *JWTVerifier, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.discovery != nil {
+		return s.discovery, s.verifier, nil
+	}
+
+	res, err := s.client.Get(s.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, nil, fmt.Errorf("get discovery document: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("get discovery document: unexpected status %v", res.Status)
+	}
+
+	var d oidcDiscovery
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&d); err != nil {
+		return nil, nil, fmt.Errorf("decode discovery document: %w", err)
+	}
+	// the issuer must match exactly, otherwise tokens of another issuer
+	// could be accepted, see OpenID Connect Discovery 1.0, section 4.3
+	if d.Issuer != s.issuer {
+		return nil, nil, fmt.Errorf("discovery document is for issuer %q, expected %q", d.Issuer, s.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("discovery document is incomplete")
+	}
+
+	s.discovery = &d
+	s.verifier = NewJWTVerifier(d.JWKSURI, d.Issuer, s.clientID,
+		WithJWTHTTPClient(s.client),
+		WithJWTClock(s.clock),
+		WithJWTTokenUse(""),
+	)
+	return s.discovery, s.verifier, nil
+}
+
+func (s *OIDCService) AuthCodeURL(state, codeChallenge, nonce string) (string, error) {
+	d, _, err := s.discover()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", s.clientID)
+	q.Set("redirect_uri", s.redirectURL)
+	q.Set("scope", strings.Join(s.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (s *OIDCService) Exchange(code, codeVerifier, nonce string) (string, LoginResult, error) {
+	tok, err := s.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.redirectURL},
+		"code_verifier": {codeVerifier},
+	})
+	if err != nil {
+		return "", LoginResult{}, err
+	}
+	return s.loginResult(tok, nonce, "")
+}
+
+func (s *OIDCService) Login(user, pass string) (LoginResult, error) {
+	tok, err := s.token(url.Values{
+		"grant_type": {"password"},
+		"username":   {user},
+		"password":   {pass},
+		"scope":      {strings.Join(s.scopes, " ")},
+	})
+	if err != nil {
+		return LoginResult{}, err
+	}
+	_, res, err := s.loginResult(tok, "", user)
+	return res, err
+}
+
+func (s *OIDCService) AnswerChallenge(user, challenge, payload string) (LoginResult, error) {
+	return LoginResult{}, fmt.Errorf("challenges are not supported by OpenID Connect: %w", ErrValidation)
+}
+
+func (s *OIDCService) Refresh(user, refreshToken string) (LoginResult, error) {
+	tok, err := s.token(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	_, res, err := s.loginResult(tok, "", user)
+	return res, err
+}
+
+func (s *OIDCService) TokenValid(token string) bool {
+	_, verifier, err := s.discover()
+	if err != nil {
+		return false
+	}
+	_, err = verifier.Verify(token)
+	return err == nil
+}
+
+// token sends a request to the token endpoint.
+func (s *OIDCService) token(form url.Values) (oidcTokenResponse, error) {
+	d, _, err := s.discover()
+	if err != nil {
+		return oidcTokenResponse{}, err
+	}
+
+	if s.clientSecret == "" {
+		form.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcTokenResponse{}, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.clientSecret != "" {
+		// RFC 6749, section 2.3.1 requires the credentials to be form encoded
+		req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return oidcTokenResponse{}, fmt.Errorf("token request: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var tok oidcTokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tok); err != nil {
+		return oidcTokenResponse{}, fmt.Errorf("decode token response (status %v): %w", res.Status, err)
+	}
+	switch {
+	case tok.Error == "invalid_grant":
+		return oidcTokenResponse{}, fmt.Errorf("token request: %v: %w", tok.ErrorDescription, ErrUnauthorized)
+	case tok.Error != "":
+		return oidcTokenResponse{}, fmt.Errorf("token request: %v: %v", tok.Error, tok.ErrorDescription)
+	case res.StatusCode != http.StatusOK:
+		return oidcTokenResponse{}, fmt.Errorf("token request: unexpected status %v", res.Status)
+	case tok.IDToken == "":
+		return oidcTokenResponse{}, fmt.Errorf("token response contains no ID token")
+	}
+	return tok, nil
+}
+
+// loginResult verifies the ID token of a token response and maps it to a
+// user. If nonce or user are not empty, the token must match them.
+func (s *OIDCService) loginResult(tok oidcTokenResponse, nonce, user string) (string, LoginResult, error) {
+	_, verifier, err := s.discover()
+	if err != nil {
+		return "", LoginResult{}, err
+	}
+
+	claims, err := verifier.Verify(tok.IDToken)
+	if err != nil {
+		return "", LoginResult{}, fmt.Errorf("verify ID token: %w", err)
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return "", LoginResult{}, fmt.Errorf("nonce of ID token doesn't match: %w", ErrUnauthorized)
+	}
+
+	username, _ := claims.Raw[s.usernameClaim].(string)
+	if username == "" {
+		return "", LoginResult{}, fmt.Errorf("ID token has no claim %q: %w", s.usernameClaim, ErrUnauthorized)
+	}
+	// the session and all ACLs refer to the mapped name, so a login must
+	// not end up as another user
+	if user != "" && username != user {
+		return "", LoginResult{}, fmt.Errorf("ID token is for %q, not %q: %w", username, user, ErrUnauthorized)
+	}
+
+	return username, LoginResult{
+		Success:      true,
+		Token:        tok.IDToken,
+		RefreshToken: tok.RefreshToken,
+		ExpiresAt:    time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
@@ -0,0 +1,414 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+	appcfg "github.com/tsatke/verbose-broccoli/internal/app/config"
+	"golang.org/x/net/nettest"
+)
+
+// fakeOIDCProvider is a minimal OpenID Connect provider. Its authorization
+// endpoint logs in loginAs without asking.
+type fakeOIDCProvider struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	issuer   string
+	loginAs  string
+
+	mu       sync.Mutex
+	users    map[string]string
+	codes    map[string]fakeOIDCCode
+	refreshs map[string]string
+}
+
+type fakeOIDCCode struct {
+	user        string
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func newFakeOIDCProvider(clientID string) (*fakeOIDCProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &fakeOIDCProvider{
+		key:      key,
+		clientID: clientID,
+		users:    map[string]string{},
+		codes:    map[string]fakeOIDCCode{},
+		refreshs: map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(M{
+			"issuer":                 p.issuer,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(M{
+			"keys": []M{jwk("kid", &p.key.PublicKey)},
+		})
+	})
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+
+	p.srv = httptest.NewServer(mux)
+	p.issuer = p.srv.URL
+	return p, nil
+}
+
+func (p *fakeOIDCProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.clientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := uuid.New().String()
+	p.mu.Lock()
+	p.codes[code] = fakeOIDCCode{
+		user:        p.loginAs,
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	u, _ := url.Parse(q.Get("redirect_uri"))
+	rq := u.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	u.RawQuery = rq.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (p *fakeOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fail := func(e string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(M{"error": e, "error_description": e})
+	}
+
+	if r.PostFormValue("client_id") != p.clientID {
+		fail("invalid_client")
+		return
+	}
+
+	var user, nonce string
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		code, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		if !ok ||
+			code.redirectURI != r.PostFormValue("redirect_uri") ||
+			code.challenge != pkceChallenge(r.PostFormValue("code_verifier")) {
+			fail("invalid_grant")
+			return
+		}
+		user, nonce = code.user, code.nonce
+	case "password":
+		pass, ok := p.users[r.PostFormValue("username")]
+		if !ok || pass != r.PostFormValue("password") {
+			fail("invalid_grant")
+			return
+		}
+		user = r.PostFormValue("username")
+	case "refresh_token":
+		var ok bool
+		user, ok = p.refreshs[r.PostFormValue("refresh_token")]
+		if !ok {
+			fail("invalid_grant")
+			return
+		}
+	default:
+		fail("unsupported_grant_type")
+		return
+	}
+
+	refreshToken := uuid.New().String()
+	p.refreshs[refreshToken] = user
+	_ = json.NewEncoder(w).Encode(M{
+		"access_token":  uuid.New().String(),
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": refreshToken,
+		"id_token": signJWT(p.key, "kid", "RS256", M{
+			"iss":                p.issuer,
+			"aud":                p.clientID,
+			"sub":                user,
+			"preferred_username": user,
+			"email":              user + "@example.com",
+			"nonce":              nonce,
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Hour).Unix(),
+		}),
+	})
+}
+
+func TestOIDCServiceSuite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	suite.Run(t, new(OIDCServiceSuite))
+}
+
+type OIDCServiceSuite struct {
+	suite.Suite
+
+	provider *fakeOIDCProvider
+	service  *OIDCService
+}
+
+func (suite *OIDCServiceSuite) SetupTest() {
+	var err error
+	suite.provider, err = newFakeOIDCProvider("client-id")
+	suite.Require().NoError(err)
+	suite.provider.users["alice"] = "alicepass"
+	suite.provider.loginAs = "alice"
+
+	vp := viper.New()
+	vp.Set(appcfg.OIDCIssuer, suite.provider.issuer)
+	vp.Set(appcfg.OIDCClientID, "client-id")
+	vp.Set(appcfg.OIDCRedirectURL, "http://app.example/rest/auth/oidc/callback")
+	vp.Set(appcfg.OIDCScopes, []string{"openid", "profile"})
+	vp.Set(appcfg.OIDCUsernameClaim, "preferred_username")
+	suite.service = NewOIDCService(appcfg.Config{Viper: vp})
+}
+
+func (suite *OIDCServiceSuite) TearDownTest() {
+	suite.provider.srv.Close()
+}
+
+// authorize follows the redirect to the provider and returns the code.
+func (suite *OIDCServiceSuite) authorize(state, verifier, nonce string) string {
+	u, err := suite.service.AuthCodeURL(state, pkceChallenge(verifier), nonce)
+	suite.Require().NoError(err)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(u)
+	suite.Require().NoError(err)
+	suite.Require().NoError(res.Body.Close())
+	suite.Require().Equal(http.StatusFound, res.StatusCode)
+
+	loc, err := url.Parse(res.Header.Get("Location"))
+	suite.Require().NoError(err)
+	suite.Equal(state, loc.Query().Get("state"))
+	return loc.Query().Get("code")
+}
+
+func (suite *OIDCServiceSuite) TestAuthCodeURL() {
+	u, err := suite.service.AuthCodeURL("state", "challenge", "nonce")
+	suite.NoError(err)
+
+	parsed, err := url.Parse(u)
+	suite.Require().NoError(err)
+	suite.Equal(suite.provider.srv.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	suite.Equal(url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"client-id"},
+		"redirect_uri":          {"http://app.example/rest/auth/oidc/callback"},
+		"scope":                 {"openid profile"},
+		"state":                 {"state"},
+		"nonce":                 {"nonce"},
+		"code_challenge":        {"challenge"},
+		"code_challenge_method": {"S256"},
+	}, parsed.Query())
+}
+
+func (suite *OIDCServiceSuite) TestExchange() {
+	code := suite.authorize("state", "verifier", "nonce")
+
+	user, res, err := suite.service.Exchange(code, "verifier", "nonce")
+	suite.NoError(err)
+	suite.Equal("alice", user)
+	suite.True(res.Success)
+	suite.NotEmpty(res.RefreshToken)
+	suite.WithinDuration(time.Now().Add(time.Hour), res.ExpiresAt, time.Minute)
+	suite.True(suite.service.TokenValid(res.Token))
+}
+
+func (suite *OIDCServiceSuite) TestExchangeWrongVerifier() {
+	code := suite.authorize("state", "verifier", "nonce")
+
+	_, _, err := suite.service.Exchange(code, "otherverifier", "nonce")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *OIDCServiceSuite) TestExchangeWrongNonce() {
+	code := suite.authorize("state", "verifier", "nonce")
+
+	_, _, err := suite.service.Exchange(code, "verifier", "othernonce")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *OIDCServiceSuite) TestUsernameClaim() {
+	suite.service.usernameClaim = "email"
+	code := suite.authorize("state", "verifier", "nonce")
+
+	user, _, err := suite.service.Exchange(code, "verifier", "nonce")
+	suite.NoError(err)
+	suite.Equal("alice@example.com", user)
+
+	suite.service.usernameClaim = "missing"
+	code = suite.authorize("state", "verifier", "nonce")
+	_, _, err = suite.service.Exchange(code, "verifier", "nonce")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *OIDCServiceSuite) TestLogin() {
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.NoError(err)
+	suite.True(res.Success)
+	suite.True(suite.service.TokenValid(res.Token))
+
+	_, err = suite.service.Login("alice", "wrongpass")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *OIDCServiceSuite) TestRefresh() {
+	res, err := suite.service.Login("alice", "alicepass")
+	suite.Require().NoError(err)
+
+	refreshed, err := suite.service.Refresh("alice", res.RefreshToken)
+	suite.NoError(err)
+	suite.True(suite.service.TokenValid(refreshed.Token))
+	suite.NotEqual(res.RefreshToken, refreshed.RefreshToken)
+
+	// the token must not belong to another user
+	_, err = suite.service.Refresh("bob", refreshed.RefreshToken)
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	_, err = suite.service.Refresh("alice", "invalid")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *OIDCServiceSuite) TestTokenValid() {
+	suite.False(suite.service.TokenValid(""))
+	suite.False(suite.service.TokenValid(signJWT(suite.provider.key, "kid", "RS256", M{
+		"iss": suite.provider.issuer,
+		"aud": "other-client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})))
+}
+
+func (suite *OIDCServiceSuite) TestDiscoveryIssuerMismatch() {
+	suite.provider.issuer = "https://evil.example"
+
+	_, err := suite.service.AuthCodeURL("state", "challenge", "nonce")
+	suite.Error(err)
+}
+
+func (suite *OIDCServiceSuite) TestAnswerChallenge() {
+	_, err := suite.service.AnswerChallenge("alice", "challenge", "payload")
+	suite.ErrorIs(err, ErrValidation)
+}
+
+// startApp runs an App that uses the service and returns its base URL and a
+// client that doesn't follow redirects.
+func (suite *OIDCServiceSuite) startApp() (string, *http.Client) {
+	lis, err := nettest.NewLocalListener("tcp")
+	suite.Require().NoError(err)
+	base := "http://" + lis.Addr().String()
+	suite.service.redirectURL = base + "/rest/auth/oidc/callback"
+
+	a := New(lis, WithAuthService(suite.service))
+	go func() {
+		_ = a.Run()
+	}()
+	suite.T().Cleanup(func() {
+		_ = a.Close()
+	})
+
+	jar, _ := cookiejar.New(nil)
+	return base, &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (suite *OIDCServiceSuite) get(client *http.Client, u string, status int) *http.Response {
+	res, err := client.Get(u)
+	suite.Require().NoError(err)
+	suite.Require().NoError(res.Body.Close())
+	suite.Require().Equal(status, res.StatusCode, u)
+	return res
+}
+
+func (suite *OIDCServiceSuite) TestLoginFlow() {
+	base, client := suite.startApp()
+
+	res := suite.get(client, base+"/rest/auth/oidc/start?return_to=/index.html", http.StatusFound)
+	res = suite.get(client, res.Header.Get("Location"), http.StatusFound)
+	res = suite.get(client, res.Header.Get("Location"), http.StatusFound)
+	suite.Equal("/index.html", res.Header.Get("Location"))
+
+	res, err := client.Get(base + "/rest/user")
+	suite.Require().NoError(err)
+	data, err := io.ReadAll(res.Body)
+	suite.NoError(err)
+	suite.NoError(res.Body.Close())
+	suite.Equal(http.StatusOK, res.StatusCode)
+	suite.JSONEq(`{"username":"alice","quota":{"bytes":{"used":0},"documents":{"used":0}}}`, string(data))
+}
+
+func (suite *OIDCServiceSuite) TestCallbackInvalidState() {
+	base, client := suite.startApp()
+
+	res := suite.get(client, base+"/rest/auth/oidc/start", http.StatusFound)
+	res = suite.get(client, res.Header.Get("Location"), http.StatusFound)
+
+	loc, err := url.Parse(res.Header.Get("Location"))
+	suite.Require().NoError(err)
+	q := loc.Query()
+	q.Set("state", "forged")
+	loc.RawQuery = q.Encode()
+	suite.get(client, loc.String(), http.StatusBadRequest)
+
+	// the state can't be used again
+	q.Set("state", res.Request.URL.Query().Get("state"))
+	loc.RawQuery = q.Encode()
+	suite.get(client, loc.String(), http.StatusBadRequest)
+
+	suite.get(client, base+"/rest/user", http.StatusUnauthorized)
+}
+
+func (suite *OIDCServiceSuite) TestStartInvalidReturnTo() {
+	base, client := suite.startApp()
+
+	suite.get(client, base+"/rest/auth/oidc/start?return_to="+url.QueryEscape("//evil.example"), http.StatusBadRequest)
+}
+
+func (suite *OIDCServiceSuite) TestCallbackProviderError() {
+	base, client := suite.startApp()
+
+	suite.get(client, base+"/rest/auth/oidc/start", http.StatusFound)
+	suite.get(client, base+"/rest/auth/oidc/callback?error=access_denied", http.StatusUnauthorized)
+}
@@ -0,0 +1,374 @@
+package app
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var OpenAPIJSON []byte
+
+// openAPIPrefix is the server URL of the OpenAPI document, all paths in
+// the document are relative to it.
+const openAPIPrefix = "/rest"
+
+// openAPISpec is the part of an OpenAPI 3 document that is needed to
+// validate requests.
+type openAPISpec struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas    map[string]*jsonSchema       `json:"schemas"`
+		Parameters map[string]*openAPIParameter `json:"parameters"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *jsonSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Ref      string      `json:"$ref"`
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+// jsonSchema is the subset of JSON schema that is used in the OpenAPI
+// document.
+type jsonSchema struct {
+	Ref        string                 `json:"$ref"`
+	Type       string                 `json:"type"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Required   []string               `json:"required"`
+	Items      *jsonSchema            `json:"items"`
+	Enum       []interface{}          `json:"enum"`
+	MinLength  *int                   `json:"minLength"`
+	MaxLength  *int                   `json:"maxLength"`
+	Minimum    *float64               `json:"minimum"`
+	Maximum    *float64               `json:"maximum"`
+	MinItems   *int                   `json:"minItems"`
+	MaxItems   *int                   `json:"maxItems"`
+}
+
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func loadOpenAPISpec(data []byte) (*openAPISpec, error) {
+	var spec openAPISpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	return &spec, nil
+}
+
+// operation returns the operation for a gin route, e.g. "/rest/doc/:id",
+// or nil if the route is not documented.
+func (s *openAPISpec) operation(method, fullPath string) *openAPIOperation {
+	if !strings.HasPrefix(fullPath, openAPIPrefix+"/") {
+		return nil
+	}
+	return s.Paths[ginPathToOpenAPI(strings.TrimPrefix(fullPath, openAPIPrefix))][strings.ToLower(method)]
+}
+
+// ginPathToOpenAPI converts path parameters from ":id" to "{id}".
+func ginPathToOpenAPI(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *openAPISpec) resolve(schema *jsonSchema) *jsonSchema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+func (s *openAPISpec) resolveParameter(p *openAPIParameter) *openAPIParameter {
+	for p != nil && p.Ref != "" {
+		p = s.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+	return p
+}
+
+// maxJSONBody is the size up to which JSON bodies are accepted, since they
+// are read into memory to be validated.
+const maxJSONBody = 1 << 20
+
+// validateRequest checks the parameters and the body of the request
+// against the operation. A JSON body is read completely and replaced, so
+// that handlers can still read it. It fails with ErrTooLarge if the body is
+// larger than maxJSONBody.
+func (s *openAPISpec) validateRequest(op *openAPIOperation, c *gin.Context) ([]ValidationError, error) {
+	var errs []ValidationError
+
+	for _, p := range op.Parameters {
+		p = s.resolveParameter(p)
+		if p == nil {
+			continue
+		}
+
+		var value string
+		var ok bool
+		switch p.In {
+		case "path":
+			value = c.Param(p.Name)
+			ok = value != ""
+		case "query":
+			value, ok = c.GetQuery(p.Name)
+		case "header":
+			value = c.GetHeader(p.Name)
+			ok = value != ""
+		default:
+			continue
+		}
+
+		field := p.In + "." + p.Name
+		if !ok {
+			if p.Required {
+				errs = append(errs, ValidationError{field, "is required"})
+			}
+			continue
+		}
+		errs = append(errs, s.validate(p.Schema, s.parameterValue(p.Schema, value), field)...)
+	}
+
+	body := op.RequestBody
+	if body == nil {
+		return errs, nil
+	}
+
+	if c.Request.ContentLength == 0 {
+		if body.Required {
+			errs = append(errs, ValidationError{"body", "is required"})
+		}
+		return errs, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	if mediaType == "" {
+		// the handlers never required a content type for JSON bodies
+		mediaType = "application/json"
+	}
+	content, ok := body.Content[mediaType]
+	if !ok {
+		var allowed []string
+		for ct := range body.Content {
+			allowed = append(allowed, ct)
+		}
+		sort.Strings(allowed)
+		errs = append(errs, ValidationError{"body", "content type must be one of " + strings.Join(allowed, ", ")})
+		return errs, nil
+	}
+
+	if mediaType != "application/json" || content.Schema == nil {
+		return errs, nil
+	}
+
+	if c.Request.ContentLength > maxJSONBody {
+		return nil, fmt.Errorf("body of %d bytes: %w", c.Request.ContentLength, ErrTooLarge)
+	}
+	// the content length may be unknown
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxJSONBody+1))
+	if err != nil {
+		errs = append(errs, ValidationError{"body", "unable to read body"})
+		return errs, nil
+	}
+	if len(data) > maxJSONBody {
+		return nil, fmt.Errorf("body of more than %d bytes: %w", maxJSONBody, ErrTooLarge)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		errs = append(errs, ValidationError{"body", "invalid JSON"})
+		return errs, nil
+	}
+	return append(errs, s.validate(content.Schema, value, "body")...), nil
+}
+
+// parameterValue converts a raw parameter into the value that a JSON decoder
+// would produce for the type of the schema.
+func (s *openAPISpec) parameterValue(schema *jsonSchema, value string) interface{} {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return value
+	}
+
+	switch schema.Type {
+	case "integer", "number":
+		return json.Number(value)
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// validate validates a decoded JSON value (or a raw parameter string)
+// against the schema.
+func (s *openAPISpec) validate(schema *jsonSchema, value interface{}, field string) []ValidationError {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	invalid := func(format string, args ...interface{}) []ValidationError {
+		return []ValidationError{{field, fmt.Sprintf(format, args...)}}
+	}
+
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, e := range schema.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return invalid("must be one of %v", schema.Enum)
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("must be an object")
+		}
+
+		var errs []ValidationError
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, ValidationError{field + "." + name, "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := schema.Properties[name]; ok {
+				errs = append(errs, s.validate(prop, obj[name], field+"."+name)...)
+			}
+		}
+		return errs
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return invalid("must be an array")
+		}
+		if schema.MinItems != nil && len(arr) < *schema.MinItems {
+			return invalid("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(arr) > *schema.MaxItems {
+			return invalid("must have at most %d items", *schema.MaxItems)
+		}
+
+		var errs []ValidationError
+		for i, item := range arr {
+			errs = append(errs, s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return errs
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return invalid("must be a string")
+		}
+		if schema.MinLength != nil && len(str) < *schema.MinLength {
+			if *schema.MinLength == 1 {
+				return invalid("must not be empty")
+			}
+			return invalid("must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && len(str) > *schema.MaxLength {
+			return invalid("must be at most %d characters long", *schema.MaxLength)
+		}
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			return invalid("must be a %s", schema.Type)
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return invalid("must be a %s", schema.Type)
+		}
+		if schema.Type == "integer" {
+			if _, err := num.Int64(); err != nil {
+				return invalid("must be an integer")
+			}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return invalid("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			return invalid("must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid("must be a boolean")
+		}
+	}
+
+	return nil
+}
+
+// middlewareValidateRequest rejects all requests to documented routes that
+// don't match the OpenAPI document.
+func middlewareValidateRequest(spec *openAPISpec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := spec.operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			return
+		}
+
+		errs, err := spec.validateRequest(op, c)
+		if err != nil {
+			abortWithError(c, err, "request body too large")
+			return
+		}
+		if len(errs) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, Response{
+				Success: false,
+				Code:    CodeValidation,
+				Message: "request does not match schema",
+				Errors:  errs,
+			})
+			return
+		}
+	}
+}
+
+func (a *App) HandlerOpenAPI() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", OpenAPIJSON)
+	}
+}
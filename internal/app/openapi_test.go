@@ -0,0 +1,220 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func (suite *AppSuite) TestOpenAPIDocument() {
+	suite.
+		Get("/openapi.json").
+		ExpectCustom(func(res *http.Response) {
+			data, err := io.ReadAll(res.Body)
+			suite.NoError(err)
+			suite.NoError(res.Body.Close())
+
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.Equal("application/json", res.Header.Get("Content-Type"))
+			suite.JSONEq(string(OpenAPIJSON), string(data))
+		})
+}
+
+// TestOpenAPIRoutes checks that the OpenAPI document and the routes of the
+// router describe the same API.
+func (suite *AppSuite) TestOpenAPIRoutes() {
+	spec, err := loadOpenAPISpec(OpenAPIJSON)
+	suite.Require().NoError(err)
+
+	routes := map[string]bool{}
+	for _, route := range suite.app.router.Routes() {
+		// WebDAV methods can't be described in OpenAPI
+		if strings.HasPrefix(route.Path, "/rest/dav") {
+			continue
+		}
+
+		routes[route.Method+" "+route.Path] = true
+		suite.NotNilf(spec.operation(route.Method, route.Path), "route %s %s is not documented", route.Method, route.Path)
+	}
+
+	for p, ops := range spec.Paths {
+		for method := range ops {
+			ginPath := openAPIPrefix + p
+			ginPath = strings.NewReplacer("{", ":", "}", "").Replace(ginPath)
+			suite.Truef(routes[strings.ToUpper(method)+" "+ginPath], "documented operation %s %s has no route", method, p)
+		}
+	}
+}
+
+func (suite *AppSuite) TestOpenAPIReferences() {
+	spec, err := loadOpenAPISpec(OpenAPIJSON)
+	suite.Require().NoError(err)
+
+	var check func(schema *jsonSchema)
+	check = func(schema *jsonSchema) {
+		if schema == nil {
+			return
+		}
+		if schema.Ref != "" {
+			suite.NotNilf(spec.resolve(schema), "unresolvable reference %s", schema.Ref)
+		}
+		for _, prop := range schema.Properties {
+			check(prop)
+		}
+		check(schema.Items)
+	}
+	for _, schema := range spec.Components.Schemas {
+		check(schema)
+	}
+	for _, ops := range spec.Paths {
+		for _, op := range ops {
+			for _, p := range op.Parameters {
+				suite.NotNil(spec.resolveParameter(p))
+			}
+			if op.RequestBody != nil {
+				for _, content := range op.RequestBody.Content {
+					check(content.Schema)
+				}
+			}
+		}
+	}
+}
+
+func (suite *AppSuite) TestValidationMissingField() {
+	suite.login()
+
+	suite.
+		Post("/doc").
+		BodyJSON(M{
+			"name": "myfile",
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "request does not match schema",
+			"errors": []M{
+				{"field": "body.filename", "message": "is required"},
+			},
+		})
+}
+
+func (suite *AppSuite) TestValidationWrongTypes() {
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": 1234,
+			"password": "",
+		}).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "request does not match schema",
+			"errors": []M{
+				{"field": "body.password", "message": "must not be empty"},
+				{"field": "body.username", "message": "must be a string"},
+			},
+		})
+}
+
+func (suite *AppSuite) TestValidationInvalidJSON() {
+	suite.
+		Post("/auth/login").
+		Body([]byte("{")).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "request does not match schema",
+			"errors": []M{
+				{"field": "body", "message": "invalid JSON"},
+			},
+		})
+}
+
+func (suite *AppSuite) TestValidationBodyTooLarge() {
+	body := []byte(`{"username": "` + strings.Repeat("a", maxJSONBody) + `", "password": "pass"}`)
+
+	suite.
+		Post("/auth/login").
+		Body(body).
+		ExpectJSON(http.StatusRequestEntityTooLarge, M{
+			"success": false,
+			"code":    "too_large",
+			"message": "request body too large",
+		})
+	// the same without a content length
+	suite.
+		Post("/auth/login").
+		BodyReader(io.MultiReader(bytes.NewReader(body))).
+		ExpectJSON(http.StatusRequestEntityTooLarge, M{
+			"success": false,
+			"code":    "too_large",
+			"message": "request body too large",
+		})
+}
+
+func (suite *AppSuite) TestValidationContentType() {
+	suite.login()
+
+	suite.
+		Post("/doc/abc/content").
+		Header("Content-Type", "text/plain").
+		Body([]byte("hello")).
+		ExpectJSON(http.StatusBadRequest, M{
+			"success": false,
+			"code":    "validation",
+			"message": "request does not match schema",
+			"errors": []M{
+				{"field": "body", "message": "content type must be one of multipart/form-data"},
+			},
+		})
+}
+
+func (suite *AppSuite) TestValidateSchema() {
+	spec, err := loadOpenAPISpec([]byte(`{
+  "components": {
+    "schemas": {
+      "Item": {"type": "integer", "minimum": 1, "maximum": 10},
+      "List": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}},
+      "Kind": {"type": "string", "enum": ["a", "b"]},
+      "Pair": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}}
+    }
+  }
+}`))
+	suite.Require().NoError(err)
+
+	decode := func(s string) interface{} {
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var v interface{}
+		suite.Require().NoError(dec.Decode(&v))
+		return v
+	}
+
+	list := &jsonSchema{Ref: "#/components/schemas/List"}
+	suite.Empty(spec.validate(list, decode(`[1, 5, 10]`), "body"))
+	suite.Equal([]ValidationError{
+		{"body[0]", "must be at least 1"},
+		{"body[1]", "must be an integer"},
+		{"body[2]", "must be at most 10"},
+	}, spec.validate(list, decode(`[0, 1.5, 11]`), "body"))
+	suite.Equal([]ValidationError{
+		{"body", "must be an array"},
+	}, spec.validate(list, decode(`{}`), "body"))
+
+	pair := &jsonSchema{Ref: "#/components/schemas/Pair"}
+	suite.Empty(spec.validate(pair, decode(`["a", "b"]`), "body"))
+	suite.Equal([]ValidationError{
+		{"body", "must have at least 1 items"},
+	}, spec.validate(pair, decode(`[]`), "body"))
+	suite.Equal([]ValidationError{
+		{"body", "must have at most 2 items"},
+	}, spec.validate(pair, decode(`["a", "b", "c"]`), "body"))
+
+	kind := &jsonSchema{Ref: "#/components/schemas/Kind"}
+	suite.Empty(spec.validate(kind, "a", "query.kind"))
+	suite.Equal([]ValidationError{
+		{"query.kind", "must be one of [a b]"},
+	}, spec.validate(kind, "c", "query.kind"))
+}
@@ -1,10 +1,14 @@
 package app
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
 )
 
 type Option func(*App)
@@ -39,6 +43,89 @@ func WithAuthService(s AuthService) Option {
 	}
 }
 
+func WithAPITokenRepo(r APITokenRepo) Option {
+	return func(a *App) {
+		a.apiTokens = r
+	}
+}
+
+func WithShareLinkRepo(r ShareLinkRepo) Option {
+	return func(a *App) {
+		a.shareLinks = r
+	}
+}
+
+func WithTransferTokenRepo(r TransferTokenRepo) Option {
+	return func(a *App) {
+		a.transferTokens = r
+	}
+}
+
+func WithQuotaRepo(r QuotaRepo) Option {
+	return func(a *App) {
+		a.quotas = r
+	}
+}
+
+// WithDefaultQuota sets the limits for users without a quota of their own or
+// of one of their groups, 0 is unlimited.
+func WithDefaultQuota(maxBytes, maxDocuments int64) Option {
+	return func(a *App) {
+		a.defaultQuota = Quota{MaxBytes: maxBytes, MaxDocuments: maxDocuments}
+	}
+}
+
+func WithJobQueue(q JobQueue) Option {
+	return func(a *App) {
+		a.jobs = q
+	}
+}
+
+// WithJobWorkers sets how many jobs this instance runs at the same time.
+func WithJobWorkers(n int) Option {
+	return func(a *App) {
+		a.jobWorkers = n
+	}
+}
+
+// WithJob registers the function that runs the jobs of a type, which are
+// attempted at most maxAttempts times.
+func WithJob(typ string, maxAttempts int, fn JobFunc) Option {
+	return func(a *App) {
+		a.jobTypes[typ] = jobType{fn: fn, maxAttempts: maxAttempts}
+	}
+}
+
+// WithJobSchedule enqueues a job of the type without payload at the
+// interval, while the job workers run.
+func WithJobSchedule(typ string, interval time.Duration) Option {
+	return func(a *App) {
+		a.jobSchedules = append(a.jobSchedules, jobSchedule{typ: typ, interval: interval})
+	}
+}
+
+// WithDrainDelay sets how long Shutdown reports the app as unhealthy before
+// it stops accepting requests.
+func WithDrainDelay(d time.Duration) Option {
+	return func(a *App) {
+		a.drainDelay = d
+	}
+}
+
+func WithSessionStore(s sessions.Store) Option {
+	return func(a *App) {
+		a.sessionStore = s
+	}
+}
+
+// WithSessionOptions sets the options of the session cookie, see
+// NewSessionOptions. The default is DefaultSessionOptions.
+func WithSessionOptions(opts sessions.Options) Option {
+	return func(a *App) {
+		a.sessionOptions = opts
+	}
+}
+
 func WithHTTPServer(s *http.Server) Option {
 	return func(a *App) {
 		a.srv = s
@@ -56,3 +143,37 @@ func WithClock(c Clock) Option {
 		a.clock = c
 	}
 }
+
+// WithTransferURLs enables the transfer URLs of HandlerGetContentURL, which
+// are valid for the given duration. If the object storage isn't a
+// URLPresigner, the app serves the URLs itself and signs them with the
+// secret.
+func WithTransferURLs(ttl time.Duration, secret []byte) Option {
+	return func(a *App) {
+		a.transferTTL = ttl
+		a.transferSecret = secret
+	}
+}
+
+// NewTransferURLOption returns the WithTransferURLs option for the config.
+func NewTransferURLOption(cfg config.Config) (Option, error) {
+	if !cfg.GetBool(config.TransferURLs) {
+		return WithTransferURLs(0, nil), nil
+	}
+	ttl := cfg.GetDuration(config.TransferURLTTL)
+	if ttl <= 0 {
+		return nil, fmt.Errorf("%v must be positive", config.TransferURLTTL)
+	}
+	secret, err := tokenSecret(cfg.GetString(config.TransferSecret))
+	if err != nil {
+		return nil, err
+	}
+	return WithTransferURLs(ttl, secret), nil
+}
+
+// WithAdminGroup sets the group whose members may use the admin API.
+func WithAdminGroup(group string) Option {
+	return func(a *App) {
+		a.adminGroup = group
+	}
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var _ APITokenRepo = (*PostgresAPITokenRepo)(nil)
+
+type PostgresAPITokenRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresAPITokenRepo(p *PostgresDatabaseProvider) *PostgresAPITokenRepo {
+	return &PostgresAPITokenRepo{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresAPITokenRepo) Create(t APIToken) error {
+	_, err := r.db.Exec(`INSERT INTO au_api_tokens (token_id, name, owner, hash, scopes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		t.ID, t.Name, t.Owner, t.Hash, pq.Array(t.Scopes), t.Created, t.Expires)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("insert token: %v: %w", err, ErrConflict)
+	} else if err != nil {
+		return fmt.Errorf("insert token: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresAPITokenRepo) Get(hash string) (APIToken, error) {
+	row := r.db.QueryRow(`SELECT token_id, name, owner, hash, scopes, created, expires FROM au_api_tokens WHERE hash = $1`, hash)
+	t, err := scanAPIToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, fmt.Errorf("token: %w", ErrNotFound)
+	}
+	return t, err
+}
+
+func (r *PostgresAPITokenRepo) List(owner string) ([]APIToken, error) {
+	rows, err := r.db.Query(`SELECT token_id, name, owner, hash, scopes, created, expires FROM au_api_tokens WHERE owner = $1 ORDER BY created, token_id`, owner)
+	if err != nil {
+		return nil, fmt.Errorf("list tokens: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return tokens, nil
+}
+
+func (r *PostgresAPITokenRepo) Delete(owner, id string) error {
+	res, err := r.db.Exec(`DELETE FROM au_api_tokens WHERE token_id = $1 AND owner = $2`, id, owner)
+	if err != nil {
+		return fmt.Errorf("delete token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("token %v: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresAPITokenRepo) DeleteAll(owner string) error {
+	if _, err := r.db.Exec(`DELETE FROM au_api_tokens WHERE owner = $1`, owner); err != nil {
+		return fmt.Errorf("delete tokens: %w", err)
+	}
+	return nil
+}
+
+func scanAPIToken(row scanner) (APIToken, error) {
+	var t APIToken
+	if err := row.Scan(&t.ID, &t.Name, &t.Owner, &t.Hash, pq.Array(&t.Scopes), &t.Created, &t.Expires); err != nil {
+		return APIToken{}, fmt.Errorf("scan: %w", err)
+	}
+	return t, nil
+}
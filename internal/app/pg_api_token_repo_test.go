@@ -0,0 +1,144 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresAPITokenRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresAPITokenRepoTestSuite))
+}
+
+type PostgresAPITokenRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresAPITokenRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresAPITokenRepo{suite.db}
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestCreate() {
+	created := time.Now()
+	expires := created.Add(time.Hour)
+
+	suite.mock.
+		ExpectExec(`INSERT INTO au_api_tokens (token_id, name, owner, hash, scopes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
+		WithArgs("tokenID", "backup", "username", "hash", `{"read","write"}`, created, expires).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Create(APIToken{
+		ID:      "tokenID",
+		Name:    "backup",
+		Owner:   "username",
+		Hash:    "hash",
+		Scopes:  []string{"read", "write"},
+		Created: created,
+		Expires: expires,
+	}))
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestCreateAlreadyExists() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_api_tokens (token_id, name, owner, hash, scopes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	err := suite.repo.Create(APIToken{ID: "tokenID"})
+	suite.ErrorIs(err, ErrConflict)
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestGet() {
+	created := time.Now()
+	expires := created.Add(time.Hour)
+
+	suite.mock.
+		ExpectQuery(`SELECT token_id, name, owner, hash, scopes, created, expires FROM au_api_tokens WHERE hash = $1`).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"token_id", "name", "owner", "hash", "scopes", "created", "expires"}).
+			AddRow("tokenID", "backup", "username", "hash", `{read,share}`, created, expires))
+
+	t, err := suite.repo.Get("hash")
+	suite.NoError(err)
+	suite.Equal(APIToken{
+		ID:      "tokenID",
+		Name:    "backup",
+		Owner:   "username",
+		Hash:    "hash",
+		Scopes:  []string{"read", "share"},
+		Created: created,
+		Expires: expires,
+	}, t)
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestGetNotExists() {
+	suite.mock.
+		ExpectQuery(`SELECT token_id, name, owner, hash, scopes, created, expires FROM au_api_tokens WHERE hash = $1`).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"token_id", "name", "owner", "hash", "scopes", "created", "expires"}))
+
+	_, err := suite.repo.Get("hash")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestList() {
+	created := time.Now()
+	expires := created.Add(time.Hour)
+
+	suite.mock.
+		ExpectQuery(`SELECT token_id, name, owner, hash, scopes, created, expires FROM au_api_tokens WHERE owner = $1 ORDER BY created, token_id`).
+		WithArgs("username").
+		WillReturnRows(sqlmock.NewRows([]string{"token_id", "name", "owner", "hash", "scopes", "created", "expires"}).
+			AddRow("token1", "backup", "username", "hash1", `{read}`, created, expires).
+			AddRow("token2", "sync", "username", "hash2", `{read,write}`, created, expires))
+
+	tokens, err := suite.repo.List("username")
+	suite.NoError(err)
+	suite.Len(tokens, 2)
+	suite.Equal("token1", tokens[0].ID)
+	suite.Equal([]string{"read", "write"}, tokens[1].Scopes)
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestDelete() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_api_tokens WHERE token_id = $1 AND owner = $2`).
+		WithArgs("tokenID", "username").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Delete("username", "tokenID"))
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestDeleteNotExists() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_api_tokens WHERE token_id = $1 AND owner = $2`).
+		WithArgs("tokenID", "username").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := suite.repo.Delete("username", "tokenID")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresAPITokenRepoTestSuite) TestDeleteAll() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_api_tokens WHERE owner = $1`).
+		WithArgs("username").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	suite.NoError(suite.repo.DeleteAll("username"))
+}
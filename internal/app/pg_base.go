@@ -60,6 +60,11 @@ func NewPostgresDatabaseProvider(log zerolog.Logger, cfg config.Config, ssl bool
 	return p, nil
 }
 
+// Close closes the connection pool of the database.
+func (i *PostgresDatabaseProvider) Close() error {
+	return i.DB.Close()
+}
+
 func (i *PostgresDatabaseProvider) tx(fn func(tx *sql.Tx) error) error {
 	return tx(i.DB, fn)
 }
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ BlobRepo = (*PostgresBlobRepo)(nil)
+
+type PostgresBlobRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresBlobRepo(p *PostgresDatabaseProvider) *PostgresBlobRepo {
+	return &PostgresBlobRepo{
+		db: p.DB,
+	}
+}
+
+// Acquire waits for a Collect that holds the lock of the blob, and inserts
+// the blob again if Collect deleted it.
+func (r *PostgresBlobRepo) Acquire(hash string) (bool, error) {
+	var stored bool
+	err := r.db.QueryRow(`INSERT INTO au_blobs (hash, refs) VALUES ($1, 1) ON CONFLICT (hash) DO UPDATE SET refs = au_blobs.refs + 1 RETURNING stored`, hash).Scan(&stored)
+	if err != nil {
+		return false, fmt.Errorf("acquire blob: %w", err)
+	}
+	return stored, nil
+}
+
+func (r *PostgresBlobRepo) Claim(hash string, now, until time.Time) (bool, bool, error) {
+	var stored bool
+	err := r.db.QueryRow(`UPDATE au_blobs SET claimed_until = $3 WHERE hash = $1 AND NOT stored AND (claimed_until IS NULL OR claimed_until <= $2) RETURNING stored`,
+		hash, now, until).Scan(&stored)
+	if err == nil {
+		return true, stored, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, false, fmt.Errorf("claim blob: %w", err)
+	}
+
+	// either stored or claimed by another upload
+	err = r.db.QueryRow(`SELECT stored FROM au_blobs WHERE hash = $1`, hash).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	} else if err != nil {
+		return false, false, fmt.Errorf("get blob: %w", err)
+	}
+	return false, stored, nil
+}
+
+func (r *PostgresBlobRepo) Unclaim(hash string) error {
+	if _, err := r.db.Exec(`UPDATE au_blobs SET claimed_until = NULL WHERE hash = $1`, hash); err != nil {
+		return fmt.Errorf("update blob: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresBlobRepo) Stored(hash string) error {
+	res, err := r.db.Exec(`UPDATE au_blobs SET stored = true, claimed_until = NULL WHERE hash = $1`, hash)
+	if err != nil {
+		return fmt.Errorf("update blob: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresBlobRepo) Release(hash string) error {
+	return tx(r.db, func(tx *sql.Tx) error {
+		return releaseBlob(tx, hash)
+	})
+}
+
+func releaseBlob(tx *sql.Tx, hash string) error {
+	res, err := tx.Exec(`UPDATE au_blobs SET refs = refs - 1 WHERE hash = $1`, hash)
+	if err != nil {
+		return fmt.Errorf("release blob: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("blob %v: %w", hash, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresBlobRepo) Link(id DocID, hash string) error {
+	return tx(r.db, func(tx *sql.Tx) error {
+		var old string
+		err := tx.QueryRow(`SELECT hash FROM au_document_blobs WHERE doc_id = $1 FOR UPDATE`, id).Scan(&old)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("get blob of document: %w", err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO au_document_blobs (doc_id, hash) VALUES ($1, $2) ON CONFLICT (doc_id) DO UPDATE SET hash = EXCLUDED.hash`, id, hash)
+		if err != nil {
+			return fmt.Errorf("link blob: %w", err)
+		}
+		if old == "" {
+			return nil
+		}
+		return releaseBlob(tx, old)
+	})
+}
+
+func (r *PostgresBlobRepo) Unlink(id DocID) error {
+	return tx(r.db, func(tx *sql.Tx) error {
+		var hash string
+		err := tx.QueryRow(`DELETE FROM au_document_blobs WHERE doc_id = $1 RETURNING hash`, id).Scan(&hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("blob of %v: %w", id, ErrNotFound)
+		} else if err != nil {
+			return fmt.Errorf("unlink blob: %w", err)
+		}
+		return releaseBlob(tx, hash)
+	})
+}
+
+func (r *PostgresBlobRepo) Blob(id DocID) (string, error) {
+	var hash string
+	err := r.db.QueryRow(`SELECT hash FROM au_document_blobs WHERE doc_id = $1`, id).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("blob of %v: %w", id, ErrNotFound)
+	} else if err != nil {
+		return "", fmt.Errorf("get blob of document: %w", err)
+	}
+	return hash, nil
+}
+
+// Collect deletes one blob per transaction, which holds the lock of the row
+// of the blob while del runs. Blobs that another Collect is deleting are
+// skipped. The blobs are visited in the order of their hashes, so that a
+// blob that del fails for isn't tried again in the same run.
+func (r *PostgresBlobRepo) Collect(del func(hash string) error) (int, error) {
+	n, failed := 0, 0
+	var firstErr error
+	last := ""
+	for {
+		var hash string
+		var delErr error
+		err := tx(r.db, func(tx *sql.Tx) error {
+			err := tx.QueryRow(`SELECT hash FROM au_blobs WHERE refs <= 0 AND hash > $1 ORDER BY hash LIMIT 1 FOR UPDATE SKIP LOCKED`, last).Scan(&hash)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("find unreferenced blob: %w", err)
+			}
+
+			if delErr = del(hash); delErr != nil {
+				return nil
+			}
+			if _, err := tx.Exec(`DELETE FROM au_blobs WHERE hash = $1`, hash); err != nil {
+				return fmt.Errorf("delete blob: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		if hash == "" {
+			break
+		}
+		last = hash
+
+		if delErr != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("blob %v: %w", hash, delErr)
+			}
+			continue
+		}
+		n++
+	}
+	if firstErr != nil {
+		return n, fmt.Errorf("%d blobs not deleted: %w", failed, firstErr)
+	}
+	return n, nil
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresBlobRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresBlobRepoTestSuite))
+}
+
+type PostgresBlobRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresBlobRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresBlobRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresBlobRepo{suite.db}
+}
+
+func (suite *PostgresBlobRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestAcquire() {
+	suite.mock.
+		ExpectQuery(`INSERT INTO au_blobs (hash, refs) VALUES ($1, 1) ON CONFLICT (hash) DO UPDATE SET refs = au_blobs.refs + 1 RETURNING stored`).
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"stored"}).AddRow(true))
+
+	stored, err := suite.repo.Acquire("abc")
+	suite.NoError(err)
+	suite.True(stored)
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestClaim() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectQuery(`UPDATE au_blobs SET claimed_until = $3 WHERE hash = $1 AND NOT stored AND (claimed_until IS NULL OR claimed_until <= $2) RETURNING stored`).
+		WithArgs("abc", now, now.Add(blobClaim)).
+		WillReturnRows(sqlmock.NewRows([]string{"stored"}).AddRow(false))
+
+	claimed, stored, err := suite.repo.Claim("abc", now, now.Add(blobClaim))
+	suite.NoError(err)
+	suite.True(claimed)
+	suite.False(stored)
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestClaimedByOther() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectQuery(`UPDATE au_blobs SET claimed_until = $3 WHERE hash = $1 AND NOT stored AND (claimed_until IS NULL OR claimed_until <= $2) RETURNING stored`).
+		WithArgs("abc", now, now.Add(blobClaim)).
+		WillReturnRows(sqlmock.NewRows([]string{"stored"}))
+	suite.mock.
+		ExpectQuery(`SELECT stored FROM au_blobs WHERE hash = $1`).
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"stored"}).AddRow(true))
+
+	claimed, stored, err := suite.repo.Claim("abc", now, now.Add(blobClaim))
+	suite.NoError(err)
+	suite.False(claimed)
+	suite.True(stored)
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestStoredNotExists() {
+	suite.mock.
+		ExpectExec(`UPDATE au_blobs SET stored = true, claimed_until = NULL WHERE hash = $1`).
+		WithArgs("abc").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	suite.ErrorIs(suite.repo.Stored("abc"), ErrNotFound)
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestLinkReplaces() {
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_document_blobs WHERE doc_id = $1 FOR UPDATE`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("old"))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_document_blobs (doc_id, hash) VALUES ($1, $2) ON CONFLICT (doc_id) DO UPDATE SET hash = EXCLUDED.hash`).
+		WithArgs("docID", "new").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`UPDATE au_blobs SET refs = refs - 1 WHERE hash = $1`).
+		WithArgs("old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	suite.NoError(suite.repo.Link("docID", "new"))
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestLinkNew() {
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_document_blobs WHERE doc_id = $1 FOR UPDATE`).
+		WithArgs("docID").
+		WillReturnError(sql.ErrNoRows)
+	suite.mock.
+		ExpectExec(`INSERT INTO au_document_blobs (doc_id, hash) VALUES ($1, $2) ON CONFLICT (doc_id) DO UPDATE SET hash = EXCLUDED.hash`).
+		WithArgs("docID", "new").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	suite.NoError(suite.repo.Link("docID", "new"))
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestUnlink() {
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`DELETE FROM au_document_blobs WHERE doc_id = $1 RETURNING hash`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("abc"))
+	suite.mock.
+		ExpectExec(`UPDATE au_blobs SET refs = refs - 1 WHERE hash = $1`).
+		WithArgs("abc").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	suite.NoError(suite.repo.Unlink("docID"))
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestUnlinkNotExists() {
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`DELETE FROM au_document_blobs WHERE doc_id = $1 RETURNING hash`).
+		WithArgs("docID").
+		WillReturnError(sql.ErrNoRows)
+	suite.mock.ExpectRollback()
+
+	suite.ErrorIs(suite.repo.Unlink("docID"), ErrNotFound)
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestCollect() {
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_blobs WHERE refs <= 0 AND hash > $1 ORDER BY hash LIMIT 1 FOR UPDATE SKIP LOCKED`).
+		WithArgs("").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("abc"))
+	suite.mock.
+		ExpectExec(`DELETE FROM au_blobs WHERE hash = $1`).
+		WithArgs("abc").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_blobs WHERE refs <= 0 AND hash > $1 ORDER BY hash LIMIT 1 FOR UPDATE SKIP LOCKED`).
+		WithArgs("abc").
+		WillReturnError(sql.ErrNoRows)
+	suite.mock.ExpectCommit()
+
+	var deleted []string
+	n, err := suite.repo.Collect(func(hash string) error {
+		deleted = append(deleted, hash)
+		return nil
+	})
+	suite.NoError(err)
+	suite.Equal(1, n)
+	suite.Equal([]string{"abc"}, deleted)
+}
+
+func (suite *PostgresBlobRepoTestSuite) TestCollectSkipsFailed() {
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_blobs WHERE refs <= 0 AND hash > $1 ORDER BY hash LIMIT 1 FOR UPDATE SKIP LOCKED`).
+		WithArgs("").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("abc"))
+	suite.mock.ExpectCommit()
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_blobs WHERE refs <= 0 AND hash > $1 ORDER BY hash LIMIT 1 FOR UPDATE SKIP LOCKED`).
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("def"))
+	suite.mock.
+		ExpectExec(`DELETE FROM au_blobs WHERE hash = $1`).
+		WithArgs("def").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT hash FROM au_blobs WHERE refs <= 0 AND hash > $1 ORDER BY hash LIMIT 1 FOR UPDATE SKIP LOCKED`).
+		WithArgs("def").
+		WillReturnError(sql.ErrNoRows)
+	suite.mock.ExpectCommit()
+
+	broken := errors.New("broken")
+	n, err := suite.repo.Collect(func(hash string) error {
+		if hash == "abc" {
+			return broken
+		}
+		return nil
+	})
+	suite.ErrorIs(err, broken)
+	suite.Equal(1, n)
+}
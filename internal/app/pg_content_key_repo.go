@@ -0,0 +1,81 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var _ ContentKeyRepo = (*PostgresContentKeyRepo)(nil)
+
+type PostgresContentKeyRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresContentKeyRepo(p *PostgresDatabaseProvider) *PostgresContentKeyRepo {
+	return &PostgresContentKeyRepo{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresContentKeyRepo) Create(id DocID, key WrappedKey) error {
+	res, err := r.db.Exec(`INSERT INTO au_content_keys (doc_id, master_key_id, wrapped_key) VALUES ($1, $2, $3) ON CONFLICT (doc_id) DO NOTHING`,
+		id, key.MasterKeyID, key.Key)
+	if err != nil {
+		return fmt.Errorf("insert content key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("content key of %v: %w", id, ErrConflict)
+	}
+	return nil
+}
+
+func (r *PostgresContentKeyRepo) Set(id DocID, key WrappedKey) error {
+	_, err := r.db.Exec(`INSERT INTO au_content_keys (doc_id, master_key_id, wrapped_key) VALUES ($1, $2, $3) ON CONFLICT (doc_id) DO UPDATE SET master_key_id = EXCLUDED.master_key_id, wrapped_key = EXCLUDED.wrapped_key`,
+		id, key.MasterKeyID, key.Key)
+	if err != nil {
+		return fmt.Errorf("upsert content key: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresContentKeyRepo) Get(id DocID) (WrappedKey, error) {
+	var key WrappedKey
+	err := r.db.QueryRow(`SELECT master_key_id, wrapped_key FROM au_content_keys WHERE doc_id = $1`, id).Scan(&key.MasterKeyID, &key.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WrappedKey{}, fmt.Errorf("content key of %v: %w", id, ErrNotFound)
+	} else if err != nil {
+		return WrappedKey{}, fmt.Errorf("get content key: %w", err)
+	}
+	return key, nil
+}
+
+func (r *PostgresContentKeyRepo) Delete(id DocID) error {
+	if _, err := r.db.Exec(`DELETE FROM au_content_keys WHERE doc_id = $1`, id); err != nil {
+		return fmt.Errorf("delete content key: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresContentKeyRepo) Stale(masterKeyID string) ([]DocID, error) {
+	rows, err := r.db.Query(`SELECT doc_id FROM au_content_keys WHERE master_key_id <> $1 ORDER BY doc_id`, masterKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("list content keys: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var ids []DocID
+	for rows.Next() {
+		var id DocID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresContentKeyRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresContentKeyRepoTestSuite))
+}
+
+type PostgresContentKeyRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresContentKeyRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresContentKeyRepo{suite.db}
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TestSet() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_content_keys (doc_id, master_key_id, wrapped_key) VALUES ($1, $2, $3) ON CONFLICT (doc_id) DO UPDATE SET master_key_id = EXCLUDED.master_key_id, wrapped_key = EXCLUDED.wrapped_key`).
+		WithArgs("docID", "k1", []byte("wrapped")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Set("docID", WrappedKey{MasterKeyID: "k1", Key: []byte("wrapped")}))
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TestCreateConflict() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_content_keys (doc_id, master_key_id, wrapped_key) VALUES ($1, $2, $3) ON CONFLICT (doc_id) DO NOTHING`).
+		WithArgs("docID", "k1", []byte("wrapped")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := suite.repo.Create("docID", WrappedKey{MasterKeyID: "k1", Key: []byte("wrapped")})
+	suite.ErrorIs(err, ErrConflict)
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TestGet() {
+	suite.mock.
+		ExpectQuery(`SELECT master_key_id, wrapped_key FROM au_content_keys WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"master_key_id", "wrapped_key"}).
+			AddRow("k1", []byte("wrapped")))
+
+	key, err := suite.repo.Get("docID")
+	suite.NoError(err)
+	suite.Equal(WrappedKey{MasterKeyID: "k1", Key: []byte("wrapped")}, key)
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TestGetNotExists() {
+	suite.mock.
+		ExpectQuery(`SELECT master_key_id, wrapped_key FROM au_content_keys WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := suite.repo.Get("docID")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TestDelete() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_content_keys WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Delete("docID"))
+}
+
+func (suite *PostgresContentKeyRepoTestSuite) TestStale() {
+	suite.mock.
+		ExpectQuery(`SELECT doc_id FROM au_content_keys WHERE master_key_id <> $1 ORDER BY doc_id`).
+		WithArgs("k2").
+		WillReturnRows(sqlmock.NewRows([]string{"doc_id"}).
+			AddRow("a").
+			AddRow("b"))
+
+	ids, err := suite.repo.Stale("k2")
+	suite.NoError(err)
+	suite.Equal([]DocID{"a", "b"}, ids)
+}
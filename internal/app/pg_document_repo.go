@@ -3,8 +3,11 @@ package app
 import (
 	"database/sql"
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 var _ DocumentRepo = (*PostgresDocumentRepo)(nil)
@@ -21,7 +24,7 @@ func NewPostgresDocumentRepo(p *PostgresDatabaseProvider) *PostgresDocumentRepo
 
 func (i *PostgresDocumentRepo) Create(header DocumentHeader, acl ACL) error {
 	return tx(i.db, func(tx *sql.Tx) error {
-		docHeaderInsert, err := tx.Prepare(`INSERT INTO au_document_headers (doc_id, name, owner, created) VALUES ($1, $2, $3, $4)`)
+		docHeaderInsert, err := tx.Prepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`)
 		if err != nil {
 			return fmt.Errorf("prepare header insert: %w", err)
 		}
@@ -29,12 +32,14 @@ func (i *PostgresDocumentRepo) Create(header DocumentHeader, acl ACL) error {
 			_ = docHeaderInsert.Close()
 		}()
 
-		_, err = docHeaderInsert.Exec(header.ID, header.Name, header.Owner, header.Created)
-		if err != nil {
+		_, err = docHeaderInsert.Exec(header.ID, header.Name, header.Owner, header.Size, header.Created, nullableTime{header.Updated, !header.Updated.IsZero()})
+		if isUniqueViolation(err) {
+			return fmt.Errorf("insert header: %v: %w", err, ErrConflict)
+		} else if err != nil {
 			return fmt.Errorf("insert header: %w", err)
 		}
 
-		docACLInsert, err := tx.Prepare(`INSERT INTO au_document_acls (doc_id, username, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6)`)
+		docACLInsert, err := tx.Prepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`)
 		if err != nil {
 			return fmt.Errorf("prepare acl insert: %w", err)
 		}
@@ -43,19 +48,30 @@ func (i *PostgresDocumentRepo) Create(header DocumentHeader, acl ACL) error {
 		}()
 
 		for _, perm := range acl.Permissions {
-			_, err = docACLInsert.Exec(header.ID, perm.Username, perm.Read, perm.Write, perm.Delete, perm.Share)
+			_, err = docACLInsert.Exec(header.ID, perm.Principal.Type, perm.Principal.Name, perm.Read, perm.Write, perm.Delete, perm.Share)
 			if err != nil {
 				return fmt.Errorf("insert ACL: %w", err)
 			}
 		}
 
-		return nil
+		return addUsage(tx, header.Owner, header.Size, 1)
 	})
 }
 
 func (i *PostgresDocumentRepo) Update(header DocumentHeader, acl ACL) error {
 	return tx(i.db, func(tx *sql.Tx) error {
-		docHeaderUpdate, err := tx.Prepare(`UPDATE au_document_headers SET (name, owner, created, updated) = ($1, $2, $3, $4) WHERE doc_id = $5`)
+		// the row is locked, so that concurrent updates can't count the
+		// same change of the size twice
+		var oldOwner string
+		var oldSize int64
+		err := tx.QueryRow(`SELECT owner, size FROM au_document_headers WHERE doc_id = $1 FOR UPDATE`, header.ID).Scan(&oldOwner, &oldSize)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("document %v: %w", header.ID, ErrNotFound)
+		} else if err != nil {
+			return fmt.Errorf("lock header: %w", err)
+		}
+
+		docHeaderUpdate, err := tx.Prepare(`UPDATE au_document_headers SET (name, owner, size, created, updated) = ($1, $2, $3, $4, $5) WHERE doc_id = $6`)
 		if err != nil {
 			return fmt.Errorf("prepare header insert: %w", err)
 		}
@@ -63,12 +79,20 @@ func (i *PostgresDocumentRepo) Update(header DocumentHeader, acl ACL) error {
 			_ = docHeaderUpdate.Close()
 		}()
 
-		_, err = docHeaderUpdate.Exec(header.Name, header.Owner, header.Created, header.Updated, header.ID)
+		res, err := docHeaderUpdate.Exec(header.Name, header.Owner, header.Size, header.Created, nullableTime{header.Updated, !header.Updated.IsZero()}, header.ID)
 		if err != nil {
 			return fmt.Errorf("update header: %w", err)
 		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("document %v: %w", header.ID, ErrNotFound)
+		}
+
+		// the ACL may have gained or lost entries, so replace it entirely
+		if _, err := tx.Exec(`DELETE FROM au_document_acls WHERE doc_id = $1`, header.ID); err != nil {
+			return fmt.Errorf("delete ACL: %w", err)
+		}
 
-		docACLInsert, err := tx.Prepare(`UPDATE au_document_acls SET (username, read, write, delete, share) = ($1, $2, $3, $4, $5) WHERE doc_id = $6`)
+		docACLInsert, err := tx.Prepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`)
 		if err != nil {
 			return fmt.Errorf("prepare acl insert: %w", err)
 		}
@@ -77,53 +101,139 @@ func (i *PostgresDocumentRepo) Update(header DocumentHeader, acl ACL) error {
 		}()
 
 		for _, perm := range acl.Permissions {
-			_, err = docACLInsert.Exec(perm.Username, perm.Read, perm.Write, perm.Delete, perm.Share, header.ID)
+			_, err = docACLInsert.Exec(header.ID, perm.Principal.Type, perm.Principal.Name, perm.Read, perm.Write, perm.Delete, perm.Share)
 			if err != nil {
 				return fmt.Errorf("update ACL: %w", err)
 			}
 		}
 
-		return nil
+		if oldOwner == header.Owner {
+			return addUsage(tx, header.Owner, header.Size-oldSize, 0)
+		}
+		if err := addUsage(tx, oldOwner, -oldSize, -1); err != nil {
+			return err
+		}
+		return addUsage(tx, header.Owner, header.Size, 1)
 	})
 }
 
 func (i *PostgresDocumentRepo) Get(id DocID) (DocumentHeader, error) {
-	row := i.db.QueryRow(`SELECT doc_id, name, owner, created, updated FROM au_document_headers WHERE doc_id = $1`, id)
+	row := i.db.QueryRow(`SELECT doc_id, name, owner, size, created, updated FROM au_document_headers WHERE doc_id = $1`, id)
+	h, err := scanDocumentHeader(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DocumentHeader{}, fmt.Errorf("document %v: %w", id, ErrNotFound)
+	}
+	return h, err
+}
 
-	var h DocumentHeader
-	var nt nullableTime
-	if err := row.Scan(&h.ID, &h.Name, &h.Owner, &h.Created, &nt); err != nil {
-		return DocumentHeader{}, fmt.Errorf("scan: %w", err)
+func (i *PostgresDocumentRepo) Delete(id DocID) error {
+	return tx(i.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM au_document_acls WHERE doc_id = $1`, id); err != nil {
+			return fmt.Errorf("delete ACL: %w", err)
+		}
+
+		var owner string
+		var size int64
+		err := tx.QueryRow(`DELETE FROM au_document_headers WHERE doc_id = $1 RETURNING owner, size`, id).Scan(&owner, &size)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("document %v: %w", id, ErrNotFound)
+		} else if err != nil {
+			return fmt.Errorf("delete header: %w", err)
+		}
+
+		return addUsage(tx, owner, -size, -1)
+	})
+}
+
+func (i *PostgresDocumentRepo) Usage(owner string) (Usage, error) {
+	var u Usage
+	err := i.db.QueryRow(`SELECT bytes, documents FROM au_usage WHERE owner = $1`, owner).Scan(&u.Bytes, &u.Documents)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Usage{}, nil
+	} else if err != nil {
+		return Usage{}, fmt.Errorf("get usage: %w", err)
 	}
-	if nt.Valid {
-		h.Updated = nt.Time
+	return u, nil
+}
+
+// addUsage adds to the usage of the owner, see Usage.
+func addUsage(tx *sql.Tx, owner string, bytes, documents int64) error {
+	_, err := tx.Exec(`INSERT INTO au_usage (owner, bytes, documents) VALUES ($1, $2, $3) ON CONFLICT (owner) DO UPDATE SET bytes = au_usage.bytes + EXCLUDED.bytes, documents = au_usage.documents + EXCLUDED.documents`, owner, bytes, documents)
+	if err != nil {
+		return fmt.Errorf("update usage: %w", err)
 	}
-	return h, nil
+	return nil
 }
 
-func (i *PostgresDocumentRepo) Delete(id DocID) error {
-	panic("implement me")
+func (i *PostgresDocumentRepo) List(username string, groups []string) ([]DocumentHeader, error) {
+	// a document is readable by several principals, but must be listed once
+	rows, err := i.db.Query(`SELECT h.doc_id, h.name, h.owner, h.size, h.created, h.updated FROM au_document_headers h WHERE EXISTS (SELECT 1 FROM au_document_acls a WHERE a.doc_id = h.doc_id AND a.read AND ((a.principal_type = 'user' AND a.principal = $1) OR (a.principal_type = 'group' AND a.principal = ANY($2)))) ORDER BY h.doc_id`, username, pq.Array(groups))
+	if err != nil {
+		return nil, fmt.Errorf("list documents: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var headers []DocumentHeader
+	for rows.Next() {
+		h, err := scanDocumentHeader(rows)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return headers, nil
 }
 
 func (i *PostgresDocumentRepo) ACL(id DocID) (ACL, error) {
-	rows, err := i.db.Query(`SELECT username, read, write, delete, share FROM au_document_acls WHERE doc_id = $1`, id)
+	rows, err := i.db.Query(`SELECT principal_type, principal, read, write, delete, share FROM au_document_acls WHERE doc_id = $1`, id)
 	if err != nil {
 		return ACL{}, fmt.Errorf("get ACL: %w", err)
 	}
+	defer func() {
+		_ = rows.Close()
+	}()
 
 	acl := ACL{
-		Permissions: map[string]Permission{},
+		Permissions: map[Principal]Permission{},
 	}
 	for rows.Next() {
 		var p Permission
-		if err := rows.Scan(&p.Username, &p.Read, &p.Write, &p.Delete, &p.Share); err != nil {
+		if err := rows.Scan(&p.Principal.Type, &p.Principal.Name, &p.Read, &p.Write, &p.Delete, &p.Share); err != nil {
 			return ACL{}, fmt.Errorf("scan: %w", err)
 		}
-		acl.Permissions[p.Username] = p
+		acl.Permissions[p.Principal] = p
 	}
 	return acl, nil
 }
 
+// isUniqueViolation reports whether the error is a violated unique
+// constraint, which happens when inserting a document that already exists.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDocumentHeader(row scanner) (DocumentHeader, error) {
+	var h DocumentHeader
+	var nt nullableTime
+	if err := row.Scan(&h.ID, &h.Name, &h.Owner, &h.Size, &h.Created, &nt); err != nil {
+		return DocumentHeader{}, fmt.Errorf("scan: %w", err)
+	}
+	if nt.Valid {
+		h.Updated = nt.Time
+	}
+	return h, nil
+}
+
 type nullableTime struct {
 	Time  time.Time
 	Valid bool // Valid is true if Time is not NULL
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -41,18 +42,22 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreate() {
 	suite.mock.
 		ExpectBegin()
 	prepHeader := suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, created) VALUES ($1, $2, $3, $4)`).
+		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`).
 		WillBeClosed()
 	prepHeader.
 		ExpectExec().
-		WithArgs("docID", "docName", "username", docCreateTime).
+		WithArgs("docID", "docName", "username", 0, docCreateTime, nil).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	prepACL := suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, username, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6)`).
+		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
 		WillBeClosed()
 	prepACL.
 		ExpectExec().
-		WithArgs("docID", "username", true, true, true, true).
+		WithArgs("docID", "user", "username", true, true, true, true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_usage (owner, bytes, documents) VALUES ($1, $2, $3) ON CONFLICT (owner) DO UPDATE SET bytes = au_usage.bytes + EXCLUDED.bytes, documents = au_usage.documents + EXCLUDED.documents`).
+		WithArgs("username", 0, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	suite.mock.
 		ExpectCommit()
@@ -63,13 +68,13 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreate() {
 		Owner:   "username",
 		Created: docCreateTime,
 	}, ACL{
-		Permissions: map[string]Permission{
-			"username": {
-				Username: "username",
-				Read:     true,
-				Write:    true,
-				Delete:   true,
-				Share:    true,
+		Permissions: map[Principal]Permission{
+			UserPrincipal("username"): {
+				Principal: UserPrincipal("username"),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
 			},
 		},
 	}))
@@ -82,7 +87,7 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailHeaderPrepare() {
 	suite.mock.
 		ExpectBegin()
 	suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, created) VALUES ($1, $2, $3, $4)`).
+		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`).
 		WillReturnError(testErr)
 	suite.mock.
 		ExpectRollback()
@@ -93,13 +98,13 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailHeaderPrepare() {
 		Owner:   "username",
 		Created: docCreateTime,
 	}, ACL{
-		Permissions: map[string]Permission{
-			"username": {
-				Username: "username",
-				Read:     true,
-				Write:    true,
-				Delete:   true,
-				Share:    true,
+		Permissions: map[Principal]Permission{
+			UserPrincipal("username"): {
+				Principal: UserPrincipal("username"),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
 			},
 		},
 	}), testErr)
@@ -112,11 +117,11 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailHeader() {
 	suite.mock.
 		ExpectBegin()
 	prepHeader := suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, created) VALUES ($1, $2, $3, $4)`).
+		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`).
 		WillBeClosed()
 	prepHeader.
 		ExpectExec().
-		WithArgs("docID", "docName", "username", docCreateTime).
+		WithArgs("docID", "docName", "username", 0, docCreateTime, nil).
 		WillReturnError(testErr)
 	suite.mock.
 		ExpectRollback()
@@ -127,18 +132,41 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailHeader() {
 		Owner:   "username",
 		Created: docCreateTime,
 	}, ACL{
-		Permissions: map[string]Permission{
-			"username": {
-				Username: "username",
-				Read:     true,
-				Write:    true,
-				Delete:   true,
-				Share:    true,
+		Permissions: map[Principal]Permission{
+			UserPrincipal("username"): {
+				Principal: UserPrincipal("username"),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
 			},
 		},
 	}), testErr)
 }
 
+func (suite *PostgresDocumentRepoTestSuite) TestCreateAlreadyExists() {
+	docCreateTime := time.Now()
+
+	suite.mock.
+		ExpectBegin()
+	prep := suite.mock.
+		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`).
+		WillBeClosed()
+	prep.
+		ExpectExec().
+		WithArgs("docID", "docName", "username", 0, docCreateTime, nil).
+		WillReturnError(&pq.Error{Code: "23505"})
+	suite.mock.
+		ExpectRollback()
+
+	suite.ErrorIs(suite.index.Create(DocumentHeader{
+		ID:      "docID",
+		Name:    "docName",
+		Owner:   "username",
+		Created: docCreateTime,
+	}, ACL{}), ErrConflict)
+}
+
 func (suite *PostgresDocumentRepoTestSuite) TestCreateFailACLPrepare() {
 	docCreateTime := time.Now()
 
@@ -146,14 +174,14 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailACLPrepare() {
 	suite.mock.
 		ExpectBegin()
 	prepHeader := suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, created) VALUES ($1, $2, $3, $4)`).
+		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`).
 		WillBeClosed()
 	prepHeader.
 		ExpectExec().
-		WithArgs("docID", "docName", "username", docCreateTime).
+		WithArgs("docID", "docName", "username", 0, docCreateTime, nil).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, username, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6)`).
+		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
 		WillReturnError(testErr)
 	suite.mock.
 		ExpectRollback()
@@ -164,13 +192,13 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailACLPrepare() {
 		Owner:   "username",
 		Created: docCreateTime,
 	}, ACL{
-		Permissions: map[string]Permission{
-			"username": {
-				Username: "username",
-				Read:     true,
-				Write:    true,
-				Delete:   true,
-				Share:    true,
+		Permissions: map[Principal]Permission{
+			UserPrincipal("username"): {
+				Principal: UserPrincipal("username"),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
 			},
 		},
 	}), testErr)
@@ -183,18 +211,18 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailACL() {
 	suite.mock.
 		ExpectBegin()
 	prepHeader := suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, created) VALUES ($1, $2, $3, $4)`).
+		ExpectPrepare(`INSERT INTO au_document_headers (doc_id, name, owner, size, created, updated) VALUES ($1, $2, $3, $4, $5, $6)`).
 		WillBeClosed()
 	prepHeader.
 		ExpectExec().
-		WithArgs("docID", "docName", "username", docCreateTime).
+		WithArgs("docID", "docName", "username", 0, docCreateTime, nil).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	prepACL := suite.mock.
-		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, username, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6)`).
+		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
 		WillBeClosed()
 	prepACL.
 		ExpectExec().
-		WithArgs("docID", "username", true, true, true, true).
+		WithArgs("docID", "user", "username", true, true, true, true).
 		WillReturnError(testErr)
 	suite.mock.
 		ExpectRollback()
@@ -205,13 +233,13 @@ func (suite *PostgresDocumentRepoTestSuite) TestCreateFailACL() {
 		Owner:   "username",
 		Created: docCreateTime,
 	}, ACL{
-		Permissions: map[string]Permission{
-			"username": {
-				Username: "username",
-				Read:     true,
-				Write:    true,
-				Delete:   true,
-				Share:    true,
+		Permissions: map[Principal]Permission{
+			UserPrincipal("username"): {
+				Principal: UserPrincipal("username"),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
 			},
 		},
 	}), testErr)
@@ -260,3 +288,226 @@ func (suite *PostgresDocumentRepoTestSuite) TestFailCommit() {
 		return err
 	}), testErr)
 }
+
+func (suite *PostgresDocumentRepoTestSuite) TestDelete() {
+	suite.mock.
+		ExpectBegin()
+	suite.mock.
+		ExpectExec(`DELETE FROM au_document_acls WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectQuery(`DELETE FROM au_document_headers WHERE doc_id = $1 RETURNING owner, size`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "size"}).AddRow("username", 5))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_usage (owner, bytes, documents) VALUES ($1, $2, $3) ON CONFLICT (owner) DO UPDATE SET bytes = au_usage.bytes + EXCLUDED.bytes, documents = au_usage.documents + EXCLUDED.documents`).
+		WithArgs("username", -5, -1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectCommit()
+
+	suite.NoError(suite.index.Delete("docID"))
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestDeleteNotExists() {
+	suite.mock.
+		ExpectBegin()
+	suite.mock.
+		ExpectExec(`DELETE FROM au_document_acls WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.
+		ExpectQuery(`DELETE FROM au_document_headers WHERE doc_id = $1 RETURNING owner, size`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "size"}))
+	suite.mock.
+		ExpectRollback()
+
+	suite.ErrorIs(suite.index.Delete("docID"), ErrNotFound)
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestGetNotExists() {
+	suite.mock.
+		ExpectQuery(`SELECT doc_id, name, owner, size, created, updated FROM au_document_headers WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"doc_id", "name", "owner", "size", "created", "updated"}))
+
+	_, err := suite.index.Get("docID")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestList() {
+	created := time.Now()
+	updated := created.Add(time.Minute)
+
+	suite.mock.
+		ExpectQuery(`SELECT h.doc_id, h.name, h.owner, h.size, h.created, h.updated FROM au_document_headers h WHERE EXISTS (SELECT 1 FROM au_document_acls a WHERE a.doc_id = h.doc_id AND a.read AND ((a.principal_type = 'user' AND a.principal = $1) OR (a.principal_type = 'group' AND a.principal = ANY($2)))) ORDER BY h.doc_id`).
+		WithArgs("username", pq.Array([]string{"editors"})).
+		WillReturnRows(sqlmock.NewRows([]string{"doc_id", "name", "owner", "size", "created", "updated"}).
+			AddRow("docA", "a.txt", "username", 5, created, updated).
+			AddRow("docB", "b.txt", "otheruser", 0, created, nil))
+
+	headers, err := suite.index.List("username", []string{"editors"})
+	suite.NoError(err)
+	suite.Equal([]DocumentHeader{
+		{ID: "docA", Name: "a.txt", Owner: "username", Size: 5, Created: created, Updated: updated},
+		{ID: "docB", Name: "b.txt", Owner: "otheruser", Created: created},
+	}, headers)
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestACL() {
+	suite.mock.
+		ExpectQuery(`SELECT principal_type, principal, read, write, delete, share FROM au_document_acls WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"principal_type", "principal", "read", "write", "delete", "share"}).
+			AddRow("user", "username", true, true, true, true).
+			AddRow("group", "editors", true, true, false, false))
+
+	acl, err := suite.index.ACL("docID")
+	suite.NoError(err)
+	suite.Equal(ACL{
+		Permissions: map[Principal]Permission{
+			UserPrincipal("username"): {
+				Principal: UserPrincipal("username"),
+				Read:      true,
+				Write:     true,
+				Delete:    true,
+				Share:     true,
+			},
+			GroupPrincipal("editors"): {
+				Principal: GroupPrincipal("editors"),
+				Read:      true,
+				Write:     true,
+			},
+		},
+	}, acl)
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestUpdate() {
+	created := time.Now()
+	updated := created.Add(time.Minute)
+
+	suite.mock.
+		ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT owner, size FROM au_document_headers WHERE doc_id = $1 FOR UPDATE`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "size"}).AddRow("username", 3))
+	prepHeader := suite.mock.
+		ExpectPrepare(`UPDATE au_document_headers SET (name, owner, size, created, updated) = ($1, $2, $3, $4, $5) WHERE doc_id = $6`).
+		WillBeClosed()
+	prepHeader.
+		ExpectExec().
+		WithArgs("docName", "username", 5, created, updated, "docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`DELETE FROM au_document_acls WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	prepACL := suite.mock.
+		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
+		WillBeClosed()
+	prepACL.
+		ExpectExec().
+		WithArgs("docID", "group", "editors", true, false, false, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_usage (owner, bytes, documents) VALUES ($1, $2, $3) ON CONFLICT (owner) DO UPDATE SET bytes = au_usage.bytes + EXCLUDED.bytes, documents = au_usage.documents + EXCLUDED.documents`).
+		WithArgs("username", 2, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectCommit()
+
+	suite.NoError(suite.index.Update(DocumentHeader{
+		ID:      "docID",
+		Name:    "docName",
+		Owner:   "username",
+		Size:    5,
+		Created: created,
+		Updated: updated,
+	}, ACL{
+		Permissions: map[Principal]Permission{
+			GroupPrincipal("editors"): {
+				Principal: GroupPrincipal("editors"),
+				Read:      true,
+			},
+		},
+	}))
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestUpdateOwner() {
+	created := time.Now()
+
+	suite.mock.
+		ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT owner, size FROM au_document_headers WHERE doc_id = $1 FOR UPDATE`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "size"}).AddRow("olduser", 5))
+	prepHeader := suite.mock.
+		ExpectPrepare(`UPDATE au_document_headers SET (name, owner, size, created, updated) = ($1, $2, $3, $4, $5) WHERE doc_id = $6`).
+		WillBeClosed()
+	prepHeader.
+		ExpectExec().
+		WithArgs("docName", "username", 5, created, nil, "docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`DELETE FROM au_document_acls WHERE doc_id = $1`).
+		WithArgs("docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectPrepare(`INSERT INTO au_document_acls (doc_id, principal_type, principal, read, write, delete, share) VALUES ($1, $2, $3, $4, $5, $6, $7)`).
+		WillBeClosed()
+	// the document moves from the usage of the old owner to the new one
+	suite.mock.
+		ExpectExec(`INSERT INTO au_usage (owner, bytes, documents) VALUES ($1, $2, $3) ON CONFLICT (owner) DO UPDATE SET bytes = au_usage.bytes + EXCLUDED.bytes, documents = au_usage.documents + EXCLUDED.documents`).
+		WithArgs("olduser", -5, -1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_usage (owner, bytes, documents) VALUES ($1, $2, $3) ON CONFLICT (owner) DO UPDATE SET bytes = au_usage.bytes + EXCLUDED.bytes, documents = au_usage.documents + EXCLUDED.documents`).
+		WithArgs("username", 5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectCommit()
+
+	suite.NoError(suite.index.Update(DocumentHeader{
+		ID:      "docID",
+		Name:    "docName",
+		Owner:   "username",
+		Size:    5,
+		Created: created,
+	}, ACL{}))
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestUpdateNotExists() {
+	suite.mock.
+		ExpectBegin()
+	suite.mock.
+		ExpectQuery(`SELECT owner, size FROM au_document_headers WHERE doc_id = $1 FOR UPDATE`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "size"}))
+	suite.mock.
+		ExpectRollback()
+
+	suite.ErrorIs(suite.index.Update(DocumentHeader{ID: "docID"}, ACL{}), ErrNotFound)
+}
+
+func (suite *PostgresDocumentRepoTestSuite) TestUsage() {
+	suite.mock.
+		ExpectQuery(`SELECT bytes, documents FROM au_usage WHERE owner = $1`).
+		WithArgs("username").
+		WillReturnRows(sqlmock.NewRows([]string{"bytes", "documents"}).AddRow(1234, 2))
+	suite.mock.
+		ExpectQuery(`SELECT bytes, documents FROM au_usage WHERE owner = $1`).
+		WithArgs("nobody").
+		WillReturnRows(sqlmock.NewRows([]string{"bytes", "documents"}))
+
+	u, err := suite.index.Usage("username")
+	suite.NoError(err)
+	suite.Equal(Usage{Bytes: 1234, Documents: 2}, u)
+
+	u, err = suite.index.Usage("nobody")
+	suite.NoError(err)
+	suite.Equal(Usage{}, u)
+}
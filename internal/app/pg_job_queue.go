@@ -0,0 +1,142 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ JobQueue = (*PostgresJobQueue)(nil)
+
+// PostgresJobQueue is a JobQueue that is shared by all instances of the app.
+// Workers claim jobs with SKIP LOCKED, so that they don't wait for each other.
+type PostgresJobQueue struct {
+	db *sql.DB
+}
+
+func NewPostgresJobQueue(p *PostgresDatabaseProvider) *PostgresJobQueue {
+	return &PostgresJobQueue{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresJobQueue) Enqueue(j Job) error {
+	_, err := r.db.Exec(`INSERT INTO au_jobs (job_id, type, owner, payload, state, max_attempts, run_at, created, updated) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		j.ID, j.Type, j.Owner, j.Payload, j.State, j.MaxAttempts, j.RunAt, j.Created, j.Updated)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("insert job: %v: %w", err, ErrConflict)
+	} else if err != nil {
+		return fmt.Errorf("insert job: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresJobQueue) Get(id string) (Job, error) {
+	row := r.db.QueryRow(`SELECT job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished FROM au_jobs WHERE job_id = $1`, id)
+	j, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, fmt.Errorf("job %v: %w", id, ErrNotFound)
+	}
+	return j, err
+}
+
+func (r *PostgresJobQueue) List(owner string) ([]Job, error) {
+	rows, err := r.db.Query(`SELECT job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished FROM au_jobs WHERE owner = $1 ORDER BY created DESC, job_id DESC`, owner)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return jobs, nil
+}
+
+// Claim skips the jobs that are claimed by other workers at the same time.
+func (r *PostgresJobQueue) Claim(now, leaseUntil time.Time) (Job, error) {
+	row := r.db.QueryRow(`UPDATE au_jobs SET state = 'running', attempts = attempts + 1, lease_until = $2, updated = $1 WHERE id = (SELECT id FROM au_jobs WHERE (state = 'queued' AND (run_at <= $1 OR cancel_requested)) OR (state = 'running' AND lease_until < $1) ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished`,
+		now, leaseUntil)
+	j, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, fmt.Errorf("job: %w", ErrNotFound)
+	}
+	return j, err
+}
+
+func (r *PostgresJobQueue) Heartbeat(j Job) (bool, error) {
+	var cancel bool
+	err := r.db.QueryRow(`UPDATE au_jobs SET done = $3, total = $4, result = $5, lease_until = $6, updated = $7 WHERE job_id = $1 AND attempts = $2 AND state = 'running' RETURNING cancel_requested`,
+		j.ID, j.Attempts, j.Done, j.Total, j.Result, j.LeaseUntil, j.Updated).Scan(&cancel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("running job %v: %w", j.ID, ErrNotFound)
+	} else if err != nil {
+		return false, fmt.Errorf("update job: %w", err)
+	}
+	return cancel, nil
+}
+
+func (r *PostgresJobQueue) Finish(j Job) error {
+	res, err := r.db.Exec(`UPDATE au_jobs SET state = $3, done = $4, total = $5, result = $6, error = $7, run_at = $8, lease_until = NULL, updated = $9, finished = $10 WHERE job_id = $1 AND attempts = $2 AND state = 'running'`,
+		j.ID, j.Attempts, j.State, j.Done, j.Total, j.Result, j.Error, j.RunAt, j.Updated, nullableTime{j.Finished, !j.Finished.IsZero()})
+	if err != nil {
+		return fmt.Errorf("update job: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("running job %v: %w", j.ID, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresJobQueue) Cancel(id string, now time.Time) error {
+	res, err := r.db.Exec(`UPDATE au_jobs SET cancel_requested = true, updated = $2 WHERE job_id = $1 AND state IN ('queued', 'running')`, id, now)
+	if err != nil {
+		return fmt.Errorf("cancel job: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// either the job doesn't exist or it's finished
+		if _, err := r.Get(id); err != nil {
+			return err
+		}
+		return fmt.Errorf("job %v is finished: %w", id, ErrConflict)
+	}
+	return nil
+}
+
+func (r *PostgresJobQueue) DeleteFinished(before time.Time) (int, error) {
+	res, err := r.db.Exec(`DELETE FROM au_jobs WHERE finished < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("delete jobs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
+func scanJob(row scanner) (Job, error) {
+	var j Job
+	var leaseUntil, finished nullableTime
+	if err := row.Scan(&j.ID, &j.Type, &j.Owner, &j.Payload, &j.Result, &j.State, &j.Done, &j.Total, &j.Error, &j.Attempts, &j.MaxAttempts, &j.CancelRequested, &j.RunAt, &leaseUntil, &j.Created, &j.Updated, &finished); err != nil {
+		return Job{}, fmt.Errorf("scan: %w", err)
+	}
+	if leaseUntil.Valid {
+		j.LeaseUntil = leaseUntil.Time
+	}
+	if finished.Valid {
+		j.Finished = finished.Time
+	}
+	return j, nil
+}
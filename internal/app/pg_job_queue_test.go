@@ -0,0 +1,126 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresJobQueueTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresJobQueueTestSuite))
+}
+
+type PostgresJobQueueTestSuite struct {
+	suite.Suite
+
+	queue *PostgresJobQueue
+	mock  sqlmock.Sqlmock
+	db    *sql.DB
+}
+
+func (suite *PostgresJobQueueTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.queue = &PostgresJobQueue{suite.db}
+}
+
+func (suite *PostgresJobQueueTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+var jobColumns = []string{"job_id", "type", "owner", "payload", "result", "state", "done", "total", "error", "attempts", "max_attempts", "cancel_requested", "run_at", "lease_until", "created", "updated", "finished"}
+
+func (suite *PostgresJobQueueTestSuite) TestClaim() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	lease := now.Add(jobLease)
+	suite.mock.
+		ExpectQuery(`UPDATE au_jobs SET state = 'running', attempts = attempts + 1, lease_until = $2, updated = $1 WHERE id = (SELECT id FROM au_jobs WHERE (state = 'queued' AND (run_at <= $1 OR cancel_requested)) OR (state = 'running' AND lease_until < $1) ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished`).
+		WithArgs(now, lease).
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow("job", "import", "user", []byte(`{}`), nil, "running", 0, 0, "", 1, 1, false, now, lease, now, now, nil))
+
+	j, err := suite.queue.Claim(now, lease)
+	suite.NoError(err)
+	suite.Equal(Job{
+		ID:          "job",
+		Type:        "import",
+		Owner:       "user",
+		Payload:     []byte(`{}`),
+		State:       JobRunning,
+		Attempts:    1,
+		MaxAttempts: 1,
+		RunAt:       now,
+		LeaseUntil:  lease,
+		Created:     now,
+		Updated:     now,
+	}, j)
+}
+
+func (suite *PostgresJobQueueTestSuite) TestClaimNone() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectQuery(`UPDATE au_jobs SET state = 'running', attempts = attempts + 1, lease_until = $2, updated = $1 WHERE id = (SELECT id FROM au_jobs WHERE (state = 'queued' AND (run_at <= $1 OR cancel_requested)) OR (state = 'running' AND lease_until < $1) ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished`).
+		WithArgs(now, now.Add(jobLease)).
+		WillReturnRows(sqlmock.NewRows(jobColumns))
+
+	_, err := suite.queue.Claim(now, now.Add(jobLease))
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresJobQueueTestSuite) TestHeartbeat() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectQuery(`UPDATE au_jobs SET done = $3, total = $4, result = $5, lease_until = $6, updated = $7 WHERE job_id = $1 AND attempts = $2 AND state = 'running' RETURNING cancel_requested`).
+		WithArgs("job", 2, 1, 4, []byte(`[]`), now.Add(jobLease), now).
+		WillReturnRows(sqlmock.NewRows([]string{"cancel_requested"}).AddRow(true))
+
+	cancel, err := suite.queue.Heartbeat(Job{ID: "job", Attempts: 2, Done: 1, Total: 4, Result: []byte(`[]`), LeaseUntil: now.Add(jobLease), Updated: now})
+	suite.NoError(err)
+	suite.True(cancel)
+}
+
+func (suite *PostgresJobQueueTestSuite) TestFinishNotRunning() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectExec(`UPDATE au_jobs SET state = $3, done = $4, total = $5, result = $6, error = $7, run_at = $8, lease_until = NULL, updated = $9, finished = $10 WHERE job_id = $1 AND attempts = $2 AND state = 'running'`).
+		WithArgs("job", 1, JobSucceeded, 0, 0, []byte(nil), "", now, now, nullableTime{now, true}).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := suite.queue.Finish(Job{ID: "job", Attempts: 1, State: JobSucceeded, RunAt: now, Updated: now, Finished: now})
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresJobQueueTestSuite) TestCancelFinished() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectExec(`UPDATE au_jobs SET cancel_requested = true, updated = $2 WHERE job_id = $1 AND state IN ('queued', 'running')`).
+		WithArgs("job", now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.
+		ExpectQuery(`SELECT job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished FROM au_jobs WHERE job_id = $1`).
+		WithArgs("job").
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow("job", "import", "user", []byte(`{}`), nil, "succeeded", 0, 0, "", 1, 1, false, now, nil, now, now, now))
+
+	suite.ErrorIs(suite.queue.Cancel("job", now), ErrConflict)
+}
+
+func (suite *PostgresJobQueueTestSuite) TestCancelNotFound() {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.mock.
+		ExpectExec(`UPDATE au_jobs SET cancel_requested = true, updated = $2 WHERE job_id = $1 AND state IN ('queued', 'running')`).
+		WithArgs("job", now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.
+		ExpectQuery(`SELECT job_id, type, owner, payload, result, state, done, total, error, attempts, max_attempts, cancel_requested, run_at, lease_until, created, updated, finished FROM au_jobs WHERE job_id = $1`).
+		WithArgs("job").
+		WillReturnRows(sqlmock.NewRows(jobColumns))
+
+	suite.ErrorIs(suite.queue.Cancel("job", now), ErrNotFound)
+}
@@ -0,0 +1,208 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var _ LocalUserRepo = (*PostgresLocalUserRepo)(nil)
+
+type PostgresLocalUserRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresLocalUserRepo(p *PostgresDatabaseProvider) *PostgresLocalUserRepo {
+	return &PostgresLocalUserRepo{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresLocalUserRepo) Create(u LocalUser) error {
+	_, err := r.db.Exec(`INSERT INTO au_users (username, password_hash, totp_secret, totp_pending, totp_last_step, disabled) VALUES ($1, $2, $3, $4, $5, $6)`,
+		u.Username, u.PasswordHash, u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, u.Disabled)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("insert user: %v: %w", err, ErrConflict)
+	} else if err != nil {
+		return fmt.Errorf("insert user: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) Get(username string) (LocalUser, error) {
+	u, err := scanLocalUser(r.db.QueryRow(`SELECT username, password_hash, totp_secret, totp_pending, totp_last_step, disabled FROM au_users WHERE username = $1`, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LocalUser{}, fmt.Errorf("user %v: %w", username, ErrNotFound)
+	} else if err != nil {
+		return LocalUser{}, fmt.Errorf("get user: %w", err)
+	}
+	return u, nil
+}
+
+func (r *PostgresLocalUserRepo) List() ([]LocalUser, error) {
+	rows, err := r.db.Query(`SELECT username, password_hash, totp_secret, totp_pending, totp_last_step, disabled FROM au_users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var users []LocalUser
+	for rows.Next() {
+		u, err := scanLocalUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return users, nil
+}
+
+func (r *PostgresLocalUserRepo) Update(u LocalUser) error {
+	res, err := r.db.Exec(`UPDATE au_users SET password_hash = $2, totp_secret = $3, totp_pending = $4, totp_last_step = $5, disabled = $6 WHERE username = $1`,
+		u.Username, u.PasswordHash, u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, u.Disabled)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("user %v: %w", u.Username, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) Delete(username string) error {
+	// memberships are deleted by the foreign key
+	res, err := r.db.Exec(`DELETE FROM au_users WHERE username = $1`, username)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("user %v: %w", username, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) UseTOTPStep(username string, step int64) error {
+	// the condition makes concurrent uses of the same code fail
+	res, err := r.db.Exec(`UPDATE au_users SET totp_last_step = $2 WHERE username = $1 AND totp_last_step < $2`, username, step)
+	if err != nil {
+		return fmt.Errorf("update TOTP step: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("TOTP step %v of user %v: %w", step, username, ErrConflict)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) Groups() ([]string, error) {
+	return r.queryStrings(`SELECT name FROM au_groups ORDER BY name`)
+}
+
+func (r *PostgresLocalUserRepo) CreateGroup(name string) error {
+	_, err := r.db.Exec(`INSERT INTO au_groups (name) VALUES ($1)`, name)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("insert group: %v: %w", err, ErrConflict)
+	} else if err != nil {
+		return fmt.Errorf("insert group: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) DeleteGroup(name string) error {
+	res, err := r.db.Exec(`DELETE FROM au_groups WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("delete group: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("group %v: %w", name, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) AddMember(group, username string) error {
+	_, err := r.db.Exec(`INSERT INTO au_group_members (group_name, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`, group, username)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %v or group %v: %w", username, group, ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("insert member: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) RemoveMember(group, username string) error {
+	res, err := r.db.Exec(`DELETE FROM au_group_members WHERE group_name = $1 AND username = $2`, group, username)
+	if err != nil {
+		return fmt.Errorf("delete member: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("user %v in group %v: %w", username, group, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresLocalUserRepo) GroupsOf(username string) ([]string, error) {
+	return r.queryStrings(`SELECT group_name FROM au_group_members WHERE username = $1 ORDER BY group_name`, username)
+}
+
+func (r *PostgresLocalUserRepo) Memberships() (map[string][]string, error) {
+	rows, err := r.db.Query(`SELECT username, group_name FROM au_group_members ORDER BY username, group_name`)
+	if err != nil {
+		return nil, fmt.Errorf("list members: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	memberships := map[string][]string{}
+	for rows.Next() {
+		var username, group string
+		if err := rows.Scan(&username, &group); err != nil {
+			return nil, fmt.Errorf("scan member: %w", err)
+		}
+		memberships[username] = append(memberships[username], group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return memberships, nil
+}
+
+func (r *PostgresLocalUserRepo) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var res []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		res = append(res, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return res, nil
+}
+
+func scanLocalUser(row scanner) (LocalUser, error) {
+	var u LocalUser
+	err := row.Scan(&u.Username, &u.PasswordHash, &u.TOTPSecret, &u.TOTPPending, &u.TOTPLastStep, &u.Disabled)
+	return u, err
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresLocalUserRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresLocalUserRepoTestSuite))
+}
+
+type PostgresLocalUserRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresLocalUserRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresLocalUserRepo{suite.db}
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestCreate() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_users (username, password_hash, totp_secret, totp_pending, totp_last_step, disabled) VALUES ($1, $2, $3, $4, $5, $6)`).
+		WithArgs("alice", "hash", "", "", 0, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Create(LocalUser{
+		Username:     "alice",
+		PasswordHash: "hash",
+	}))
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestCreateAlreadyExists() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_users (username, password_hash, totp_secret, totp_pending, totp_last_step, disabled) VALUES ($1, $2, $3, $4, $5, $6)`).
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	suite.ErrorIs(suite.repo.Create(LocalUser{Username: "alice"}), ErrConflict)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestGet() {
+	suite.mock.
+		ExpectQuery(`SELECT username, password_hash, totp_secret, totp_pending, totp_last_step, disabled FROM au_users WHERE username = $1`).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "password_hash", "totp_secret", "totp_pending", "totp_last_step", "disabled"}).
+			AddRow("alice", "hash", "secret", "", 42, true))
+
+	u, err := suite.repo.Get("alice")
+	suite.NoError(err)
+	suite.Equal(LocalUser{
+		Username:     "alice",
+		PasswordHash: "hash",
+		TOTPSecret:   "secret",
+		TOTPLastStep: 42,
+		Disabled:     true,
+	}, u)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestGetNotFound() {
+	suite.mock.
+		ExpectQuery(`SELECT username, password_hash, totp_secret, totp_pending, totp_last_step, disabled FROM au_users WHERE username = $1`).
+		WithArgs("alice").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := suite.repo.Get("alice")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestUpdate() {
+	suite.mock.
+		ExpectExec(`UPDATE au_users SET password_hash = $2, totp_secret = $3, totp_pending = $4, totp_last_step = $5, disabled = $6 WHERE username = $1`).
+		WithArgs("alice", "hash", "", "pending", 0, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Update(LocalUser{
+		Username:     "alice",
+		PasswordHash: "hash",
+		TOTPPending:  "pending",
+	}))
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestUpdateNotFound() {
+	suite.mock.
+		ExpectExec(`UPDATE au_users SET password_hash = $2, totp_secret = $3, totp_pending = $4, totp_last_step = $5, disabled = $6 WHERE username = $1`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	suite.ErrorIs(suite.repo.Update(LocalUser{Username: "alice"}), ErrNotFound)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestUseTOTPStep() {
+	suite.mock.
+		ExpectExec(`UPDATE au_users SET totp_last_step = $2 WHERE username = $1 AND totp_last_step < $2`).
+		WithArgs("alice", 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.UseTOTPStep("alice", 42))
+
+	// the step was used concurrently
+	suite.mock.
+		ExpectExec(`UPDATE au_users SET totp_last_step = $2 WHERE username = $1 AND totp_last_step < $2`).
+		WithArgs("alice", 42).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.ErrorIs(suite.repo.UseTOTPStep("alice", 42), ErrConflict)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestList() {
+	suite.mock.
+		ExpectQuery(`SELECT username, password_hash, totp_secret, totp_pending, totp_last_step, disabled FROM au_users ORDER BY username`).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "password_hash", "totp_secret", "totp_pending", "totp_last_step", "disabled"}).
+			AddRow("alice", "hash1", "", "", 0, false).
+			AddRow("bob", "hash2", "", "", 0, true))
+
+	users, err := suite.repo.List()
+	suite.NoError(err)
+	suite.Equal([]LocalUser{
+		{Username: "alice", PasswordHash: "hash1"},
+		{Username: "bob", PasswordHash: "hash2", Disabled: true},
+	}, users)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestDelete() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_users WHERE username = $1`).
+		WithArgs("alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.Delete("alice"))
+
+	suite.mock.
+		ExpectExec(`DELETE FROM au_users WHERE username = $1`).
+		WithArgs("alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.ErrorIs(suite.repo.Delete("alice"), ErrNotFound)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestGroups() {
+	suite.mock.
+		ExpectQuery(`SELECT name FROM au_groups ORDER BY name`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).
+			AddRow("admin").
+			AddRow("users"))
+
+	groups, err := suite.repo.Groups()
+	suite.NoError(err)
+	suite.Equal([]string{"admin", "users"}, groups)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestCreateGroup() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_groups (name) VALUES ($1)`).
+		WithArgs("admin").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.CreateGroup("admin"))
+
+	suite.mock.
+		ExpectExec(`INSERT INTO au_groups (name) VALUES ($1)`).
+		WithArgs("admin").
+		WillReturnError(&pq.Error{Code: "23505"})
+	suite.ErrorIs(suite.repo.CreateGroup("admin"), ErrConflict)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestDeleteGroupNotFound() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_groups WHERE name = $1`).
+		WithArgs("admin").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	suite.ErrorIs(suite.repo.DeleteGroup("admin"), ErrNotFound)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestAddMember() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_group_members (group_name, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`).
+		WithArgs("admin", "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.AddMember("admin", "alice"))
+
+	// the group or the user doesn't exist
+	suite.mock.
+		ExpectExec(`INSERT INTO au_group_members (group_name, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`).
+		WithArgs("admin", "bob").
+		WillReturnError(&pq.Error{Code: "23503"})
+	suite.ErrorIs(suite.repo.AddMember("admin", "bob"), ErrNotFound)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestRemoveMemberNotFound() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_group_members WHERE group_name = $1 AND username = $2`).
+		WithArgs("admin", "alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	suite.ErrorIs(suite.repo.RemoveMember("admin", "alice"), ErrNotFound)
+}
+
+func (suite *PostgresLocalUserRepoTestSuite) TestMemberships() {
+	suite.mock.
+		ExpectQuery(`SELECT username, group_name FROM au_group_members ORDER BY username, group_name`).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "group_name"}).
+			AddRow("alice", "admin").
+			AddRow("alice", "users").
+			AddRow("bob", "users"))
+
+	memberships, err := suite.repo.Memberships()
+	suite.NoError(err)
+	suite.Equal(map[string][]string{
+		"alice": {"admin", "users"},
+		"bob":   {"users"},
+	}, memberships)
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var _ QuotaRepo = (*PostgresQuotaRepo)(nil)
+
+type PostgresQuotaRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresQuotaRepo(p *PostgresDatabaseProvider) *PostgresQuotaRepo {
+	return &PostgresQuotaRepo{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresQuotaRepo) Set(q Quota) error {
+	_, err := r.db.Exec(`INSERT INTO au_quotas (principal_type, principal, max_bytes, max_documents) VALUES ($1, $2, $3, $4) ON CONFLICT (principal_type, principal) DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_documents = EXCLUDED.max_documents`,
+		q.Principal.Type, q.Principal.Name, q.MaxBytes, q.MaxDocuments)
+	if err != nil {
+		return fmt.Errorf("upsert quota: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresQuotaRepo) Delete(p Principal) error {
+	res, err := r.db.Exec(`DELETE FROM au_quotas WHERE principal_type = $1 AND principal = $2`, p.Type, p.Name)
+	if err != nil {
+		return fmt.Errorf("delete quota: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("quota of %v %v: %w", p.Type, p.Name, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresQuotaRepo) List() ([]Quota, error) {
+	return r.query(`SELECT principal_type, principal, max_bytes, max_documents FROM au_quotas ORDER BY principal_type, principal`)
+}
+
+func (r *PostgresQuotaRepo) Find(user string, groups []string) ([]Quota, error) {
+	return r.query(`SELECT principal_type, principal, max_bytes, max_documents FROM au_quotas WHERE (principal_type = 'user' AND principal = $1) OR (principal_type = 'group' AND principal = ANY($2)) ORDER BY principal_type, principal`, user, pq.Array(groups))
+}
+
+func (r *PostgresQuotaRepo) query(query string, args ...interface{}) ([]Quota, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list quotas: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var quotas []Quota
+	for rows.Next() {
+		var q Quota
+		if err := rows.Scan(&q.Principal.Type, &q.Principal.Name, &q.MaxBytes, &q.MaxDocuments); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		quotas = append(quotas, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return quotas, nil
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresQuotaRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresQuotaRepoTestSuite))
+}
+
+type PostgresQuotaRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresQuotaRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresQuotaRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresQuotaRepo{suite.db}
+}
+
+func (suite *PostgresQuotaRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresQuotaRepoTestSuite) TestSet() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_quotas (principal_type, principal, max_bytes, max_documents) VALUES ($1, $2, $3, $4) ON CONFLICT (principal_type, principal) DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_documents = EXCLUDED.max_documents`).
+		WithArgs("group", "staff", 1000, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Set(Quota{Principal: GroupPrincipal("staff"), MaxBytes: 1000}))
+}
+
+func (suite *PostgresQuotaRepoTestSuite) TestDeleteNotExists() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_quotas WHERE principal_type = $1 AND principal = $2`).
+		WithArgs("user", "alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	suite.ErrorIs(suite.repo.Delete(UserPrincipal("alice")), ErrNotFound)
+}
+
+func (suite *PostgresQuotaRepoTestSuite) TestFind() {
+	suite.mock.
+		ExpectQuery(`SELECT principal_type, principal, max_bytes, max_documents FROM au_quotas WHERE (principal_type = 'user' AND principal = $1) OR (principal_type = 'group' AND principal = ANY($2)) ORDER BY principal_type, principal`).
+		WithArgs("alice", pq.Array([]string{"staff"})).
+		WillReturnRows(sqlmock.NewRows([]string{"principal_type", "principal", "max_bytes", "max_documents"}).
+			AddRow("group", "staff", 1000, 10).
+			AddRow("user", "alice", 100, 0))
+
+	quotas, err := suite.repo.Find("alice", []string{"staff"})
+	suite.NoError(err)
+	suite.Equal([]Quota{
+		{Principal: GroupPrincipal("staff"), MaxBytes: 1000, MaxDocuments: 10},
+		{Principal: UserPrincipal("alice"), MaxBytes: 100},
+	}, quotas)
+}
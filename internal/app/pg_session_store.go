@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+var _ sessions.Store = (*PostgresSessionStore)(nil)
+
+// PostgresSessionStore keeps sessions in the database. The cookie only
+// contains the signed ID of the session, so deleting the session, e.g. on
+// logout, revokes it even if the cookie was copied.
+type PostgresSessionStore struct {
+	db      *sql.DB
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+	clock   Clock
+}
+
+func NewPostgresSessionStore(p *PostgresDatabaseProvider) (*PostgresSessionStore, error) {
+	pairs, err := sessionKeyPairs(p.Config.GetStringSlice(config.SessionKeys))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PostgresSessionStore{
+		db:     p.DB,
+		codecs: securecookie.CodecsFromPairs(pairs...),
+		clock:  TimeClock{},
+	}
+	s.Options(DefaultSessionOptions)
+	return s, nil
+}
+
+func (s *PostgresSessionStore) Options(opts sessions.Options) {
+	s.options = opts.ToGorillaOptions()
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(opts.MaxAge)
+		}
+	}
+}
+
+func (s *PostgresSessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the request. Unknown, expired and revoked
+// sessions result in a new session.
+func (s *PostgresSessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.codecs...); err != nil {
+		return session, err
+	}
+
+	var data []byte
+	err = s.db.QueryRow(`SELECT data FROM au_sessions WHERE session_id = $1 AND expires > $2`, id, s.clock.Now()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, nil
+	} else if err != nil {
+		return session, fmt.Errorf("load session: %w", err)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, fmt.Errorf("decode session: %w", err)
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session, or deletes it if its MaxAge is negative. Sessions
+// that are renewed, see App.renewSession, are stored with a new ID and the
+// old one is deleted.
+func (s *PostgresSessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.db.Exec(`DELETE FROM au_sessions WHERE session_id = $1`, session.ID); err != nil {
+				return fmt.Errorf("delete session: %w", err)
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if renew, _ := session.Values[renewSessionKey].(bool); renew {
+		delete(session.Values, renewSessionKey)
+		if session.ID != "" {
+			if _, err := s.db.Exec(`DELETE FROM au_sessions WHERE session_id = $1`, session.ID); err != nil {
+				return fmt.Errorf("delete session: %w", err)
+			}
+			session.ID = ""
+		}
+	}
+
+	now := s.clock.Now()
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+
+		// the expired sessions of other users are never read again
+		if _, err := s.db.Exec(`DELETE FROM au_sessions WHERE expires <= $1`, now); err != nil {
+			return fmt.Errorf("delete expired sessions: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return fmt.Errorf("encode session: %w", err)
+	}
+
+	maxAge := time.Duration(session.Options.MaxAge) * time.Second
+	if maxAge == 0 {
+		// the cookie lives until the browser is closed, which the server
+		// can't know
+		maxAge = time.Duration(DefaultSessionOptions.MaxAge) * time.Second
+	}
+	if _, err := s.db.Exec(`INSERT INTO au_sessions (session_id, data, expires) VALUES ($1, $2, $3) ON CONFLICT (session_id) DO UPDATE SET data = $2, expires = $3`,
+		session.ID, buf.Bytes(), now.Add(maxAge)); err != nil {
+		return fmt.Errorf("store session: %w", err)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("encode session ID: %w", err)
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresSessionStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresSessionStoreTestSuite))
+}
+
+type PostgresSessionStoreTestSuite struct {
+	suite.Suite
+
+	store *PostgresSessionStore
+	mock  sqlmock.Sqlmock
+	db    *sql.DB
+	now   time.Time
+}
+
+func (suite *PostgresSessionStoreTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.now = time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	pairs, err := sessionKeyPairs(nil)
+	suite.NoError(err)
+	suite.store = &PostgresSessionStore{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(pairs...),
+		clock:  SingleTimestampClock{suite.now},
+	}
+	suite.store.Options(DefaultSessionOptions)
+}
+
+func (suite *PostgresSessionStoreTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+// request returns a request with the cookie of a session with the given ID.
+func (suite *PostgresSessionStoreTestSuite) request(id string) *http.Request {
+	encoded, err := securecookie.EncodeMulti(SessionCookieName, id, suite.store.codecs...)
+	suite.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: encoded})
+	return req
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestNewWithoutCookie() {
+	s, err := suite.store.New(httptest.NewRequest(http.MethodGet, "/", nil), SessionCookieName)
+	suite.NoError(err)
+	suite.True(s.IsNew)
+	suite.Empty(s.ID)
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestNew() {
+	var buf bytes.Buffer
+	suite.NoError(gob.NewEncoder(&buf).Encode(map[interface{}]interface{}{
+		UserIDKey: "username",
+	}))
+
+	suite.mock.
+		ExpectQuery(`SELECT data FROM au_sessions WHERE session_id = $1 AND expires > $2`).
+		WithArgs("sessionID", suite.now).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(buf.Bytes()))
+
+	s, err := suite.store.New(suite.request("sessionID"), SessionCookieName)
+	suite.NoError(err)
+	suite.False(s.IsNew)
+	suite.Equal("sessionID", s.ID)
+	suite.Equal("username", s.Values[UserIDKey])
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestNewRevoked() {
+	suite.mock.
+		ExpectQuery(`SELECT data FROM au_sessions WHERE session_id = $1 AND expires > $2`).
+		WithArgs("sessionID", suite.now).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}))
+
+	s, err := suite.store.New(suite.request("sessionID"), SessionCookieName)
+	suite.NoError(err)
+	suite.True(s.IsNew)
+	suite.Empty(s.ID)
+	suite.Empty(s.Values)
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestNewForgedCookie() {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "sessionID"})
+
+	s, err := suite.store.New(req, SessionCookieName)
+	suite.Error(err)
+	suite.True(s.IsNew)
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestSaveNew() {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	s, err := suite.store.New(req, SessionCookieName)
+	suite.NoError(err)
+	s.Values[UserIDKey] = "username"
+
+	suite.mock.
+		ExpectExec(`DELETE FROM au_sessions WHERE expires <= $1`).
+		WithArgs(suite.now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_sessions (session_id, data, expires) VALUES ($1, $2, $3) ON CONFLICT (session_id) DO UPDATE SET data = $2, expires = $3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), suite.now.Add(24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := httptest.NewRecorder()
+	suite.NoError(suite.store.Save(req, rec, s))
+	suite.NotEmpty(s.ID)
+
+	// the cookie contains the signed ID
+	cookies := rec.Result().Cookies()
+	suite.Require().Len(cookies, 1)
+	var id string
+	suite.NoError(securecookie.DecodeMulti(SessionCookieName, cookies[0].Value, &id, suite.store.codecs...))
+	suite.Equal(s.ID, id)
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestSaveRenew() {
+	var buf bytes.Buffer
+	suite.NoError(gob.NewEncoder(&buf).Encode(map[interface{}]interface{}{}))
+	suite.mock.
+		ExpectQuery(`SELECT data FROM au_sessions WHERE session_id = $1 AND expires > $2`).
+		WithArgs("sessionID", suite.now).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(buf.Bytes()))
+
+	req := suite.request("sessionID")
+	s, err := suite.store.New(req, SessionCookieName)
+	suite.NoError(err)
+	s.Values[UserIDKey] = "username"
+	s.Values[renewSessionKey] = true
+
+	suite.mock.
+		ExpectExec(`DELETE FROM au_sessions WHERE session_id = $1`).
+		WithArgs("sessionID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.
+		ExpectExec(`DELETE FROM au_sessions WHERE expires <= $1`).
+		WithArgs(suite.now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_sessions (session_id, data, expires) VALUES ($1, $2, $3) ON CONFLICT (session_id) DO UPDATE SET data = $2, expires = $3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), suite.now.Add(24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := httptest.NewRecorder()
+	suite.NoError(suite.store.Save(req, rec, s))
+	suite.NotEmpty(s.ID)
+	suite.NotEqual("sessionID", s.ID)
+	suite.NotContains(s.Values, renewSessionKey)
+
+	cookies := rec.Result().Cookies()
+	suite.Require().Len(cookies, 1)
+	var id string
+	suite.NoError(securecookie.DecodeMulti(SessionCookieName, cookies[0].Value, &id, suite.store.codecs...))
+	suite.Equal(s.ID, id)
+}
+
+func (suite *PostgresSessionStoreTestSuite) TestSaveDelete() {
+	var buf bytes.Buffer
+	suite.NoError(gob.NewEncoder(&buf).Encode(map[interface{}]interface{}{}))
+	suite.mock.
+		ExpectQuery(`SELECT data FROM au_sessions WHERE session_id = $1 AND expires > $2`).
+		WithArgs("sessionID", suite.now).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(buf.Bytes()))
+
+	req := suite.request("sessionID")
+	s, err := suite.store.New(req, SessionCookieName)
+	suite.NoError(err)
+	s.Options.MaxAge = -1
+
+	suite.mock.
+		ExpectExec(`DELETE FROM au_sessions WHERE session_id = $1`).
+		WithArgs("sessionID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := httptest.NewRecorder()
+	suite.NoError(suite.store.Save(req, rec, s))
+
+	cookies := rec.Result().Cookies()
+	suite.Require().Len(cookies, 1)
+	suite.Empty(cookies[0].Value)
+	suite.Equal(-1, cookies[0].MaxAge)
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ ShareLinkRepo = (*PostgresShareLinkRepo)(nil)
+
+type PostgresShareLinkRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresShareLinkRepo(p *PostgresDatabaseProvider) *PostgresShareLinkRepo {
+	return &PostgresShareLinkRepo{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresShareLinkRepo) Create(l ShareLink) error {
+	_, err := r.db.Exec(`INSERT INTO au_share_links (link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		l.ID, l.DocID, l.CreatedBy, l.Hash, l.PasswordHash, l.MaxDownloads, l.Downloads, l.Created, l.Expires, nullableTime{l.LastAccess, !l.LastAccess.IsZero()})
+	if isUniqueViolation(err) {
+		return fmt.Errorf("insert link: %v: %w", err, ErrConflict)
+	} else if isForeignKeyViolation(err) {
+		return fmt.Errorf("document %v: %w", l.DocID, ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("insert link: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresShareLinkRepo) Get(hash string) (ShareLink, error) {
+	row := r.db.QueryRow(`SELECT link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access, failed_attempts FROM au_share_links WHERE hash = $1`, hash)
+	l, err := scanShareLink(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, fmt.Errorf("link: %w", ErrNotFound)
+	}
+	return l, err
+}
+
+func (r *PostgresShareLinkRepo) List(doc DocID) ([]ShareLink, error) {
+	rows, err := r.db.Query(`SELECT link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access, failed_attempts FROM au_share_links WHERE doc_id = $1 ORDER BY created, link_id`, doc)
+	if err != nil {
+		return nil, fmt.Errorf("list links: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var links []ShareLink
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return links, nil
+}
+
+func (r *PostgresShareLinkRepo) Delete(doc DocID, id string) error {
+	res, err := r.db.Exec(`DELETE FROM au_share_links WHERE link_id = $1 AND doc_id = $2`, id, doc)
+	if err != nil {
+		return fmt.Errorf("delete link: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("link %v: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresShareLinkRepo) CountAccess(hash string, at time.Time) error {
+	// the conditions make concurrent downloads fail once the limit is reached
+	res, err := r.db.Exec(`UPDATE au_share_links SET downloads = downloads + 1, last_access = $2 WHERE hash = $1 AND expires > $2 AND (max_downloads = 0 OR downloads < max_downloads) AND failed_attempts < $3`, hash, at, maxShareLinkFailures)
+	if err != nil {
+		return fmt.Errorf("count access: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("link: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresShareLinkRepo) CountFailure(hash string) error {
+	res, err := r.db.Exec(`UPDATE au_share_links SET failed_attempts = failed_attempts + 1 WHERE hash = $1`, hash)
+	if err != nil {
+		return fmt.Errorf("count failure: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("link: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (r *PostgresShareLinkRepo) DeleteCreatedBy(user string) error {
+	if _, err := r.db.Exec(`DELETE FROM au_share_links WHERE created_by = $1`, user); err != nil {
+		return fmt.Errorf("delete links: %w", err)
+	}
+	return nil
+}
+
+func scanShareLink(row scanner) (ShareLink, error) {
+	var l ShareLink
+	var lastAccess nullableTime
+	if err := row.Scan(&l.ID, &l.DocID, &l.CreatedBy, &l.Hash, &l.PasswordHash, &l.MaxDownloads, &l.Downloads, &l.Created, &l.Expires, &lastAccess, &l.FailedAttempts); err != nil {
+		return ShareLink{}, fmt.Errorf("scan: %w", err)
+	}
+	if lastAccess.Valid {
+		l.LastAccess = lastAccess.Time
+	}
+	return l, nil
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresShareLinkRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresShareLinkRepoTestSuite))
+}
+
+type PostgresShareLinkRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresShareLinkRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresShareLinkRepo{suite.db}
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestCreate() {
+	created := time.Now()
+	expires := created.Add(time.Hour)
+
+	suite.mock.
+		ExpectExec(`INSERT INTO au_share_links (link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`).
+		WithArgs("linkID", "docID", "username", "hash", "", 3, 0, created, expires, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	suite.NoError(suite.repo.Create(ShareLink{
+		ID:           "linkID",
+		DocID:        "docID",
+		CreatedBy:    "username",
+		Hash:         "hash",
+		MaxDownloads: 3,
+		Created:      created,
+		Expires:      expires,
+	}))
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestCreateDocumentNotFound() {
+	suite.mock.
+		ExpectExec(`INSERT INTO au_share_links (link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`).
+		WillReturnError(&pq.Error{Code: "23503"})
+
+	err := suite.repo.Create(ShareLink{ID: "linkID", DocID: "docID"})
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestGet() {
+	created := time.Now()
+	expires := created.Add(time.Hour)
+	accessed := created.Add(time.Minute)
+
+	suite.mock.
+		ExpectQuery(`SELECT link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access, failed_attempts FROM au_share_links WHERE hash = $1`).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"link_id", "doc_id", "created_by", "hash", "password_hash", "max_downloads", "downloads", "created", "expires", "last_access", "failed_attempts"}).
+			AddRow("linkID", "docID", "username", "hash", "bcrypt", 3, 1, created, expires, accessed, 2))
+
+	l, err := suite.repo.Get("hash")
+	suite.NoError(err)
+	suite.Equal(ShareLink{
+		ID:             "linkID",
+		DocID:          "docID",
+		CreatedBy:      "username",
+		Hash:           "hash",
+		PasswordHash:   "bcrypt",
+		MaxDownloads:   3,
+		Downloads:      1,
+		Created:        created,
+		Expires:        expires,
+		LastAccess:     accessed,
+		FailedAttempts: 2,
+	}, l)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestGetNotFound() {
+	suite.mock.
+		ExpectQuery(`SELECT link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access, failed_attempts FROM au_share_links WHERE hash = $1`).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"link_id", "doc_id", "created_by", "hash", "password_hash", "max_downloads", "downloads", "created", "expires", "last_access", "failed_attempts"}))
+
+	_, err := suite.repo.Get("hash")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestList() {
+	created := time.Now()
+	expires := created.Add(time.Hour)
+
+	suite.mock.
+		ExpectQuery(`SELECT link_id, doc_id, created_by, hash, password_hash, max_downloads, downloads, created, expires, last_access, failed_attempts FROM au_share_links WHERE doc_id = $1 ORDER BY created, link_id`).
+		WithArgs("docID").
+		WillReturnRows(sqlmock.NewRows([]string{"link_id", "doc_id", "created_by", "hash", "password_hash", "max_downloads", "downloads", "created", "expires", "last_access", "failed_attempts"}).
+			AddRow("linkA", "docID", "username", "hashA", "", 0, 5, created, expires, nil, 0).
+			AddRow("linkB", "docID", "otheruser", "hashB", "", 1, 0, created, expires, nil, 0))
+
+	links, err := suite.repo.List("docID")
+	suite.NoError(err)
+	suite.Equal([]ShareLink{
+		{ID: "linkA", DocID: "docID", CreatedBy: "username", Hash: "hashA", Downloads: 5, Created: created, Expires: expires},
+		{ID: "linkB", DocID: "docID", CreatedBy: "otheruser", Hash: "hashB", MaxDownloads: 1, Created: created, Expires: expires},
+	}, links)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestDelete() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_share_links WHERE link_id = $1 AND doc_id = $2`).
+		WithArgs("linkID", "docID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.Delete("docID", "linkID"))
+
+	suite.mock.
+		ExpectExec(`DELETE FROM au_share_links WHERE link_id = $1 AND doc_id = $2`).
+		WithArgs("linkID", "otherdoc").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.ErrorIs(suite.repo.Delete("otherdoc", "linkID"), ErrNotFound)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestCountAccess() {
+	now := time.Now()
+
+	suite.mock.
+		ExpectExec(`UPDATE au_share_links SET downloads = downloads + 1, last_access = $2 WHERE hash = $1 AND expires > $2 AND (max_downloads = 0 OR downloads < max_downloads) AND failed_attempts < $3`).
+		WithArgs("hash", now, maxShareLinkFailures).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.CountAccess("hash", now))
+
+	// expired, used up or locked
+	suite.mock.
+		ExpectExec(`UPDATE au_share_links SET downloads = downloads + 1, last_access = $2 WHERE hash = $1 AND expires > $2 AND (max_downloads = 0 OR downloads < max_downloads) AND failed_attempts < $3`).
+		WithArgs("hash", now, maxShareLinkFailures).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.ErrorIs(suite.repo.CountAccess("hash", now), ErrNotFound)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestCountFailure() {
+	suite.mock.
+		ExpectExec(`UPDATE au_share_links SET failed_attempts = failed_attempts + 1 WHERE hash = $1`).
+		WithArgs("hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.NoError(suite.repo.CountFailure("hash"))
+
+	suite.mock.
+		ExpectExec(`UPDATE au_share_links SET failed_attempts = failed_attempts + 1 WHERE hash = $1`).
+		WithArgs("otherhash").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.ErrorIs(suite.repo.CountFailure("otherhash"), ErrNotFound)
+}
+
+func (suite *PostgresShareLinkRepoTestSuite) TestDeleteCreatedBy() {
+	suite.mock.
+		ExpectExec(`DELETE FROM au_share_links WHERE created_by = $1`).
+		WithArgs("username").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.NoError(suite.repo.DeleteCreatedBy("username"))
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+var _ TransferTokenRepo = (*PostgresTransferTokenRepo)(nil)
+
+type PostgresTransferTokenRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresTransferTokenRepo(p *PostgresDatabaseProvider) *PostgresTransferTokenRepo {
+	return &PostgresTransferTokenRepo{
+		db: p.DB,
+	}
+}
+
+func (r *PostgresTransferTokenRepo) Use(id string, expires, now time.Time) error {
+	return tx(r.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM au_transfer_tokens WHERE expires <= $1`, now); err != nil {
+			return fmt.Errorf("delete expired tokens: %w", err)
+		}
+		_, err := tx.Exec(`INSERT INTO au_transfer_tokens (token_id, expires) VALUES ($1, $2)`, id, expires)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("transfer token %v: %w", id, ErrConflict)
+		} else if err != nil {
+			return fmt.Errorf("insert token: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgresTransferTokenRepoTestSuite(t *testing.T) {
+	suite.Run(t, new(PostgresTransferTokenRepoTestSuite))
+}
+
+type PostgresTransferTokenRepoTestSuite struct {
+	suite.Suite
+
+	repo *PostgresTransferTokenRepo
+	mock sqlmock.Sqlmock
+	db   *sql.DB
+}
+
+func (suite *PostgresTransferTokenRepoTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	suite.NoError(err)
+
+	suite.mock = mock
+	suite.db = db
+	suite.repo = &PostgresTransferTokenRepo{suite.db}
+}
+
+func (suite *PostgresTransferTokenRepoTestSuite) TearDownTest() {
+	suite.NoError(suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresTransferTokenRepoTestSuite) TestUse() {
+	now := time.Now()
+	expires := now.Add(time.Minute)
+
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectExec(`DELETE FROM au_transfer_tokens WHERE expires <= $1`).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_transfer_tokens (token_id, expires) VALUES ($1, $2)`).
+		WithArgs("tokenID", expires).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	suite.NoError(suite.repo.Use("tokenID", expires, now))
+}
+
+func (suite *PostgresTransferTokenRepoTestSuite) TestUseTwice() {
+	now := time.Now()
+	expires := now.Add(time.Minute)
+
+	suite.mock.ExpectBegin()
+	suite.mock.
+		ExpectExec(`DELETE FROM au_transfer_tokens WHERE expires <= $1`).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.
+		ExpectExec(`INSERT INTO au_transfer_tokens (token_id, expires) VALUES ($1, $2)`).
+		WithArgs("tokenID", expires).
+		WillReturnError(&pq.Error{Code: "23505"})
+	suite.mock.ExpectRollback()
+
+	suite.ErrorIs(suite.repo.Use("tokenID", expires, now), ErrConflict)
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"fmt"
+	"io"
+)
+
+// Quota limits the storage that the documents of a user may take up. Quotas
+// are set for single users or for groups, see effectiveQuota.
+type Quota struct {
+	Principal Principal
+	// MaxBytes and MaxDocuments are 0 if unlimited.
+	MaxBytes     int64
+	MaxDocuments int64
+}
+
+// Usage is the storage that the documents of a user take up. It is tracked
+// by the DocumentRepo, the owner of a document is charged for it.
+type Usage struct {
+	Bytes     int64
+	Documents int64
+}
+
+type QuotaRepo interface {
+	// Set creates or replaces the quota of the principal.
+	Set(Quota) error
+	Delete(Principal) error
+	// List returns all quotas, ordered by principal.
+	List() ([]Quota, error)
+	// Find returns the quotas of the given user and groups, if set.
+	Find(user string, groups []string) ([]Quota, error)
+}
+
+// effectiveQuota returns the quota of the user. A quota of the user itself
+// takes precedence over those of its groups, of which the most generous
+// limit applies. If there are neither, the default applies.
+func effectiveQuota(quotas []Quota, user string, def Quota) Quota {
+	var groupQuota *Quota
+	for _, q := range quotas {
+		if q.Principal == UserPrincipal(user) {
+			return q
+		}
+		if groupQuota == nil {
+			q := q
+			groupQuota = &q
+			continue
+		}
+		groupQuota.MaxBytes = maxLimit(groupQuota.MaxBytes, q.MaxBytes)
+		groupQuota.MaxDocuments = maxLimit(groupQuota.MaxDocuments, q.MaxDocuments)
+	}
+	if groupQuota != nil {
+		return *groupQuota
+	}
+	return def
+}
+
+// maxLimit returns the more generous of two limits, where 0 is unlimited.
+func maxLimit(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// remainingBytes returns how many bytes may still be stored, or -1 if the
+// quota has no limit. The given number of bytes is about to be freed, e.g.
+// because content is replaced.
+func (q Quota) remainingBytes(u Usage, freed int64) int64 {
+	if q.MaxBytes == 0 {
+		return -1
+	}
+	if rem := q.MaxBytes - u.Bytes + freed; rem > 0 {
+		return rem
+	}
+	return 0
+}
+
+// checkDocuments fails with ErrQuotaExceeded if no more documents may be
+// created.
+func (q Quota) checkDocuments(u Usage) error {
+	if q.MaxDocuments != 0 && u.Documents >= q.MaxDocuments {
+		return fmt.Errorf("%d of %d documents: %w", u.Documents, q.MaxDocuments, ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// quotaOf returns the quota and the usage of the owner of documents. The
+// groups of the current user are used if it is the owner, otherwise they
+// are resolved if the auth service supports it.
+func (a *App) quotaOf(owner, user string, groups []string) (Quota, Usage, error) {
+	if owner != user {
+		groups = nil
+		if r, ok := a.auth.(GroupResolver); ok {
+			var err error
+			if groups, err = r.UserGroups(owner); err != nil {
+				return Quota{}, Usage{}, fmt.Errorf("resolve groups: %w", err)
+			}
+		}
+	}
+
+	quotas, err := a.quotas.Find(owner, groups)
+	if err != nil {
+		return Quota{}, Usage{}, fmt.Errorf("find quotas: %w", err)
+	}
+	usage, err := a.documents.Usage(owner)
+	if err != nil {
+		return Quota{}, Usage{}, fmt.Errorf("get usage: %w", err)
+	}
+	return effectiveQuota(quotas, owner, a.defaultQuota), usage, nil
+}
+
+// quotaReader fails with ErrQuotaExceeded as soon as more than limit bytes
+// are read through it, unless the limit is negative. Storages must not keep
+// content whose reader failed.
+type quotaReader struct {
+	rd    io.Reader
+	limit int64
+	n     int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n += int64(n)
+	if r.limit >= 0 && r.n > r.limit {
+		return n, fmt.Errorf("more than %d bytes: %w", r.limit, ErrQuotaExceeded)
+	}
+	return n, err
+}
@@ -1,12 +1,13 @@
 package app
 
 import (
-	"net/http"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,7 +18,7 @@ func (a *App) setupCORS() {
 
 	a.router.Use(cors.New(cors.Config{
 		AllowOrigins:     a.corsOrigins,
-		AllowHeaders:     []string{"Origin", "Content-Type"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", CSRFTokenHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -25,48 +26,212 @@ func (a *App) setupCORS() {
 }
 
 func (a *App) setupRoutes() {
-	store := cookie.NewStore([]byte("secret"))
-	a.router.Use(sessions.Sessions("SessionID", store))
+	spec, err := loadOpenAPISpec(OpenAPIJSON)
+	if err != nil {
+		// the document is embedded, so this can only happen during development
+		panic(fmt.Errorf("load OpenAPI document: %w", err))
+	}
+
+	a.router.Use(sessions.Sessions(SessionCookieName, a.sessionStore))
 
 	a.router.Use(func(c *gin.Context) {
 		// don't check the token for the these routes
 		switch c.FullPath() {
 		case "/rest/healthcheck",
+			"/rest/openapi.json",
 			"/rest/auth/challenge",
-			"/rest/auth/login":
+			"/rest/auth/login",
+			"/rest/auth/oidc/start",
+			"/rest/auth/oidc/callback",
+			"/rest/public/:token",
+			"/rest/transfer/:token":
+			return
+		case "/rest/dav",
+			"/rest/dav/*path":
+			// WebDAV clients use basic authentication, see HandlerWebDAV
+			return
+		}
+
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			a.authenticateAPIToken(c, strings.TrimPrefix(auth, "Bearer "))
 			return
 		}
 
 		sess := sessions.Default(c)
-		if sess.Get(UserIDKey) == nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, Response{
-				Success: false,
-				Message: "not logged in",
-			})
+		user, _ := sess.Get(UserIDKey).(string)
+		if user == "" {
+			abortWithError(c, ErrUnauthorized, "not logged in")
 			return
 		}
+
+		if err := a.refreshSession(sess); err != nil {
+			// the token may still be valid, so only give up if it isn't
+			a.log.Warn().Err(err).Msg("refresh session")
+		}
+
+		token, _ := sess.Get(UserIDTokenKey).(string)
+		if !a.auth.TokenValid(token) {
+			if err := a.endSession(sess); err != nil {
+				abortWithError(c, err, "unable to save session")
+				return
+			}
+			abortWithError(c, ErrUnauthorized, "session expired")
+			return
+		}
+		groups, _ := sess.Get(GroupsKey).([]string)
+		c.Set(UserIDKey, user)
+		c.Set(GroupsKey, groups)
 	})
 
+	a.router.Use(a.middlewareCSRF())
+	a.router.Use(middlewareValidateRequest(spec))
+
 	rest := a.router.Group("/rest")
 	{
 		rest.GET("/healthcheck", a.HandlerHealthcheck())
+		rest.GET("/openapi.json", a.HandlerOpenAPI())
+		rest.GET("/public/:token", a.HandlerGetPublic())
+		transfer := rest.Group("/transfer", a.middlewareTransferURLs())
+		{
+			transfer.GET("/:token", a.HandlerTransfer())
+			transfer.PUT("/:token", a.HandlerTransfer())
+		}
 		rest.GET("/user", a.HandlerUser())
+		rest.GET("/user/tokens", a.HandlerGetTokens())
+		rest.POST("/user/tokens", a.HandlerPostToken())
+		rest.DELETE("/user/tokens/:id", a.HandlerDeleteToken())
+		rest.POST("/user/mfa/totp", a.HandlerPostTOTP())
+		rest.POST("/user/mfa/totp/confirm", a.HandlerPostTOTPConfirm())
 		doc := rest.Group("/doc")
 		{
 			doc.GET("/:id/content", a.HandlerGetContent())
 			doc.POST("/:id/content", a.HandlerPostContent())
+			doc.GET("/:id/content/url", a.middlewareTransferURLs(), a.HandlerGetContentURL())
+			doc.POST("/:id/content/url", a.middlewareTransferURLs(), a.HandlerPostContentURL())
+			doc.POST("/:id/content/complete", a.middlewareTransferURLs(), a.HandlerPostContentComplete())
+			doc.POST("/:id/share", a.HandlerPostShare())
+			doc.GET("/:id/links", a.HandlerGetShareLinks())
+			doc.POST("/:id/links", a.HandlerPostShareLink())
+			doc.DELETE("/:id/links/:link", a.HandlerDeleteShareLink())
 
 			doc.GET("/:id", a.HandlerGetDocument())
 			doc.DELETE("/:id", a.HandlerDeleteDocument())
 
+			doc.POST("/archive", a.HandlerPostArchive())
+			doc.POST("/import", a.HandlerPostImport())
+			doc.GET("/import/:id", a.HandlerGetImport())
+
 			doc.GET("", a.HandlerGetDocuments())
 			doc.POST("", a.HandlerPostDocument())
 		}
+		jobs := rest.Group("/jobs")
+		{
+			jobs.GET("", a.HandlerGetJobs())
+			jobs.GET("/:id", a.HandlerGetJob())
+			jobs.POST("/:id/cancel", a.HandlerPostJobCancel())
+		}
+		admin := rest.Group("/admin", a.middlewareAdmin())
+		{
+			admin.GET("/quotas", a.HandlerGetQuotas())
+			admin.PUT("/quotas/:type/:name", a.HandlerPutQuota())
+			admin.DELETE("/quotas/:type/:name", a.HandlerDeleteQuota())
+
+			users := admin.Group("", a.middlewareUserAdmin())
+			users.GET("/users", a.HandlerGetUsers())
+			users.POST("/users", a.HandlerPostUser())
+			users.DELETE("/users/:name", a.HandlerDeleteUser())
+			users.POST("/users/:name/disable", a.HandlerPostUserEnabled(false))
+			users.POST("/users/:name/enable", a.HandlerPostUserEnabled(true))
+			users.POST("/users/:name/password", a.HandlerPostUserPassword())
+
+			users.GET("/groups", a.HandlerGetGroups())
+			users.POST("/groups", a.HandlerPostGroup())
+			users.DELETE("/groups/:name", a.HandlerDeleteGroup())
+			users.PUT("/groups/:name/members/:user", a.HandlerPutGroupMember())
+			users.DELETE("/groups/:name/members/:user", a.HandlerDeleteGroupMember())
+		}
+		dav := a.HandlerWebDAV("/rest/dav")
+		for _, method := range WebDAVMethods {
+			rest.Handle(method, "/dav", dav)
+			rest.Handle(method, "/dav/*path", dav)
+		}
 		auth := rest.Group("/auth")
 		{
 			auth.POST("/login", a.HandlerAuthLogin())
 			auth.GET("/logout", a.HandlerAuthLogout())
 			auth.POST("/challenge", a.HandlerAuthChallenge())
+			auth.GET("/csrf", a.HandlerCSRFToken())
+			auth.GET("/oidc/start", a.HandlerOIDCStart())
+			auth.GET("/oidc/callback", a.HandlerOIDCCallback())
 		}
 	}
 }
+
+// tokenRefreshThreshold is how long before its expiry the token of a session
+// is refreshed.
+const tokenRefreshThreshold = 5 * time.Minute
+
+// refreshSession obtains a new token for the session if the current one is
+// about to expire and the session has a refresh token.
+func (a *App) refreshSession(sess sessions.Session) error {
+	user, _ := sess.Get(UserIDKey).(string)
+	refreshToken, _ := sess.Get(RefreshTokenKey).(string)
+	expiry, _ := sess.Get(TokenExpiryKey).(int64)
+	if refreshToken == "" || expiry == 0 {
+		return nil
+	}
+	if a.clock.Now().Add(tokenRefreshThreshold).Before(time.Unix(expiry, 0)) {
+		return nil
+	}
+
+	res, err := a.auth.Refresh(user, refreshToken)
+	if err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	setSessionLogin(sess, user, res)
+	if err := sess.Save(); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	return nil
+}
+
+// authenticateAPIToken authenticates a request with the secret of an API
+// token. The route must be allowed by the scopes of the token.
+func (a *App) authenticateAPIToken(c *gin.Context, secret string) {
+	t, err := a.apiTokens.Get(hashAPIToken(secret))
+	if errors.Is(err, ErrNotFound) {
+		abortWithError(c, ErrUnauthorized, "invalid token")
+		return
+	} else if err != nil {
+		abortWithError(c, err, "unable to check token")
+		return
+	}
+	if !a.clock.Now().Before(t.Expires) {
+		abortWithError(c, ErrUnauthorized, "token expired")
+		return
+	}
+
+	scope, ok := apiTokenRouteScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		abortWithError(c, ErrForbidden, "route can't be used with a token")
+		return
+	}
+	if !t.hasScope(scope) {
+		abortWithError(c, ErrForbidden, fmt.Sprintf("token lacks scope %q", scope))
+		return
+	}
+
+	// there is no session with the groups of a login, so they have to be
+	// looked up for every request
+	var groups []string
+	if r, ok := a.auth.(GroupResolver); ok {
+		if groups, err = r.UserGroups(t.Owner); err != nil {
+			abortWithError(c, err, "unable to resolve groups")
+			return
+		}
+	}
+
+	c.Set(UserIDKey, t.Owner)
+	c.Set(GroupsKey, groups)
+	c.Set(apiTokenKey, t)
+}
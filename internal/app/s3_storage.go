@@ -1,12 +1,19 @@
 package app
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/smithy-go"
 	"github.com/tsatke/verbose-broccoli/internal/app/config"
@@ -21,15 +28,23 @@ type s3StorageClientAPI interface {
 	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 }
 
+type s3PresignAPI interface {
+	PresignGetObject(context.Context, *s3.GetObjectInput, ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignPutObject(context.Context, *s3.PutObjectInput, ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
 type S3Storage struct {
-	bucket string
-	client s3StorageClientAPI
+	bucket    string
+	client    s3StorageClientAPI
+	presigner s3PresignAPI
 }
 
 func NewS3Storage(cfg config.Config) *S3Storage {
+	client := s3.NewFromConfig(cfg.AWS)
 	return &S3Storage{
-		bucket: cfg.GetString(config.AWSS3Bucket),
-		client: s3.NewFromConfig(cfg.AWS),
+		bucket:    cfg.GetString(config.AWSS3Bucket),
+		client:    client,
+		presigner: s3.NewPresignClient(client),
 	}
 }
 
@@ -39,7 +54,7 @@ func (s *S3Storage) Create(docID DocID, rd io.Reader) error {
 		Key:    aws.String(string(docID)),
 	})
 	if err == nil {
-		return fmt.Errorf("object already exists")
+		return fmt.Errorf("object %v: %w", docID, ErrConflict)
 	} else if !smithyCodeIs(err, "NotFound") {
 		return fmt.Errorf("head object: %w", err)
 	}
@@ -60,7 +75,29 @@ func (s *S3Storage) Read(docID DocID) (io.ReadCloser, error) {
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(string(docID)),
 	})
-	if err != nil {
+	if smithyCodeIs(err, "NoSuchKey") {
+		return nil, fmt.Errorf("object %v: %w", docID, ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	return res.Body, nil
+}
+
+func (s *S3Storage) ReadRange(docID DocID, offset, length int64) (io.ReadCloser, error) {
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length == 0 {
+		return readCloserWrapper{bytes.NewReader(nil)}, nil
+	} else if length > 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+	res, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(string(docID)),
+		Range:  aws.String(rng),
+	})
+	if smithyCodeIs(err, "NoSuchKey") {
+		return nil, fmt.Errorf("object %v: %w", docID, ErrNotFound)
+	} else if err != nil {
 		return nil, fmt.Errorf("get object: %w", err)
 	}
 	return res.Body, nil
@@ -71,7 +108,9 @@ func (s *S3Storage) Update(docID DocID, rd io.Reader) error {
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(string(docID)),
 	})
-	if err != nil {
+	if smithyCodeIs(err, "NotFound") {
+		return fmt.Errorf("object %v: %w", docID, ErrNotFound)
+	} else if err != nil {
 		return fmt.Errorf("head object: %w", err)
 	}
 
@@ -97,6 +136,73 @@ func (s *S3Storage) Delete(docID DocID) error {
 	return nil
 }
 
+func (s *S3Storage) Stat(docID DocID) (ObjectInfo, error) {
+	res, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(string(docID)),
+	})
+	if smithyCodeIs(err, "NotFound") {
+		return ObjectInfo{}, fmt.Errorf("object %v: %w", docID, ErrNotFound)
+	} else if err != nil {
+		return ObjectInfo{}, fmt.Errorf("head object: %w", err)
+	}
+
+	info := ObjectInfo{Size: res.ContentLength}
+	// the ETag of objects that were uploaded in one part without KMS
+	// encryption is the MD5 hash of the content, other ETags have a suffix
+	if etag := strings.Trim(aws.ToString(res.ETag), `"`); len(etag) == 32 {
+		if _, err := hex.DecodeString(etag); err == nil {
+			info.MD5 = etag
+		}
+	}
+	return info, nil
+}
+
+func (s *S3Storage) PresignGet(docID DocID, ttl time.Duration) (PresignedRequest, error) {
+	req, err := s.presigner.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(string(docID)),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return PresignedRequest{}, fmt.Errorf("presign get object: %w", err)
+	}
+	return newPresignedRequest(req), nil
+}
+
+func (s *S3Storage) PresignPut(docID DocID, size int64, md5 string, ttl time.Duration) (PresignedRequest, error) {
+	in := &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(string(docID)),
+		ContentLength: size,
+	}
+	if md5 != "" {
+		sum, err := hex.DecodeString(md5)
+		if err != nil {
+			return PresignedRequest{}, fmt.Errorf("decode MD5: %v: %w", err, ErrValidation)
+		}
+		// S3 rejects content with another hash
+		in.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum))
+	}
+
+	req, err := s.presigner.PresignPutObject(context.Background(), in, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return PresignedRequest{}, fmt.Errorf("presign put object: %w", err)
+	}
+	return newPresignedRequest(req), nil
+}
+
+func newPresignedRequest(req *v4.PresignedHTTPRequest) PresignedRequest {
+	header := req.SignedHeader.Clone()
+	// clients set these themselves
+	header.Del("Host")
+	header.Del("Content-Length")
+	return PresignedRequest{
+		Method: req.Method,
+		URL:    req.URL,
+		Header: header,
+	}
+}
+
 func smithyCodeIs(err error, expected string) bool {
 	var ae smithy.APIError
 	if errors.As(err, &ae) {
@@ -6,7 +6,9 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/mock"
@@ -31,6 +33,13 @@ func (suite *S3StorageTestSuite) SetupTest() {
 	suite.storage = &S3Storage{
 		bucket: suite.bucket,
 		client: suite.client,
+		// presigning happens locally, so a real client can be used
+		presigner: s3.NewPresignClient(s3.New(s3.Options{
+			Region: "eu-central-1",
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+			}),
+		})),
 	}
 }
 
@@ -122,7 +131,7 @@ func (suite *S3StorageTestSuite) TestCreateAlreadyExists() {
 		Return(nil, nil).
 		Once()
 
-	suite.Error(suite.storage.Create("abc", strings.NewReader("content")))
+	suite.ErrorIs(suite.storage.Create("abc", strings.NewReader("content")), ErrConflict)
 }
 
 func (suite *S3StorageTestSuite) TestCreateAPIErrInHead() {
@@ -188,6 +197,54 @@ func (suite *S3StorageTestSuite) TestRead() {
 	suite.Equal(content, string(data))
 }
 
+func (suite *S3StorageTestSuite) TestReadRange() {
+	content := "world"
+	suite.client.
+		On("GetObject",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *s3.GetObjectInput) bool {
+				return suite.Equal("abc", *i.Key) &&
+					suite.Equal(suite.bucket, *i.Bucket) &&
+					suite.Equal("bytes=6-10", *i.Range)
+			}),
+		).
+		Return(
+			&s3.GetObjectOutput{
+				Body: readCloserWrapper{strings.NewReader(content)},
+			},
+			nil,
+		).
+		Once()
+
+	rd, err := suite.storage.ReadRange("abc", 6, 5)
+	suite.NoError(err)
+
+	data, err := io.ReadAll(rd)
+	suite.NoError(err)
+	suite.Equal(content, string(data))
+}
+
+func (suite *S3StorageTestSuite) TestReadRangeToEnd() {
+	suite.client.
+		On("GetObject",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *s3.GetObjectInput) bool {
+				return suite.Equal("bytes=6-", *i.Range)
+			}),
+		).
+		Return(
+			&s3.GetObjectOutput{
+				Body: readCloserWrapper{strings.NewReader("world")},
+			},
+			nil,
+		).
+		Once()
+
+	rd, err := suite.storage.ReadRange("abc", 6, -1)
+	suite.NoError(err)
+	suite.NoError(rd.Close())
+}
+
 func (suite *S3StorageTestSuite) TestReadErrInGet() {
 	suite.client.
 		On("GetObject",
@@ -211,6 +268,50 @@ func (suite *S3StorageTestSuite) TestReadErrInGet() {
 	suite.Nil(rd)
 }
 
+func (suite *S3StorageTestSuite) TestReadNotExists() {
+	suite.client.
+		On("GetObject",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *s3.GetObjectInput) bool {
+				return suite.Equal("abc", *i.Key) &&
+					suite.Equal(suite.bucket, *i.Bucket)
+			}),
+		).
+		Return(
+			nil,
+			&smithy.GenericAPIError{
+				Code:    "NoSuchKey",
+				Message: "test message",
+			},
+		).
+		Once()
+
+	rd, err := suite.storage.Read("abc")
+	suite.ErrorIs(err, ErrNotFound)
+	suite.Nil(rd)
+}
+
+func (suite *S3StorageTestSuite) TestUpdateNotExists() {
+	suite.client.
+		On("HeadObject",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *s3.HeadObjectInput) bool {
+				return suite.Equal("abc", *i.Key) &&
+					suite.Equal(suite.bucket, *i.Bucket)
+			}),
+		).
+		Return(
+			nil,
+			&smithy.GenericAPIError{
+				Code:    "NotFound",
+				Message: "test message",
+			},
+		).
+		Once()
+
+	suite.ErrorIs(suite.storage.Update("abc", strings.NewReader("content")), ErrNotFound)
+}
+
 func (suite *S3StorageTestSuite) TestUpdate() {
 	suite.client.
 		On("HeadObject",
@@ -325,3 +426,70 @@ func (suite *S3StorageTestSuite) TestDeleteErrInDelete() {
 
 	suite.Error(suite.storage.Delete("abc"))
 }
+
+func (suite *S3StorageTestSuite) TestStat() {
+	suite.client.
+		On("HeadObject",
+			mock.IsType(context.Background()),
+			mock.MatchedBy(func(i *s3.HeadObjectInput) bool {
+				return suite.Equal("abc", *i.Key) &&
+					suite.Equal(suite.bucket, *i.Bucket)
+			}),
+		).
+		Return(&s3.HeadObjectOutput{
+			ContentLength: 5,
+			ETag:          aws.String(`"5d41402abc4b2a76b9719d911017c592"`),
+		}, nil).
+		Once()
+
+	info, err := suite.storage.Stat("abc")
+	suite.NoError(err)
+	suite.Equal(ObjectInfo{Size: 5, MD5: "5d41402abc4b2a76b9719d911017c592"}, info)
+}
+
+func (suite *S3StorageTestSuite) TestStatMultipart() {
+	suite.client.
+		On("HeadObject", mock.Anything, mock.Anything).
+		Return(&s3.HeadObjectOutput{
+			ContentLength: 5,
+			ETag:          aws.String(`"5d41402abc4b2a76b9719d911017c592-2"`),
+		}, nil).
+		Once()
+
+	// the ETag of multipart uploads isn't the MD5 hash of the content
+	info, err := suite.storage.Stat("abc")
+	suite.NoError(err)
+	suite.Equal(ObjectInfo{Size: 5}, info)
+}
+
+func (suite *S3StorageTestSuite) TestStatNotFound() {
+	suite.client.
+		On("HeadObject", mock.Anything, mock.Anything).
+		Return(nil, &smithy.GenericAPIError{Code: "NotFound"}).
+		Once()
+
+	_, err := suite.storage.Stat("abc")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *S3StorageTestSuite) TestPresignGet() {
+	req, err := suite.storage.PresignGet("abc", 15*time.Minute)
+	suite.NoError(err)
+	suite.Equal("GET", req.Method)
+	suite.True(strings.HasPrefix(req.URL, "https://test-bucket.s3.eu-central-1.amazonaws.com/abc?"), req.URL)
+	suite.Contains(req.URL, "X-Amz-Expires=900")
+	suite.Empty(req.Header.Get("Host"))
+}
+
+func (suite *S3StorageTestSuite) TestPresignPut() {
+	req, err := suite.storage.PresignPut("abc", 5, "5d41402abc4b2a76b9719d911017c592", 15*time.Minute)
+	suite.NoError(err)
+	suite.Equal("PUT", req.Method)
+	suite.True(strings.HasPrefix(req.URL, "https://test-bucket.s3.eu-central-1.amazonaws.com/abc?"), req.URL)
+	// the hash is signed, so S3 rejects other content
+	suite.Equal("XUFAKrxLKna5cZ2REBfFkg==", req.Header.Get("Content-Md5"))
+	suite.Empty(req.Header.Get("Content-Length"))
+
+	_, err = suite.storage.PresignPut("abc", 5, "nothex", 15*time.Minute)
+	suite.ErrorIs(err, ErrValidation)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gorilla/securecookie"
+	"github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+// SessionCookieName is the name of the cookie that holds the session, or
+// its ID if the session is stored on the server.
+const SessionCookieName = "SessionID"
+
+// DefaultSessionOptions are the options of the session cookie if the App is
+// created without WithSessionOptions.
+var DefaultSessionOptions = sessions.Options{
+	Path:     "/",
+	MaxAge:   int((24 * time.Hour).Seconds()),
+	HttpOnly: true,
+	SameSite: http.SameSiteLaxMode,
+}
+
+// NewSessionOptions returns the options of the session cookie from the
+// config.
+func NewSessionOptions(cfg config.Config) (sessions.Options, error) {
+	opts := sessions.Options{
+		Path:     "/",
+		Domain:   cfg.GetString(config.SessionCookieDomain),
+		MaxAge:   int(cfg.GetDuration(config.SessionCookieMaxAge).Seconds()),
+		Secure:   cfg.GetBool(config.SessionCookieSecure),
+		HttpOnly: cfg.GetBool(config.SessionCookieHTTPOnly),
+	}
+
+	switch sameSite := strings.ToLower(cfg.GetString(config.SessionCookieSameSite)); sameSite {
+	case "lax":
+		opts.SameSite = http.SameSiteLaxMode
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		// browsers reject SameSite=None cookies that aren't secure
+		if !opts.Secure {
+			return sessions.Options{}, fmt.Errorf("%v none requires %v", config.SessionCookieSameSite, config.SessionCookieSecure)
+		}
+		opts.SameSite = http.SameSiteNoneMode
+	default:
+		return sessions.Options{}, fmt.Errorf("unknown %v %q", config.SessionCookieSameSite, sameSite)
+	}
+	return opts, nil
+}
+
+// NewCookieSessionStore creates a store that keeps the session in a signed
+// and encrypted cookie, with the keys from the config.
+func NewCookieSessionStore(cfg config.Config) (sessions.Store, error) {
+	pairs, err := sessionKeyPairs(cfg.GetStringSlice(config.SessionKeys))
+	if err != nil {
+		return nil, err
+	}
+	return cookie.NewStore(pairs...), nil
+}
+
+// sessionKeyPairs parses the keys of config.SessionKeys into the key pairs
+// of securecookie.CodecsFromPairs. Without keys, a random pair is returned.
+func sessionKeyPairs(keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return [][]byte{
+			securecookie.GenerateRandomKey(64),
+			securecookie.GenerateRandomKey(32),
+		}, nil
+	}
+
+	var pairs [][]byte
+	for i, key := range keys {
+		parts := strings.SplitN(key, ":", 2)
+
+		signingKey, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("session key %d: decode signing key: %w", i, err)
+		}
+		if len(signingKey) < 32 {
+			return nil, fmt.Errorf("session key %d: signing key must have at least 32 bytes", i)
+		}
+
+		var encryptionKey []byte
+		if len(parts) == 2 {
+			encryptionKey, err = base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("session key %d: decode encryption key: %w", i, err)
+			}
+			if n := len(encryptionKey); n != 16 && n != 24 && n != 32 {
+				return nil, fmt.Errorf("session key %d: encryption key must have 16, 24 or 32 bytes", i)
+			}
+		}
+		pairs = append(pairs, signingKey, encryptionKey)
+	}
+	return pairs, nil
+}
+
+// renewSessionKey is the session value that makes the PostgresSessionStore
+// give the session a new ID when it's saved.
+const renewSessionKey = "RenewSession"
+
+// renewSession gives the session a new ID when it's saved, so that an ID
+// that was known before a login isn't logged in. Sessions that are kept in a
+// cookie don't have an ID and are replaced with every change anyway.
+func (a *App) renewSession(sess sessions.Session) {
+	if _, ok := a.sessionStore.(*PostgresSessionStore); ok {
+		sess.Set(renewSessionKey, true)
+	}
+}
+
+// endSession deletes the values of the session and the session cookie. A
+// session store on the server also deletes the session, so that copies of
+// the cookie can't be used anymore.
+func (a *App) endSession(sess sessions.Session) error {
+	sess.Clear()
+	opts := a.sessionOptions
+	opts.MaxAge = -1
+	sess.Options(opts)
+	return sess.Save()
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/google/uuid"
+	"github.com/gorilla/securecookie"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appcfg "github.com/tsatke/verbose-broccoli/internal/app/config"
+)
+
+func TestSessionKeyPairs(t *testing.T) {
+	signingKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	encryptionKey := base64.StdEncoding.EncodeToString(make([]byte, 16))
+
+	for _, tt := range []struct {
+		keys  []string
+		pairs int
+		err   string
+	}{
+		{nil, 2, ""},
+		{[]string{signingKey}, 2, ""},
+		{[]string{signingKey + ":" + encryptionKey, signingKey}, 4, ""},
+		{[]string{"not base64"}, 0, "session key 0: decode signing key"},
+		{[]string{base64.StdEncoding.EncodeToString([]byte("secret"))}, 0, "session key 0: signing key must have at least 32 bytes"},
+		{[]string{signingKey, signingKey + ":" + signingKey[:8]}, 0, "session key 1: encryption key must have 16, 24 or 32 bytes"},
+	} {
+		pairs, err := sessionKeyPairs(tt.keys)
+		if tt.err != "" {
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Len(t, pairs, tt.pairs)
+	}
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)) + ":" +
+		base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	newKey := base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+
+	store := func(keys ...string) sessions.Store {
+		vp := viper.New()
+		vp.Set(appcfg.SessionKeys, keys)
+		s, err := NewCookieSessionStore(appcfg.Config{Viper: vp})
+		require.NoError(t, err)
+		return s
+	}
+	before := store(oldKey)
+	after := store(newKey, oldKey)
+	removed := store(newKey)
+
+	// a cookie from before the rotation
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	s, err := before.New(req, SessionCookieName)
+	require.NoError(t, err)
+	s.Values[UserIDKey] = "user"
+	rec := httptest.NewRecorder()
+	require.NoError(t, before.Save(req, rec, s))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", rec.Header().Get("Set-Cookie"))
+
+	s, err = after.New(req, SessionCookieName)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", s.Values[UserIDKey])
+
+	s, err = removed.New(req, SessionCookieName)
+	assert.Error(t, err)
+	assert.True(t, s.IsNew)
+	assert.Empty(t, s.Values)
+}
+
+func TestNewSessionOptions(t *testing.T) {
+	vp := viper.New()
+	vp.Set(appcfg.SessionCookieSecure, false)
+	vp.Set(appcfg.SessionCookieHTTPOnly, true)
+	vp.Set(appcfg.SessionCookieSameSite, "Strict")
+	vp.Set(appcfg.SessionCookieMaxAge, "1h")
+	vp.Set(appcfg.SessionCookieDomain, "docs.example.com")
+
+	opts, err := NewSessionOptions(appcfg.Config{Viper: vp})
+	assert.NoError(t, err)
+	assert.Equal(t, sessions.Options{
+		Path:     "/",
+		Domain:   "docs.example.com",
+		MaxAge:   3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}, opts)
+
+	vp.Set(appcfg.SessionCookieSameSite, "none")
+	_, err = NewSessionOptions(appcfg.Config{Viper: vp})
+	assert.Error(t, err)
+
+	vp.Set(appcfg.SessionCookieSameSite, "sometimes")
+	_, err = NewSessionOptions(appcfg.Config{Viper: vp})
+	assert.Error(t, err)
+}
+
+func (suite *AppSuite) TestForgedSessionCookie() {
+	// the key that sessions used to be signed with
+	forged, err := securecookie.New([]byte("secret"), nil).Encode(SessionCookieName, map[interface{}]interface{}{
+		UserIDKey:      "admin",
+		UserIDTokenKey: "token",
+	})
+	suite.NoError(err)
+
+	suite.
+		Get("/user").
+		Header("Cookie", SessionCookieName+"="+forged).
+		ExpectJSON(http.StatusUnauthorized, M{
+			"success": false,
+			"code":    "unauthorized",
+			"message": "not logged in",
+		})
+}
+
+func (suite *AppSuite) TestSessionCookieOptions() {
+	user, pass := uuid.New().String(), uuid.New().String()
+	suite.createUser(user, pass)
+
+	suite.
+		Post("/auth/login").
+		BodyJSON(M{
+			"username": user,
+			"password": pass,
+		}).
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.NoError(res.Body.Close())
+
+			cookie := res.Header.Get("Set-Cookie")
+			suite.True(strings.HasPrefix(cookie, SessionCookieName+"="), cookie)
+			suite.Contains(cookie, "Path=/")
+			suite.Contains(cookie, "Max-Age=86400")
+			suite.Contains(cookie, "HttpOnly")
+			suite.Contains(cookie, "SameSite=Lax")
+		})
+
+	suite.
+		Get("/auth/logout").
+		ExpectCustom(func(res *http.Response) {
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.NoError(res.Body.Close())
+
+			// the cookie is deleted
+			suite.Contains(res.Header.Get("Set-Cookie"), "Max-Age=0")
+		})
+}
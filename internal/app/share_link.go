@@ -0,0 +1,57 @@
+package app
+
+import "time"
+
+// maxShareLinkFailures is how many wrong passwords lock a share link.
+const maxShareLinkFailures = 10
+
+// ShareLink gives everybody who knows its secret access to the content of a
+// document, without an account. Like for API tokens, only the hash of the
+// secret is stored.
+type ShareLink struct {
+	ID        string
+	DocID     DocID
+	CreatedBy string
+	Hash      string
+	// PasswordHash is the bcrypt hash of the password that has to be given
+	// in addition to the secret, empty if the link has no password.
+	PasswordHash string
+	// MaxDownloads is how often the link can be used, 0 if unlimited.
+	MaxDownloads int
+	Downloads    int
+	Created      time.Time
+	Expires      time.Time
+	// LastAccess is when the link was used last, zero if never.
+	LastAccess time.Time
+	// FailedAttempts counts the wrong passwords that were given for the
+	// link. The link can't be used anymore after maxShareLinkFailures.
+	FailedAttempts int
+}
+
+type ShareLinkRepo interface {
+	Create(ShareLink) error
+	// Get returns the link with the given hash.
+	Get(hash string) (ShareLink, error)
+	// List returns the links of the given document, ordered by creation.
+	List(doc DocID) ([]ShareLink, error)
+	// Delete deletes the link with the given ID, if it belongs to the
+	// given document.
+	Delete(doc DocID, id string) error
+	// CountAccess counts a download of the link with the given hash. It
+	// fails with ErrNotFound if the link is expired or has no downloads
+	// left at the given time, so that concurrent downloads can't exceed
+	// MaxDownloads.
+	CountAccess(hash string, at time.Time) error
+	// CountFailure counts a wrong password for the link with the given
+	// hash.
+	CountFailure(hash string) error
+	// DeleteCreatedBy deletes all links that the given user created.
+	DeleteCreatedBy(user string) error
+}
+
+// usable reports whether the link can be used at the given time.
+func (l ShareLink) usable(now time.Time) bool {
+	return now.Before(l.Expires) &&
+		(l.MaxDownloads == 0 || l.Downloads < l.MaxDownloads) &&
+		l.FailedAttempts < maxShareLinkFailures
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238, appendix B, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	for _, tt := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		assert.Equal(t, tt.code, totpCode(secret, totpStep(time.Unix(tt.unix, 0))), tt.unix)
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	got, ok := matchTOTP(secret, "050471", now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// codes of the neighbouring steps are accepted
+	_, ok = matchTOTP(secret, "050471", now.Add(totpPeriod), 0)
+	assert.True(t, ok)
+	_, ok = matchTOTP(secret, "050471", now.Add(-totpPeriod), 0)
+	assert.True(t, ok)
+	_, ok = matchTOTP(secret, "050471", now.Add(2*totpPeriod), 0)
+	assert.False(t, ok)
+
+	// used codes are rejected
+	_, ok = matchTOTP(secret, "050471", now, step)
+	assert.False(t, ok)
+
+	_, ok = matchTOTP(secret, "000000", now, 0)
+	assert.False(t, ok)
+	_, ok = matchTOTP(secret, "50471", now, 0)
+	assert.False(t, ok)
+	_, ok = matchTOTP("not base32!", "050471", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	secret, err := newTOTPSecret()
+	require.NoError(t, err)
+
+	u, err := url.Parse(totpProvisioningURI("verbose broccoli", "alice", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/verbose broccoli:alice", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "verbose broccoli", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
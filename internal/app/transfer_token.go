@@ -0,0 +1,12 @@
+package app
+
+import "time"
+
+// TransferTokenRepo records the transfer URLs for uploads that were used,
+// so that each of them can only be used once, see HandlerTransfer.
+type TransferTokenRepo interface {
+	// Use records the token with the given ID as used. It fails with
+	// ErrConflict if the token was used before. Tokens that are expired
+	// at the given time are forgotten, since they can't be used anymore.
+	Use(id string, expires, now time.Time) error
+}
@@ -0,0 +1,684 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/webdav"
+)
+
+var _ webdav.FileSystem = (*webdavFileSystem)(nil)
+
+// webdavFileSystem exposes all documents that a user is allowed to read as a
+// webdav.FileSystem. Directories are derived from the slash separated document
+// names. Empty directories are stored as document headers whose name ends with
+// a slash and which never have any content.
+type webdavFileSystem struct {
+	user      string
+	groups    []string
+	clock     Clock
+	genUUID   func() uuid.UUID
+	documents DocumentRepo
+	objects   ObjectStorage
+	// quotaOf returns the quota and the usage of the owner of documents.
+	quotaOf func(owner string) (Quota, Usage, error)
+	// body is the body of the request, which files are written from.
+	body *webdavBody
+}
+
+func (a *App) newWebDAVFileSystem(user string, groups []string, body *webdavBody) *webdavFileSystem {
+	return &webdavFileSystem{
+		user:      user,
+		groups:    groups,
+		body:      body,
+		clock:     a.clock,
+		genUUID:   a.genUUID,
+		documents: a.documents,
+		objects:   a.objects,
+		quotaOf: func(owner string) (Quota, Usage, error) {
+			return a.quotaOf(owner, user, groups)
+		},
+	}
+}
+
+func (fs *webdavFileSystem) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	key := webdavKey(name)
+	if key == "" {
+		return os.ErrExist
+	}
+
+	headers, err := fs.documents.List(fs.user, fs.groups)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	if _, _, ok := webdavLookup(fs.user, headers, key); ok {
+		return os.ErrExist
+	}
+	if !webdavIsDir(headers, path.Dir(key)) {
+		return os.ErrNotExist
+	}
+	if err := fs.checkDocuments(); err != nil {
+		return err
+	}
+
+	return fs.documents.Create(DocumentHeader{
+		ID:      DocID(fs.genUUID().String()),
+		Name:    key + "/",
+		Owner:   fs.user,
+		Created: fs.clock.Now(),
+	}, ownerACL(fs.user))
+}
+
+func (fs *webdavFileSystem) OpenFile(_ context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	key := webdavKey(name)
+
+	headers, err := fs.documents.List(fs.user, fs.groups)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	header, isDir, exists := webdavLookup(fs.user, headers, key)
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if !exists {
+			return nil, os.ErrNotExist
+		}
+		if isDir {
+			return &webdavDir{
+				info:     webdavDirInfo(key, headers),
+				children: webdavChildren(fs.user, headers, key),
+			}, nil
+		}
+		return &webdavReader{
+			fs:     fs,
+			header: header,
+		}, nil
+	}
+
+	if isDir {
+		return nil, os.ErrInvalid
+	}
+
+	if exists {
+		if flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+		perm, err := fs.permission(header.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !perm.Write {
+			return nil, os.ErrPermission
+		}
+		w, err := fs.newWriter(header, false)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	if flag&os.O_CREATE == 0 || key == "" {
+		return nil, os.ErrNotExist
+	}
+	if !webdavIsDir(headers, path.Dir(key)) {
+		return nil, os.ErrNotExist
+	}
+	if err := fs.checkDocuments(); err != nil {
+		return nil, err
+	}
+
+	w, err := fs.newWriter(DocumentHeader{
+		ID:      DocID(fs.genUUID().String()),
+		Name:    key,
+		Owner:   fs.user,
+		Created: fs.clock.Now(),
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (fs *webdavFileSystem) RemoveAll(_ context.Context, name string) error {
+	key := webdavKey(name)
+	if key == "" {
+		return os.ErrPermission
+	}
+
+	headers, err := fs.documents.List(fs.user, fs.groups)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+
+	affected := webdavAffected(fs.user, headers, key)
+	for _, h := range affected {
+		perm, err := fs.permission(h.ID)
+		if err != nil {
+			return err
+		}
+		if !perm.Delete {
+			return os.ErrPermission
+		}
+	}
+
+	for _, h := range affected {
+		if err := fs.documents.Delete(h.ID); err != nil {
+			return fmt.Errorf("delete document: %w", err)
+		}
+		if !h.Updated.IsZero() {
+			if err := fs.objects.Delete(h.ID); err != nil {
+				return fmt.Errorf("delete object: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *webdavFileSystem) Rename(_ context.Context, oldName, newName string) error {
+	oldKey, newKey := webdavKey(oldName), webdavKey(newName)
+	if oldKey == "" || newKey == "" {
+		return os.ErrInvalid
+	}
+
+	headers, err := fs.documents.List(fs.user, fs.groups)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	if _, _, ok := webdavLookup(fs.user, headers, newKey); ok {
+		return os.ErrExist
+	}
+	if !webdavIsDir(headers, path.Dir(newKey)) {
+		return os.ErrNotExist
+	}
+
+	affected := webdavAffected(fs.user, headers, oldKey)
+	if len(affected) == 0 {
+		return os.ErrNotExist
+	}
+
+	acls := make([]ACL, len(affected))
+	for i, h := range affected {
+		acl, err := fs.documents.ACL(h.ID)
+		if err != nil {
+			return fmt.Errorf("get ACL: %w", err)
+		}
+		if perm, _ := acl.Effective(fs.user, fs.groups); !perm.Write {
+			return os.ErrPermission
+		}
+		acls[i] = acl
+	}
+
+	for i, h := range affected {
+		h.Name = newKey + strings.TrimPrefix(h.Name, oldKey)
+		if err := fs.documents.Update(h, acls[i]); err != nil {
+			return fmt.Errorf("update document: %w", err)
+		}
+	}
+	return nil
+}
+
+func (fs *webdavFileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	key := webdavKey(name)
+
+	headers, err := fs.documents.List(fs.user, fs.groups)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	header, isDir, ok := webdavLookup(fs.user, headers, key)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if isDir {
+		return webdavDirInfo(key, headers), nil
+	}
+	return webdavFileInfoFor(header), nil
+}
+
+func (fs *webdavFileSystem) permission(id DocID) (Permission, error) {
+	acl, err := fs.documents.ACL(id)
+	if err != nil {
+		return Permission{}, fmt.Errorf("get ACL: %w", err)
+	}
+	perm, _ := acl.Effective(fs.user, fs.groups)
+	return perm, nil
+}
+
+// checkDocuments fails if the user may not create more documents.
+func (fs *webdavFileSystem) checkDocuments() error {
+	quota, usage, err := fs.quotaOf(fs.user)
+	if err != nil {
+		return err
+	}
+	return quota.checkDocuments(usage)
+}
+
+func (fs *webdavFileSystem) newWriter(header DocumentHeader, create bool) (*webdavWriter, error) {
+	quota, usage, err := fs.quotaOf(header.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	w := &webdavWriter{
+		fs:     fs,
+		header: header,
+		create: create,
+		limit:  quota.remainingBytes(usage, header.Size),
+		pw:     pw,
+		done:   make(chan error, 1),
+	}
+
+	hasContent := !header.Updated.IsZero()
+	go func() {
+		var err error
+		if hasContent {
+			err = fs.objects.Update(header.ID, pr)
+		} else {
+			err = fs.objects.Create(header.ID, pr)
+		}
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+// webdavWriter streams everything that is written to it into the object
+// storage. The document header is created or updated when the writer is
+// closed.
+type webdavWriter struct {
+	fs     *webdavFileSystem
+	header DocumentHeader
+	create bool
+	// limit is how many bytes may be written, -1 if unlimited.
+	limit   int64
+	written int64
+	pw      *io.PipeWriter
+	done    chan error
+}
+
+func (w *webdavWriter) Write(p []byte) (int, error) {
+	if w.limit >= 0 && w.written+int64(len(p)) > w.limit {
+		// the storage must not keep the partial content
+		err := fmt.Errorf("more than %d bytes: %w", w.limit, ErrQuotaExceeded)
+		_ = w.pw.CloseWithError(err)
+		return 0, err
+	}
+	n, err := w.pw.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *webdavWriter) Close() error {
+	// the file is closed even if reading the request body failed, and the
+	// truncated content must not be stored
+	if err := w.fs.body.err; err != nil {
+		_ = w.pw.CloseWithError(err)
+		<-w.done
+		return fmt.Errorf("read request body: %w", err)
+	}
+
+	_ = w.pw.Close()
+	if err := <-w.done; err != nil {
+		return fmt.Errorf("store object: %w", err)
+	}
+
+	w.header.Size = w.written
+	w.header.Updated = w.fs.clock.Now()
+
+	if w.create {
+		if err := w.fs.documents.Create(w.header, ownerACL(w.fs.user)); err != nil {
+			_ = w.fs.objects.Delete(w.header.ID)
+			return fmt.Errorf("create document: %w", err)
+		}
+		return nil
+	}
+
+	acl, err := w.fs.documents.ACL(w.header.ID)
+	if err != nil {
+		return fmt.Errorf("get ACL: %w", err)
+	}
+	if err := w.fs.documents.Update(w.header, acl); err != nil {
+		return fmt.Errorf("update document: %w", err)
+	}
+	return nil
+}
+
+func (w *webdavWriter) Read([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (w *webdavWriter) Seek(int64, int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (w *webdavWriter) Readdir(int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (w *webdavWriter) Stat() (os.FileInfo, error) {
+	info := webdavFileInfoFor(w.header)
+	info.size = w.written
+	info.modTime = w.fs.clock.Now()
+	return info, nil
+}
+
+// webdavBody records the first error of reading the request body, since
+// webdav.Handler doesn't tell the files about it.
+type webdavBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *webdavBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// webdavReader reads the content of a document from the object storage.
+// Since objects can only be read sequentially, seeking backwards re-opens the
+// object, and seeking forward skips the content in between. Storages that
+// are RangeReaders re-open the object at the new position instead.
+type webdavReader struct {
+	fs     *webdavFileSystem
+	header DocumentHeader
+
+	rd    io.ReadCloser
+	rdPos int64
+	pos   int64
+}
+
+func (r *webdavReader) Read(p []byte) (int, error) {
+	if r.pos >= r.header.Size {
+		return 0, io.EOF
+	}
+
+	if r.rd == nil || r.rdPos > r.pos || (r.rdPos < r.pos && r.ranged()) {
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	if r.rdPos < r.pos {
+		n, err := io.CopyN(io.Discard, r.rd, r.pos-r.rdPos)
+		r.rdPos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.rd.Read(p)
+	r.rdPos += int64(n)
+	r.pos = r.rdPos
+	return n, err
+}
+
+func (r *webdavReader) reopen() error {
+	if r.rd != nil {
+		_ = r.rd.Close()
+	}
+	r.rd = nil
+
+	var rd io.ReadCloser
+	var err error
+	pos := int64(0)
+	if rr, ok := r.fs.objects.(RangeReader); ok {
+		rd, err = rr.ReadRange(r.header.ID, r.pos, -1)
+		pos = r.pos
+	} else {
+		rd, err = r.fs.objects.Read(r.header.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("read object: %w", err)
+	}
+	r.rd = rd
+	r.rdPos = pos
+	return nil
+}
+
+// ranged reports whether reopen can start at any position.
+func (r *webdavReader) ranged() bool {
+	_, ok := r.fs.objects.(RangeReader)
+	return ok
+}
+
+func (r *webdavReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.header.Size
+	default:
+		return 0, os.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	r.pos = offset
+	return r.pos, nil
+}
+
+func (r *webdavReader) Close() error {
+	if r.rd == nil {
+		return nil
+	}
+	return r.rd.Close()
+}
+
+func (r *webdavReader) Write([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (r *webdavReader) Readdir(int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (r *webdavReader) Stat() (os.FileInfo, error) {
+	return webdavFileInfoFor(r.header), nil
+}
+
+type webdavDir struct {
+	info     *webdavFileInfo
+	children []os.FileInfo
+	pos      int
+}
+
+func (d *webdavDir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.children[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.children)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.pos += count
+	return rest[:count], nil
+}
+
+func (d *webdavDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *webdavDir) Read([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webdavDir) Write([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webdavDir) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+func (d *webdavDir) Close() error {
+	return nil
+}
+
+type webdavFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func webdavFileInfoFor(h DocumentHeader) *webdavFileInfo {
+	modTime := h.Updated
+	if modTime.IsZero() {
+		modTime = h.Created
+	}
+	return &webdavFileInfo{
+		name:    path.Base(h.Name),
+		size:    h.Size,
+		modTime: modTime,
+	}
+}
+
+func webdavDirInfo(key string, headers []DocumentHeader) *webdavFileInfo {
+	info := &webdavFileInfo{
+		name: path.Base("/" + key),
+		dir:  true,
+	}
+	for _, h := range headers {
+		if key == "" || h.Name == key+"/" || strings.HasPrefix(h.Name, key+"/") {
+			if fi := webdavFileInfoFor(h); fi.modTime.After(info.modTime) {
+				info.modTime = fi.modTime
+			}
+		}
+	}
+	return info
+}
+
+func (i *webdavFileInfo) Name() string       { return i.name }
+func (i *webdavFileInfo) Size() int64        { return i.size }
+func (i *webdavFileInfo) ModTime() time.Time { return i.modTime }
+func (i *webdavFileInfo) IsDir() bool        { return i.dir }
+func (i *webdavFileInfo) Sys() interface{}   { return nil }
+
+func (i *webdavFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0777
+	}
+	return 0666
+}
+
+// ContentType implements webdav.ContentTyper, so that the content of a
+// document doesn't have to be read to answer a PROPFIND.
+func (i *webdavFileInfo) ContentType(context.Context) (string, error) {
+	if typ := mime.TypeByExtension(path.Ext(i.name)); typ != "" {
+		return typ, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// webdavKey converts a webdav path into a document name, e.g. "/a/b.txt"
+// into "a/b.txt". The root directory has the key "".
+func webdavKey(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// webdavLookup finds the entry with the given key. If there are multiple
+// documents with the same name, documents owned by the user take precedence.
+func webdavLookup(user string, headers []DocumentHeader, key string) (header DocumentHeader, isDir, ok bool) {
+	if key == "" {
+		return DocumentHeader{}, true, true
+	}
+
+	for _, h := range headers {
+		if h.Name != key {
+			continue
+		}
+		if !ok || (header.Owner != user && h.Owner == user) {
+			header, ok = h, true
+		}
+	}
+	if ok {
+		return header, false, true
+	}
+
+	if webdavIsDir(headers, key) {
+		return DocumentHeader{}, true, true
+	}
+	return DocumentHeader{}, false, false
+}
+
+func webdavIsDir(headers []DocumentHeader, key string) bool {
+	if key == "" || key == "." {
+		return true
+	}
+	for _, h := range headers {
+		if strings.HasPrefix(h.Name, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// webdavAffected returns all documents that are affected by an operation
+// on the given key, which is either the file itself or everything within the
+// directory.
+func webdavAffected(user string, headers []DocumentHeader, key string) []DocumentHeader {
+	if h, isDir, ok := webdavLookup(user, headers, key); !ok {
+		return nil
+	} else if !isDir {
+		return []DocumentHeader{h}
+	}
+
+	var affected []DocumentHeader
+	for _, h := range headers {
+		if strings.HasPrefix(h.Name, key+"/") {
+			affected = append(affected, h)
+		}
+	}
+	return affected
+}
+
+func webdavChildren(user string, headers []DocumentHeader, key string) []os.FileInfo {
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+
+	var children []os.FileInfo
+	seen := map[string]bool{}
+	for _, h := range headers {
+		if !strings.HasPrefix(h.Name, prefix) || h.Name == prefix {
+			continue
+		}
+
+		rest := strings.TrimPrefix(h.Name, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i]
+			if !seen[rest] {
+				seen[rest] = true
+				children = append(children, webdavDirInfo(prefix+rest, headers))
+			}
+			continue
+		}
+
+		if !seen[rest] {
+			seen[rest] = true
+			file, _, _ := webdavLookup(user, headers, prefix+rest)
+			children = append(children, webdavFileInfoFor(file))
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	return children
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// User is a user as shown to administrators.
+type User struct {
+	Username string   `json:"username"`
+	Enabled  bool     `json:"enabled"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// Users returns all users. Like all methods of the admin API, it requires a
+// session of a member of the admin group.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	var res struct {
+		Users []User `json:"users"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/users", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Users, nil
+}
+
+// CreateUser creates a user with the given password.
+func (c *Client) CreateUser(ctx context.Context, user, pass string) error {
+	return c.doJSON(ctx, http.MethodPost, "/admin/users", map[string]string{
+		"username": user,
+		"password": pass,
+	}, nil)
+}
+
+// DeleteUser deletes a user.
+func (c *Client) DeleteUser(ctx context.Context, user string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/users/"+url.PathEscape(user), nil, nil)
+}
+
+// DisableUser disables a user, who can't log in afterwards.
+func (c *Client) DisableUser(ctx context.Context, user string) error {
+	return c.doJSON(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(user)+"/disable", nil, nil)
+}
+
+// EnableUser enables a disabled user.
+func (c *Client) EnableUser(ctx context.Context, user string) error {
+	return c.doJSON(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(user)+"/enable", nil, nil)
+}
+
+// SetPassword sets the password of a user.
+func (c *Client) SetPassword(ctx context.Context, user, pass string) error {
+	return c.doJSON(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(user)+"/password", map[string]string{
+		"password": pass,
+	}, nil)
+}
+
+// Groups returns the names of all groups.
+func (c *Client) Groups(ctx context.Context) ([]string, error) {
+	var res struct {
+		Groups []string `json:"groups"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/groups", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Groups, nil
+}
+
+// CreateGroup creates a group.
+func (c *Client) CreateGroup(ctx context.Context, group string) error {
+	return c.doJSON(ctx, http.MethodPost, "/admin/groups", map[string]string{
+		"name": group,
+	}, nil)
+}
+
+// DeleteGroup deletes a group.
+func (c *Client) DeleteGroup(ctx context.Context, group string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/groups/"+url.PathEscape(group), nil, nil)
+}
+
+// AddGroupMember adds a user to a group.
+func (c *Client) AddGroupMember(ctx context.Context, group, user string) error {
+	return c.doJSON(ctx, http.MethodPut, "/admin/groups/"+url.PathEscape(group)+"/members/"+url.PathEscape(user), nil, nil)
+}
+
+// RemoveGroupMember removes a user from a group.
+func (c *Client) RemoveGroupMember(ctx context.Context, group, user string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/groups/"+url.PathEscape(group)+"/members/"+url.PathEscape(user), nil, nil)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// LoginResult is the result of Login and AnswerChallenge. If Challenge is
+// not empty, the challenge has to be answered with AnswerChallenge before
+// the session is logged in.
+type LoginResult struct {
+	Challenge string
+}
+
+type loginResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+}
+
+// Login logs in with the given credentials. Invalid credentials result in
+// an error that matches ErrUnauthorized.
+func (c *Client) Login(ctx context.Context, user, pass string) (LoginResult, error) {
+	c.resetCSRF()
+
+	var res loginResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/auth/login", map[string]string{
+		"username": user,
+		"password": pass,
+	}, &res); err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{
+		Challenge: res.Challenge,
+	}, nil
+}
+
+// AnswerChallenge answers a challenge that was returned by Login.
+func (c *Client) AnswerChallenge(ctx context.Context, user, challenge, response string) (LoginResult, error) {
+	c.resetCSRF()
+
+	var res loginResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/auth/challenge", map[string]string{
+		"username":        user,
+		"challenge":       challenge,
+		"client_response": response,
+	}, &res); err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{
+		Challenge: res.Challenge,
+	}, nil
+}
+
+// Logout ends the current session.
+func (c *Client) Logout(ctx context.Context) error {
+	c.resetCSRF()
+	return c.doJSON(ctx, http.MethodGet, "/auth/logout", nil, nil)
+}
+
+// User returns the name of the user that is currently logged in.
+func (c *Client) User(ctx context.Context) (string, error) {
+	var res struct {
+		Username string `json:"username"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/user", nil, &res); err != nil {
+		return "", err
+	}
+	return res.Username, nil
+}
+
+// ChallengeSoftwareTokenMFA is the challenge of a login that requires the
+// code of an authenticator app, see EnrollTOTP.
+const ChallengeSoftwareTokenMFA = "SOFTWARE_TOKEN_MFA"
+
+// EnrollTOTP starts the enrollment of an authenticator app and returns the
+// secret and the otpauth:// URI for the app. The enrollment is finished
+// with ConfirmTOTP.
+func (c *Client) EnrollTOTP(ctx context.Context) (secret, uri string, err error) {
+	var res struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/user/mfa/totp", nil, &res); err != nil {
+		return "", "", err
+	}
+	return res.Secret, res.URI, nil
+}
+
+// ConfirmTOTP confirms the enrollment of an authenticator app with a code
+// from the app. Afterwards, Login returns ChallengeSoftwareTokenMFA.
+func (c *Client) ConfirmTOTP(ctx context.Context, code string) error {
+	return c.doJSON(ctx, http.MethodPost, "/user/mfa/totp/confirm", map[string]string{
+		"code": code,
+	}, nil)
+}
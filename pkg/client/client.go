@@ -0,0 +1,193 @@
+// Package client implements a client for the REST API of the document
+// management system.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Client is a client for the REST API. After a successful Login, the
+// session cookie is kept in the cookie jar of the underlying http.Client.
+// Alternatively, requests can be authenticated with an API token, see
+// WithAPIToken.
+type Client struct {
+	baseURL  *url.URL
+	http     *http.Client
+	apiToken string
+
+	mu sync.Mutex
+	// csrfToken is the CSRF token of the session, which is fetched before
+	// the first request that changes something.
+	csrfToken string
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client that is used for all requests. If the
+// given client has no cookie jar, a new one is created.
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) {
+		client.http = c
+	}
+}
+
+// WithAPIToken authenticates all requests with the given API token instead
+// of a session. Tokens are created with CreateToken.
+func WithAPIToken(token string) Option {
+	return func(client *Client) {
+		client.apiToken = token
+	}
+}
+
+// New creates a new client for the server at the given base URL, e.g.
+// "https://docs.example.com".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base URL: %w", err)
+	}
+
+	c := &Client{
+		baseURL: u,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.http == nil {
+		c.http = &http.Client{}
+	}
+	if c.http.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, fmt.Errorf("create cookie jar: %w", err)
+		}
+		c.http.Jar = jar
+	}
+
+	return c, nil
+}
+
+// HTTPClient returns the http.Client that is used by this client.
+func (c *Client) HTTPClient() *http.Client {
+	return c.http
+}
+
+// BaseURL returns the base URL of the server.
+func (c *Client) BaseURL() *url.URL {
+	u := *c.baseURL
+	return &u
+}
+
+func (c *Client) url(endpoint string) string {
+	return c.baseURL.String() + "/rest" + endpoint
+}
+
+func (c *Client) newRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(endpoint), body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if c.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiToken)
+		return req, nil
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if endpoint == "/auth/login" || endpoint == "/auth/challenge" {
+			break
+		}
+		token, err := c.csrf(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-CSRF-Token", token)
+	}
+	return req, nil
+}
+
+// csrf returns the CSRF token of the session.
+func (c *Client) csrf(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.csrfToken != "" {
+		return c.csrfToken, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/auth/csrf"), nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(req, &res); err != nil {
+		return "", err
+	}
+	c.csrfToken = res.Token
+	return c.csrfToken, nil
+}
+
+// resetCSRF forgets the CSRF token, since it changes with the session.
+func (c *Client) resetCSRF() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.csrfToken = ""
+}
+
+// do performs the request and decodes the JSON response into the given
+// value, if it is not nil. Non-2xx responses are returned as *Error.
+func (c *Client) do(req *http.Request, v interface{}) error {
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+
+	if v == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) doJSON(ctx context.Context, method, endpoint string, body, v interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, endpoint, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, v)
+}
@@ -0,0 +1,421 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsatke/verbose-broccoli/internal/app"
+	"golang.org/x/net/nettest"
+)
+
+func TestClientSuite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	suite.Run(t, new(ClientSuite))
+}
+
+type ClientSuite struct {
+	suite.Suite
+
+	ctx    context.Context
+	app    *app.App
+	auth   *app.MemAuthService
+	client *Client
+}
+
+func (suite *ClientSuite) SetupTest() {
+	suite.ctx = context.Background()
+
+	lis, err := nettest.NewLocalListener("tcp")
+	suite.Require().NoError(err)
+
+	suite.auth = app.NewMemAuthService()
+	suite.auth.CreateUser("testuser", "testpass")
+
+	suite.app = app.New(lis,
+		app.WithAuthService(suite.auth),
+		app.WithTransferURLs(time.Minute, []byte("secret")),
+	)
+	go func() {
+		if err := suite.app.Run(); err != nil {
+			panic(err)
+		}
+	}()
+
+	suite.client, err = New("http://" + lis.Addr().String())
+	suite.Require().NoError(err)
+}
+
+func (suite *ClientSuite) TearDownTest() {
+	suite.NoError(suite.app.Close())
+}
+
+func (suite *ClientSuite) login() {
+	res, err := suite.client.Login(suite.ctx, "testuser", "testpass")
+	suite.Require().NoError(err)
+	suite.Empty(res.Challenge)
+}
+
+func (suite *ClientSuite) TestLoginLogout() {
+	suite.login()
+
+	user, err := suite.client.User(suite.ctx)
+	suite.NoError(err)
+	suite.Equal("testuser", user)
+
+	suite.NoError(suite.client.Logout(suite.ctx))
+
+	_, err = suite.client.User(suite.ctx)
+	suite.True(errors.Is(err, ErrUnauthorized))
+}
+
+func (suite *ClientSuite) TestLoginInvalidCredentials() {
+	_, err := suite.client.Login(suite.ctx, "testuser", "wrongpass")
+	suite.True(errors.Is(err, ErrUnauthorized))
+
+	var e *Error
+	suite.Require().True(errors.As(err, &e))
+	suite.Equal(http.StatusUnauthorized, e.StatusCode)
+	suite.Equal("invalid credentials", e.Message)
+}
+
+func (suite *ClientSuite) TestDocumentLifecycle() {
+	suite.login()
+
+	id, err := suite.client.CreateDocument(suite.ctx, "hello.txt")
+	suite.Require().NoError(err)
+	suite.NotEmpty(id)
+
+	doc, err := suite.client.Document(suite.ctx, id)
+	suite.NoError(err)
+	suite.Equal(id, doc.ID)
+	suite.Equal("hello.txt", doc.Name)
+	suite.Equal("testuser", doc.Owner)
+	suite.False(doc.HasContent())
+
+	content := bytes.Repeat([]byte("hello"), 1000)
+
+	var uploaded int64
+	suite.NoError(suite.client.Upload(suite.ctx, id, "hello.txt", bytes.NewReader(content), int64(len(content)), func(transferred, total int64) {
+		suite.EqualValues(len(content), total)
+		uploaded = transferred
+	}))
+	suite.EqualValues(len(content), uploaded)
+
+	docs, err := suite.client.Documents(suite.ctx)
+	suite.NoError(err)
+	suite.Require().Len(docs, 1)
+	suite.Equal(id, docs[0].ID)
+	suite.EqualValues(len(content), docs[0].Size)
+	suite.True(docs[0].HasContent())
+
+	var buf bytes.Buffer
+	var downloaded int64
+	n, err := suite.client.Download(suite.ctx, id, &buf, func(transferred, _ int64) {
+		downloaded = transferred
+	})
+	suite.NoError(err)
+	suite.EqualValues(len(content), n)
+	suite.EqualValues(len(content), downloaded)
+	suite.Equal(content, buf.Bytes())
+
+	suite.NoError(suite.client.DeleteDocument(suite.ctx, id))
+
+	_, err = suite.client.Document(suite.ctx, id)
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *ClientSuite) TestArchive() {
+	suite.login()
+
+	id, err := suite.client.CreateDocument(suite.ctx, "hello.txt")
+	suite.Require().NoError(err)
+	suite.NoError(suite.client.Upload(suite.ctx, id, "hello.txt", bytes.NewReader([]byte("hello")), 5, nil))
+
+	var buf bytes.Buffer
+	n, err := suite.client.Archive(suite.ctx, []string{id, "unknown"}, &buf)
+	suite.NoError(err)
+	suite.EqualValues(buf.Len(), n)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	suite.Require().NoError(err)
+	suite.Require().Len(zr.File, 2)
+	suite.Equal("hello.txt", zr.File[0].Name)
+	suite.Equal("manifest.json", zr.File[1].Name)
+
+	_, err = suite.client.Archive(suite.ctx, nil, &buf)
+	suite.True(errors.Is(err, ErrBadRequest))
+}
+
+func (suite *ClientSuite) TestImport() {
+	suite.login()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("docs/hello.txt")
+	suite.NoError(err)
+	_, err = w.Write([]byte("hello"))
+	suite.NoError(err)
+	suite.NoError(zw.Close())
+
+	id, err := suite.client.StartImport(suite.ctx, "legacy.zip", bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+	suite.Require().NoError(err)
+
+	imp, err := suite.client.WaitImport(suite.ctx, id, 10*time.Millisecond, nil)
+	suite.NoError(err)
+	suite.Equal("finished", imp.State)
+	suite.Require().Len(imp.Results, 1)
+	suite.Equal("docs/hello.txt", imp.Results[0].Name)
+
+	doc, err := suite.client.Document(suite.ctx, imp.Results[0].ID)
+	suite.NoError(err)
+	suite.Equal("docs/hello.txt", doc.Name)
+	suite.EqualValues(5, doc.Size)
+
+	_, err = suite.client.StartImport(suite.ctx, "notes.txt", bytes.NewReader([]byte("hello")), 5, nil)
+	suite.True(errors.Is(err, ErrBadRequest))
+	_, err = suite.client.Import(suite.ctx, "unknown")
+	suite.True(errors.Is(err, ErrNotFound))
+
+	jobs, err := suite.client.Jobs(suite.ctx)
+	suite.NoError(err)
+	suite.Require().Len(jobs, 1)
+	suite.Equal(id, jobs[0].ID)
+	suite.Equal("import", jobs[0].Type)
+	suite.Equal("succeeded", jobs[0].State)
+
+	job, err := suite.client.Job(suite.ctx, id)
+	suite.NoError(err)
+	suite.Equal(jobs[0].ID, job.ID)
+	suite.True(errors.Is(suite.client.CancelJob(suite.ctx, id), ErrConflict))
+	_, err = suite.client.Job(suite.ctx, "unknown")
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *ClientSuite) TestNotLoggedIn() {
+	_, err := suite.client.CreateDocument(suite.ctx, "hello.txt")
+	suite.True(errors.Is(err, ErrUnauthorized))
+	suite.False(errors.Is(err, ErrNotFound))
+}
+
+func (suite *ClientSuite) TestShare() {
+	suite.login()
+
+	id, err := suite.client.CreateDocument(suite.ctx, "shared.txt")
+	suite.Require().NoError(err)
+	suite.NoError(suite.client.Share(suite.ctx, id, Permission{
+		Username: "otheruser",
+		Read:     true,
+	}))
+
+	suite.auth.CreateUser("otheruser", "otherpass")
+	other, err := New(suite.client.BaseURL().String())
+	suite.Require().NoError(err)
+	_, err = other.Login(suite.ctx, "otheruser", "otherpass")
+	suite.Require().NoError(err)
+
+	docs, err := other.Documents(suite.ctx)
+	suite.NoError(err)
+	suite.Require().Len(docs, 1)
+	suite.Equal(id, docs[0].ID)
+
+	err = other.Share(suite.ctx, id, Permission{
+		Username: "otheruser",
+		Read:     true,
+		Share:    true,
+	})
+	suite.True(errors.Is(err, ErrForbidden))
+}
+
+func (suite *ClientSuite) TestShareGroup() {
+	suite.login()
+
+	id, err := suite.client.CreateDocument(suite.ctx, "shared.txt")
+	suite.Require().NoError(err)
+	suite.NoError(suite.client.Share(suite.ctx, id, Permission{
+		Group: "team",
+		Read:  true,
+	}))
+
+	suite.auth.CreateUser("member", "memberpass")
+	suite.Require().NoError(suite.auth.CreateGroup("team"))
+	suite.Require().NoError(suite.auth.AddUserToGroup("member", "team"))
+	member, err := New(suite.client.BaseURL().String())
+	suite.Require().NoError(err)
+	_, err = member.Login(suite.ctx, "member", "memberpass")
+	suite.Require().NoError(err)
+
+	docs, err := member.Documents(suite.ctx)
+	suite.NoError(err)
+	suite.Require().Len(docs, 1)
+	suite.Equal(id, docs[0].ID)
+}
+
+func (suite *ClientSuite) TestShareLinks() {
+	suite.login()
+
+	id, err := suite.client.CreateDocument(suite.ctx, "shared.txt")
+	suite.Require().NoError(err)
+	data := []byte("hello")
+	suite.Require().NoError(suite.client.Upload(suite.ctx, id, "shared.txt", bytes.NewReader(data), int64(len(data)), nil))
+
+	linkID, token, err := suite.client.CreateShareLink(suite.ctx, id, ShareLinkOptions{
+		MaxDownloads: 1,
+	})
+	suite.Require().NoError(err)
+
+	res, err := http.Get(suite.client.PublicURL(token))
+	suite.Require().NoError(err)
+	got, err := io.ReadAll(res.Body)
+	suite.NoError(err)
+	suite.NoError(res.Body.Close())
+	suite.Equal(http.StatusOK, res.StatusCode)
+	suite.Equal(data, got)
+
+	links, err := suite.client.ShareLinks(suite.ctx, id)
+	suite.NoError(err)
+	suite.Require().Len(links, 1)
+	suite.Equal(linkID, links[0].ID)
+	suite.Equal(1, links[0].Downloads)
+	suite.NotNil(links[0].LastAccess)
+
+	suite.NoError(suite.client.RevokeShareLink(suite.ctx, id, linkID))
+	err = suite.client.RevokeShareLink(suite.ctx, id, linkID)
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *ClientSuite) TestTransferURLs() {
+	suite.login()
+
+	id, err := suite.client.CreateDocument(suite.ctx, "direct.txt")
+	suite.Require().NoError(err)
+	data := []byte("hello")
+	suite.Require().NoError(suite.client.UploadDirect(suite.ctx, id, bytes.NewReader(data), int64(len(data)), "5d41402abc4b2a76b9719d911017c592", nil))
+
+	doc, err := suite.client.Document(suite.ctx, id)
+	suite.NoError(err)
+	suite.Equal(int64(len(data)), doc.Size)
+
+	u, err := suite.client.ContentURL(suite.ctx, id)
+	suite.Require().NoError(err)
+	suite.Equal(http.MethodGet, u.Method)
+
+	// the URL works without the session
+	res, err := http.Get(u.URL)
+	suite.Require().NoError(err)
+	got, err := io.ReadAll(res.Body)
+	suite.NoError(err)
+	suite.NoError(res.Body.Close())
+	suite.Equal(http.StatusOK, res.StatusCode)
+	suite.Equal(data, got)
+
+	err = suite.client.UploadDirect(suite.ctx, id, bytes.NewReader(data), int64(len(data)), "00000000000000000000000000000000", nil)
+	suite.True(errors.Is(err, ErrBadRequest))
+}
+
+func (suite *ClientSuite) TestValidationError() {
+	suite.login()
+
+	_, err := suite.client.CreateDocument(suite.ctx, "")
+	suite.True(errors.Is(err, ErrBadRequest))
+
+	var e *Error
+	suite.Require().True(errors.As(err, &e))
+	suite.Equal("validation", e.Code)
+	suite.Equal([]FieldError{
+		{Field: "body.filename", Message: "must not be empty"},
+	}, e.Fields)
+}
+
+func (suite *ClientSuite) TestAPIToken() {
+	suite.login()
+
+	id, secret, err := suite.client.CreateToken(suite.ctx, "ci", []string{ScopeRead}, time.Time{})
+	suite.Require().NoError(err)
+
+	tokens, err := suite.client.Tokens(suite.ctx)
+	suite.NoError(err)
+	suite.Require().Len(tokens, 1)
+	suite.Equal(id, tokens[0].ID)
+	suite.Equal("ci", tokens[0].Name)
+	suite.Equal([]string{ScopeRead}, tokens[0].Scopes)
+
+	tokenClient, err := New(suite.client.BaseURL().String(), WithAPIToken(secret))
+	suite.Require().NoError(err)
+
+	user, err := tokenClient.User(suite.ctx)
+	suite.NoError(err)
+	suite.Equal("testuser", user)
+
+	_, err = tokenClient.CreateDocument(suite.ctx, "file")
+	suite.True(errors.Is(err, ErrForbidden))
+
+	suite.NoError(suite.client.RevokeToken(suite.ctx, id))
+	_, err = tokenClient.User(suite.ctx)
+	suite.True(errors.Is(err, ErrUnauthorized))
+}
+
+func (suite *ClientSuite) TestAdmin() {
+	suite.NoError(suite.auth.CreateGroup(app.DefaultAdminGroup))
+	suite.NoError(suite.auth.AddUserToGroup("testuser", app.DefaultAdminGroup))
+	suite.login()
+
+	suite.NoError(suite.client.CreateUser(suite.ctx, "alice", "alicepass"))
+	suite.NoError(suite.client.CreateGroup(suite.ctx, "editors"))
+	suite.NoError(suite.client.AddGroupMember(suite.ctx, "editors", "alice"))
+	suite.NoError(suite.client.DisableUser(suite.ctx, "alice"))
+
+	users, err := suite.client.Users(suite.ctx)
+	suite.NoError(err)
+	suite.Equal([]User{
+		{Username: "alice", Enabled: false, Groups: []string{"editors"}},
+		{Username: "testuser", Enabled: true, Groups: []string{"admin"}},
+	}, users)
+
+	groups, err := suite.client.Groups(suite.ctx)
+	suite.NoError(err)
+	suite.Equal([]string{"admin", "editors"}, groups)
+
+	err = suite.client.CreateGroup(suite.ctx, "editors")
+	suite.True(errors.Is(err, ErrConflict))
+	err = suite.client.DeleteUser(suite.ctx, "bob")
+	suite.True(errors.Is(err, ErrNotFound))
+}
+
+func (suite *ClientSuite) TestQuota() {
+	suite.NoError(suite.auth.CreateGroup(app.DefaultAdminGroup))
+	suite.NoError(suite.auth.AddUserToGroup("testuser", app.DefaultAdminGroup))
+	suite.login()
+
+	suite.NoError(suite.client.SetQuota(suite.ctx, Quota{Type: "user", Name: "testuser", MaxBytes: 5, MaxDocuments: 10}))
+	quotas, err := suite.client.Quotas(suite.ctx)
+	suite.NoError(err)
+	suite.Equal([]Quota{{Type: "user", Name: "testuser", MaxBytes: 5, MaxDocuments: 10}}, quotas)
+
+	id, err := suite.client.CreateDocument(suite.ctx, "quota.txt")
+	suite.Require().NoError(err)
+	data := []byte("hello world")
+	err = suite.client.Upload(suite.ctx, id, "quota.txt", bytes.NewReader(data), int64(len(data)), nil)
+	suite.True(errors.Is(err, ErrForbidden))
+	var e *Error
+	suite.Require().True(errors.As(err, &e))
+	suite.Equal("quota_exceeded", e.Code)
+
+	usage, err := suite.client.Usage(suite.ctx)
+	suite.NoError(err)
+	suite.Equal(Usage{
+		Bytes:     QuotaUsage{Used: 0, Limit: 5},
+		Documents: QuotaUsage{Used: 1, Limit: 10},
+	}, usage)
+
+	suite.NoError(suite.client.DeleteQuota(suite.ctx, "user", "testuser"))
+	err = suite.client.DeleteQuota(suite.ctx, "user", "testuser")
+	suite.True(errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// ProgressFunc is called whenever content has been transferred. Total is -1
+// if the size of the content is unknown.
+type ProgressFunc func(transferred, total int64)
+
+// Upload streams the content from the given reader to the document with
+// the given ID. The content is not buffered in memory. Size is only used
+// for progress reporting and may be -1 if unknown. Progress may be nil.
+func (c *Client) Upload(ctx context.Context, id, filename string, rd io.Reader, size int64, progress ProgressFunc) error {
+	return c.uploadFile(ctx, "/doc/"+url.PathEscape(id)+"/content", filename, rd, size, progress, nil)
+}
+
+// uploadFile streams the content as the file of a multipart form and
+// decodes the JSON response into v, if it is not nil.
+func (c *Client) uploadFile(ctx context.Context, endpoint, filename string, rd io.Reader, size int64, progress ProgressFunc, v interface{}) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		part, err := mw.CreateFormFile("file", filename)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, &progressReader{rd: rd, total: size, progress: progress}); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_ = pw.CloseWithError(mw.Close())
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, endpoint, pr)
+	if err != nil {
+		_ = pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	err = c.do(req, v)
+	_ = pr.Close()
+	return err
+}
+
+// OpenContent opens the content of the document with the given ID for
+// reading. The caller must close the returned reader.
+func (c *Client) OpenContent(ctx context.Context, id string) (io.ReadCloser, int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/doc/"+url.PathEscape(id)+"/content", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	if err := checkResponse(res); err != nil {
+		_ = res.Body.Close()
+		return nil, 0, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// Download streams the content of the document with the given ID into the
+// given writer and returns the number of bytes written. Progress may be nil.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer, progress ProgressFunc) (int64, error) {
+	rd, size, err := c.OpenContent(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	n, err := io.Copy(w, &progressReader{rd: rd, total: size, progress: progress})
+	if err != nil {
+		return n, fmt.Errorf("copy content: %w", err)
+	}
+	return n, nil
+}
+
+// Archive streams a ZIP archive of the content of the documents with the
+// given IDs into the given writer and returns the number of bytes written.
+// Documents that can't be read are skipped and listed in the manifest.json
+// entry of the archive.
+func (c *Client) Archive(ctx context.Context, ids []string, w io.Writer) (int64, error) {
+	data, err := json.Marshal(struct {
+		IDs []string `json:"ids"`
+	}{ids})
+	if err != nil {
+		return 0, fmt.Errorf("encode request: %w", err)
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/doc/archive", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if err := checkResponse(res); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return n, fmt.Errorf("copy archive: %w", err)
+	}
+	return n, nil
+}
+
+type progressReader struct {
+	rd          io.Reader
+	total       int64
+	transferred int64
+	progress    ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	if n > 0 {
+		r.transferred += int64(n)
+		if r.progress != nil {
+			r.progress(r.transferred, r.total)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Document is the header information of a document.
+type Document struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Owner   string     `json:"owner"`
+	Size    int64      `json:"size"`
+	Created time.Time  `json:"created"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// HasContent reports whether content has been uploaded for the document.
+func (d Document) HasContent() bool {
+	return d.Updated != nil
+}
+
+// CreateDocument creates a new document without content and returns its
+// ID. Use Upload to store the content.
+func (c *Client) CreateDocument(ctx context.Context, filename string) (string, error) {
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/doc", map[string]string{
+		"filename": filename,
+	}, &res); err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+// Document returns the document with the given ID.
+func (c *Client) Document(ctx context.Context, id string) (Document, error) {
+	var res Document
+	if err := c.doJSON(ctx, http.MethodGet, "/doc/"+url.PathEscape(id), nil, &res); err != nil {
+		return Document{}, err
+	}
+	return res, nil
+}
+
+// Documents returns all documents that the user is allowed to read.
+func (c *Client) Documents(ctx context.Context) ([]Document, error) {
+	var res struct {
+		Documents []Document `json:"documents"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/doc", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Documents, nil
+}
+
+// DeleteDocument deletes the document and its content.
+func (c *Client) DeleteDocument(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/doc/"+url.PathEscape(id), nil, nil)
+}
+
+// Permission describes what a user, or all members of a group, is allowed to
+// do with a document. Exactly one of Username and Group must be set.
+type Permission struct {
+	Username string `json:"username,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Read     bool   `json:"read"`
+	Write    bool   `json:"write"`
+	Delete   bool   `json:"delete"`
+	Share    bool   `json:"share"`
+}
+
+// Share sets the permissions of another user or of a group on the document
+// with the given ID. A permission without any rights revokes access for that
+// user or group.
+func (c *Client) Share(ctx context.Context, id string, perm Permission) error {
+	return c.doJSON(ctx, http.MethodPost, "/doc/"+url.PathEscape(id)+"/share", perm, nil)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Sentinel errors that can be matched against an *Error with errors.Is.
+var (
+	ErrBadRequest   = &Error{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized = &Error{StatusCode: http.StatusUnauthorized}
+	ErrForbidden    = &Error{StatusCode: http.StatusForbidden}
+	ErrNotFound     = &Error{StatusCode: http.StatusNotFound}
+	ErrConflict     = &Error{StatusCode: http.StatusConflict}
+	ErrServer       = &Error{StatusCode: http.StatusInternalServerError}
+)
+
+// Error is returned for every response with a status code that is not 2xx.
+// Message and Fields are taken from the response envelope, if there is one.
+type Error struct {
+	StatusCode int
+	// Code identifies the kind of error, e.g. "not_found" or "forbidden".
+	Code    string
+	Message string
+	// Fields describes why the request was rejected by the validation
+	// against the OpenAPI document of the server.
+	Fields []FieldError
+}
+
+// FieldError describes a single invalid value of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is reports whether the target is one of the sentinel errors with the same
+// status code. All 5xx status codes match ErrServer.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Message != "" {
+		return false
+	}
+	if t.StatusCode == http.StatusInternalServerError {
+		return e.StatusCode >= 500
+	}
+	return e.StatusCode == t.StatusCode
+}
+
+// envelope is the common part of all responses of the REST API.
+type envelope struct {
+	Success bool         `json:"success"`
+	Code    string       `json:"code,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	e := &Error{
+		StatusCode: res.StatusCode,
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	var env envelope
+	if err := json.Unmarshal(data, &env); err == nil {
+		e.Code = env.Code
+		e.Message = env.Message
+		e.Fields = env.Errors
+	}
+	return e
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Import is an archive whose entries are imported as documents in the
+// background.
+type Import struct {
+	ID string `json:"id"`
+	// State is one of "running", "finished", "failed" and "canceled".
+	State string `json:"state"`
+	// Size is the size of the archive, Read how much of it is imported.
+	Size int64 `json:"size"`
+	Read int64 `json:"read"`
+	// Error is why the import failed as a whole.
+	Error    string         `json:"error,omitempty"`
+	Results  []ImportResult `json:"results"`
+	Created  time.Time      `json:"created"`
+	Finished *time.Time     `json:"finished,omitempty"`
+}
+
+// ImportResult is the result of importing a single entry of an archive.
+// ID is empty and Error is set if the import of the entry failed.
+type ImportResult struct {
+	Name  string `json:"name"`
+	ID    string `json:"id,omitempty"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// StartImport uploads a ZIP, tar or gzip compressed tar archive and returns
+// the ID of its import, whose progress is polled with Import. Size is only
+// used for progress reporting and may be -1 if unknown. Progress may be nil.
+func (c *Client) StartImport(ctx context.Context, filename string, rd io.Reader, size int64, progress ProgressFunc) (string, error) {
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := c.uploadFile(ctx, "/doc/import", filename, rd, size, progress, &res); err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+// Import returns the import with the given ID.
+func (c *Client) Import(ctx context.Context, id string) (Import, error) {
+	var res struct {
+		Import Import `json:"import"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/doc/import/"+url.PathEscape(id), nil, &res); err != nil {
+		return Import{}, err
+	}
+	return res.Import, nil
+}
+
+// WaitImport polls the import with the given ID until it isn't running
+// anymore. Progress may be nil, it's called with the progress of reading
+// the archive.
+func (c *Client) WaitImport(ctx context.Context, id string, interval time.Duration, progress ProgressFunc) (Import, error) {
+	for {
+		imp, err := c.Import(ctx, id)
+		if err != nil {
+			return Import{}, err
+		}
+		if progress != nil {
+			progress(imp.Read, imp.Size)
+		}
+		if imp.State != "running" {
+			return imp, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Import{}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Job is an operation that runs in the background on the server, e.g. an
+// import.
+type Job struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// State is one of "queued", "running", "succeeded", "failed" and
+	// "canceled".
+	State string `json:"state"`
+	// Done and Total are the progress of the job, Total is 0 if unknown.
+	Done            int64 `json:"done"`
+	Total           int64 `json:"total"`
+	Attempts        int   `json:"attempts"`
+	MaxAttempts     int   `json:"max_attempts"`
+	CancelRequested bool  `json:"cancel_requested"`
+	// Error is why the last attempt failed.
+	Error string `json:"error,omitempty"`
+	// Result depends on the type of the job.
+	Result   json.RawMessage `json:"result,omitempty"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+	RunAt    *time.Time      `json:"run_at,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+}
+
+// Jobs returns the jobs of the user, newest first.
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var res struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/jobs", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Jobs, nil
+}
+
+// Job returns the job with the given ID.
+func (c *Client) Job(ctx context.Context, id string) (Job, error) {
+	var res struct {
+		Job Job `json:"job"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &res); err != nil {
+		return Job{}, err
+	}
+	return res.Job, nil
+}
+
+// CancelJob requests the cancellation of the job with the given ID. The job
+// may still finish before it's canceled. ErrConflict if it's finished
+// already.
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/jobs/"+url.PathEscape(id)+"/cancel", nil, nil)
+}
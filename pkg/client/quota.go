@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// QuotaUsage is how much of a limit of a quota is used. Limit is 0 if
+// unlimited.
+type QuotaUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit,omitempty"`
+}
+
+// Usage is the storage that the documents of the user take up.
+type Usage struct {
+	Bytes     QuotaUsage `json:"bytes"`
+	Documents QuotaUsage `json:"documents"`
+}
+
+// Usage returns the storage that the documents of the user that is logged
+// in take up, and the limits of its quota.
+func (c *Client) Usage(ctx context.Context) (Usage, error) {
+	var res struct {
+		Quota Usage `json:"quota"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/user", nil, &res); err != nil {
+		return Usage{}, err
+	}
+	return res.Quota, nil
+}
+
+// Quota is the quota of a user or a group, as shown to administrators.
+// The limits are 0 if unlimited.
+type Quota struct {
+	// Type is either "user" or "group".
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	MaxBytes     int64  `json:"max_bytes"`
+	MaxDocuments int64  `json:"max_documents"`
+}
+
+// Quotas returns the quotas of all users and groups.
+func (c *Client) Quotas(ctx context.Context) ([]Quota, error) {
+	var res struct {
+		Quotas []Quota `json:"quotas"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/quotas", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Quotas, nil
+}
+
+// SetQuota creates or replaces the quota of a user or a group.
+func (c *Client) SetQuota(ctx context.Context, q Quota) error {
+	return c.doJSON(ctx, http.MethodPut, quotaEndpoint(q.Type, q.Name), map[string]int64{
+		"max_bytes":     q.MaxBytes,
+		"max_documents": q.MaxDocuments,
+	}, nil)
+}
+
+// DeleteQuota deletes the quota of a user or a group. Typ is either "user"
+// or "group".
+func (c *Client) DeleteQuota(ctx context.Context, typ, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, quotaEndpoint(typ, name), nil, nil)
+}
+
+func quotaEndpoint(typ, name string) string {
+	return "/admin/quotas/" + url.PathEscape(typ) + "/" + url.PathEscape(name)
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ShareLink is a link that gives access to the content of a document
+// without an account. The token of the link is only returned by
+// CreateShareLink.
+type ShareLink struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	// Password is whether the link requires a password.
+	Password bool `json:"password"`
+	// MaxDownloads is how often the link can be used, 0 if unlimited.
+	MaxDownloads int        `json:"max_downloads"`
+	Downloads    int        `json:"downloads"`
+	Created      time.Time  `json:"created"`
+	Expires      time.Time  `json:"expires"`
+	LastAccess   *time.Time `json:"last_access,omitempty"`
+}
+
+// ShareLinkOptions restrict the use of a share link. The zero value creates
+// a link without password and download limit, whose expiry is picked by the
+// server.
+type ShareLinkOptions struct {
+	Expires      time.Time
+	MaxDownloads int
+	Password     string
+}
+
+// CreateShareLink creates a share link for the document with the given ID and
+// returns its ID and token, see PublicURL.
+func (c *Client) CreateShareLink(ctx context.Context, id string, opts ShareLinkOptions) (linkID, token string, err error) {
+	req := struct {
+		Expires      *time.Time `json:"expires,omitempty"`
+		MaxDownloads int        `json:"max_downloads,omitempty"`
+		Password     string     `json:"password,omitempty"`
+	}{
+		MaxDownloads: opts.MaxDownloads,
+		Password:     opts.Password,
+	}
+	if !opts.Expires.IsZero() {
+		req.Expires = &opts.Expires
+	}
+
+	var res struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/doc/"+url.PathEscape(id)+"/links", req, &res); err != nil {
+		return "", "", err
+	}
+	return res.ID, res.Token, nil
+}
+
+// ShareLinks returns the share links of the document with the given ID.
+func (c *Client) ShareLinks(ctx context.Context, id string) ([]ShareLink, error) {
+	var res struct {
+		Links []ShareLink `json:"links"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/doc/"+url.PathEscape(id)+"/links", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Links, nil
+}
+
+// RevokeShareLink deletes the share link with the given ID of the document.
+func (c *Client) RevokeShareLink(ctx context.Context, id, linkID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/doc/"+url.PathEscape(id)+"/links/"+url.PathEscape(linkID), nil, nil)
+}
+
+// PublicURL returns the URL under which the content of a share link can be
+// downloaded without an account.
+func (c *Client) PublicURL(token string) string {
+	return c.url("/public/" + url.PathEscape(token))
+}
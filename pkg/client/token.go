@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Scopes of API tokens.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+	ScopeShare  = "share"
+)
+
+// Token is an API token. The secret of the token is only returned by
+// CreateToken.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// CreateToken creates an API token with the given scopes and returns its ID
+// and secret. If expires is zero, the server picks the expiry. Creating
+// tokens requires a session, see Login.
+func (c *Client) CreateToken(ctx context.Context, name string, scopes []string, expires time.Time) (id, secret string, err error) {
+	req := struct {
+		Name    string     `json:"name"`
+		Scopes  []string   `json:"scopes"`
+		Expires *time.Time `json:"expires,omitempty"`
+	}{
+		Name:   name,
+		Scopes: scopes,
+	}
+	if !expires.IsZero() {
+		req.Expires = &expires
+	}
+
+	var res struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/user/tokens", req, &res); err != nil {
+		return "", "", err
+	}
+	return res.ID, res.Token, nil
+}
+
+// Tokens returns the API tokens of the user.
+func (c *Client) Tokens(ctx context.Context) ([]Token, error) {
+	var res struct {
+		Tokens []Token `json:"tokens"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/user/tokens", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Tokens, nil
+}
+
+// RevokeToken deletes the API token with the given ID.
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/user/tokens/"+url.PathEscape(id), nil, nil)
+}
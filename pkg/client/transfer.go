@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransferURL is a short-lived URL that content can be transferred with
+// directly, without streaming it through the API, e.g. a presigned S3 URL.
+// It needs no authentication, but the headers have to be sent with the
+// request.
+type TransferURL struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Expires time.Time         `json:"expires"`
+}
+
+type transferRequest struct {
+	Size int64  `json:"size"`
+	MD5  string `json:"md5,omitempty"`
+}
+
+// ContentURL returns a URL that the content of the document with the given
+// ID can be downloaded from.
+func (c *Client) ContentURL(ctx context.Context, id string) (TransferURL, error) {
+	var res TransferURL
+	if err := c.doJSON(ctx, http.MethodGet, "/doc/"+url.PathEscape(id)+"/content/url", nil, &res); err != nil {
+		return TransferURL{}, err
+	}
+	return c.resolveTransferURL(res)
+}
+
+// UploadURL returns a URL that content with the given size and hex encoded
+// MD5 hash can be uploaded to, see UploadDirect. The hash may be empty.
+func (c *Client) UploadURL(ctx context.Context, id string, size int64, md5 string) (TransferURL, error) {
+	var res TransferURL
+	if err := c.doJSON(ctx, http.MethodPost, "/doc/"+url.PathEscape(id)+"/content/url", transferRequest{size, md5}, &res); err != nil {
+		return TransferURL{}, err
+	}
+	return c.resolveTransferURL(res)
+}
+
+// CompleteUpload confirms an upload to a URL of UploadURL, which updates
+// the document if the stored content has the given size and hash.
+func (c *Client) CompleteUpload(ctx context.Context, id string, size int64, md5 string) error {
+	return c.doJSON(ctx, http.MethodPost, "/doc/"+url.PathEscape(id)+"/content/complete", transferRequest{size, md5}, nil)
+}
+
+// UploadDirect uploads content of the given size to the URL of UploadURL
+// and completes the upload. Progress may be nil.
+func (c *Client) UploadDirect(ctx context.Context, id string, rd io.Reader, size int64, md5 string, progress ProgressFunc) error {
+	u, err := c.UploadURL(ctx, id, size, md5)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, u.Method, u.URL, &progressReader{rd: rd, total: size, progress: progress})
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	for k, v := range u.Headers {
+		req.Header.Set(k, v)
+	}
+	if err := c.do(req, nil); err != nil {
+		return err
+	}
+
+	return c.CompleteUpload(ctx, id, size, md5)
+}
+
+// resolveTransferURL makes URLs that are relative to the server absolute.
+func (c *Client) resolveTransferURL(u TransferURL) (TransferURL, error) {
+	ref, err := url.Parse(u.URL)
+	if err != nil {
+		return TransferURL{}, fmt.Errorf("parse transfer URL: %w", err)
+	}
+	u.URL = c.baseURL.ResolveReference(ref).String()
+	return u, nil
+}